@@ -0,0 +1,76 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const stateFileExt = ".rkestate"
+
+// ExtractSnapshot writes the etcd snapshot stored in the compressed snapshot file at zipPath to writer
+func ExtractSnapshot(zipPath, snapshotName string, writer io.Writer) error {
+	return extractFile(zipPath, func(name string) bool {
+		return path.Base(name) == snapshotName
+	}, writer)
+}
+
+// ExtractStateFile returns the cluster state file bundled in the compressed snapshot file at zipPath
+func ExtractStateFile(zipPath string) (string, error) {
+	stateFile := &strings.Builder{}
+	err := extractFile(zipPath, func(name string) bool {
+		return strings.HasSuffix(name, stateFileExt)
+	}, stateFile)
+	return stateFile.String(), err
+}
+
+func extractFile(zipPath string, match func(string) bool, writer io.Writer) error {
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open compressed snapshot: %v", err)
+	}
+	defer zipReader.Close()
+	for _, file := range zipReader.File {
+		if !match(file.Name) {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("Failed to read [%s] from compressed snapshot: %v", file.Name, err)
+		}
+		defer reader.Close()
+		_, err = io.Copy(writer, reader)
+		return err
+	}
+	return fmt.Errorf("File not found in compressed snapshot [%s]", path.Base(zipPath))
+}
+
+// DownloadToFile downloads the first of the files that exists in the backend to a temp file. It returns the name
+// of the downloaded file, the caller must remove the temp file.
+func DownloadToFile(ctx context.Context, backend Backend, names ...string) (string, string, error) {
+	var errs []string
+	for _, name := range names {
+		reader, err := backend.Download(ctx, name)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		defer reader.Close()
+		tmpFile, err := ioutil.TempFile("", name)
+		if err != nil {
+			return "", "", err
+		}
+		defer tmpFile.Close()
+		if _, err := io.Copy(tmpFile, reader); err != nil {
+			os.Remove(tmpFile.Name())
+			return "", "", fmt.Errorf("Failed to download [%s] from %s backend: %v", name, backend.Name(), err)
+		}
+		return name, tmpFile.Name(), nil
+	}
+	return "", "", fmt.Errorf("%s", strings.Join(errs, ", "))
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	v3 "github.com/rancher/rke/types"
+)
+
+const azureBlobEndpointFormat = "https://%s.blob.core.windows.net"
+
+type azureBlobBackend struct {
+	folder       string
+	containerURL azblob.ContainerURL
+}
+
+func newAzureBlobBackend(config *v3.AzureBlobBackupConfig) (*azureBlobBackend, error) {
+	credential, err := azblob.NewSharedKeyCredential(config.AccountName, config.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid azure blob storage credentials: %v", err)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf(azureBlobEndpointFormat, config.AccountName)
+	}
+	serviceURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid azure blob storage endpoint [%s]: %v", endpoint, err)
+	}
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	return &azureBlobBackend{
+		folder:       config.Folder,
+		containerURL: azblob.NewServiceURL(*serviceURL, pipeline).NewContainerURL(config.ContainerName),
+	}, nil
+}
+
+func (b *azureBlobBackend) Name() string {
+	return AzureBlobBackendName
+}
+
+func (b *azureBlobBackend) Upload(ctx context.Context, name string, reader io.Reader) error {
+	blobURL := b.containerURL.NewBlockBlobURL(objectKey(b.folder, name))
+	if _, err := azblob.UploadStreamToBlockBlob(ctx, reader, blobURL, azblob.UploadStreamToBlockBlobOptions{}); err != nil {
+		return fmt.Errorf("Failed to upload snapshot [%s] to azure blob storage: %v", name, err)
+	}
+	return nil
+}
+
+func (b *azureBlobBackend) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	blobURL := b.containerURL.NewBlobURL(objectKey(b.folder, name))
+	response, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download snapshot [%s] from azure blob storage: %v", name, err)
+	}
+	return response.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}), nil
+}
+
+func (b *azureBlobBackend) Remove(ctx context.Context, name string) error {
+	blobURL := b.containerURL.NewBlobURL(objectKey(b.folder, name))
+	if _, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{}); err != nil {
+		if storageErr, ok := err.(azblob.StorageError); ok && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil
+		}
+		return fmt.Errorf("Failed to remove snapshot [%s] from azure blob storage: %v", name, err)
+	}
+	return nil
+}
+
+func (b *azureBlobBackend) List(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	options := azblob.ListBlobsSegmentOptions{}
+	if b.folder != "" {
+		options.Prefix = strings.TrimSuffix(b.folder, "/") + "/"
+	}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		response, err := b.containerURL.ListBlobsFlatSegment(ctx, marker, options)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list snapshots in azure blob storage: %v", err)
+		}
+		marker = response.NextMarker
+		for _, blob := range response.Segment.BlobItems {
+			name := strings.TrimPrefix(blob.Name, options.Prefix)
+			// only the files directly in the folder are snapshots
+			if strings.Contains(name, "/") {
+				continue
+			}
+			snapshot := Snapshot{
+				Name:    path.Base(name),
+				Created: blob.Properties.LastModified,
+			}
+			if blob.Properties.ContentLength != nil {
+				snapshot.Size = *blob.Properties.ContentLength
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return sortSnapshots(snapshots), nil
+}
@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+)
+
+const (
+	FilesystemBackendName = "filesystem"
+	SFTPBackendName       = "sftp"
+	AzureBlobBackendName  = "azure-blob"
+	S3BackendName         = "s3"
+)
+
+// Backend stores etcd snapshot files outside of the etcd hosts. Snapshots are always taken on the etcd hosts first,
+// rke then moves the files between the hosts and the backend, and the SnapshotUploader uploads the recurring ones.
+// The S3 backend is the exception, the snapshots are uploaded and downloaded by rke-tools on the hosts, so it's only
+// used to list and remove them, unless the snapshots are encrypted.
+type Backend interface {
+	// Name is the backend type, used in logs
+	Name() string
+	// Upload stores the content of reader as file name
+	Upload(ctx context.Context, name string, reader io.Reader) error
+	// Download returns the content of file name, the caller must close it
+	Download(ctx context.Context, name string) (io.ReadCloser, error)
+	// Remove deletes file name, it's not an error if the file doesn't exist
+	Remove(ctx context.Context, name string) error
+	// List returns the files in the backend
+	List(ctx context.Context) ([]Snapshot, error)
+}
+
+// Snapshot is a file stored in a backend
+type Snapshot struct {
+	Name    string
+	Size    int64
+	Created time.Time
+}
+
+// IsTransferredByRKE returns true if rke moves the snapshot files between the etcd hosts and the backend,
+// instead of rke-tools on the hosts
+func IsTransferredByRKE(bc *v3.BackupConfig) bool {
+	return HasBackend(bc) && !IsTransferredByRKETools(bc)
+}
+
+// IsTransferredByRKETools returns true if rke-tools uploads and downloads the snapshots to S3 on the etcd hosts.
+// rke-tools can't encrypt, encrypted snapshots are encrypted on the etcd hosts before they're copied off the host or
+// uploaded, the recurring ones by the snapshot uploader.
+func IsTransferredByRKETools(bc *v3.BackupConfig) bool {
+	return bc != nil && bc.S3BackupConfig != nil && bc.Encryption == nil
+}
+
+// HasSnapshotUploader returns true if the recurring snapshots are uploaded by the snapshot uploader container on the
+// etcd hosts. The filesystem backend is on the machine running rke, it only stores the snapshots taken with rke.
+func HasSnapshotUploader(bc *v3.BackupConfig) bool {
+	return IsTransferredByRKE(bc) && bc.FilesystemBackupConfig == nil
+}
+
+// HasBackend returns true if a backend is configured to store the snapshots outside of the etcd hosts
+func HasBackend(bc *v3.BackupConfig) bool {
+	return bc != nil && (bc.S3BackupConfig != nil ||
+		bc.FilesystemBackupConfig != nil ||
+		bc.SFTPBackupConfig != nil ||
+		bc.AzureBlobBackupConfig != nil)
+}
+
+// NewBackend returns the backend configured in bc, or nil if snapshots are only kept on the etcd hosts
+func NewBackend(bc *v3.BackupConfig) (Backend, error) {
+	backend, err := newBackend(bc)
+	if err != nil || backend == nil {
+		return nil, err
+	}
+	if bc.Encryption != nil {
+		return newEncryptedBackend(backend, bc.Encryption)
+	}
+	return backend, nil
+}
+
+// NewUnencryptedBackend returns the backend configured in bc without the encryption, for files that are encrypted
+// on the etcd hosts already
+func NewUnencryptedBackend(bc *v3.BackupConfig) (Backend, error) {
+	return newBackend(bc)
+}
+
+func newBackend(bc *v3.BackupConfig) (Backend, error) {
+	if bc == nil {
+		return nil, nil
+	}
+	switch {
+	case bc.S3BackupConfig != nil:
+		return newS3Backend(bc.S3BackupConfig)
+	case bc.FilesystemBackupConfig != nil:
+		return newFilesystemBackend(bc.FilesystemBackupConfig)
+	case bc.SFTPBackupConfig != nil:
+		return newSFTPBackend(bc.SFTPBackupConfig)
+	case bc.AzureBlobBackupConfig != nil:
+		return newAzureBlobBackend(bc.AzureBlobBackupConfig)
+	}
+	return nil, nil
+}
+
+// Validate checks that at most one backend is configured and that it has the required options
+func Validate(bc *v3.BackupConfig) error {
+	if bc == nil {
+		return nil
+	}
+	var configured []string
+	if bc.S3BackupConfig != nil {
+		configured = append(configured, S3BackendName)
+	}
+	if bc.FilesystemBackupConfig != nil {
+		configured = append(configured, FilesystemBackendName)
+		if len(bc.FilesystemBackupConfig.Path) == 0 {
+			return fmt.Errorf("etcd filesystem backup backend path can't be empty")
+		}
+	}
+	if bc.SFTPBackupConfig != nil {
+		configured = append(configured, SFTPBackendName)
+		if len(bc.SFTPBackupConfig.Address) == 0 {
+			return fmt.Errorf("etcd sftp backup backend address can't be empty")
+		}
+		if len(bc.SFTPBackupConfig.User) == 0 {
+			return fmt.Errorf("etcd sftp backup backend user can't be empty")
+		}
+		if len(bc.SFTPBackupConfig.Password) == 0 && len(bc.SFTPBackupConfig.SSHKey) == 0 && len(bc.SFTPBackupConfig.SSHKeyPath) == 0 {
+			return fmt.Errorf("etcd sftp backup backend needs a password, ssh_key or ssh_key_path")
+		}
+		if len(bc.SFTPBackupConfig.HostKey) == 0 {
+			return fmt.Errorf("etcd sftp backup backend host_key can't be empty, it's used to verify the identity of the server")
+		}
+	}
+	if bc.AzureBlobBackupConfig != nil {
+		configured = append(configured, AzureBlobBackendName)
+		if len(bc.AzureBlobBackupConfig.AccountName) == 0 || len(bc.AzureBlobBackupConfig.AccountKey) == 0 {
+			return fmt.Errorf("etcd azure blob backup backend account_name and account_key can't be empty")
+		}
+		if len(bc.AzureBlobBackupConfig.ContainerName) == 0 {
+			return fmt.Errorf("etcd azure blob backup backend container_name can't be empty")
+		}
+	}
+	if len(configured) > 1 {
+		return fmt.Errorf("only one etcd backup backend can be configured, found [%s]", strings.Join(configured, ", "))
+	}
+	if bc.Encryption != nil {
+		// snapshots kept on the etcd hosts are as sensitive as the etcd data dir, only the uploaded ones are encrypted
+		if len(configured) == 0 {
+			return fmt.Errorf("etcd snapshot encryption requires a backup backend")
+		}
+		if err := ValidateEncryption(bc.Encryption); err != nil {
+			return fmt.Errorf("invalid etcd snapshot encryption configuration: %v", err)
+		}
+	}
+	return nil
+}
+
+func objectKey(folder, name string) string {
+	if folder == "" {
+		return name
+	}
+	return path.Join(folder, name)
+}
+
+func sortSnapshots(snapshots []Snapshot) []Snapshot {
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name < snapshots[j].Name
+	})
+	return snapshots
+}
@@ -0,0 +1,267 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testSnapshotName = "rke_etcd_snapshot_test.zip"
+	testSFTPUser     = "rke"
+	testSFTPPassword = "secret"
+	// well known development account of the Azurite emulator
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+func testBackend(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	content := []byte("etcd snapshot content")
+
+	assert.Nil(t, backend.Upload(ctx, testSnapshotName, bytes.NewReader(content)))
+	// uploading again replaces the snapshot
+	assert.Nil(t, backend.Upload(ctx, testSnapshotName, bytes.NewReader(content)))
+
+	snapshots, err := backend.List(ctx)
+	assert.Nil(t, err)
+	if assert.Len(t, snapshots, 1) {
+		assert.Equal(t, testSnapshotName, snapshots[0].Name)
+		assert.Equal(t, int64(len(content)), snapshots[0].Size)
+	}
+
+	reader, err := backend.Download(ctx, testSnapshotName)
+	if assert.Nil(t, err) {
+		downloaded, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Nil(t, reader.Close())
+		assert.Equal(t, content, downloaded)
+	}
+
+	assert.Nil(t, backend.Remove(ctx, testSnapshotName))
+	// removing a missing snapshot is not an error
+	assert.Nil(t, backend.Remove(ctx, testSnapshotName))
+	snapshots, err = backend.List(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, snapshots, 0)
+
+	_, err = backend.Download(ctx, testSnapshotName)
+	assert.NotNil(t, err)
+}
+
+func TestFilesystemBackend(t *testing.T) {
+	backend, err := NewBackend(&v3.BackupConfig{
+		FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: filepath.Join(t.TempDir(), "snapshots")},
+	})
+	assert.Nil(t, err)
+	testBackend(t, backend)
+}
+
+func TestSFTPBackend(t *testing.T) {
+	address, hostKey := startSFTPServer(t)
+	backend, err := NewBackend(&v3.BackupConfig{
+		SFTPBackupConfig: &v3.SFTPBackupConfig{
+			Address:  address,
+			User:     testSFTPUser,
+			Password: testSFTPPassword,
+			HostKey:  string(ssh.MarshalAuthorizedKey(hostKey)),
+			Folder:   filepath.Join(t.TempDir(), "snapshots"),
+		},
+	})
+	assert.Nil(t, err)
+	testBackend(t, backend)
+
+	backend, err = NewBackend(&v3.BackupConfig{
+		SFTPBackupConfig: &v3.SFTPBackupConfig{
+			Address:  address,
+			User:     testSFTPUser,
+			Password: "wrong",
+			HostKey:  string(ssh.MarshalAuthorizedKey(hostKey)),
+		},
+	})
+	assert.Nil(t, err)
+	_, err = backend.List(context.Background())
+	assert.NotNil(t, err)
+
+	// a server with another host key is rejected
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	otherPublicKey, err := ssh.NewPublicKey(&otherKey.PublicKey)
+	assert.Nil(t, err)
+	backend, err = NewBackend(&v3.BackupConfig{
+		SFTPBackupConfig: &v3.SFTPBackupConfig{
+			Address:  address,
+			User:     testSFTPUser,
+			Password: testSFTPPassword,
+			HostKey:  string(ssh.MarshalAuthorizedKey(otherPublicKey)),
+		},
+	})
+	assert.Nil(t, err)
+	_, err = backend.List(context.Background())
+	assert.NotNil(t, err)
+}
+
+// TestAzureBlobBackend runs against an Azurite emulator, for example:
+// docker run -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0
+// RKE_TEST_AZURITE_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1 go test ./backup
+func TestAzureBlobBackend(t *testing.T) {
+	endpoint := os.Getenv("RKE_TEST_AZURITE_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("RKE_TEST_AZURITE_ENDPOINT is not set")
+	}
+	backend, err := NewBackend(&v3.BackupConfig{
+		AzureBlobBackupConfig: &v3.AzureBlobBackupConfig{
+			AccountName:   azuriteAccountName,
+			AccountKey:    azuriteAccountKey,
+			ContainerName: "rke-test",
+			Endpoint:      endpoint,
+			Folder:        "snapshots",
+		},
+	})
+	assert.Nil(t, err)
+	// the container may already exist from a previous run
+	backend.(*azureBlobBackend).containerURL.Create(context.Background(), nil, "")
+	testBackend(t, backend)
+}
+
+// TestS3Backend runs against a MinIO server, for example:
+// docker run -p 9000:9000 minio/minio server /data
+// RKE_TEST_S3_ENDPOINT=http://127.0.0.1:9000 RKE_TEST_S3_BUCKET=rke-test go test ./backup
+func TestS3Backend(t *testing.T) {
+	endpoint := os.Getenv("RKE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("RKE_TEST_S3_ENDPOINT is not set")
+	}
+	backend, err := NewBackend(&v3.BackupConfig{
+		S3BackupConfig: &v3.S3BackupConfig{
+			Endpoint:   endpoint,
+			BucketName: os.Getenv("RKE_TEST_S3_BUCKET"),
+			AccessKey:  os.Getenv("RKE_TEST_S3_ACCESS_KEY"),
+			SecretKey:  os.Getenv("RKE_TEST_S3_SECRET_KEY"),
+			Folder:     "snapshots",
+		},
+	})
+	assert.Nil(t, err)
+	testBackend(t, backend)
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Validate(nil))
+	assert.Nil(t, Validate(&v3.BackupConfig{}))
+	assert.Nil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: "/mnt/nfs"}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{SFTPBackupConfig: &v3.SFTPBackupConfig{Address: "backup:22", User: "rke"}}))
+	// the host key of the server is required
+	assert.NotNil(t, Validate(&v3.BackupConfig{SFTPBackupConfig: &v3.SFTPBackupConfig{Address: "backup:22", User: "rke", Password: "secret"}}))
+	assert.Nil(t, Validate(&v3.BackupConfig{SFTPBackupConfig: &v3.SFTPBackupConfig{Address: "backup:22", User: "rke", Password: "secret", HostKey: "ssh-ed25519 AAAA"}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{AzureBlobBackupConfig: &v3.AzureBlobBackupConfig{AccountName: "rke", AccountKey: "key"}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{
+		S3BackupConfig:         &v3.S3BackupConfig{Endpoint: "s3.amazonaws.com", BucketName: "rke"},
+		FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: "/mnt/nfs"},
+	}))
+}
+
+func TestIsTransferredByRKE(t *testing.T) {
+	assert.False(t, IsTransferredByRKE(nil))
+	assert.False(t, IsTransferredByRKE(&v3.BackupConfig{}))
+	assert.False(t, IsTransferredByRKE(&v3.BackupConfig{S3BackupConfig: &v3.S3BackupConfig{}}))
+	assert.True(t, IsTransferredByRKE(&v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{}}))
+	assert.True(t, IsTransferredByRKE(&v3.BackupConfig{SFTPBackupConfig: &v3.SFTPBackupConfig{}}))
+	assert.True(t, IsTransferredByRKE(&v3.BackupConfig{AzureBlobBackupConfig: &v3.AzureBlobBackupConfig{}}))
+}
+
+func TestExtractFromCompressedSnapshot(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "snapshot.zip")
+	zipFile, err := os.Create(zipPath)
+	assert.Nil(t, err)
+	zipWriter := zip.NewWriter(zipFile)
+	for name, content := range map[string]string{
+		"backup/snapshot":          "etcd data",
+		"backup/snapshot.rkestate": "{}",
+	} {
+		writer, err := zipWriter.Create(name)
+		assert.Nil(t, err)
+		_, err = writer.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zipWriter.Close())
+	assert.Nil(t, zipFile.Close())
+
+	snapshot := &bytes.Buffer{}
+	assert.Nil(t, ExtractSnapshot(zipPath, "snapshot", snapshot))
+	assert.Equal(t, "etcd data", snapshot.String())
+	stateFile, err := ExtractStateFile(zipPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", stateFile)
+	assert.NotNil(t, ExtractSnapshot(zipPath, "other", &bytes.Buffer{}))
+}
+
+// startSFTPServer serves sftp with password authentication on a random local port
+func startSFTPServer(t *testing.T) (string, ssh.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.Nil(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testSFTPUser && string(password) == testSFTPPassword {
+				return nil, nil
+			}
+			return nil, assert.AnError
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range channelRequests {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			continue
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
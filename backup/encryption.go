@@ -0,0 +1,289 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+const (
+	ageHeader       = "age-encryption.org/v1"
+	ageRecipientHRP = "age1"
+	pgpBlockPrefix  = "-----BEGIN PGP"
+)
+
+// encryptionKeys are the parsed keys of a BackupEncryptionConfig, either age or PGP keys are set
+type encryptionKeys struct {
+	ageRecipients []age.Recipient
+	ageIdentities []age.Identity
+	pgpRecipients openpgp.EntityList
+	pgpKeyring    openpgp.EntityList
+}
+
+// encryptedBackend encrypts the snapshots before they're uploaded and decrypts them after they're downloaded. rke
+// uses it on the etcd hosts to encrypt the snapshots, the plain snapshot never leaves the hosts.
+type encryptedBackend struct {
+	Backend
+	keys *encryptionKeys
+	// allowUnencrypted downloads the snapshots that are not encrypted instead of failing
+	allowUnencrypted bool
+}
+
+type decryptedReader struct {
+	io.Reader
+	io.Closer
+}
+
+// ValidateEncryption checks that the encryption options can be combined and that the keys can be parsed
+func ValidateEncryption(config *v3.BackupEncryptionConfig) error {
+	_, err := parseEncryptionKeys(config)
+	return err
+}
+
+// Encrypt returns a writer that encrypts to w, closing it doesn't close w
+func Encrypt(config *v3.BackupEncryptionConfig, w io.Writer) (io.WriteCloser, error) {
+	keys, err := parseEncryptionKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	return keys.encrypt(w)
+}
+
+// Decrypt returns the decrypted content of r, the content is returned as is if it isn't encrypted
+func Decrypt(config *v3.BackupEncryptionConfig, r io.Reader) (io.Reader, bool, error) {
+	keys, err := parseEncryptionKeys(config)
+	if err != nil {
+		return nil, false, err
+	}
+	return keys.decrypt(r)
+}
+
+func newEncryptedBackend(backend Backend, config *v3.BackupEncryptionConfig) (Backend, error) {
+	keys, err := parseEncryptionKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedBackend{Backend: backend, keys: keys, allowUnencrypted: config.AllowUnencrypted}, nil
+}
+
+// hostEncryptionConfig returns the encryption configuration used on the etcd hosts. The snapshots are encrypted for
+// the public keys of the key file, the private key stays on the machine running rke.
+func hostEncryptionConfig(config *v3.BackupEncryptionConfig) (*v3.BackupEncryptionConfig, error) {
+	hostConfig := config.DeepCopy()
+	if config.KeyFile == "" {
+		return hostConfig, nil
+	}
+	keys, err := parseEncryptionKeys(&v3.BackupEncryptionConfig{KeyFile: config.KeyFile})
+	if err != nil {
+		return nil, err
+	}
+	for _, recipient := range keys.ageRecipients {
+		x25519Recipient, ok := recipient.(*age.X25519Recipient)
+		if !ok {
+			return nil, fmt.Errorf("Unsupported recipient in age key file [%s]", config.KeyFile)
+		}
+		hostConfig.Recipients = append(hostConfig.Recipients, x25519Recipient.String())
+	}
+	for _, entity := range keys.pgpKeyring {
+		var publicKey bytes.Buffer
+		armorWriter, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := entity.Serialize(armorWriter); err != nil {
+			return nil, fmt.Errorf("Failed to export PGP public key of key file [%s]: %v", config.KeyFile, err)
+		}
+		if err := armorWriter.Close(); err != nil {
+			return nil, err
+		}
+		hostConfig.Recipients = append(hostConfig.Recipients, publicKey.String())
+	}
+	hostConfig.KeyFile = ""
+	return hostConfig, nil
+}
+
+func (b *encryptedBackend) Upload(ctx context.Context, name string, reader io.Reader) error {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		encryptWriter, err := b.keys.encrypt(pipeWriter)
+		if err == nil {
+			_, err = io.Copy(encryptWriter, reader)
+		}
+		if err == nil {
+			err = encryptWriter.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	err := b.Backend.Upload(ctx, name, pipeReader)
+	// unblock the encryption if the upload stopped reading
+	pipeReader.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+func (b *encryptedBackend) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, err := b.Backend.Download(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, encrypted, err := b.keys.decrypt(reader)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("Failed to decrypt snapshot [%s]: %v", name, err)
+	}
+	if !encrypted {
+		// anyone with write access to the backend could have replaced an encrypted snapshot
+		if !b.allowUnencrypted {
+			reader.Close()
+			return nil, fmt.Errorf("Snapshot [%s] in %s backend is not encrypted, restore it with --allow-unencrypted if it was taken without encryption", name, b.Name())
+		}
+		log.Warnf(ctx, "[etcd] Snapshot [%s] in %s backend is not encrypted", name, b.Name())
+	}
+	return decryptedReader{Reader: decrypted, Closer: reader}, nil
+}
+
+func parseEncryptionKeys(config *v3.BackupEncryptionConfig) (*encryptionKeys, error) {
+	if config == nil {
+		return nil, fmt.Errorf("encryption configuration is empty")
+	}
+	keys := &encryptionKeys{}
+	if config.Passphrase != "" {
+		if config.KeyFile != "" || len(config.Recipients) > 0 {
+			return nil, fmt.Errorf("encryption passphrase can't be combined with key_file or recipients")
+		}
+		recipient, err := age.NewScryptRecipient(config.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(config.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		keys.ageRecipients = []age.Recipient{recipient}
+		keys.ageIdentities = []age.Identity{identity}
+		return keys, nil
+	}
+	if config.KeyFile == "" && len(config.Recipients) == 0 {
+		return nil, fmt.Errorf("encryption requires a passphrase, a key_file or recipients")
+	}
+
+	if config.KeyFile != "" {
+		keyFile, err := ioutil.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read encryption key file [%s]: %v", config.KeyFile, err)
+		}
+		if bytes.Contains(keyFile, []byte(pgpBlockPrefix)) {
+			keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyFile))
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse PGP key file [%s]: %v", config.KeyFile, err)
+			}
+			keys.pgpKeyring = keyring
+			keys.pgpRecipients = append(keys.pgpRecipients, keyring...)
+		} else {
+			identities, err := age.ParseIdentities(bytes.NewReader(keyFile))
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse age key file [%s]: %v", config.KeyFile, err)
+			}
+			for _, identity := range identities {
+				x25519Identity, ok := identity.(*age.X25519Identity)
+				if !ok {
+					return nil, fmt.Errorf("Unsupported identity in age key file [%s]", config.KeyFile)
+				}
+				keys.ageRecipients = append(keys.ageRecipients, x25519Identity.Recipient())
+			}
+			keys.ageIdentities = identities
+		}
+	}
+
+	for _, recipient := range config.Recipients {
+		recipient = strings.TrimSpace(recipient)
+		switch {
+		case strings.HasPrefix(recipient, ageRecipientHRP):
+			ageRecipient, err := age.ParseX25519Recipient(recipient)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse age recipient [%s]: %v", recipient, err)
+			}
+			keys.ageRecipients = append(keys.ageRecipients, ageRecipient)
+		case strings.HasPrefix(recipient, pgpBlockPrefix):
+			entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(recipient))
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse PGP recipient: %v", err)
+			}
+			keys.pgpRecipients = append(keys.pgpRecipients, entities...)
+		default:
+			return nil, fmt.Errorf("Unsupported encryption recipient [%s], expected an age public key or an armored PGP public key", recipient)
+		}
+	}
+	if len(keys.ageRecipients) > 0 && len(keys.pgpRecipients) > 0 {
+		return nil, fmt.Errorf("age and PGP encryption keys can't be combined")
+	}
+	return keys, nil
+}
+
+func (k *encryptionKeys) encrypt(w io.Writer) (io.WriteCloser, error) {
+	if len(k.pgpRecipients) > 0 {
+		return openpgp.Encrypt(w, k.pgpRecipients, nil, nil, nil)
+	}
+	return age.Encrypt(w, k.ageRecipients...)
+}
+
+func (k *encryptionKeys) decrypt(r io.Reader) (io.Reader, bool, error) {
+	bufReader := bufio.NewReader(r)
+	header, err := bufReader.Peek(len(ageHeader))
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	switch {
+	case bytes.Equal(header, []byte(ageHeader)):
+		if len(k.ageIdentities) == 0 {
+			return nil, true, fmt.Errorf("file is encrypted with age, the passphrase or the key_file it was encrypted with is required")
+		}
+		decrypted, err := age.Decrypt(bufReader, k.ageIdentities...)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			if errors.As(err, &noMatch) {
+				return nil, true, fmt.Errorf("the configured passphrase or key_file doesn't match the key the file was encrypted with")
+			}
+			return nil, true, err
+		}
+		return decrypted, true, nil
+	case bytes.HasPrefix(header, []byte(pgpBlockPrefix)):
+		block, err := armor.Decode(bufReader)
+		if err != nil {
+			return nil, true, err
+		}
+		return k.decryptPGP(block.Body)
+	// binary OpenPGP packets have the high bit of the first byte set, zip and etcd db files don't
+	case len(header) > 0 && header[0]&0x80 != 0:
+		return k.decryptPGP(bufReader)
+	}
+	return bufReader, false, nil
+}
+
+func (k *encryptionKeys) decryptPGP(r io.Reader) (io.Reader, bool, error) {
+	if len(k.pgpKeyring) == 0 {
+		return nil, true, fmt.Errorf("file is encrypted with PGP, the key_file with the private key it was encrypted for is required")
+	}
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		return nil, fmt.Errorf("passphrase protected PGP private keys are not supported")
+	}
+	message, err := openpgp.ReadMessage(r, k.pgpKeyring, prompt, nil)
+	if err != nil {
+		if err == pgperrors.ErrKeyIncorrect {
+			return nil, true, fmt.Errorf("the configured key_file doesn't match the key the file was encrypted for")
+		}
+		return nil, true, err
+	}
+	return message.UnverifiedBody, true, nil
+}
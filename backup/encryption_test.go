@@ -0,0 +1,198 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestEncryptedBackend(t *testing.T) {
+	ageKeyFile, ageRecipient := writeAgeKeyFile(t)
+	pgpKeyFile, pgpPublicKey := writePGPKeyFile(t)
+
+	for name, encryption := range map[string]*v3.BackupEncryptionConfig{
+		"passphrase":       {Passphrase: "secret"},
+		"age key file":     {KeyFile: ageKeyFile},
+		"age recipients":   {KeyFile: ageKeyFile, Recipients: []string{ageRecipient}},
+		"pgp key file":     {KeyFile: pgpKeyFile},
+		"pgp recipients":   {KeyFile: pgpKeyFile, Recipients: []string{pgpPublicKey}},
+		"recipient only":   {Recipients: []string{ageRecipient}},
+		"wrong key file":   {KeyFile: ageKeyFile},
+		"wrong pgp key":    {KeyFile: pgpKeyFile},
+		"wrong passphrase": {Passphrase: "secret"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			path := t.TempDir()
+			backend, err := NewBackend(&v3.BackupConfig{
+				FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: path},
+				Encryption:             encryption,
+			})
+			if !assert.Nil(t, err) {
+				return
+			}
+			content := []byte("etcd snapshot content")
+			assert.Nil(t, backend.Upload(ctx, testSnapshotName, bytes.NewReader(content)))
+
+			stored, err := ioutil.ReadFile(filepath.Join(path, testSnapshotName))
+			assert.Nil(t, err)
+			assert.NotContains(t, string(stored), string(content))
+
+			restoreEncryption := encryption
+			switch name {
+			case "recipient only":
+				restoreEncryption = &v3.BackupEncryptionConfig{Recipients: encryption.Recipients}
+			case "wrong key file":
+				otherKeyFile, _ := writeAgeKeyFile(t)
+				restoreEncryption = &v3.BackupEncryptionConfig{KeyFile: otherKeyFile}
+			case "wrong pgp key":
+				otherKeyFile, _ := writePGPKeyFile(t)
+				restoreEncryption = &v3.BackupEncryptionConfig{KeyFile: otherKeyFile}
+			case "wrong passphrase":
+				restoreEncryption = &v3.BackupEncryptionConfig{Passphrase: "wrong"}
+			}
+			backend, err = NewBackend(&v3.BackupConfig{
+				FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: path},
+				Encryption:             restoreEncryption,
+			})
+			assert.Nil(t, err)
+			reader, err := backend.Download(ctx, testSnapshotName)
+			if restoreEncryption != encryption {
+				assert.NotNil(t, err)
+				return
+			}
+			if assert.Nil(t, err) {
+				downloaded, err := ioutil.ReadAll(reader)
+				assert.Nil(t, err)
+				assert.Nil(t, reader.Close())
+				assert.Equal(t, content, downloaded)
+			}
+		})
+	}
+}
+
+func TestDecryptPlainFile(t *testing.T) {
+	content := []byte("PK\x03\x04 plain snapshot")
+	decrypted, encrypted, err := Decrypt(&v3.BackupEncryptionConfig{Passphrase: "secret"}, bytes.NewReader(content))
+	assert.Nil(t, err)
+	assert.False(t, encrypted)
+	plain, err := ioutil.ReadAll(decrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, content, plain)
+}
+
+func TestValidateEncryption(t *testing.T) {
+	ageKeyFile, ageRecipient := writeAgeKeyFile(t)
+	_, pgpPublicKey := writePGPKeyFile(t)
+	filesystem := &v3.FilesystemBackupConfig{Path: "/mnt/nfs"}
+
+	assert.Nil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: filesystem, Encryption: &v3.BackupEncryptionConfig{Passphrase: "secret"}}))
+	assert.Nil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: filesystem, Encryption: &v3.BackupEncryptionConfig{Recipients: []string{pgpPublicKey}}}))
+	// encryption only applies to the snapshots stored in a backend
+	assert.NotNil(t, Validate(&v3.BackupConfig{Encryption: &v3.BackupEncryptionConfig{Passphrase: "secret"}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: filesystem, Encryption: &v3.BackupEncryptionConfig{}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: filesystem, Encryption: &v3.BackupEncryptionConfig{Passphrase: "secret", KeyFile: ageKeyFile}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: filesystem, Encryption: &v3.BackupEncryptionConfig{KeyFile: ageKeyFile, Recipients: []string{pgpPublicKey}}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: filesystem, Encryption: &v3.BackupEncryptionConfig{Recipients: []string{"ssh-rsa AAAA"}}}))
+	assert.NotNil(t, Validate(&v3.BackupConfig{FilesystemBackupConfig: filesystem, Encryption: &v3.BackupEncryptionConfig{KeyFile: "/does/not/exist"}}))
+
+	assert.False(t, IsTransferredByRKETools(&v3.BackupConfig{S3BackupConfig: &v3.S3BackupConfig{}, Encryption: &v3.BackupEncryptionConfig{Recipients: []string{ageRecipient}}}))
+	assert.True(t, IsTransferredByRKE(&v3.BackupConfig{S3BackupConfig: &v3.S3BackupConfig{}, Encryption: &v3.BackupEncryptionConfig{Recipients: []string{ageRecipient}}}))
+}
+
+func writeAgeKeyFile(t *testing.T) (string, string) {
+	identity, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "age.key")
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte(identity.String()+"\n"), 0600))
+	return keyFile, identity.Recipient().String()
+}
+
+func writePGPKeyFile(t *testing.T) (string, string) {
+	// gpg generated keys have hash preferences, without them openpgp falls back to RIPEMD160
+	entity, err := openpgp.NewEntity("rke", "", "rke@example.com", &packet.Config{DefaultHash: crypto.SHA256})
+	assert.Nil(t, err)
+
+	privateKey := &bytes.Buffer{}
+	writer, err := armor.Encode(privateKey, openpgp.PrivateKeyType, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, entity.SerializePrivate(writer, nil))
+	assert.Nil(t, writer.Close())
+
+	publicKey := &bytes.Buffer{}
+	writer, err = armor.Encode(publicKey, openpgp.PublicKeyType, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, entity.Serialize(writer))
+	assert.Nil(t, writer.Close())
+
+	keyFile := filepath.Join(t.TempDir(), "pgp.key")
+	assert.Nil(t, ioutil.WriteFile(keyFile, privateKey.Bytes(), 0600))
+	return keyFile, publicKey.String()
+}
+
+func TestEncryptedBackendUnencryptedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	content := []byte("PK\x03\x04 plain snapshot")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(path, testSnapshotName), content, 0600))
+	encryption := &v3.BackupEncryptionConfig{Passphrase: "secret"}
+	backend, err := NewBackend(&v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: path}, Encryption: encryption})
+	assert.Nil(t, err)
+	// the plain snapshot may have replaced an encrypted one
+	_, err = backend.Download(ctx, testSnapshotName)
+	assert.NotNil(t, err)
+
+	encryption.AllowUnencrypted = true
+	backend, err = NewBackend(&v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: path}, Encryption: encryption})
+	assert.Nil(t, err)
+	reader, err := backend.Download(ctx, testSnapshotName)
+	if assert.Nil(t, err) {
+		downloaded, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Nil(t, reader.Close())
+		assert.Equal(t, content, downloaded)
+	}
+}
+
+func TestHostEncryptionConfig(t *testing.T) {
+	ageKeyFile, ageRecipient := writeAgeKeyFile(t)
+	pgpKeyFile, _ := writePGPKeyFile(t)
+	for name, encryption := range map[string]*v3.BackupEncryptionConfig{
+		"passphrase":     {Passphrase: "secret"},
+		"age key file":   {KeyFile: ageKeyFile},
+		"age recipients": {KeyFile: ageKeyFile, Recipients: []string{ageRecipient}},
+		"pgp key file":   {KeyFile: pgpKeyFile},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hostEncryption, err := hostEncryptionConfig(encryption)
+			assert.Nil(t, err)
+			// the private key stays on the machine running rke
+			assert.Empty(t, hostEncryption.KeyFile)
+			assert.Equal(t, encryption.Passphrase, hostEncryption.Passphrase)
+
+			var encrypted bytes.Buffer
+			writer, err := Encrypt(hostEncryption, &encrypted)
+			assert.Nil(t, err)
+			_, err = writer.Write([]byte("etcd snapshot content"))
+			assert.Nil(t, err)
+			assert.Nil(t, writer.Close())
+			decrypted, isEncrypted, err := Decrypt(encryption, &encrypted)
+			if assert.Nil(t, err) {
+				assert.True(t, isEncrypted)
+				plain, err := ioutil.ReadAll(decrypted)
+				assert.Nil(t, err)
+				assert.Equal(t, "etcd snapshot content", string(plain))
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	v3 "github.com/rancher/rke/types"
+)
+
+type filesystemBackend struct {
+	path string
+}
+
+func newFilesystemBackend(config *v3.FilesystemBackupConfig) (*filesystemBackend, error) {
+	if err := os.MkdirAll(config.Path, 0700); err != nil {
+		return nil, fmt.Errorf("Failed to create snapshot directory [%s]: %v", config.Path, err)
+	}
+	return &filesystemBackend{path: config.Path}, nil
+}
+
+func (b *filesystemBackend) Name() string {
+	return FilesystemBackendName
+}
+
+func (b *filesystemBackend) Upload(ctx context.Context, name string, reader io.Reader) error {
+	// write to a temp file first so a failed upload never replaces a good snapshot
+	tmpFile, err := ioutil.TempFile(b.path, "."+name)
+	if err != nil {
+		return fmt.Errorf("Failed to create snapshot file in [%s]: %v", b.path, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := io.Copy(tmpFile, reader); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("Failed to write snapshot [%s]: %v", name, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("Failed to write snapshot [%s]: %v", name, err)
+	}
+	return os.Rename(tmpFile.Name(), filepath.Join(b.path, name))
+}
+
+func (b *filesystemBackend) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(b.path, name))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open snapshot [%s]: %v", name, err)
+	}
+	return file, nil
+}
+
+func (b *filesystemBackend) Remove(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(b.path, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove snapshot [%s]: %v", name, err)
+	}
+	return nil
+}
+
+func (b *filesystemBackend) List(ctx context.Context) ([]Snapshot, error) {
+	files, err := ioutil.ReadDir(b.path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list snapshots in [%s]: %v", b.path, err)
+	}
+	var snapshots []Snapshot
+	for _, file := range files {
+		if file.IsDir() || file.Name()[0] == '.' {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:    file.Name(),
+			Size:    file.Size(),
+			Created: file.ModTime(),
+		})
+	}
+	return sortSnapshots(snapshots), nil
+}
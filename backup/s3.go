@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	v3 "github.com/rancher/rke/types"
+)
+
+const (
+	awsS3Endpoint   = "s3.amazonaws.com"
+	defaultS3Region = "us-east-1"
+)
+
+type s3Backend struct {
+	bucket string
+	folder string
+	client *s3.S3
+	sess   *session.Session
+}
+
+func newS3Backend(config *v3.S3BackupConfig) (*s3Backend, error) {
+	sess, err := NewS3Session(config)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Backend{
+		bucket: config.BucketName,
+		folder: config.Folder,
+		client: s3.New(sess),
+		sess:   sess,
+	}, nil
+}
+
+// NewS3Session returns a session for the endpoint, region and credentials of the S3 config, the default AWS
+// credential chain is used when no access key is set
+func NewS3Session(config *v3.S3BackupConfig) (*session.Session, error) {
+	awsConfig := &aws.Config{
+		Region: aws.String(config.Region),
+	}
+	if config.Region == "" {
+		awsConfig.Region = aws.String(defaultS3Region)
+	}
+	if config.Endpoint != "" && config.Endpoint != awsS3Endpoint {
+		endpoint := config.Endpoint
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		awsConfig.Endpoint = aws.String(endpoint)
+		// S3 compatible servers such as MinIO don't support virtual hosted buckets
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	if len(config.AccessKey) > 0 || len(config.SecretKey) > 0 {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, "")
+	}
+	if config.CustomCA != "" {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(config.CustomCA)) {
+			return nil, fmt.Errorf("Failed to parse S3 endpoint CA certificate")
+		}
+		awsConfig.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: certPool},
+			},
+		}
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create S3 session: %v", err)
+	}
+	return sess, nil
+}
+
+func (b *s3Backend) Name() string {
+	return S3BackendName
+}
+
+func (b *s3Backend) Upload(ctx context.Context, name string, reader io.Reader) error {
+	uploader := s3manager.NewUploader(b.sess)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(objectKey(b.folder, name)),
+		Body:   reader,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to upload snapshot [%s] to S3: %v", name, err)
+	}
+	return nil
+}
+
+func (b *s3Backend) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	output, err := b.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(objectKey(b.folder, name)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download snapshot [%s] from S3: %v", name, err)
+	}
+	return output.Body, nil
+}
+
+func (b *s3Backend) Remove(ctx context.Context, name string) error {
+	_, err := b.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(objectKey(b.folder, name)),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil
+		}
+		return fmt.Errorf("Failed to remove snapshot [%s] from S3: %v", name, err)
+	}
+	return nil
+}
+
+func (b *s3Backend) List(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Delimiter: aws.String("/"),
+	}
+	if b.folder != "" {
+		input.Prefix = aws.String(strings.TrimSuffix(b.folder, "/") + "/")
+	}
+	err := b.client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if strings.HasSuffix(aws.StringValue(object.Key), "/") {
+				continue
+			}
+			snapshots = append(snapshots, Snapshot{
+				Name:    path.Base(aws.StringValue(object.Key)),
+				Size:    aws.Int64Value(object.Size),
+				Created: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list snapshots in S3 bucket [%s]: %v", b.bucket, err)
+	}
+	return sortSnapshots(snapshots), nil
+}
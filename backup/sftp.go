@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/pkg/sftp"
+	v3 "github.com/rancher/rke/types"
+	"golang.org/x/crypto/ssh"
+)
+
+type sftpBackend struct {
+	config    *v3.SFTPBackupConfig
+	sshConfig *ssh.ClientConfig
+}
+
+// sftpFile closes the sftp session together with the downloaded file
+type sftpFile struct {
+	*sftp.File
+	client *sftpClient
+}
+
+type sftpClient struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+func newSFTPBackend(config *v3.SFTPBackupConfig) (*sftpBackend, error) {
+	sshConfig := &ssh.ClientConfig{
+		User: config.User,
+	}
+	if len(config.Password) > 0 {
+		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(config.Password))
+	}
+	key := config.SSHKey
+	if len(key) == 0 && len(config.SSHKeyPath) > 0 {
+		keyBytes, err := ioutil.ReadFile(config.SSHKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read sftp ssh key [%s]: %v", config.SSHKeyPath, err)
+		}
+		key = string(keyBytes)
+	}
+	if len(key) > 0 {
+		signer, err := ssh.ParsePrivateKey([]byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse sftp ssh key: %v", err)
+		}
+		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signer))
+	}
+	// the snapshots are only sent to the server with the configured host key
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(config.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse sftp host key: %v", err)
+	}
+	sshConfig.HostKeyCallback = ssh.FixedHostKey(hostKey)
+	return &sftpBackend{config: config, sshConfig: sshConfig}, nil
+}
+
+func (b *sftpBackend) Name() string {
+	return SFTPBackendName
+}
+
+func (b *sftpBackend) connect() (*sftpClient, error) {
+	conn, err := ssh.Dial("tcp", b.config.Address, b.sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to sftp server [%s]: %v", b.config.Address, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to start sftp session with [%s]: %v", b.config.Address, err)
+	}
+	return &sftpClient{Client: client, conn: conn}, nil
+}
+
+func (c *sftpClient) Close() error {
+	c.Client.Close()
+	return c.conn.Close()
+}
+
+func (f *sftpFile) Close() error {
+	f.File.Close()
+	return f.client.Close()
+}
+
+func (b *sftpBackend) Upload(ctx context.Context, name string, reader io.Reader) error {
+	client, err := b.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if len(b.config.Folder) > 0 {
+		if err := client.MkdirAll(b.config.Folder); err != nil {
+			return fmt.Errorf("Failed to create folder [%s] on sftp server: %v", b.config.Folder, err)
+		}
+	}
+	key := objectKey(b.config.Folder, name)
+	tmpKey := objectKey(b.config.Folder, "."+name+".part")
+	file, err := client.Create(tmpKey)
+	if err != nil {
+		return fmt.Errorf("Failed to create [%s] on sftp server: %v", tmpKey, err)
+	}
+	if _, err := file.ReadFrom(reader); err != nil {
+		file.Close()
+		client.Remove(tmpKey)
+		return fmt.Errorf("Failed to upload snapshot [%s] to sftp server: %v", name, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Failed to upload snapshot [%s] to sftp server: %v", name, err)
+	}
+	// PosixRename replaces an existing snapshot with the same name, plain Rename fails on most servers
+	if err := client.PosixRename(tmpKey, key); err != nil {
+		client.Remove(key)
+		if err := client.Rename(tmpKey, key); err != nil {
+			return fmt.Errorf("Failed to upload snapshot [%s] to sftp server: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (b *sftpBackend) Download(ctx context.Context, name string) (io.ReadCloser, error) {
+	client, err := b.connect()
+	if err != nil {
+		return nil, err
+	}
+	file, err := client.Open(objectKey(b.config.Folder, name))
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Failed to open snapshot [%s] on sftp server: %v", name, err)
+	}
+	return &sftpFile{File: file, client: client}, nil
+}
+
+func (b *sftpBackend) Remove(ctx context.Context, name string) error {
+	client, err := b.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Remove(objectKey(b.config.Folder, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove snapshot [%s] from sftp server: %v", name, err)
+	}
+	return nil
+}
+
+func (b *sftpBackend) List(ctx context.Context) ([]Snapshot, error) {
+	client, err := b.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	folder := b.config.Folder
+	if folder == "" {
+		folder = "."
+	}
+	files, err := client.ReadDir(folder)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Failed to list snapshots on sftp server: %v", err)
+	}
+	var snapshots []Snapshot
+	for _, file := range files {
+		if file.IsDir() || path.Base(file.Name())[0] == '.' {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:    file.Name(),
+			Size:    file.Size(),
+			Created: file.ModTime(),
+		})
+	}
+	return sortSnapshots(snapshots), nil
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+)
+
+const (
+	// rke-tools names the recurring snapshots <creation time>_etcd
+	rollingSnapshotSuffix = "_etcd"
+	compressedExtension   = ".zip"
+)
+
+// SnapshotUploader uploads the recurring snapshots of an etcd host to the backend and removes the uploaded ones
+// once they're older than the retention period. It runs next to the rolling snapshot container on the etcd hosts,
+// for the backends rke-tools can't upload to.
+type SnapshotUploader struct {
+	Backend Backend
+	// Dir is the snapshot directory of the etcd host
+	Dir       string
+	Retention time.Duration
+	// SettleTime is how long a snapshot file is left unchanged before it's uploaded, rke-tools may still write it
+	SettleTime time.Duration
+
+	uploaded map[string]bool
+}
+
+// IsRollingSnapshot returns true if the file is a recurring snapshot taken by rke-tools
+func IsRollingSnapshot(fileName string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(fileName, compressedExtension), rollingSnapshotSuffix)
+}
+
+// HostConfig returns the backup configuration used by the snapshot uploader on the etcd hosts, the files it refers
+// to on the machine running rke are inlined and only the public encryption keys are kept
+func HostConfig(bc *v3.BackupConfig) (*v3.BackupConfig, error) {
+	hostConfig := bc.DeepCopy()
+	if sftpConfig := hostConfig.SFTPBackupConfig; sftpConfig != nil && len(sftpConfig.SSHKey) == 0 && len(sftpConfig.SSHKeyPath) > 0 {
+		key, err := ioutil.ReadFile(sftpConfig.SSHKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read sftp ssh key [%s]: %v", sftpConfig.SSHKeyPath, err)
+		}
+		sftpConfig.SSHKey = string(key)
+		sftpConfig.SSHKeyPath = ""
+	}
+	if hostConfig.Encryption != nil {
+		encryption, err := hostEncryptionConfig(hostConfig.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		hostConfig.Encryption = encryption
+	}
+	return hostConfig, nil
+}
+
+// UploadSnapshot uploads the snapshot with the name once, the compressed file is preferred over the uncompressed one
+func (u *SnapshotUploader) UploadSnapshot(ctx context.Context, name string) error {
+	for _, fileName := range []string{name + compressedExtension, name} {
+		if _, err := os.Stat(filepath.Join(u.Dir, fileName)); err != nil {
+			continue
+		}
+		return u.upload(ctx, fileName)
+	}
+	return fmt.Errorf("Failed to find snapshot [%s] in [%s]", name, u.Dir)
+}
+
+// Run syncs the snapshots every interval until ctx is done
+func (u *SnapshotUploader) Run(ctx context.Context, interval time.Duration) error {
+	log.Infof(ctx, "[etcd] Uploading the snapshots in [%s] to the %s backend", u.Dir, u.Backend.Name())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// failed uploads are retried on the next sync
+		if err := u.Sync(ctx, time.Now()); err != nil {
+			log.Warnf(ctx, "[etcd] Failed to upload snapshots to the %s backend: %v", u.Backend.Name(), err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync uploads the recurring snapshots that are not in the backend yet, and removes the recurring snapshots older
+// than the retention period from the backend
+func (u *SnapshotUploader) Sync(ctx context.Context, now time.Time) error {
+	if u.uploaded == nil {
+		u.uploaded = map[string]bool{}
+	}
+	files, err := ioutil.ReadDir(u.Dir)
+	if err != nil {
+		return fmt.Errorf("Failed to list snapshots in [%s]: %v", u.Dir, err)
+	}
+	local := map[string]os.FileInfo{}
+	for _, file := range files {
+		if file.Mode().IsRegular() && IsRollingSnapshot(file.Name()) {
+			local[file.Name()] = file
+		}
+	}
+	var pending []string
+	for name, file := range local {
+		if u.uploaded[name] || now.Sub(file.ModTime()) < u.SettleTime {
+			continue
+		}
+		// the compressed snapshot replaces the uncompressed one
+		if _, ok := local[name+compressedExtension]; ok {
+			continue
+		}
+		pending = append(pending, name)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	sort.Strings(pending)
+
+	snapshots, err := u.Backend.List(ctx)
+	if err != nil {
+		return err
+	}
+	stored := map[string]bool{}
+	for _, snapshot := range snapshots {
+		stored[snapshot.Name] = true
+		if u.Retention == 0 || !IsRollingSnapshot(snapshot.Name) || now.Sub(snapshot.Created) <= u.Retention {
+			continue
+		}
+		log.Infof(ctx, "[etcd] Removing snapshot [%s] older than %s from the %s backend", snapshot.Name, u.Retention, u.Backend.Name())
+		if err := u.Backend.Remove(ctx, snapshot.Name); err != nil {
+			return err
+		}
+	}
+	for _, name := range pending {
+		if !stored[name] {
+			if err := u.upload(ctx, name); err != nil {
+				return err
+			}
+		}
+		u.uploaded[name] = true
+	}
+	return nil
+}
+
+func (u *SnapshotUploader) upload(ctx context.Context, name string) error {
+	file, err := os.Open(filepath.Join(u.Dir, name))
+	if err != nil {
+		return fmt.Errorf("Failed to open snapshot [%s]: %v", name, err)
+	}
+	defer file.Close()
+	log.Infof(ctx, "[etcd] Uploading snapshot [%s] to the %s backend", name, u.Backend.Name())
+	return u.Backend.Upload(ctx, name, file)
+}
@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3Server stores the objects of a bucket in memory, it implements the requests of the S3 backend
+type fakeS3Server struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+type fakeS3ListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Name     string
+	KeyCount int
+	Contents []fakeS3Object
+}
+
+type fakeS3Object struct {
+	Key          string
+	LastModified time.Time
+	Size         int
+}
+
+func (s *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+s.bucket), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		result := fakeS3ListResult{Name: s.bucket}
+		for objectKey, content := range s.objects {
+			if strings.HasPrefix(objectKey, r.URL.Query().Get("prefix")) {
+				result.Contents = append(result.Contents, fakeS3Object{Key: objectKey, LastModified: time.Now().UTC(), Size: len(content)})
+			}
+		}
+		result.KeyCount = len(result.Contents)
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		content, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = content
+	case r.Method == http.MethodGet:
+		content, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func startFakeS3Server(t *testing.T) (*fakeS3Server, *v3.S3BackupConfig) {
+	server := &fakeS3Server{bucket: "rke-test", objects: map[string][]byte{}}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	return server, &v3.S3BackupConfig{
+		Endpoint:   httpServer.URL,
+		BucketName: server.bucket,
+		AccessKey:  "access",
+		SecretKey:  "secret",
+		Folder:     "snapshots",
+	}
+}
+
+func listSnapshotNames(t *testing.T, backend Backend) []string {
+	snapshots, err := backend.List(context.Background())
+	assert.Nil(t, err)
+	var names []string
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	return names
+}
+
+func TestSnapshotUploader(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	backendDir := filepath.Join(t.TempDir(), "backend")
+	backend, err := NewBackend(&v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: backendDir}})
+	assert.Nil(t, err)
+	snapshotDir := t.TempDir()
+	writeSnapshot := func(name string, modTime time.Time) {
+		filePath := filepath.Join(snapshotDir, name)
+		assert.Nil(t, ioutil.WriteFile(filePath, []byte(name), 0600))
+		assert.Nil(t, os.Chtimes(filePath, modTime, modTime))
+	}
+	settled := now.Add(-2 * time.Minute)
+	writeSnapshot("2021-04-01T00:00:00Z_etcd.zip", settled)
+	// the compressed snapshot is uploaded instead
+	writeSnapshot("2021-04-01T12:00:00Z_etcd", settled)
+	writeSnapshot("2021-04-01T12:00:00Z_etcd.zip", settled)
+	writeSnapshot("2021-04-02T00:00:00Z_etcd", settled)
+	// still written by rke-tools
+	writeSnapshot("2021-04-02T12:00:00Z_etcd.zip", now)
+	// one-time snapshots are uploaded by rke, the other files are not snapshots
+	writeSnapshot("rke_etcd_snapshot_2021-04-01T06:00:00Z.zip", settled)
+	writeSnapshot("pki.bundle.tar.gz", settled)
+
+	uploader := &SnapshotUploader{Backend: backend, Dir: snapshotDir, Retention: 72 * time.Hour, SettleTime: time.Minute}
+	assert.Nil(t, uploader.Sync(ctx, now))
+	assert.Equal(t, []string{"2021-04-01T00:00:00Z_etcd.zip", "2021-04-01T12:00:00Z_etcd.zip", "2021-04-02T00:00:00Z_etcd"}, listSnapshotNames(t, backend))
+	content, err := ioutil.ReadFile(filepath.Join(backendDir, "2021-04-01T12:00:00Z_etcd.zip"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2021-04-01T12:00:00Z_etcd.zip", string(content))
+
+	// snapshots are only uploaded once, recurring snapshots older than the retention are removed from the backend
+	assert.Nil(t, os.Remove(filepath.Join(backendDir, "2021-04-01T12:00:00Z_etcd.zip")))
+	old := now.Add(-73 * time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(backendDir, "2021-04-01T00:00:00Z_etcd.zip"), old, old))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(backendDir, "rke_etcd_snapshot_old.zip"), []byte("one-time"), 0600))
+	assert.Nil(t, os.Chtimes(filepath.Join(backendDir, "rke_etcd_snapshot_old.zip"), old, old))
+	assert.Nil(t, uploader.Sync(ctx, now.Add(2*time.Minute)))
+	assert.Equal(t, []string{"2021-04-02T00:00:00Z_etcd", "2021-04-02T12:00:00Z_etcd.zip", "rke_etcd_snapshot_old.zip"}, listSnapshotNames(t, backend))
+}
+
+func TestSnapshotUploaderEncryptedS3(t *testing.T) {
+	ctx := context.Background()
+	server, s3Config := startFakeS3Server(t)
+	ageKeyFile, _ := writeAgeKeyFile(t)
+	bc := &v3.BackupConfig{S3BackupConfig: s3Config, Encryption: &v3.BackupEncryptionConfig{KeyFile: ageKeyFile}}
+	// rke-tools can't encrypt, the recurring snapshots are uploaded by the snapshot uploader
+	assert.False(t, IsTransferredByRKETools(bc))
+	assert.True(t, HasSnapshotUploader(bc))
+
+	// the uploader on the etcd host only has the public key
+	hostConfig, err := HostConfig(bc)
+	assert.Nil(t, err)
+	assert.Empty(t, hostConfig.Encryption.KeyFile)
+	backend, err := NewBackend(hostConfig)
+	assert.Nil(t, err)
+	snapshotDir := t.TempDir()
+	content := []byte("PK\x03\x04 recurring snapshot")
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(snapshotDir, "2021-04-01T00:00:00Z_etcd.zip"), content, 0600))
+	uploader := &SnapshotUploader{Backend: backend, Dir: snapshotDir}
+	assert.Nil(t, uploader.Sync(ctx, time.Now()))
+
+	stored, ok := server.objects["snapshots/2021-04-01T00:00:00Z_etcd.zip"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.True(t, bytes.HasPrefix(stored, []byte(ageHeader)))
+	assert.NotContains(t, string(stored), string(content))
+
+	// rke restores it with the private key
+	backend, err = NewBackend(bc)
+	assert.Nil(t, err)
+	reader, err := backend.Download(ctx, "2021-04-01T00:00:00Z_etcd.zip")
+	if assert.Nil(t, err) {
+		downloaded, err := ioutil.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Nil(t, reader.Close())
+		assert.Equal(t, content, downloaded)
+	}
+}
+
+func TestHostConfig(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_rsa")
+	assert.Nil(t, ioutil.WriteFile(keyPath, []byte("private key"), 0600))
+	bc := &v3.BackupConfig{SFTPBackupConfig: &v3.SFTPBackupConfig{Address: "backup:22", SSHKeyPath: keyPath}}
+	hostConfig, err := HostConfig(bc)
+	assert.Nil(t, err)
+	// the key file is on the machine running rke
+	assert.Equal(t, "private key", hostConfig.SFTPBackupConfig.SSHKey)
+	assert.Empty(t, hostConfig.SFTPBackupConfig.SSHKeyPath)
+	assert.Equal(t, keyPath, bc.SFTPBackupConfig.SSHKeyPath)
+}
+
+func TestHasSnapshotUploader(t *testing.T) {
+	assert.False(t, HasSnapshotUploader(nil))
+	assert.False(t, HasSnapshotUploader(&v3.BackupConfig{S3BackupConfig: &v3.S3BackupConfig{}}))
+	assert.False(t, HasSnapshotUploader(&v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{}}))
+	assert.True(t, HasSnapshotUploader(&v3.BackupConfig{SFTPBackupConfig: &v3.SFTPBackupConfig{}}))
+	assert.True(t, HasSnapshotUploader(&v3.BackupConfig{AzureBlobBackupConfig: &v3.AzureBlobBackupConfig{}}))
+}
@@ -427,8 +427,8 @@ func (c *Cluster) deployMetricServer(ctx context.Context, data map[string]interf
 		return nil
 	}
 	log.Infof(ctx, "[addons] Setting up Metrics Server")
-	s := strings.Split(c.SystemImages.MetricsServer, ":")
-	versionTag := s[len(s)-1]
+	// the version is empty for an image without tag
+	versionTag, _ := util.GetImageTagFromImage(c.SystemImages.MetricsServer)
 
 	MetricsServerConfig := MetricsServerOptions{
 		MetricsServerImage: c.SystemImages.MetricsServer,
@@ -607,9 +607,8 @@ func (c *Cluster) deployIngress(ctx context.Context, data map[string]interface{}
 	}
 	// since nginx ingress controller 0.16.0, it can be run as non-root and doesn't require privileged anymore.
 	// So we can use securityContext instead of setting privileges via initContainer.
-	ingressSplits := strings.SplitN(c.SystemImages.Ingress, ":", 2)
-	if len(ingressSplits) == 2 {
-		version := strings.Split(ingressSplits[1], "-")[0]
+	if ingressTag, err := util.GetImageTagFromImage(c.SystemImages.Ingress); err == nil {
+		version := strings.Split(ingressTag, "-")[0]
 		if version < "0.16.0" {
 			ingressConfig.AlpineImage = c.SystemImages.Alpine
 		}
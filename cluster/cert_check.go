@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/pki/cert"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// CheckCertificates reports the expiry and the missing SANs of the certificates of the state file, and the
+// certificates of the nodes and of the local kubeconfig that drifted from the state file
+func (c *Cluster) CheckCertificates(ctx context.Context, fullState *FullState, warnWithin time.Duration, checkNodes bool) ([]pki.CertificateCheck, error) {
+	now := time.Now()
+	stateCerts := fullState.CurrentState.CertificatesBundle
+	if len(stateCerts) == 0 {
+		stateCerts = fullState.DesiredState.CertificatesBundle
+	}
+	if len(stateCerts) == 0 {
+		return nil, fmt.Errorf("Failed to check certificates: no certificates found in state file [%s]", c.StateFilePath)
+	}
+	checks, err := c.checkStateCertificates(stateCerts, now, warnWithin)
+	if err != nil {
+		return nil, err
+	}
+	if checkNodes {
+		for _, host := range hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts) {
+			hostChecks, err := c.checkHostCertificates(ctx, host, stateCerts, now, warnWithin)
+			if err != nil {
+				return nil, err
+			}
+			checks = append(checks, hostChecks...)
+		}
+	}
+	kubeConfigChecks, err := checkKubeConfigCertificates(c.LocalKubeConfigPath, stateCerts, now, warnWithin)
+	if err != nil {
+		return nil, err
+	}
+	return append(checks, kubeConfigChecks...), nil
+}
+
+func (c *Cluster) checkStateCertificates(stateCerts map[string]pki.CertificatePKI, now time.Time, warnWithin time.Duration) ([]pki.CertificateCheck, error) {
+	var checks []pki.CertificateCheck
+	for _, certName := range sortedCertNames(stateCerts) {
+		// the service account token key is the key of the kube-apiserver certificate
+		if certName == pki.ServiceAccountTokenKeyName || stateCerts[certName].Certificate == nil {
+			continue
+		}
+		expected, err := pki.GetExpectedAltNames(&c.RancherKubernetesEngineConfig, certName)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, pki.CheckCertificate(certName, pki.StateLocation, stateCerts[certName].Certificate, expected, now, warnWithin))
+	}
+	return checks, nil
+}
+
+func (c *Cluster) checkHostCertificates(ctx context.Context, host *hosts.Host, stateCerts map[string]pki.CertificatePKI, now time.Time, warnWithin time.Duration) ([]pki.CertificateCheck, error) {
+	nodeCerts := pki.GenerateRKENodeCerts(ctx, c.RancherKubernetesEngineConfig, host.Address, stateCerts)
+	var certNames []string
+	for _, certName := range sortedCertNames(nodeCerts) {
+		if nodeCerts[certName].Certificate != nil {
+			certNames = append(certNames, certName)
+		}
+	}
+	log.Infof(ctx, "[certificates] Reading %d certificates deployed on host [%s]", len(certNames), host.Address)
+	deployed, err := pki.FetchDeployedCertificatesFromHost(ctx, host, certNames, c.SystemImages.Alpine, c.PrivateRegistriesMap)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the certificates of host [%s]: %v", host.Address, err)
+	}
+	var checks []pki.CertificateCheck
+	for _, certName := range certNames {
+		if deployed[certName] == nil {
+			checks = append(checks, pki.CertificateCheck{Name: certName, Location: host.Address, Problems: []string{"certificate is not deployed on the node"}})
+			continue
+		}
+		check := pki.CheckCertificate(certName, host.Address, deployed[certName], nil, now, warnWithin)
+		pki.CompareCertificate(&check, deployed[certName], stateCerts[certName].Certificate)
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// checkKubeConfigCertificates checks the CA and the client certificate of the admin kubeconfig, a missing kubeconfig is
+// not checked
+func checkKubeConfigCertificates(kubeConfigPath string, stateCerts map[string]pki.CertificatePKI, now time.Time, warnWithin time.Duration) ([]pki.CertificateCheck, error) {
+	if _, err := os.Stat(kubeConfigPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+	kubeConfig, err := clientcmd.LoadFromFile(kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read kubeconfig [%s]: %v", kubeConfigPath, err)
+	}
+	var checks []pki.CertificateCheck
+	for _, cluster := range kubeConfig.Clusters {
+		if len(cluster.CertificateAuthorityData) == 0 {
+			continue
+		}
+		check, err := checkKubeConfigCertificate(pki.CACertName, kubeConfigPath, cluster.CertificateAuthorityData, stateCerts[pki.CACertName].Certificate, now, warnWithin)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	for _, authInfo := range kubeConfig.AuthInfos {
+		if len(authInfo.ClientCertificateData) == 0 {
+			continue
+		}
+		check, err := checkKubeConfigCertificate(pki.KubeAdminCertName, kubeConfigPath, authInfo.ClientCertificateData, stateCerts[pki.KubeAdminCertName].Certificate, now, warnWithin)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func checkKubeConfigCertificate(certName, kubeConfigPath string, certPEM []byte, stateCert *x509.Certificate, now time.Time, warnWithin time.Duration) (pki.CertificateCheck, error) {
+	certs, err := cert.ParseCertsPEM(certPEM)
+	if err != nil {
+		return pki.CertificateCheck{}, fmt.Errorf("Failed to parse certificate [%s] of kubeconfig [%s]: %v", certName, kubeConfigPath, err)
+	}
+	check := pki.CheckCertificate(certName, kubeConfigPath, certs[0], nil, now, warnWithin)
+	pki.CompareCertificate(&check, certs[0], stateCert)
+	return check, nil
+}
+
+func sortedCertNames(certs map[string]pki.CertificatePKI) []string {
+	var certNames []string
+	for certName := range certs {
+		certNames = append(certNames, certName)
+	}
+	sort.Strings(certNames)
+	return certNames
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/rancher/rke/docker/dockertest"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/pki/cert"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// deployCertificates makes the certificates readable by the certificate checker of the host, like the certificates
+// deployed in /etc/kubernetes/ssl
+func deployCertificates(t *testing.T, runtime *dockertest.Runtime, image string, certs map[string]pki.CertificatePKI) {
+	ctx := context.Background()
+	_, err := runtime.ContainerCreate(ctx, &container.Config{Image: image}, &container.HostConfig{RestartPolicy: container.RestartPolicy{Name: "always"}}, nil, nil, pki.CertCheckerContainer)
+	assert.Nil(t, err)
+	assert.Nil(t, runtime.ContainerStart(ctx, pki.CertCheckerContainer, types.ContainerStartOptions{}))
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for certName, certificate := range certs {
+		content := cert.EncodeCertPEM(certificate.Certificate)
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: filepath.Base(pki.GetCertPath(certName)), Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write(content)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, runtime.CopyToContainer(ctx, pki.CertCheckerContainer, filepath.Dir(pki.GetCertPath(pki.CACertName)), &buf, types.CopyToContainerOptions{}))
+}
+
+func problemsByLocation(checks []pki.CertificateCheck) map[string]string {
+	problems := map[string]string{}
+	for _, check := range checks {
+		if len(check.Problems) > 0 {
+			problems[check.Name+"@"+check.Location] = strings.Join(check.Problems, ", ")
+		}
+	}
+	return problems
+}
+
+func TestCheckCertificates(t *testing.T) {
+	ctx := context.Background()
+	c := &Cluster{RancherKubernetesEngineConfig: v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{Address: "10.0.0.1", HostnameOverride: "node1", Role: []string{"controlplane", "etcd"}},
+			{Address: "10.0.0.2", HostnameOverride: "node2", Role: []string{"worker"}},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: "10.43.0.0/16"},
+			Kubelet: v3.KubeletService{ClusterDomain: "cluster.local"},
+		},
+	}}
+	c.SystemImages.Alpine = "rancher/rke-tools:v0.1.78"
+	c.LocalKubeConfigPath = filepath.Join(t.TempDir(), "kube_config_cluster.yml")
+	c.StateFilePath = "cluster.rkestate"
+	stateCerts, err := pki.GenerateRKECerts(ctx, c.RancherKubernetesEngineConfig, "", "")
+	assert.Nil(t, err)
+	fullState := &FullState{CurrentState: State{CertificatesBundle: stateCerts}}
+
+	// the certificates of the state file are valid and have all their SANs
+	checks, err := c.CheckCertificates(ctx, fullState, 30*24*time.Hour, false)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, checks)
+	assert.Empty(t, problemsByLocation(checks))
+	// all of them expire within 20 years
+	checks, err = c.CheckCertificates(ctx, fullState, 20*365*24*time.Hour, false)
+	assert.Nil(t, err)
+	for _, check := range checks {
+		assert.True(t, check.Expiring, check.Name)
+		assert.False(t, check.HasErrors(), check.Name)
+	}
+
+	// a SAN added to the configuration is missing from the kube-apiserver certificate
+	c.Authentication.SANs = []string{"api.example.com"}
+	checks, err = c.CheckCertificates(ctx, fullState, 30*24*time.Hour, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{pki.KubeAPICertName + "@" + pki.StateLocation: "missing SAN api.example.com"}, problemsByLocation(checks))
+	c.Authentication.SANs = nil
+
+	// the kubeconfig has a client certificate of another cluster
+	otherCerts, err := pki.GenerateRKECerts(ctx, c.RancherKubernetesEngineConfig, "", "")
+	assert.Nil(t, err)
+	otherKeyPEM, err := cert.EncodePrivateKeyPEM(otherCerts[pki.KubeAdminCertName].Key)
+	assert.Nil(t, err)
+	kubeConfig := pki.GetKubeConfigX509WithData("https://10.0.0.1:6443", "local", pki.KubeAdminCertName,
+		string(cert.EncodeCertPEM(stateCerts[pki.CACertName].Certificate)),
+		string(cert.EncodeCertPEM(otherCerts[pki.KubeAdminCertName].Certificate)),
+		string(otherKeyPEM))
+	assert.Nil(t, ioutil.WriteFile(c.LocalKubeConfigPath, []byte(kubeConfig), 0600))
+	checks, err = c.CheckCertificates(ctx, fullState, 30*24*time.Hour, false)
+	assert.Nil(t, err)
+	problems := problemsByLocation(checks)
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[pki.KubeAdminCertName+"@"+c.LocalKubeConfigPath], "differs from the state file")
+	assert.Nil(t, ioutil.WriteFile(c.LocalKubeConfigPath, []byte(pki.GetKubeConfigX509WithData("https://10.0.0.1:6443", "local", pki.KubeAdminCertName,
+		string(cert.EncodeCertPEM(stateCerts[pki.CACertName].Certificate)),
+		string(cert.EncodeCertPEM(stateCerts[pki.KubeAdminCertName].Certificate)),
+		stateCerts[pki.KubeAdminCertName].KeyPEM)), 0600))
+
+	// the worker has the CA and the kube-proxy certificate of the state, the node certificate of another cluster and misses
+	// the other certificates
+	runtime := dockertest.NewRuntime("10.0.0.2", nil)
+	runtime.AddImage(c.SystemImages.Alpine)
+	deployCertificates(t, runtime, c.SystemImages.Alpine, map[string]pki.CertificatePKI{
+		pki.CACertName:        stateCerts[pki.CACertName],
+		pki.KubeNodeCertName:  otherCerts[pki.KubeNodeCertName],
+		pki.KubeProxyCertName: stateCerts[pki.KubeProxyCertName],
+	})
+	c.WorkerHosts = []*hosts.Host{{RKEConfigNode: c.Nodes[1], DClient: runtime}}
+	checks, err = c.CheckCertificates(ctx, fullState, 30*24*time.Hour, true)
+	assert.Nil(t, err)
+	problems = problemsByLocation(checks)
+	assert.Contains(t, problems[pki.KubeNodeCertName+"@10.0.0.2"], "differs from the state file")
+	for key, problem := range problems {
+		if key != pki.KubeNodeCertName+"@10.0.0.2" {
+			assert.Equal(t, "certificate is not deployed on the node", problem, key)
+		}
+	}
+	assert.NotContains(t, problems, pki.CACertName+"@10.0.0.2")
+	assert.NotContains(t, problems, pki.KubeProxyCertName+"@10.0.0.2")
+	// the checker is removed once the certificates are read
+	assert.Empty(t, runtime.ContainerNames())
+}
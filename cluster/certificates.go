@@ -2,9 +2,10 @@ package cluster
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/k8s"
@@ -80,13 +81,16 @@ func GetClusterCertsFromKubernetes(ctx context.Context, kubeCluster *Cluster) (m
 			return nil, fmt.Errorf("certificate or key of %s is not found", certName)
 		}
 		certificatePEM := string(cert.EncodeCertPEM(secretCert[0]))
-		keyPEM := string(cert.EncodePrivateKeyPEM(secretKey.(*rsa.PrivateKey)))
+		keyPEM, err := cert.EncodePrivateKeyPEM(secretKey.(crypto.Signer))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode private key of %s: %v", certName, err)
+		}
 
 		certMap[certName] = pki.CertificatePKI{
 			Certificate:    secretCert[0],
-			Key:            secretKey.(*rsa.PrivateKey),
+			Key:            secretKey.(crypto.Signer),
 			CertificatePEM: certificatePEM,
-			KeyPEM:         keyPEM,
+			KeyPEM:         string(keyPEM),
 			Config:         secretConfig,
 			EnvName:        string(secret.Data["EnvName"]),
 			ConfigEnvName:  string(secret.Data["ConfigEnvName"]),
@@ -135,7 +139,7 @@ func RotateRKECertificates(ctx context.Context, c *Cluster, flags ExternalFlags,
 	rotateFlags := c.RancherKubernetesEngineConfig.RotateCertificates
 	if rotateFlags.CACertificates {
 		// rotate CA cert and RequestHeader CA cert
-		if err := pki.GenerateRKECACerts(ctx, c.Certificates, flags.ClusterFilePath, flags.ConfigDir); err != nil {
+		if err := pki.GenerateRKECACerts(ctx, c.Certificates, c.RancherKubernetesEngineConfig, flags.ClusterFilePath, flags.ConfigDir); err != nil {
 			return err
 		}
 		rotateFlags.Services = nil
@@ -154,11 +158,15 @@ func RotateRKECertificates(ctx context.Context, c *Cluster, flags ExternalFlags,
 	if len(rotateFlags.Services) == 0 || (len(rotateFlags.Services) == 1 && rotateFlags.Services[0] == "") {
 		// do not rotate service account token
 		if c.Certificates[pki.ServiceAccountTokenKeyName].Key != nil {
-			serviceAccountTokenKey = string(cert.EncodePrivateKeyPEM(c.Certificates[pki.ServiceAccountTokenKeyName].Key))
+			encodedKey, err := cert.EncodePrivateKeyPEM(c.Certificates[pki.ServiceAccountTokenKeyName].Key)
+			if err != nil {
+				return fmt.Errorf("Failed to encode the service account token key: %v", err)
+			}
+			serviceAccountTokenKey = string(encodedKey)
 		}
 		// check for legacy clusters prior to requestheaderca
 		if c.Certificates[pki.RequestHeaderCACertName].Certificate == nil {
-			if err := pki.GenerateRKERequestHeaderCACert(ctx, c.Certificates, flags.ClusterFilePath, flags.ConfigDir); err != nil {
+			if err := pki.GenerateRKERequestHeaderCACert(ctx, c.Certificates, c.RancherKubernetesEngineConfig, flags.ClusterFilePath, flags.ConfigDir); err != nil {
 				return err
 			}
 		}
@@ -175,7 +183,7 @@ func RotateRKECertificates(ctx context.Context, c *Cluster, flags ExternalFlags,
 				pki.ServiceAccountTokenKeyName,
 				"",
 				c.Certificates[pki.ServiceAccountTokenKeyName].Certificate,
-				privateKey.(*rsa.PrivateKey), nil)
+				privateKey.(crypto.Signer), nil)
 		}
 	}
 	clusterState.DesiredState.CertificatesBundle = c.Certificates
@@ -219,7 +227,37 @@ func compareCerts(ctx context.Context, kubeCluster, currentCluster *Cluster) {
 				return
 			}
 		}
+		// certificates renewed before their expiry
+		for _, certName := range sortedCertNames(currentCluster.Certificates) {
+			currentCert := currentCluster.Certificates[certName]
+			desiredCert, ok := kubeCluster.Certificates[certName]
+			if !ok || currentCert.Certificate == nil || !pki.IsRenewableCertificate(certName) {
+				continue
+			}
+			if desiredCert.CertificatePEM != currentCert.CertificatePEM {
+				log.Infof(ctx, "[certificates] %s certificate renewed, force deploying certs", certName)
+				kubeCluster.ForceDeployCerts = true
+				return
+			}
+		}
+	}
+}
+
+// renewExpiringCertificates renews the certificates expiring within the renewal window of the cluster
+func renewExpiringCertificates(ctx context.Context, kubeCluster *Cluster, certs map[string]pki.CertificatePKI, flags ExternalFlags) error {
+	renewal := kubeCluster.CertificateRenewal
+	if renewal == nil || renewal.Disabled {
+		return nil
 	}
+	renewBefore := time.Now().Add(time.Duration(renewal.RenewBeforeDays) * 24 * time.Hour)
+	expiring := pki.GetExpiringCertificates(certs, renewBefore)
+	if len(expiring) == 0 {
+		return nil
+	}
+	if err := pki.RenewCertificates(ctx, certs, kubeCluster.RancherKubernetesEngineConfig, expiring, flags.ClusterFilePath, flags.ConfigDir); err != nil {
+		return fmt.Errorf("Failed to renew expiring certificates: %v", err)
+	}
+	return nil
 }
 
 func (c *Cluster) IsKubeletGenerateServingCertificateEnabled() bool {
@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/sirupsen/logrus"
+)
+
+// Checkpoint records the phases and worker hosts completed by rke up for a desired state,
+// so an interrupted run can be resumed with --resume
+type Checkpoint struct {
+	DesiredStateHash string   `json:"desiredStateHash,omitempty"`
+	Phases           []string `json:"phases,omitempty"`
+	WorkerHosts      []string `json:"workerHosts,omitempty"`
+}
+
+// GetCheckpoint returns the checkpoint to run rke up with. The stored checkpoint is only reused when
+// resuming and when it was recorded for the same desired state, otherwise a new one is started.
+func (s *FullState) GetCheckpoint(ctx context.Context, resume bool) (*Checkpoint, error) {
+	hash, err := s.getDesiredStateHash()
+	if err != nil {
+		return nil, err
+	}
+	if resume {
+		switch {
+		case s.Checkpoint == nil:
+			log.Infof(ctx, "[checkpoint] No checkpoint found in state file, running all phases")
+		case s.Checkpoint.DesiredStateHash != hash:
+			log.Warnf(ctx, "[checkpoint] Checkpoint was recorded for a different desired state, running all phases")
+		default:
+			log.Infof(ctx, "[checkpoint] Resuming from checkpoint, completed phases: %v", s.Checkpoint.Phases)
+			return s.Checkpoint, nil
+		}
+	}
+	s.Checkpoint = &Checkpoint{DesiredStateHash: hash}
+	return s.Checkpoint, nil
+}
+
+func (s *FullState) getDesiredStateHash() (string, error) {
+	certs := make([]string, 0, len(s.DesiredState.CertificatesBundle))
+	for name, certificate := range s.DesiredState.CertificatesBundle {
+		// the kubeconfig of the kube-admin certificate is rebuilt on every run and is never deployed to the hosts
+		if name == pki.KubeAdminCertName {
+			continue
+		}
+		certs = append(certs, name+"="+certificate.CertificatePEM)
+	}
+	sort.Strings(certs)
+	desiredState, err := json.Marshal(struct {
+		RKEConfig        interface{} `json:"rkeConfig"`
+		Certificates     []string    `json:"certificates"`
+		EncryptionConfig string      `json:"encryptionConfig"`
+	}{s.DesiredState.RancherKubernetesEngineConfig, certs, s.DesiredState.EncryptionConfig})
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal desired state: %v", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(desiredState)), nil
+}
+
+func (c *Checkpoint) IsComplete(phase string) bool {
+	if c == nil {
+		return false
+	}
+	for _, p := range c.Phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checkpoint) IsWorkerHostComplete(host *hosts.Host) bool {
+	if c == nil {
+		return false
+	}
+	for _, address := range c.WorkerHosts {
+		if address == host.Address {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checkpoint) complete(phase string) {
+	if !c.IsComplete(phase) {
+		c.Phases = append(c.Phases, phase)
+	}
+}
+
+// SaveCheckpoint marks the phase as complete and writes it to the state file
+func (c *Cluster) SaveCheckpoint(ctx context.Context, fullState *FullState, phase string) error {
+	if c.Checkpoint == nil {
+		return nil
+	}
+	c.Checkpoint.complete(phase)
+	fullState.Checkpoint = c.Checkpoint
+	logrus.Debugf("[checkpoint] Phase [%s] completed", phase)
+	return fullState.WriteStateFile(ctx, c.StateFilePath)
+}
+
+// SetUpHostsWithCheckpoint deploys the certificates and the configuration files to the hosts, unless a resumed run
+// already did. The local kubeconfig is not part of the checkpoint, it's rebuilt either way.
+func (c *Cluster) SetUpHostsWithCheckpoint(ctx context.Context, flags ExternalFlags, fullState *FullState) error {
+	if c.Checkpoint.IsComplete(PhaseCertificates) {
+		log.Infof(ctx, "[checkpoint] Certificates were deployed to the hosts in a previous run, skipping")
+		return rebuildLocalAdminConfig(ctx, c)
+	}
+	if err := c.SetUpHosts(ctx, flags); err != nil {
+		return err
+	}
+	return c.SaveCheckpoint(ctx, fullState, PhaseCertificates)
+}
+
+// SaveWorkerCheckpoint records the hosts that finished the worker plane during this run and writes them
+// to the state file, it's called even if the worker plane failed so the next run can skip them
+func (c *Cluster) SaveWorkerCheckpoint(ctx context.Context, fullState *FullState) error {
+	if c.Checkpoint == nil {
+		return nil
+	}
+	for _, host := range hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts) {
+		if host.WorkerDeployed && !c.Checkpoint.IsWorkerHostComplete(host) {
+			c.Checkpoint.WorkerHosts = append(c.Checkpoint.WorkerHosts, host.Address)
+		}
+	}
+	fullState.Checkpoint = c.Checkpoint
+	return fullState.WriteStateFile(ctx, c.StateFilePath)
+}
+
+// ClearCheckpoint removes the checkpoint from the state file after a successful run
+func (c *Cluster) ClearCheckpoint(ctx context.Context, fullState *FullState) error {
+	c.Checkpoint = nil
+	if fullState.Checkpoint == nil {
+		return nil
+	}
+	fullState.Checkpoint = nil
+	return fullState.WriteStateFile(ctx, c.StateFilePath)
+}
@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/pki"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCheckpointTestState(t *testing.T) *FullState {
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		ClusterName: "local",
+		Nodes: []v3.RKEConfigNode{
+			{Address: "127.0.0.1", Role: []string{"controlplane", "etcd", "worker"}},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: "10.43.0.0/16"},
+			Kubelet: v3.KubeletService{ClusterDomain: "cluster.local"},
+		},
+	}
+	certs, err := pki.GenerateRKECerts(context.Background(), *rkeConfig, "", "")
+	assert.Nil(t, err)
+	return &FullState{DesiredState: State{
+		RancherKubernetesEngineConfig: rkeConfig,
+		CertificatesBundle:            certs,
+		EncryptionConfig:              "encryption config",
+	}}
+}
+
+func TestGetCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	fullState := newCheckpointTestState(t)
+	checkpoint, err := fullState.GetCheckpoint(ctx, false)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, checkpoint.DesiredStateHash)
+	assert.False(t, checkpoint.IsComplete(PhaseCertificates))
+	checkpoint.complete(PhaseCertificates)
+	checkpoint.complete(PhaseCertificates)
+	assert.Equal(t, []string{PhaseCertificates}, checkpoint.Phases)
+
+	// the checkpoint is only reused when resuming
+	resumed, err := fullState.GetCheckpoint(ctx, true)
+	assert.Nil(t, err)
+	assert.True(t, resumed.IsComplete(PhaseCertificates))
+	// the kube-admin kubeconfig is rebuilt on every run
+	kubeAdmin := fullState.DesiredState.CertificatesBundle[pki.KubeAdminCertName]
+	kubeAdmin.CertificatePEM = "regenerated"
+	fullState.DesiredState.CertificatesBundle[pki.KubeAdminCertName] = kubeAdmin
+	resumed, err = fullState.GetCheckpoint(ctx, true)
+	assert.Nil(t, err)
+	assert.True(t, resumed.IsComplete(PhaseCertificates))
+
+	tests := []struct {
+		name   string
+		change func(s *FullState)
+	}{
+		{"cluster configuration", func(s *FullState) { s.DesiredState.RancherKubernetesEngineConfig.Version = "v1.20.5-rancher1-1" }},
+		{"nodes", func(s *FullState) {
+			s.DesiredState.RancherKubernetesEngineConfig.Nodes = append(s.DesiredState.RancherKubernetesEngineConfig.Nodes, v3.RKEConfigNode{Address: "127.0.0.2", Role: []string{"worker"}})
+		}},
+		{"certificate", func(s *FullState) {
+			kubeAPI := s.DesiredState.CertificatesBundle[pki.KubeAPICertName]
+			kubeAPI.CertificatePEM = "rotated"
+			s.DesiredState.CertificatesBundle[pki.KubeAPICertName] = kubeAPI
+		}},
+		{"encryption configuration", func(s *FullState) { s.DesiredState.EncryptionConfig = "rotated encryption config" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fullState := newCheckpointTestState(t)
+			checkpoint, err := fullState.GetCheckpoint(ctx, false)
+			assert.Nil(t, err)
+			checkpoint.complete(PhaseControlPlane)
+			hash := checkpoint.DesiredStateHash
+
+			tt.change(fullState)
+			resumed, err := fullState.GetCheckpoint(ctx, true)
+			assert.Nil(t, err)
+			assert.NotEqual(t, hash, resumed.DesiredStateHash)
+			assert.False(t, resumed.IsComplete(PhaseControlPlane))
+		})
+	}
+}
+
+func TestSaveCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	var nilCheckpoint *Checkpoint
+	assert.False(t, nilCheckpoint.IsComplete(PhaseCertificates))
+	assert.False(t, nilCheckpoint.IsWorkerHostComplete(&hosts.Host{}))
+
+	fullState := newCheckpointTestState(t)
+	checkpoint, err := fullState.GetCheckpoint(ctx, false)
+	assert.Nil(t, err)
+	worker := &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: "127.0.0.1"}, WorkerDeployed: true}
+	c := &Cluster{
+		Checkpoint:    checkpoint,
+		StateFilePath: filepath.Join(t.TempDir(), "cluster.rkestate"),
+		WorkerHosts:   []*hosts.Host{worker, {RKEConfigNode: v3.RKEConfigNode{Address: "127.0.0.2"}}},
+	}
+	assert.Nil(t, c.SaveCheckpoint(ctx, fullState, PhaseCertificates))
+	assert.Nil(t, c.SaveWorkerCheckpoint(ctx, fullState))
+	readState, err := ReadStateFile(ctx, c.StateFilePath)
+	assert.Nil(t, err)
+	resumed, err := readState.GetCheckpoint(ctx, true)
+	assert.Nil(t, err)
+	assert.True(t, resumed.IsComplete(PhaseCertificates))
+	assert.True(t, resumed.IsWorkerHostComplete(worker))
+	assert.False(t, resumed.IsWorkerHostComplete(c.WorkerHosts[1]))
+
+	assert.Nil(t, c.ClearCheckpoint(ctx, fullState))
+	readState, err = ReadStateFile(ctx, c.StateFilePath)
+	assert.Nil(t, err)
+	assert.Nil(t, readState.Checkpoint)
+}
+
+func TestSetUpHostsWithCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	fullState := newCheckpointTestState(t)
+	checkpoint, err := fullState.GetCheckpoint(ctx, false)
+	assert.Nil(t, err)
+	checkpoint.complete(PhaseCertificates)
+	kubeConfigPath := filepath.Join(t.TempDir(), "kube_config_cluster.yml")
+	c := &Cluster{
+		RancherKubernetesEngineConfig: *fullState.DesiredState.RancherKubernetesEngineConfig,
+		Checkpoint:                    checkpoint,
+		Certificates:                  fullState.DesiredState.CertificatesBundle,
+		LocalKubeConfigPath:           kubeConfigPath,
+		StateFilePath:                 filepath.Join(t.TempDir(), "cluster.rkestate"),
+		// the hosts have no docker client, deploying to them would fail
+		AuthnStrategies:   map[string]bool{AuthnX509Provider: true},
+		ControlPlaneHosts: []*hosts.Host{{RKEConfigNode: v3.RKEConfigNode{Address: "127.0.0.1"}}},
+	}
+	// a resumed run skips the hosts but still writes the local kubeconfig
+	assert.Nil(t, c.SetUpHostsWithCheckpoint(ctx, ExternalFlags{}, fullState))
+	kubeConfig, err := ioutil.ReadFile(kubeConfigPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(kubeConfig), "https://127.0.0.1:6443")
+	assert.Equal(t, string(kubeConfig), c.Certificates[pki.KubeAdminCertName].Config)
+}
@@ -62,6 +62,7 @@ type Cluster struct {
 	LocalKubeConfigPath              string
 	LocalConnDialerFactory           hosts.DialerFactory
 	PrivateRegistriesMap             map[string]v3.PrivateRegistry
+	RuntimeFactory                   hosts.RuntimeFactory
 	StateFilePath                    string
 	UpdateWorkersOnly                bool
 	UseKubectlDeploy                 bool
@@ -71,6 +72,10 @@ type Cluster struct {
 	NewHosts                         map[string]bool
 	MaxUnavailableForWorkerNodes     int
 	MaxUnavailableForControlNodes    int
+	Checkpoint                       *Checkpoint
+	HostKeyVerifier                  *hosts.HostKeyVerifier
+	// LockedImageIDs are the image IDs locked with the pinned system images by pinned image
+	LockedImageIDs map[string]string
 }
 
 type encryptionConfig struct {
@@ -113,6 +118,18 @@ const (
 	nodelocalAddon  = "nodelocal"
 )
 
+// Phases of rke up, used to report progress
+const (
+	PhaseTunnelHosts   = "tunnel-hosts"
+	PhaseCertificates  = "certificates"
+	PhaseReconcile     = "reconcile"
+	PhasePrePullImages = "pre-pull-images"
+	PhaseControlPlane  = "controlplane"
+	PhaseSaveState     = "save-state"
+	PhaseWorkerPlane   = "workerplane"
+	PhaseAddons        = "addons"
+)
+
 func (c *Cluster) DeployControlPlane(ctx context.Context, svcOptionData map[string]*v3.KubernetesServicesOptions, reconcileCluster bool) (string, error) {
 	kubeClient, err := k8s.NewClient(c.LocalKubeConfigPath, c.K8sWrapTransport)
 	if err != nil {
@@ -239,7 +256,7 @@ func (c *Cluster) UpgradeControlPlane(ctx context.Context, kubeClient *kubernete
 }
 
 func (c *Cluster) DeployWorkerPlane(ctx context.Context, svcOptionData map[string]*v3.KubernetesServicesOptions, reconcileCluster bool) (string, error) {
-	var workerOnlyHosts, etcdAndWorkerHosts []*hosts.Host
+	var workerOnlyHosts, etcdAndWorkerHosts, workerPlaneHosts []*hosts.Host
 	kubeClient, err := k8s.NewClient(c.LocalKubeConfigPath, c.K8sWrapTransport)
 	if err != nil {
 		return "", fmt.Errorf("failed to initialize new kubernetes client: %v", err)
@@ -254,6 +271,11 @@ func (c *Cluster) DeployWorkerPlane(ctx context.Context, svcOptionData map[strin
 			return "", err
 		}
 		workerNodePlanMap[host.Address] = BuildRKEConfigNodePlan(ctx, c, host, svcOptions)
+		if c.Checkpoint.IsWorkerHostComplete(host) {
+			log.Infof(ctx, "[%s] Host [%s] already completed the worker plane in a previous run, skipping", services.WorkerRole, host.Address)
+			continue
+		}
+		workerPlaneHosts = append(workerPlaneHosts, host)
 		if host.IsControl {
 			continue
 		}
@@ -268,7 +290,7 @@ func (c *Cluster) DeployWorkerPlane(ctx context.Context, svcOptionData map[strin
 	}
 
 	if !reconcileCluster {
-		if err := services.RunWorkerPlane(ctx, allHosts,
+		if err := services.RunWorkerPlane(ctx, workerPlaneHosts,
 			c.LocalConnDialerFactory,
 			c.PrivateRegistriesMap,
 			workerNodePlanMap,
@@ -743,6 +765,7 @@ func InitClusterObject(ctx context.Context, rkeConfig *v3.RancherKubernetesEngin
 	if err := c.setCloudProvider(); err != nil {
 		return nil, fmt.Errorf("Failed to register cloud provider: %v", err)
 	}
+	c.HostKeyVerifier = c.newHostKeyVerifier(ctx)
 	// set hosts groups
 	if err := c.InvertIndexHosts(); err != nil {
 		return nil, fmt.Errorf("Failed to classify hosts from config file: %v", err)
@@ -769,11 +792,12 @@ func (c *Cluster) setNetworkOptions() error {
 func (c *Cluster) SetupDialers(ctx context.Context, dailersOptions hosts.DialersOptions) error {
 	c.DockerDialerFactory = dailersOptions.DockerDialerFactory
 	c.LocalConnDialerFactory = dailersOptions.LocalConnDialerFactory
+	c.RuntimeFactory = dailersOptions.RuntimeFactory
 	c.K8sWrapTransport = dailersOptions.K8sWrapTransport
 	// Create k8s wrap transport for bastion host
-	if len(c.BastionHost.Address) > 0 {
+	if c.usesBastionHost() {
 		var err error
-		c.K8sWrapTransport, err = hosts.BastionHostWrapTransport(c.BastionHost)
+		c.K8sWrapTransport, err = hosts.BastionHostWrapTransport(c.BastionHost, c.ControlPlaneHosts, c.HostKeyVerifier)
 		if err != nil {
 			return err
 		}
@@ -787,6 +811,19 @@ func (c *Cluster) SetupDialers(ctx context.Context, dailersOptions hosts.Dialers
 	return nil
 }
 
+// usesBastionHost returns true if the cluster or any node is reached through a bastion host
+func (c *Cluster) usesBastionHost() bool {
+	if len(c.BastionHost.Address) > 0 {
+		return true
+	}
+	for _, node := range c.Nodes {
+		if node.BastionHost != nil && len(node.BastionHost.Address) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func RebuildKubeconfig(ctx context.Context, kubeCluster *Cluster) error {
 	return rebuildLocalAdminConfig(ctx, kubeCluster)
 }
@@ -810,8 +847,11 @@ func rebuildLocalAdminConfig(ctx context.Context, kubeCluster *Cluster) error {
 			kubeURL := fmt.Sprintf("https://%s:6443", cpHost.Address)
 			caData := string(cert.EncodeCertPEM(caCrt))
 			crtData := string(cert.EncodeCertPEM(currentKubeConfig.Certificate))
-			keyData := string(cert.EncodePrivateKeyPEM(currentKubeConfig.Key))
-			newConfig = pki.GetKubeConfigX509WithData(kubeURL, kubeCluster.ClusterName, pki.KubeAdminCertName, caData, crtData, keyData)
+			keyData, err := cert.EncodePrivateKeyPEM(currentKubeConfig.Key)
+			if err != nil {
+				return fmt.Errorf("Failed to encode the key of the local admin config: %v", err)
+			}
+			newConfig = pki.GetKubeConfigX509WithData(kubeURL, kubeCluster.ClusterName, pki.KubeAdminCertName, caData, crtData, string(keyData))
 		}
 		if err := pki.DeployAdminConfig(ctx, newConfig, kubeCluster.LocalKubeConfigPath); err != nil {
 			return fmt.Errorf("Failed to redeploy local admin config with new host: %v", err)
@@ -1170,10 +1210,11 @@ func (c *Cluster) getRKEToolsWindowsEntryPoint() []string {
 }
 
 func (c *Cluster) getRKEToolsLinuxEntryPoint() []string {
-	v := strings.Split(c.SystemImages.KubernetesServicesSidecar, ":")
-	last := v[len(v)-1]
-
-	sv, err := util.StrToSemVer(last)
+	tag, err := util.GetImageTagFromImage(c.SystemImages.KubernetesServicesSidecar)
+	if err != nil {
+		return []string{DefaultToolsEntrypoint}
+	}
+	sv, err := util.StrToSemVer(tag)
 	if err != nil {
 		return []string{DefaultToolsEntrypoint}
 	}
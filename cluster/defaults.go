@@ -56,6 +56,8 @@ const (
 	DefaultEtcdBackupConfigRetention     = 6
 	DefaultEtcdBackupConfigTimeout       = docker.WaitTimeout
 
+	DefaultCertificateRenewBeforeDays = 30
+
 	DefaultDNSProvider = "kube-dns"
 	K8sVersionCoreDNS  = "1.14.0"
 
@@ -120,6 +122,7 @@ const (
 	DefaultMaxUnavailableControlplane = "1"
 	DefaultNodeDrainTimeout           = 120
 	DefaultNodeDrainGracePeriod       = -1
+	DefaultUpgradePauseTimeout        = 300
 	DefaultHTTPPort                   = 80
 	DefaultHTTPSPort                  = 443
 	DefaultNetworkMode                = "hostNetwork"
@@ -153,6 +156,7 @@ type ExternalFlags struct {
 	DisablePortCheck bool
 	GenerateCSR      bool
 	Local            bool
+	Resume           bool
 	UpdateOnly       bool
 	UseLocalState    bool
 }
@@ -178,17 +182,11 @@ func (c *Cluster) setClusterDefaults(ctx context.Context, flags ExternalFlags) e
 		c.PrefixPath = "/"
 	}
 	// Set bastion/jump host defaults
-	if len(c.BastionHost.Address) > 0 {
-		if len(c.BastionHost.Port) == 0 {
-			c.BastionHost.Port = DefaultSSHPort
-		}
-		if len(c.BastionHost.SSHKeyPath) == 0 {
-			c.BastionHost.SSHKeyPath = c.SSHKeyPath
-		}
-		c.BastionHost.SSHAgentAuth = c.SSHAgentAuth
-
-	}
+	c.setBastionHostDefaults(&c.BastionHost)
 	for i, host := range c.Nodes {
+		if host.BastionHost != nil {
+			c.setBastionHostDefaults(c.Nodes[i].BastionHost)
+		}
 		if len(host.InternalAddress) == 0 {
 			c.Nodes[i].InternalAddress = c.Nodes[i].Address
 		}
@@ -199,6 +197,12 @@ func (c *Cluster) setClusterDefaults(ctx context.Context, flags ExternalFlags) e
 		if len(host.SSHKeyPath) == 0 {
 			c.Nodes[i].SSHKeyPath = c.SSHKeyPath
 		}
+		if len(host.SSHKeyCommand) == 0 {
+			c.Nodes[i].SSHKeyCommand = c.SSHKeyCommand
+		}
+		if len(host.SSHKeyPassphraseCommand) == 0 {
+			c.Nodes[i].SSHKeyPassphraseCommand = c.SSHKeyPassphraseCommand
+		}
 		if len(host.Port) == 0 {
 			c.Nodes[i].Port = DefaultSSHPort
 		}
@@ -242,6 +246,10 @@ func (c *Cluster) setClusterDefaults(ctx context.Context, flags ExternalFlags) e
 	if err != nil {
 		return err
 	}
+	if err := c.setImageRewrites(); err != nil {
+		return err
+	}
+	c.pinImageDigests(ctx)
 
 	if c.RancherKubernetesEngineConfig.RotateCertificates != nil ||
 		flags.CustomCerts {
@@ -255,11 +263,57 @@ func (c *Cluster) setClusterDefaults(ctx context.Context, flags ExternalFlags) e
 	c.setClusterServicesDefaults()
 	c.setClusterNetworkDefaults()
 	c.setClusterAuthnDefaults()
+	c.setCertificateRenewalDefaults()
 	c.setNodeUpgradeStrategy()
 	c.setAddonsDefaults()
 	return nil
 }
 
+func (c *Cluster) setBastionHostDefaults(bastionHost *v3.BastionHost) {
+	if len(bastionHost.Address) == 0 {
+		return
+	}
+	if len(bastionHost.Port) == 0 {
+		bastionHost.Port = DefaultSSHPort
+	}
+	if len(bastionHost.SSHKeyPath) == 0 {
+		bastionHost.SSHKeyPath = c.SSHKeyPath
+	}
+	if len(bastionHost.SSHKeyCommand) == 0 {
+		bastionHost.SSHKeyCommand = c.SSHKeyCommand
+	}
+	if len(bastionHost.SSHKeyPassphraseCommand) == 0 {
+		bastionHost.SSHKeyPassphraseCommand = c.SSHKeyPassphraseCommand
+	}
+	// the SSH agent is used for a hop if it's enabled for the hop or for the cluster
+	bastionHost.SSHAgentAuth = bastionHost.SSHAgentAuth || c.SSHAgentAuth
+	for i := range bastionHost.Hops {
+		hop := &bastionHost.Hops[i]
+		if len(hop.Port) == 0 {
+			hop.Port = DefaultSSHPort
+		}
+		if len(hop.SSHKeyPath) == 0 {
+			hop.SSHKeyPath = c.SSHKeyPath
+		}
+		if len(hop.SSHKeyCommand) == 0 {
+			hop.SSHKeyCommand = c.SSHKeyCommand
+		}
+		if len(hop.SSHKeyPassphraseCommand) == 0 {
+			hop.SSHKeyPassphraseCommand = c.SSHKeyPassphraseCommand
+		}
+		hop.SSHAgentAuth = hop.SSHAgentAuth || c.SSHAgentAuth
+	}
+}
+
+func (c *Cluster) setCertificateRenewalDefaults() {
+	if c.CertificateRenewal == nil {
+		c.CertificateRenewal = &v3.CertificateRenewal{}
+	}
+	if c.CertificateRenewal.RenewBeforeDays == 0 {
+		c.CertificateRenewal.RenewBeforeDays = DefaultCertificateRenewBeforeDays
+	}
+}
+
 func (c *Cluster) setNodeUpgradeStrategy() {
 	if c.UpgradeStrategy == nil {
 		logrus.Debugf("No input provided for maxUnavailableWorker, setting it to default value of %v percent", strings.TrimRight(DefaultMaxUnavailableWorker, "%"))
@@ -272,6 +326,9 @@ func (c *Cluster) setNodeUpgradeStrategy() {
 	}
 	setDefaultIfEmpty(&c.UpgradeStrategy.MaxUnavailableWorker, DefaultMaxUnavailableWorker)
 	setDefaultIfEmpty(&c.UpgradeStrategy.MaxUnavailableControlplane, DefaultMaxUnavailableControlplane)
+	if c.UpgradeStrategy.Pause != nil && c.UpgradeStrategy.Pause.Timeout == 0 {
+		c.UpgradeStrategy.Pause.Timeout = DefaultUpgradePauseTimeout
+	}
 	if c.UpgradeStrategy.Drain != nil && *c.UpgradeStrategy.Drain {
 		return
 	}
@@ -466,19 +523,12 @@ func newDefaultAdmissionConfiguration() (*apiserverv1alpha1.AdmissionConfigurati
 }
 
 func (c *Cluster) setClusterImageDefaults() error {
-	var privRegURL string
-
 	imageDefaults, ok := metadata.K8sVersionToRKESystemImages[c.Version]
 	if !ok {
 		return nil
 	}
 
-	for _, privReg := range c.PrivateRegistries {
-		if privReg.IsDefault {
-			privRegURL = privReg.URL
-			break
-		}
-	}
+	privRegURL := c.defaultPrivateRegistryURL()
 	systemImagesDefaultsMap := map[*string]string{
 		&c.SystemImages.Alpine:                    d(imageDefaults.Alpine, privRegURL),
 		&c.SystemImages.NginxProxy:                d(imageDefaults.NginxProxy, privRegURL),
@@ -757,6 +807,15 @@ func (c *Cluster) setClusterAuthnDefaults() {
 	}
 }
 
+func (c *Cluster) defaultPrivateRegistryURL() string {
+	for _, privReg := range c.PrivateRegistries {
+		if privReg.IsDefault {
+			return privReg.URL
+		}
+	}
+	return ""
+}
+
 func d(image, defaultRegistryURL string) string {
 	if len(defaultRegistryURL) == 0 {
 		return image
@@ -0,0 +1,372 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
+)
+
+const (
+	PlanActionAdd    = "add"
+	PlanActionRemove = "remove"
+	PlanActionChange = "change"
+
+	redactedValue = "<redacted>"
+)
+
+type PlanDiff struct {
+	AddedHosts          []string
+	RemovedHosts        []string
+	EtcdMembersToAdd    []string
+	EtcdMembersToDelete []string
+	Nodes               []NodePlanDiff
+	Addons              []string
+}
+
+type NodePlanDiff struct {
+	Address   string
+	Action    string
+	Processes []ProcessDiff
+}
+
+type ProcessDiff struct {
+	Name    string
+	Action  string
+	Changes []string
+}
+
+// GetPlanDiff builds the plans of the current and desired states and returns what would change on the nodes
+// if the desired state was applied. It doesn't connect to any host, so docker info based options are not considered.
+func GetPlanDiff(ctx context.Context, fullState *FullState, data map[string]interface{}) (*PlanDiff, error) {
+	if fullState.DesiredState.RancherKubernetesEngineConfig == nil {
+		return nil, fmt.Errorf("desired state has no cluster configuration")
+	}
+	hostsInfoMap := make(map[string]types.Info)
+	desiredPlan, err := GeneratePlan(ctx, fullState.DesiredState.RancherKubernetesEngineConfig.DeepCopy(), hostsInfoMap, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate plan for desired state: %v", err)
+	}
+	desiredCluster, err := InitClusterObject(ctx, fullState.DesiredState.RancherKubernetesEngineConfig.DeepCopy(), ExternalFlags{}, fullState.DesiredState.EncryptionConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var currentPlan v3.RKEPlan
+	var currentCluster *Cluster
+	if fullState.CurrentState.RancherKubernetesEngineConfig != nil {
+		currentPlan, err = GeneratePlan(ctx, fullState.CurrentState.RancherKubernetesEngineConfig.DeepCopy(), hostsInfoMap, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate plan for current state: %v", err)
+		}
+		currentCluster, err = InitClusterObject(ctx, fullState.CurrentState.RancherKubernetesEngineConfig.DeepCopy(), ExternalFlags{}, fullState.CurrentState.EncryptionConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	planDiff := DiffPlans(currentPlan, desiredPlan)
+	planDiff.EtcdMembersToAdd, planDiff.EtcdMembersToDelete = diffEtcdMembers(currentCluster, desiredCluster)
+	planDiff.Addons = diffAddons(currentCluster, desiredCluster)
+	return planDiff, nil
+}
+
+// DiffPlans compares two cluster plans node by node and process by process
+func DiffPlans(currentPlan, desiredPlan v3.RKEPlan) *PlanDiff {
+	planDiff := &PlanDiff{}
+	currentNodes := make(map[string]v3.RKEConfigNodePlan)
+	for _, node := range currentPlan.Nodes {
+		currentNodes[node.Address] = node
+	}
+	desiredNodes := make(map[string]v3.RKEConfigNodePlan)
+	for _, node := range desiredPlan.Nodes {
+		desiredNodes[node.Address] = node
+	}
+
+	for _, address := range sortedNodeAddresses(desiredNodes) {
+		desiredNode := desiredNodes[address]
+		currentNode, ok := currentNodes[address]
+		if !ok {
+			planDiff.AddedHosts = append(planDiff.AddedHosts, address)
+			planDiff.Nodes = append(planDiff.Nodes, NodePlanDiff{
+				Address:   address,
+				Action:    PlanActionAdd,
+				Processes: diffProcesses(nil, desiredNode.Processes),
+			})
+			continue
+		}
+		if processDiffs := diffProcesses(currentNode.Processes, desiredNode.Processes); len(processDiffs) > 0 {
+			planDiff.Nodes = append(planDiff.Nodes, NodePlanDiff{
+				Address:   address,
+				Action:    PlanActionChange,
+				Processes: processDiffs,
+			})
+		}
+	}
+	for _, address := range sortedNodeAddresses(currentNodes) {
+		if _, ok := desiredNodes[address]; ok {
+			continue
+		}
+		planDiff.RemovedHosts = append(planDiff.RemovedHosts, address)
+		planDiff.Nodes = append(planDiff.Nodes, NodePlanDiff{
+			Address:   address,
+			Action:    PlanActionRemove,
+			Processes: diffProcesses(currentNodes[address].Processes, nil),
+		})
+	}
+	return planDiff
+}
+
+func (p *PlanDiff) IsEmpty() bool {
+	return len(p.Nodes) == 0 && len(p.EtcdMembersToAdd) == 0 && len(p.EtcdMembersToDelete) == 0 && len(p.Addons) == 0
+}
+
+func diffProcesses(currentProcesses, desiredProcesses map[string]v3.Process) []ProcessDiff {
+	var processDiffs []ProcessDiff
+	for _, name := range sortedProcessNames(desiredProcesses) {
+		desiredProcess := desiredProcesses[name]
+		currentProcess, ok := currentProcesses[name]
+		if !ok {
+			processDiffs = append(processDiffs, ProcessDiff{
+				Name:    name,
+				Action:  PlanActionAdd,
+				Changes: []string{fmt.Sprintf("image: %s", desiredProcess.Image)},
+			})
+			continue
+		}
+		if changes := diffProcess(currentProcess, desiredProcess); len(changes) > 0 {
+			processDiffs = append(processDiffs, ProcessDiff{
+				Name:    name,
+				Action:  PlanActionChange,
+				Changes: changes,
+			})
+		}
+	}
+	for _, name := range sortedProcessNames(currentProcesses) {
+		if _, ok := desiredProcesses[name]; ok {
+			continue
+		}
+		processDiffs = append(processDiffs, ProcessDiff{
+			Name:   name,
+			Action: PlanActionRemove,
+		})
+	}
+	return processDiffs
+}
+
+func diffProcess(currentProcess, desiredProcess v3.Process) []string {
+	var changes []string
+	if currentProcess.Image != desiredProcess.Image {
+		changes = append(changes, fmt.Sprintf("image: %s -> %s", currentProcess.Image, desiredProcess.Image))
+	}
+	changes = append(changes, diffOrderedList("command", currentProcess.Command, desiredProcess.Command)...)
+	changes = append(changes, diffOrderedList("arg", currentProcess.Args, desiredProcess.Args)...)
+	changes = append(changes, diffStringList("env", redactEnv(currentProcess.Env), redactEnv(desiredProcess.Env))...)
+	changes = append(changes, diffStringList("bind", currentProcess.Binds, desiredProcess.Binds)...)
+	changes = append(changes, diffStringList("volumes-from", currentProcess.VolumesFrom, desiredProcess.VolumesFrom)...)
+	changes = append(changes, diffStringList("publish", currentProcess.Publish, desiredProcess.Publish)...)
+	if currentProcess.NetworkMode != desiredProcess.NetworkMode {
+		changes = append(changes, fmt.Sprintf("network mode: %s -> %s", currentProcess.NetworkMode, desiredProcess.NetworkMode))
+	}
+	if currentProcess.PidMode != desiredProcess.PidMode {
+		changes = append(changes, fmt.Sprintf("pid mode: %s -> %s", currentProcess.PidMode, desiredProcess.PidMode))
+	}
+	if currentProcess.Privileged != desiredProcess.Privileged {
+		changes = append(changes, fmt.Sprintf("privileged: %t -> %t", currentProcess.Privileged, desiredProcess.Privileged))
+	}
+	if currentProcess.User != desiredProcess.User {
+		changes = append(changes, fmt.Sprintf("user: %s -> %s", currentProcess.User, desiredProcess.User))
+	}
+	if currentProcess.HealthCheck.URL != desiredProcess.HealthCheck.URL {
+		changes = append(changes, fmt.Sprintf("healthcheck: %s -> %s", currentProcess.HealthCheck.URL, desiredProcess.HealthCheck.URL))
+	}
+	changes = append(changes, diffResources(currentProcess.Resources, desiredProcess.Resources)...)
+	return changes
+}
+
+// diffResources compares the resource limits and reservations of the processes, unset resources have no limits
+func diffResources(currentResources, desiredResources *v3.ContainerResources) []string {
+	var current, desired v3.ContainerResources
+	if currentResources != nil {
+		current = *currentResources
+	}
+	if desiredResources != nil {
+		desired = *desiredResources
+	}
+	var changes []string
+	for _, field := range []struct {
+		name             string
+		current, desired interface{}
+	}{
+		{"cpu limit", current.CPULimit, desired.CPULimit},
+		{"cpu reservation", current.CPUReservation, desired.CPUReservation},
+		{"memory limit", current.MemoryLimit, desired.MemoryLimit},
+		{"memory reservation", current.MemoryReservation, desired.MemoryReservation},
+		{"oom score adj", current.OOMScoreAdj, desired.OOMScoreAdj},
+		{"pids limit", current.PidsLimit, desired.PidsLimit},
+	} {
+		if field.current != field.desired {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", field.name, field.current, field.desired))
+		}
+	}
+	return changes
+}
+
+// diffOrderedList compares the lists in order, the items added or removed are reported like diffStringList and a
+// reordered or duplicated item changes the whole list
+func diffOrderedList(kind string, currentList, desiredList []string) []string {
+	if reflect.DeepEqual(currentList, desiredList) || len(currentList) == 0 && len(desiredList) == 0 {
+		return nil
+	}
+	if changes := diffStringList(kind, currentList, desiredList); len(changes) > 0 {
+		return changes
+	}
+	return []string{fmt.Sprintf("%s: %s -> %s", kind, strings.Join(currentList, " "), strings.Join(desiredList, " "))}
+}
+
+// diffStringList compares the lists as sets, for the items whose order doesn't matter
+func diffStringList(kind string, currentList, desiredList []string) []string {
+	var changes []string
+	currentSet := make(map[string]bool)
+	for _, item := range currentList {
+		currentSet[item] = true
+	}
+	desiredSet := make(map[string]bool)
+	for _, item := range desiredList {
+		desiredSet[item] = true
+	}
+	for _, item := range desiredList {
+		if !currentSet[item] {
+			changes = append(changes, fmt.Sprintf("+ %s: %s", kind, item))
+		}
+	}
+	for _, item := range currentList {
+		if !desiredSet[item] {
+			changes = append(changes, fmt.Sprintf("- %s: %s", kind, item))
+		}
+	}
+	return changes
+}
+
+// redactEnv hides the values of environment variables that carry registry credentials
+func redactEnv(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, e := range env {
+		if strings.HasPrefix(e, KubeletDockerConfigEnv+"=") {
+			e = fmt.Sprintf("%s=%s(%s)", KubeletDockerConfigEnv, redactedValue, getStringChecksum(e))
+		}
+		redacted = append(redacted, e)
+	}
+	return redacted
+}
+
+func diffEtcdMembers(currentCluster, desiredCluster *Cluster) ([]string, []string) {
+	var toAdd, toDelete []string
+	if len(desiredCluster.Services.Etcd.ExternalURLs) > 0 {
+		return toAdd, toDelete
+	}
+	currentMembers := make(map[string]bool)
+	if currentCluster != nil {
+		for _, host := range currentCluster.EtcdHosts {
+			currentMembers[host.Address] = true
+		}
+	}
+	desiredMembers := make(map[string]bool)
+	for _, host := range desiredCluster.EtcdHosts {
+		desiredMembers[host.Address] = true
+		if !currentMembers[host.Address] {
+			toAdd = append(toAdd, host.Address)
+		}
+	}
+	if currentCluster != nil {
+		for _, host := range currentCluster.EtcdHosts {
+			if !desiredMembers[host.Address] {
+				toDelete = append(toDelete, host.Address)
+			}
+		}
+	}
+	return toAdd, toDelete
+}
+
+// diffAddons returns the addons whose configuration or images differ between the current and desired cluster
+func diffAddons(currentCluster, desiredCluster *Cluster) []string {
+	var addons []string
+	dnsResourceName := getAddonResourceName(dnsAddon)
+	if desiredCluster.DNS != nil && len(desiredCluster.DNS.Provider) > 0 {
+		dnsResourceName = getAddonResourceName(desiredCluster.DNS.Provider)
+	}
+	addonConfigs := []struct {
+		name   string
+		config func(c *Cluster) interface{}
+	}{
+		{NetworkPluginResourceName, func(c *Cluster) interface{} {
+			return []interface{}{c.Network, c.getNetworkImages()}
+		}},
+		{dnsResourceName, func(c *Cluster) interface{} {
+			return []interface{}{c.DNS, c.SystemImages.KubeDNS, c.SystemImages.DNSmasq, c.SystemImages.KubeDNSSidecar,
+				c.SystemImages.KubeDNSAutoscaler, c.SystemImages.CoreDNS, c.SystemImages.CoreDNSAutoscaler, c.SystemImages.Nodelocal}
+		}},
+		{IngressAddonResourceName, func(c *Cluster) interface{} {
+			return []interface{}{c.Ingress, c.SystemImages.Ingress, c.SystemImages.IngressBackend, c.SystemImages.IngressWebhook}
+		}},
+		{MetricsServerAddonResourceName, func(c *Cluster) interface{} {
+			return []interface{}{c.Monitoring, c.SystemImages.MetricsServer}
+		}},
+		{UserAddonResourceName, func(c *Cluster) interface{} {
+			return c.Addons
+		}},
+		{UserAddonsIncludeResourceName, func(c *Cluster) interface{} {
+			return c.AddonsInclude
+		}},
+	}
+	for _, addon := range addonConfigs {
+		if currentCluster == nil {
+			addons = append(addons, addon.name)
+			continue
+		}
+		if !reflect.DeepEqual(addon.config(currentCluster), addon.config(desiredCluster)) {
+			addons = append(addons, addon.name)
+		}
+	}
+	return addons
+}
+
+func (c *Cluster) getNetworkImages() []string {
+	return []string{
+		c.SystemImages.Flannel, c.SystemImages.FlannelCNI,
+		c.SystemImages.CalicoNode, c.SystemImages.CalicoCNI, c.SystemImages.CalicoControllers, c.SystemImages.CalicoCtl, c.SystemImages.CalicoFlexVol,
+		c.SystemImages.CanalNode, c.SystemImages.CanalCNI, c.SystemImages.CanalControllers, c.SystemImages.CanalFlannel, c.SystemImages.CanalFlexVol,
+		c.SystemImages.WeaveNode, c.SystemImages.WeaveCNI,
+		c.SystemImages.AciCniDeployContainer, c.SystemImages.AciHostContainer, c.SystemImages.AciOpflexContainer, c.SystemImages.AciMcastContainer,
+		c.SystemImages.AciOpenvSwitchContainer, c.SystemImages.AciControllerContainer, c.SystemImages.AciGbpServerContainer, c.SystemImages.AciOpflexServerContainer,
+	}
+}
+
+func sortedNodeAddresses(nodes map[string]v3.RKEConfigNodePlan) []string {
+	addresses := make([]string, 0, len(nodes))
+	for address := range nodes {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+func sortedProcessNames(processes map[string]v3.Process) []string {
+	names := make([]string, 0, len(processes))
+	for name := range processes {
+		names = append(names, name)
+	}
+	// etcd goes first, the way it's deployed
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == services.EtcdContainerName || names[j] == services.EtcdContainerName {
+			return names[i] == services.EtcdContainerName
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
@@ -0,0 +1,226 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffProcess(t *testing.T) {
+	process := v3.Process{
+		Name:    services.KubeletContainerName,
+		Image:   "rancher/hyperkube:v1.20.4-rancher1",
+		Command: []string{"kubelet", "--v=2"},
+		Args:    []string{"--cluster-domain=cluster.local"},
+		Env:     []string{"RKE_CLOUD_CONFIG_CHECKSUM=1234"},
+		Binds:   []string{"/etc/kubernetes:/etc/kubernetes:z"},
+	}
+	tests := []struct {
+		name    string
+		change  func(p *v3.Process)
+		changes []string
+	}{
+		{
+			name:   "unchanged",
+			change: func(p *v3.Process) {},
+		},
+		{
+			name:    "image",
+			change:  func(p *v3.Process) { p.Image = "rancher/hyperkube:v1.20.5-rancher1" },
+			changes: []string{"image: rancher/hyperkube:v1.20.4-rancher1 -> rancher/hyperkube:v1.20.5-rancher1"},
+		},
+		{
+			name:    "command",
+			change:  func(p *v3.Process) { p.Command = []string{"kubelet", "--v=4"} },
+			changes: []string{"+ command: --v=4", "- command: --v=2"},
+		},
+		{
+			name:    "args",
+			change:  func(p *v3.Process) { p.Args = append(p.Args, "--max-pods=250") },
+			changes: []string{"+ arg: --max-pods=250"},
+		},
+		{
+			name:    "env",
+			change:  func(p *v3.Process) { p.Env = []string{"RKE_CLOUD_CONFIG_CHECKSUM=5678"} },
+			changes: []string{"+ env: RKE_CLOUD_CONFIG_CHECKSUM=5678", "- env: RKE_CLOUD_CONFIG_CHECKSUM=1234"},
+		},
+		{
+			name:    "binds",
+			change:  func(p *v3.Process) { p.Binds = nil },
+			changes: []string{"- bind: /etc/kubernetes:/etc/kubernetes:z"},
+		},
+		{
+			name:    "order of the command",
+			change:  func(p *v3.Process) { p.Command = []string{"--v=2", "kubelet"} },
+			changes: []string{"command: kubelet --v=2 -> --v=2 kubelet"},
+		},
+		{
+			name:    "duplicated arg",
+			change:  func(p *v3.Process) { p.Args = append(p.Args, p.Args[0]) },
+			changes: []string{"arg: --cluster-domain=cluster.local -> --cluster-domain=cluster.local --cluster-domain=cluster.local"},
+		},
+		{
+			name:   "duplicated env",
+			change: func(p *v3.Process) { p.Env = append(p.Env, p.Env[0]) },
+		},
+		{
+			name: "registry credentials",
+			change: func(p *v3.Process) {
+				p.Env = append(p.Env, KubeletDockerConfigEnv+"=secret")
+			},
+			changes: []string{"+ env: " + KubeletDockerConfigEnv + "=" + redactedValue + "(" + getStringChecksum(KubeletDockerConfigEnv+"=secret") + ")"},
+		},
+		{
+			name: "network, pid mode and privileged",
+			change: func(p *v3.Process) {
+				p.NetworkMode = "host"
+				p.PidMode = "host"
+				p.Privileged = true
+			},
+			changes: []string{"network mode:  -> host", "pid mode:  -> host", "privileged: false -> true"},
+		},
+		{
+			name:    "resources",
+			change:  func(p *v3.Process) { p.Resources = &v3.ContainerResources{CPULimit: "500m", PidsLimit: 4096} },
+			changes: []string{"cpu limit:  -> 500m", "pids limit: 0 -> 4096"},
+		},
+		{
+			name:   "unset resources",
+			change: func(p *v3.Process) { p.Resources = &v3.ContainerResources{} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired := process
+			desired.Command = append([]string{}, process.Command...)
+			desired.Args = append([]string{}, process.Args...)
+			desired.Env = append([]string{}, process.Env...)
+			desired.Binds = append([]string{}, process.Binds...)
+			tt.change(&desired)
+			assert.Equal(t, tt.changes, diffProcess(process, desired))
+		})
+	}
+}
+
+func TestAppendArgs(t *testing.T) {
+	// the flags are sorted, the plans of the same configuration are equal
+	assert.Equal(t, []string{"kubelet", "--max-pods=250", "--v=2"}, appendArgs([]string{"kubelet"}, map[string]string{"v": "2", "max-pods": "250"}))
+}
+
+func TestDiffPlans(t *testing.T) {
+	etcd := v3.Process{Name: services.EtcdContainerName, Image: "rancher/coreos-etcd:v3.4.14-rancher1"}
+	kubelet := v3.Process{Name: services.KubeletContainerName, Image: "rancher/hyperkube:v1.20.4-rancher1"}
+	newKubelet := kubelet
+	newKubelet.Image = "rancher/hyperkube:v1.20.5-rancher1"
+	node := func(address string, processes ...v3.Process) v3.RKEConfigNodePlan {
+		nodePlan := v3.RKEConfigNodePlan{Address: address, Processes: map[string]v3.Process{}}
+		for _, process := range processes {
+			nodePlan.Processes[process.Name] = process
+		}
+		return nodePlan
+	}
+	tests := []struct {
+		name     string
+		current  v3.RKEPlan
+		desired  v3.RKEPlan
+		expected *PlanDiff
+	}{
+		{
+			name:     "unchanged",
+			current:  v3.RKEPlan{Nodes: []v3.RKEConfigNodePlan{node("1.1.1.1", etcd, kubelet)}},
+			desired:  v3.RKEPlan{Nodes: []v3.RKEConfigNodePlan{node("1.1.1.1", etcd, kubelet)}},
+			expected: &PlanDiff{},
+		},
+		{
+			name:    "new cluster",
+			desired: v3.RKEPlan{Nodes: []v3.RKEConfigNodePlan{node("1.1.1.1", kubelet, etcd)}},
+			expected: &PlanDiff{
+				AddedHosts: []string{"1.1.1.1"},
+				Nodes: []NodePlanDiff{{Address: "1.1.1.1", Action: PlanActionAdd, Processes: []ProcessDiff{
+					{Name: services.EtcdContainerName, Action: PlanActionAdd, Changes: []string{"image: " + etcd.Image}},
+					{Name: services.KubeletContainerName, Action: PlanActionAdd, Changes: []string{"image: " + kubelet.Image}},
+				}}},
+			},
+		},
+		{
+			name:    "changed image and removed process and host",
+			current: v3.RKEPlan{Nodes: []v3.RKEConfigNodePlan{node("1.1.1.1", etcd, kubelet), node("2.2.2.2", kubelet)}},
+			desired: v3.RKEPlan{Nodes: []v3.RKEConfigNodePlan{node("1.1.1.1", newKubelet)}},
+			expected: &PlanDiff{
+				RemovedHosts: []string{"2.2.2.2"},
+				Nodes: []NodePlanDiff{
+					{Address: "1.1.1.1", Action: PlanActionChange, Processes: []ProcessDiff{
+						{Name: services.KubeletContainerName, Action: PlanActionChange, Changes: []string{"image: " + kubelet.Image + " -> " + newKubelet.Image}},
+						{Name: services.EtcdContainerName, Action: PlanActionRemove},
+					}},
+					{Address: "2.2.2.2", Action: PlanActionRemove, Processes: []ProcessDiff{
+						{Name: services.KubeletContainerName, Action: PlanActionRemove},
+					}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planDiff := DiffPlans(tt.current, tt.desired)
+			assert.Equal(t, tt.expected, planDiff)
+			assert.Equal(t, len(tt.expected.Nodes) == 0, planDiff.IsEmpty())
+		})
+	}
+}
+
+func TestDiffAddons(t *testing.T) {
+	newCluster := func() *Cluster {
+		return &Cluster{RancherKubernetesEngineConfig: v3.RancherKubernetesEngineConfig{
+			Network:    v3.NetworkConfig{Plugin: "canal"},
+			DNS:        &v3.DNSConfig{Provider: "coredns"},
+			Monitoring: v3.MonitoringConfig{Provider: "metrics-server"},
+			SystemImages: v3.RKESystemImages{
+				CoreDNS:       "rancher/coredns-coredns:1.8.0",
+				MetricsServer: "rancher/metrics-server:v0.4.1",
+				CanalNode:     "rancher/calico-node:v3.17.2",
+			},
+		}}
+	}
+	tests := []struct {
+		name   string
+		change func(c *Cluster)
+		addons []string
+	}{
+		{
+			name:   "unchanged",
+			change: func(c *Cluster) {},
+		},
+		{
+			name:   "network image",
+			change: func(c *Cluster) { c.SystemImages.CanalNode = "rancher/calico-node:v3.17.3" },
+			addons: []string{NetworkPluginResourceName},
+		},
+		{
+			name:   "dns provider",
+			change: func(c *Cluster) { c.DNS.Provider = "kube-dns" },
+			addons: []string{getAddonResourceName("kube-dns")},
+		},
+		{
+			name:   "metrics server image",
+			change: func(c *Cluster) { c.SystemImages.MetricsServer = "rancher/metrics-server:v0.4.2" },
+			addons: []string{MetricsServerAddonResourceName},
+		},
+		{
+			name:   "user addons",
+			change: func(c *Cluster) { c.Addons = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n" },
+			addons: []string{UserAddonResourceName},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired := newCluster()
+			tt.change(desired)
+			assert.Equal(t, tt.addons, diffAddons(newCluster(), desired))
+		})
+	}
+	// every addon is deployed on a new cluster
+	assert.Len(t, diffAddons(nil, newCluster()), 6)
+}
@@ -3,9 +3,17 @@ package cluster
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/rancher/rke/backup"
 	"github.com/rancher/rke/docker"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
@@ -27,9 +35,121 @@ func (c *Cluster) SnapshotEtcd(ctx context.Context, snapshotName string) error {
 			return err
 		}
 	}
+	if backup.IsTransferredByRKE(c.Services.Etcd.BackupConfig) {
+		return c.uploadEtcdSnapshot(ctx, snapshotName)
+	}
 	return nil
 }
 
+func (c *Cluster) uploadEtcdSnapshot(ctx context.Context, snapshotName string) error {
+	bc := c.Services.Etcd.BackupConfig
+	// encrypted snapshots are encrypted on the etcd host before they're copied
+	backend, err := backup.NewUnencryptedBackend(bc)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile("", snapshotName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	if bc.Encryption != nil && bc.Timeout > 0 {
+		ctx = context.WithValue(ctx, docker.WaitTimeoutContextKey, bc.Timeout)
+	}
+	// every etcd host takes its own snapshot, one of them is uploaded: the first active host the snapshot can be
+	// copied from
+	var errs []string
+	for _, host := range c.EtcdHosts {
+		fileName, err := c.copyEtcdSnapshotFromHost(ctx, host, snapshotName, tmpFile)
+		if err != nil {
+			log.Warnf(ctx, "[etcd] Failed to copy snapshot [%s] from host [%s]: %v", snapshotName, host.Address, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		log.Infof(ctx, "[etcd] Uploading snapshot [%s] from host [%s] to %s backend", fileName, host.Address, backend.Name())
+		return backend.Upload(ctx, fileName, tmpFile)
+	}
+	return fmt.Errorf("Failed to copy snapshot [%s] from the etcd hosts: %s", snapshotName, strings.Join(errs, ", "))
+}
+
+// copyEtcdSnapshotFromHost writes the snapshot of the host to the start of file, it returns the name of the file that
+// was copied
+func (c *Cluster) copyEtcdSnapshotFromHost(ctx context.Context, host *hosts.Host, snapshotName string, file *os.File) (string, error) {
+	if err := file.Truncate(0); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	bc := c.Services.Etcd.BackupConfig
+	if bc.Encryption != nil {
+		return services.CopyEncryptedEtcdSnapshotFromHost(ctx, host, c.PrivateRegistriesMap, c.getBackupImage(), bc, snapshotName, file)
+	}
+	return services.CopyEtcdSnapshotFromHost(ctx, host, c.PrivateRegistriesMap, c.getBackupImage(), snapshotName, file)
+}
+
+func (c *Cluster) downloadEtcdSnapshot(ctx context.Context, snapshotName string) error {
+	backend, err := backup.NewBackend(c.Services.Etcd.BackupConfig)
+	if err != nil {
+		return err
+	}
+	log.Infof(ctx, "[etcd] Downloading snapshot [%s] from %s backend", snapshotName, backend.Name())
+	fileName, filePath, err := backup.DownloadToFile(ctx, backend, snapshotName+services.EtcdSnapshotCompressedExtension, snapshotName)
+	if err != nil {
+		return fmt.Errorf("Failed to download snapshot [%s] from %s backend: %v", snapshotName, backend.Name(), err)
+	}
+	defer os.Remove(filePath)
+	snapshotFiles := map[string]string{fileName: filePath}
+	// etcdctl restores the uncompressed snapshot, the compressed one is kept on the hosts to extract the state file
+	if fileName != snapshotName {
+		tmpFile, err := ioutil.TempFile("", snapshotName)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpFile.Name())
+		err = backup.ExtractSnapshot(filePath, snapshotName, tmpFile)
+		tmpFile.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to extract snapshot [%s]: %v", snapshotName, err)
+		}
+		snapshotFiles[snapshotName] = tmpFile.Name()
+	}
+	for _, host := range c.EtcdHosts {
+		for name, path := range snapshotFiles {
+			if err := c.deployEtcdSnapshotFile(ctx, host, name, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) deployEtcdSnapshotFile(ctx context.Context, host *hosts.Host, fileName, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return services.DeployEtcdSnapshotOnHost(ctx, host, c.PrivateRegistriesMap, c.getBackupImage(), fileName, file)
+}
+
+func (c *Cluster) getStateFileFromBackend(ctx context.Context, snapshotName string) (string, error) {
+	backend, err := backup.NewBackend(c.Services.Etcd.BackupConfig)
+	if err != nil {
+		return "", err
+	}
+	_, filePath, err := backup.DownloadToFile(ctx, backend, snapshotName+services.EtcdSnapshotCompressedExtension)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(filePath)
+	return backup.ExtractStateFile(filePath)
+}
+
 func (c *Cluster) DeployRestoreCerts(ctx context.Context, clusterCerts map[string]pki.CertificatePKI) error {
 	var errgrp errgroup.Group
 	hostsQueue := util.GetObjectQueue(c.EtcdHosts)
@@ -65,7 +185,7 @@ func (c *Cluster) DeployRestoreCerts(ctx context.Context, clusterCerts map[strin
 }
 
 func (c *Cluster) DeployStateFile(ctx context.Context, stateFilePath, snapshotName string) error {
-	stateFileExists, err := util.IsFileExists(stateFilePath)
+	stateFileExists, err := StateFileExists(ctx, stateFilePath)
 	if err != nil {
 		logrus.Warnf("Could not read cluster state file from [%s], error: [%v]. Snapshot will be created without cluster state file. You can retrieve the cluster state file using 'rke util get-state-file'", stateFilePath, err)
 		return nil
@@ -74,6 +194,16 @@ func (c *Cluster) DeployStateFile(ctx context.Context, stateFilePath, snapshotNa
 		logrus.Warnf("Could not read cluster state file from [%s], file does not exist. Snapshot will be created without cluster state file. You can retrieve the cluster state file using 'rke util get-state-file'", stateFilePath)
 		return nil
 	}
+	if !IsLocalStateBackend() {
+		// the state file is copied to the etcd hosts from a local file with the same name
+		localStateFilePath, err := c.localStateFileCopy(ctx, stateFilePath)
+		if err != nil {
+			logrus.Warnf("Could not read cluster state file from [%s], error: [%v]. Snapshot will be created without cluster state file. You can retrieve the cluster state file using 'rke util get-state-file'", stateFilePath, err)
+			return nil
+		}
+		defer os.RemoveAll(filepath.Dir(localStateFilePath))
+		stateFilePath = localStateFilePath
+	}
 
 	var errgrp errgroup.Group
 	hostsQueue := util.GetObjectQueue(c.EtcdHosts)
@@ -92,7 +222,35 @@ func (c *Cluster) DeployStateFile(ctx context.Context, stateFilePath, snapshotNa
 	return errgrp.Wait()
 }
 
+func (c *Cluster) localStateFileCopy(ctx context.Context, stateFilePath string) (string, error) {
+	backend, err := GetStateBackend(stateFilePath)
+	if err != nil {
+		return "", err
+	}
+	stateFile, err := backend.Read(ctx)
+	if err != nil {
+		return "", err
+	}
+	tmpDir, err := ioutil.TempDir("", "rke-state-")
+	if err != nil {
+		return "", err
+	}
+	localStateFilePath := filepath.Join(tmpDir, filepath.Base(stateFilePath))
+	if err := ioutil.WriteFile(localStateFilePath, stateFile, 0600); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return localStateFilePath, nil
+}
+
 func (c *Cluster) GetStateFileFromSnapshot(ctx context.Context, snapshotName string) (string, error) {
+	if backup.IsTransferredByRKE(c.Services.Etcd.BackupConfig) {
+		stateFile, err := c.getStateFileFromBackend(ctx, snapshotName)
+		if err != nil || stateFile == "" {
+			return "", fmt.Errorf("Unable to find statefile in snapshot [%s]: %v", snapshotName, err)
+		}
+		return stateFile, nil
+	}
 	backupImage := c.getBackupImage()
 	for _, host := range c.EtcdHosts {
 		stateFile, err := services.RunGetStateFileFromSnapshot(ctx, host, c.PrivateRegistriesMap, backupImage, snapshotName, c.Services.Etcd)
@@ -112,12 +270,12 @@ func (c *Cluster) PrepareBackup(ctx context.Context, snapshotPath string) error
 	backupImage := c.getBackupImage()
 	var errors []error
 	if c.Services.Etcd.BackupConfig == nil || // legacy rke local backup
-		(c.Services.Etcd.BackupConfig != nil && c.Services.Etcd.BackupConfig.S3BackupConfig == nil) { // rancher local backup
+		!backup.HasBackend(c.Services.Etcd.BackupConfig) { // rancher local backup
 		if c.Services.Etcd.BackupConfig == nil {
 			log.Infof(ctx, "[etcd] No etcd snapshot configuration found, will use local as source")
 		}
-		if c.Services.Etcd.BackupConfig != nil && c.Services.Etcd.BackupConfig.S3BackupConfig == nil {
-			log.Infof(ctx, "[etcd] etcd snapshot configuration found and no s3 backup configuration found, will use local as source")
+		if c.Services.Etcd.BackupConfig != nil {
+			log.Infof(ctx, "[etcd] etcd snapshot configuration found and no backup backend configuration found, will use local as source")
 		}
 		// stop etcd on all etcd nodes, we need this because we start the backup server on the same port
 		for _, host := range c.EtcdHosts {
@@ -159,8 +317,7 @@ func (c *Cluster) PrepareBackup(ctx context.Context, snapshotPath string) error
 	}
 
 	// s3 backup case
-	if c.Services.Etcd.BackupConfig != nil &&
-		c.Services.Etcd.BackupConfig.S3BackupConfig != nil {
+	if backup.IsTransferredByRKETools(c.Services.Etcd.BackupConfig) {
 		log.Infof(ctx, "[etcd] etcd s3 backup configuration found, will use s3 as source")
 		for _, host := range c.EtcdHosts {
 			if err := services.DownloadEtcdSnapshotFromS3(ctx, host, c.PrivateRegistriesMap, backupImage, snapshotPath, c.Services.Etcd); err != nil {
@@ -169,6 +326,14 @@ func (c *Cluster) PrepareBackup(ctx context.Context, snapshotPath string) error
 		}
 		backupReady = true
 	}
+
+	// filesystem, sftp, azure blob and encrypted backup case
+	if backup.IsTransferredByRKE(c.Services.Etcd.BackupConfig) {
+		if err := c.downloadEtcdSnapshot(ctx, snapshotPath); err != nil {
+			return err
+		}
+		backupReady = true
+	}
 	if !backupReady {
 		return fmt.Errorf("failed to prepare backup for restore")
 	}
@@ -205,6 +370,18 @@ func (c *Cluster) RemoveEtcdSnapshot(ctx context.Context, snapshotName string) e
 			return err
 		}
 	}
+	if backup.IsTransferredByRKE(c.Services.Etcd.BackupConfig) {
+		backend, err := backup.NewBackend(c.Services.Etcd.BackupConfig)
+		if err != nil {
+			return err
+		}
+		log.Infof(ctx, "[etcd] Removing snapshot [%s] from %s backend", snapshotName, backend.Name())
+		for _, name := range []string{snapshotName + services.EtcdSnapshotCompressedExtension, snapshotName} {
+			if err := backend.Remove(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -239,3 +416,152 @@ func (c *Cluster) getBackupImage() string {
 	logrus.Debugf("[etcd] Image used for etcd snapshot is: [%s]", rkeToolsImage)
 	return rkeToolsImage
 }
+
+// EtcdSnapshot is a snapshot found on the etcd hosts or in the backup backend
+type EtcdSnapshot struct {
+	Name     string
+	FileName string
+	Size     int64
+	Created  time.Time
+	// Hosts are the etcd hosts that have the snapshot file
+	Hosts []string
+	// Backend is the name of the backup backend that has the snapshot file, empty if none
+	Backend string
+	// ChecksumConsistent is nil if less than two hosts have the snapshot file
+	ChecksumConsistent *bool
+	// StateFile is nil if the snapshot file is only in the backend
+	StateFile *bool
+}
+
+// ListEtcdSnapshots returns the snapshots of all etcd hosts and the backup backend sorted by creation time
+func (c *Cluster) ListEtcdSnapshots(ctx context.Context) ([]*EtcdSnapshot, error) {
+	backupImage := c.getBackupImage()
+	snapshots := map[string]*EtcdSnapshot{}
+	getSnapshot := func(fileName string) *EtcdSnapshot {
+		name := strings.TrimSuffix(fileName, services.EtcdSnapshotCompressedExtension)
+		if _, ok := snapshots[name]; !ok {
+			snapshots[name] = &EtcdSnapshot{Name: name}
+		}
+		return snapshots[name]
+	}
+	listings := map[string][]services.EtcdSnapshotFile{}
+	for _, host := range c.EtcdHosts {
+		files, err := services.ListEtcdSnapshotsOnHost(ctx, host, c.PrivateRegistriesMap, backupImage)
+		if err != nil {
+			log.Warnf(ctx, "[etcd] Failed to list snapshots on host [%s]: %v", host.Address, err)
+			continue
+		}
+		listings[host.Address] = files
+	}
+	for name, files := range groupHostSnapshotFiles(listings) {
+		snapshot, snapshotHosts := newHostEtcdSnapshot(name, files, c.EtcdHosts)
+		snapshots[name] = snapshot
+		if len(snapshotHosts) > 1 {
+			consistent := c.etcdSnapshotFileChecksum(ctx, snapshot.FileName, snapshotHosts)
+			snapshot.ChecksumConsistent = &consistent
+		}
+	}
+
+	if backup.HasBackend(c.Services.Etcd.BackupConfig) {
+		backend, err := backup.NewBackend(c.Services.Etcd.BackupConfig)
+		if err != nil {
+			return nil, err
+		}
+		files, err := backend.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			snapshot := getSnapshot(file.Name)
+			snapshot.Backend = backend.Name()
+			if snapshot.FileName == "" {
+				snapshot.FileName = file.Name
+				snapshot.Size = file.Size
+				snapshot.Created = file.Created
+			}
+		}
+	}
+
+	snapshotList := make([]*EtcdSnapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotList = append(snapshotList, snapshot)
+	}
+	sort.Slice(snapshotList, func(i, j int) bool {
+		if snapshotList[i].Created.Equal(snapshotList[j].Created) {
+			return snapshotList[i].Name < snapshotList[j].Name
+		}
+		return snapshotList[i].Created.Before(snapshotList[j].Created)
+	})
+	return snapshotList, nil
+}
+
+// groupHostSnapshotFiles groups the snapshot files listed on the etcd hosts by snapshot and host address, the
+// compressed file is kept if a host has both
+func groupHostSnapshotFiles(listings map[string][]services.EtcdSnapshotFile) map[string]map[string]services.EtcdSnapshotFile {
+	grouped := map[string]map[string]services.EtcdSnapshotFile{}
+	for address, files := range listings {
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name, services.EtcdSnapshotCompressedExtension)
+			if _, ok := grouped[name]; !ok {
+				grouped[name] = map[string]services.EtcdSnapshotFile{}
+			}
+			if current, ok := grouped[name][address]; ok && strings.HasSuffix(current.Name, services.EtcdSnapshotCompressedExtension) {
+				continue
+			}
+			grouped[name][address] = file
+		}
+	}
+	return grouped
+}
+
+// newHostEtcdSnapshot returns the snapshot of the files on the etcd hosts and the hosts with the snapshot file. The
+// compressed file is the snapshot file if any host has it, the hosts with the uncompressed file only are listed too.
+func newHostEtcdSnapshot(name string, files map[string]services.EtcdSnapshotFile, etcdHosts []*hosts.Host) (*EtcdSnapshot, []*hosts.Host) {
+	snapshot := &EtcdSnapshot{Name: name}
+	for _, host := range etcdHosts {
+		file, ok := files[host.Address]
+		if !ok {
+			continue
+		}
+		if snapshot.FileName == "" || (file.Name != snapshot.FileName && strings.HasSuffix(file.Name, services.EtcdSnapshotCompressedExtension)) {
+			snapshot.FileName = file.Name
+			snapshot.Size = file.Size
+			snapshot.Created = file.Created
+		}
+	}
+	stateFile := false
+	var snapshotHosts []*hosts.Host
+	for _, host := range etcdHosts {
+		file, ok := files[host.Address]
+		if !ok {
+			continue
+		}
+		snapshot.Hosts = append(snapshot.Hosts, host.Address)
+		stateFile = stateFile || file.StateFile
+		if file.Name == snapshot.FileName {
+			snapshotHosts = append(snapshotHosts, host)
+		}
+	}
+	snapshot.StateFile = &stateFile
+	return snapshot, snapshotHosts
+}
+
+func (c *Cluster) etcdSnapshotFileChecksum(ctx context.Context, fileName string, etcdHosts []*hosts.Host) bool {
+	backupImage := c.getBackupImage()
+	var hostChecksum string
+	for _, etcdHost := range etcdHosts {
+		checksum, err := services.GetEtcdSnapshotChecksum(ctx, etcdHost, c.PrivateRegistriesMap, backupImage, fileName)
+		if err != nil {
+			log.Warnf(ctx, "[etcd] Failed to get checksum of snapshot [%s] on host [%s]: %v", fileName, etcdHost.Address, err)
+			return false
+		}
+		logrus.Debugf("[etcd] Checksum of snapshot [%s] on host [%s] is [%s]", fileName, etcdHost.Address, checksum)
+		if hostChecksum == "" {
+			hostChecksum = checksum
+		}
+		if checksum != hostChecksum {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/rancher/rke/docker/dockertest"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostEtcdSnapshots(t *testing.T) {
+	created := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	plain := func(name string) services.EtcdSnapshotFile {
+		return services.EtcdSnapshotFile{Name: name, Size: 100, Created: created}
+	}
+	compressed := func(name string) services.EtcdSnapshotFile {
+		return services.EtcdSnapshotFile{Name: name + services.EtcdSnapshotCompressedExtension, Size: 10, Created: created.Add(time.Minute), StateFile: true}
+	}
+	etcdHosts := []*hosts.Host{
+		{RKEConfigNode: v3.RKEConfigNode{Address: "1.1.1.1"}},
+		{RKEConfigNode: v3.RKEConfigNode{Address: "2.2.2.2"}},
+		{RKEConfigNode: v3.RKEConfigNode{Address: "3.3.3.3"}},
+	}
+	listings := map[string][]services.EtcdSnapshotFile{
+		// the first host has both files of the mixed snapshot, listed in either order
+		"1.1.1.1": {plain("mixed"), compressed("mixed"), compressed("compressed"), plain("plain")},
+		"2.2.2.2": {compressed("mixed"), plain("mixed"), compressed("compressed"), plain("plain")},
+		"3.3.3.3": {plain("mixed"), plain("plain")},
+	}
+	grouped := groupHostSnapshotFiles(listings)
+	assert.Len(t, grouped, 3)
+	assert.Equal(t, compressed("mixed"), grouped["mixed"]["1.1.1.1"])
+	assert.Equal(t, compressed("mixed"), grouped["mixed"]["2.2.2.2"])
+	assert.Equal(t, plain("mixed"), grouped["mixed"]["3.3.3.3"])
+
+	tests := []struct {
+		name          string
+		fileName      string
+		size          int64
+		hosts         []string
+		snapshotHosts int
+		stateFile     bool
+	}{
+		// the host with the uncompressed file only is listed, its checksum isn't compared
+		{"mixed", "mixed.zip", 10, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 2, true},
+		{"compressed", "compressed.zip", 10, []string{"1.1.1.1", "2.2.2.2"}, 2, true},
+		{"plain", "plain", 100, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot, snapshotHosts := newHostEtcdSnapshot(tt.name, grouped[tt.name], etcdHosts)
+			assert.Equal(t, tt.fileName, snapshot.FileName)
+			assert.Equal(t, tt.size, snapshot.Size)
+			assert.Equal(t, tt.hosts, snapshot.Hosts)
+			assert.Len(t, snapshotHosts, tt.snapshotHosts)
+			if assert.NotNil(t, snapshot.StateFile) {
+				assert.Equal(t, tt.stateFile, *snapshot.StateFile)
+			}
+		})
+	}
+}
+
+func TestUploadEtcdSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	c := &Cluster{}
+	c.SystemImages.Alpine = "rancher/rke-tools:v0.1.78"
+	c.Services.Etcd.BackupConfig = &v3.BackupConfig{FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: dir}}
+	for _, address := range []string{"1.1.1.1", "2.2.2.2"} {
+		runtime := dockertest.NewRuntime(address, nil)
+		runtime.AddImage(c.getBackupImage())
+		c.EtcdHosts = append(c.EtcdHosts, &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: address}, DClient: runtime})
+	}
+	// the first etcd host failed to take the snapshot
+	c.EtcdHosts[1].DClient.(*dockertest.Runtime).HostFiles[services.EtcdSnapshotPath+"snapshot"+services.EtcdSnapshotCompressedExtension] = []byte("snapshot")
+
+	assert.Nil(t, c.uploadEtcdSnapshot(context.Background(), "snapshot"))
+	content, err := ioutil.ReadFile(filepath.Join(dir, "snapshot"+services.EtcdSnapshotCompressedExtension))
+	assert.Nil(t, err)
+	assert.Equal(t, "snapshot", string(content))
+
+	err = c.uploadEtcdSnapshot(context.Background(), "missing")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "host [1.1.1.1]")
+		assert.Contains(t, err.Error(), "host [2.2.2.2]")
+	}
+}
+
+func TestValidateEtcdSnapshotUploader(t *testing.T) {
+	c := &Cluster{}
+	c.EtcdHosts = []*hosts.Host{{RKEConfigNode: v3.RKEConfigNode{Address: "1.1.1.1"}, DockerInfo: types.Info{Architecture: "s390x"}}}
+	assert.Nil(t, validateEtcdSnapshotUploader(c))
+
+	// the snapshots to s3 are uploaded by rke-tools
+	enabled := true
+	c.Services.Etcd.Snapshot = &enabled
+	c.Services.Etcd.BackupConfig = &v3.BackupConfig{S3BackupConfig: &v3.S3BackupConfig{}}
+	assert.Nil(t, validateEtcdSnapshotUploader(c))
+
+	// the encrypted snapshots are encrypted by rke on the etcd hosts
+	c.Services.Etcd.BackupConfig.Encryption = &v3.BackupEncryptionConfig{Recipients: []string{"age1"}}
+	assert.NotNil(t, validateEtcdSnapshotUploader(c))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"strings"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/rancher/rke/docker"
@@ -63,6 +64,9 @@ func doDeployFile(ctx context.Context, host *hosts.Host, fileName, fileContents,
 			fmt.Sprintf("%s:/etc/kubernetes", path.Join(host.PrefixPath, "/etc/kubernetes")),
 		},
 	}
+	if dir := path.Dir(fileName); !strings.HasPrefix(dir, "/etc/kubernetes") {
+		hostCfg.Binds = append(hostCfg.Binds, fmt.Sprintf("%s:%s", path.Join(host.PrefixPath, dir), dir))
+	}
 	if hosts.IsDockerSELinuxEnabled(host) {
 		// We apply the label because we do not rewrite SELinux labels anymore on volume mounts (no :z)
 		logrus.Debugf("Applying security opt label [%s] for [%s] container on host [%s]", SELinuxLabel, ContainerName, host.Address)
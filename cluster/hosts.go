@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/docker/docker/api/types"
+	"github.com/rancher/rke/docker"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
@@ -39,7 +40,7 @@ func (c *Cluster) TunnelHosts(ctx context.Context, flags ExternalFlags) error {
 	for _, uniqueHost := range uniqueHosts {
 		runHost := uniqueHost
 		errgrp.Go(func() error {
-			if err := runHost.TunnelUp(ctx, c.DockerDialerFactory, c.getPrefixPath(runHost.OS()), c.Version); err != nil {
+			if err := runHost.TunnelUp(ctx, c.DockerDialerFactory, c.RuntimeFactory, c.getPrefixPath(runHost.OS()), c.Version); err != nil {
 				// Unsupported Docker version is NOT a connectivity problem that we can recover! So we bail out on it
 				if strings.Contains(err.Error(), "Unsupported Docker version found") {
 					return err
@@ -60,7 +61,14 @@ func (c *Cluster) TunnelHosts(ctx context.Context, flags ExternalFlags) error {
 		c.WorkerHosts = removeFromHosts(host, c.WorkerHosts)
 		c.RancherKubernetesEngineConfig.Nodes = removeFromRKENodes(host.RKEConfigNode, c.RancherKubernetesEngineConfig.Nodes)
 	}
-	return ValidateHostCount(c)
+	if err := ValidateHostCount(c); err != nil {
+		return err
+	}
+	if err := validateEtcdSnapshotUploader(c); err != nil {
+		return err
+	}
+	c.unpinLoadedImages(ctx)
+	return nil
 }
 
 func (c *Cluster) InvertIndexHosts() error {
@@ -84,7 +92,15 @@ func (c *Cluster) InvertIndexHosts() error {
 		if c.IgnoreDockerVersion != nil {
 			newHost.IgnoreDockerVersion = *c.IgnoreDockerVersion
 		}
-		if c.BastionHost.Address != "" {
+		newHost.HostKeyVerifier = c.HostKeyVerifier
+		if host.Transport == hosts.DockerTLSTransport {
+			newHost.PortForwardImage = c.SystemImages.Alpine
+			newHost.PortForwardRegistries = c.PrivateRegistriesMap
+		}
+		if host.BastionHost != nil {
+			// The bastion host of the node replaces the bastion host of the cluster
+			newHost.BastionHost = *host.BastionHost
+		} else if c.BastionHost.Address != "" {
 			// Add the bastion host information to each host object
 			newHost.BastionHost = c.BastionHost
 		}
@@ -256,6 +272,13 @@ func (c *Cluster) SetUpHosts(ctx context.Context, flags ExternalFlags) error {
 			log.Infof(ctx, "[%s] Successfully deployed kubernetes cloud config to Cluster nodes", cloudConfigFileName)
 		}
 
+		if docker.HasExecCredentialPlugin(c.PrivateRegistriesMap) {
+			if err := c.deployKubeletDockerConfig(ctx, hostList); err != nil {
+				return err
+			}
+			log.Infof(ctx, "[%s] Successfully deployed kubelet private registries credentials to Cluster nodes", KubeletDockerConfigPath)
+		}
+
 		if c.Authentication.Webhook != nil {
 			if err := deployFile(ctx, hostList, c.SystemImages.Alpine, c.PrivateRegistriesMap, authnWebhookFileName, c.Authentication.Webhook.ConfigFile); err != nil {
 				return err
@@ -303,6 +326,16 @@ func (c *Cluster) SetUpHosts(ctx context.Context, flags ExternalFlags) error {
 	return nil
 }
 
+// deployKubeletDockerConfig writes the private registries credentials of the kubelet, with the credentials of the
+// credential helpers, in the root directory of the kubelet
+func (c *Cluster) deployKubeletDockerConfig(ctx context.Context, hostList []*hosts.Host) error {
+	kubeletDockerConfig, err := docker.GetKubeletDockerConfig(c.PrivateRegistriesMap)
+	if err != nil {
+		return fmt.Errorf("Failed to get the private registries credentials of the kubelet: %v", err)
+	}
+	return deployFile(ctx, hostList, c.SystemImages.Alpine, c.PrivateRegistriesMap, KubeletDockerConfigPath, kubeletDockerConfig)
+}
+
 func CheckEtcdHostsChanged(kubeCluster, currentCluster *Cluster) error {
 	if currentCluster != nil {
 		etcdChanged := hosts.IsHostListChanged(currentCluster.EtcdHosts, kubeCluster.EtcdHosts)
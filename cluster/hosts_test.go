@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rke/docker/dockertest"
+	"github.com/rancher/rke/hosts"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvertIndexHostsBastionHost(t *testing.T) {
+	c := &Cluster{RancherKubernetesEngineConfig: v3.RancherKubernetesEngineConfig{
+		SSHKeyPath:  "~/.ssh/id_rsa",
+		BastionHost: v3.BastionHost{Address: "bastion.example.com"},
+		Nodes: []v3.RKEConfigNode{
+			{Address: "10.0.0.1", Role: []string{"controlplane"}},
+			{Address: "10.1.0.1", Role: []string{"worker"}, BastionHost: &v3.BastionHost{
+				Address: "rack-1.example.com",
+				Hops:    []v3.BastionHop{{Address: "10.1.0.254", SSHKeyPath: "~/.ssh/rack_1"}},
+			}},
+			{Address: "10.2.0.1", Role: []string{"worker"}, BastionHost: &v3.BastionHost{}},
+		},
+	}}
+	c.setBastionHostDefaults(&c.BastionHost)
+	for i := range c.Nodes {
+		if c.Nodes[i].BastionHost != nil {
+			c.setBastionHostDefaults(c.Nodes[i].BastionHost)
+		}
+	}
+	assert.Nil(t, c.InvertIndexHosts())
+	assert.Nil(t, validateBastionHost(*c.Nodes[1].BastionHost))
+
+	assert.Equal(t, "bastion.example.com", c.ControlPlaneHosts[0].BastionHost.Address)
+	assert.Equal(t, DefaultSSHPort, c.ControlPlaneHosts[0].BastionHost.Port)
+	rackBastion := c.WorkerHosts[0].BastionHost
+	assert.Equal(t, "rack-1.example.com", rackBastion.Address)
+	if assert.Len(t, rackBastion.Hops, 1) {
+		assert.Equal(t, DefaultSSHPort, rackBastion.Hops[0].Port)
+		assert.Equal(t, "~/.ssh/rack_1", rackBastion.Hops[0].SSHKeyPath)
+	}
+	// an empty bastion host connects to the node directly
+	assert.Equal(t, "", c.WorkerHosts[1].BastionHost.Address)
+	assert.True(t, c.usesBastionHost())
+
+	assert.NotNil(t, validateBastionHost(v3.BastionHost{Hops: []v3.BastionHop{{Address: "10.2.0.254"}}}))
+	assert.NotNil(t, validateBastionHost(v3.BastionHost{Address: "bastion.example.com", Hops: []v3.BastionHop{{}}}))
+}
+
+func TestSetBastionHostDefaultsSSHAgentAuth(t *testing.T) {
+	c := &Cluster{}
+	bastionHost := &v3.BastionHost{
+		Address:      "bastion.example.com",
+		SSHAgentAuth: true,
+		Hops:         []v3.BastionHop{{Address: "10.1.0.254", SSHAgentAuth: true}, {Address: "10.1.1.254"}},
+	}
+	c.setBastionHostDefaults(bastionHost)
+	assert.True(t, bastionHost.SSHAgentAuth)
+	assert.True(t, bastionHost.Hops[0].SSHAgentAuth)
+	assert.False(t, bastionHost.Hops[1].SSHAgentAuth)
+
+	// enabled for the cluster it's used for every hop
+	c.SSHAgentAuth = true
+	bastionHost = &v3.BastionHost{Address: "bastion.example.com", Hops: []v3.BastionHop{{Address: "10.1.0.254"}}}
+	c.setBastionHostDefaults(bastionHost)
+	assert.True(t, bastionHost.SSHAgentAuth)
+	assert.True(t, bastionHost.Hops[0].SSHAgentAuth)
+}
+
+func TestSetBastionHostDefaultsSSHKeyCommand(t *testing.T) {
+	c := &Cluster{}
+	c.SSHKeyCommand = "vault read ssh-key"
+	c.SSHKeyPassphraseCommand = "vault read ssh-key-passphrase"
+	bastionHost := &v3.BastionHost{
+		Address: "bastion.example.com",
+		Hops:    []v3.BastionHop{{Address: "10.1.0.254", SSHKeyCommand: "cat rack-1.key"}},
+	}
+	c.setBastionHostDefaults(bastionHost)
+	assert.Equal(t, "vault read ssh-key", bastionHost.SSHKeyCommand)
+	assert.Equal(t, "vault read ssh-key-passphrase", bastionHost.SSHKeyPassphraseCommand)
+	assert.Equal(t, "cat rack-1.key", bastionHost.Hops[0].SSHKeyCommand)
+	assert.Equal(t, "vault read ssh-key-passphrase", bastionHost.Hops[0].SSHKeyPassphraseCommand)
+}
+
+func TestKubeletDockerConfigCredentialHelper(t *testing.T) {
+	ctx := context.Background()
+	helper := filepath.Join(t.TempDir(), "docker-credential-test")
+	script := `#!/bin/sh
+read url
+echo "{\"ServerURL\":\"$url\",\"Username\":\"<token>\",\"Secret\":\"$(date +%s%N)\"}"
+`
+	assert.Nil(t, ioutil.WriteFile(helper, []byte(script), 0700))
+	c := &Cluster{PrivateRegistriesMap: map[string]v3.PrivateRegistry{
+		"helper.example.com": {URL: "helper.example.com", CredentialPlugin: map[string]string{"exec": helper}},
+	}}
+	c.SystemImages.Alpine = "rancher/rke-tools:v0.1.78"
+	c.DNS = &v3.DNSConfig{}
+	runtime := dockertest.NewRuntime("10.0.0.1", nil)
+	runtime.AddImage(c.SystemImages.Alpine)
+	host := &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: "10.0.0.1", InternalAddress: "10.0.0.1"}, DClient: runtime}
+
+	// the rotating token is not in the kubelet environment, it would recreate the kubelet on every run
+	kubelet := c.BuildKubeletProcess(host, v3.KubernetesServicesOptions{})
+	for _, env := range kubelet.Env {
+		assert.False(t, strings.HasPrefix(env, KubeletDockerConfigEnv+"="), env)
+	}
+	assert.Nil(t, c.deployKubeletDockerConfig(ctx, []*hosts.Host{host}))
+	assert.Contains(t, runtime.Events(), "10.0.0.1 create file-deployer")
+
+	// the kubelet is not deployed without its credentials
+	c.PrivateRegistriesMap["helper.example.com"] = v3.PrivateRegistry{URL: "helper.example.com", CredentialPlugin: map[string]string{"exec": helper + "-missing"}}
+	assert.NotNil(t, c.deployKubeletDockerConfig(ctx, []*hosts.Host{host}))
+}
@@ -0,0 +1,354 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	ref "github.com/docker/distribution/reference"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+	"github.com/rancher/rke/util"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// ImageDigestResolver returns the digest of the image in its registry
+type ImageDigestResolver func(ctx context.Context, image string) (string, error)
+
+// ImageDigestChange is a system image whose tag points to another digest than the locked one
+type ImageDigestChange struct {
+	Image   string
+	Locked  string
+	Current string
+}
+
+// rewriteImage applies the first rewrite rule matching the image
+func rewriteImage(image string, rules []v3.ImageRewriteRule) (string, error) {
+	for _, rule := range rules {
+		if rule.Prefix != "" {
+			if strings.HasPrefix(image, rule.Prefix) {
+				return rule.Replacement + strings.TrimPrefix(image, rule.Prefix), nil
+			}
+			continue
+		}
+		if rule.Regex == "" {
+			return "", fmt.Errorf("Image rewrite rule with replacement [%s] must set a prefix or a regex", rule.Replacement)
+		}
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return "", fmt.Errorf("Failed to parse image rewrite regex [%s]: %v", rule.Regex, err)
+		}
+		if re.MatchString(image) {
+			return re.ReplaceAllString(image, rule.Replacement), nil
+		}
+	}
+	return image, nil
+}
+
+// systemImageFields returns the images of the system images to update them in place
+func systemImageFields(images *v3.RKESystemImages) []*string {
+	v := reflect.ValueOf(images).Elem()
+	var fields []*string
+	for i := 0; i < v.NumField(); i++ {
+		if field, ok := v.Field(i).Addr().Interface().(*string); ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (c *Cluster) setImageRewrites() error {
+	if len(c.ImageRewrites) == 0 {
+		return nil
+	}
+	for _, image := range systemImageFields(&c.SystemImages) {
+		if *image == "" {
+			continue
+		}
+		rewritten, err := rewriteImage(*image, c.ImageRewrites)
+		if err != nil {
+			return err
+		}
+		if rewritten != *image {
+			logrus.Debugf("Rewriting image [%s] to [%s]", *image, rewritten)
+			*image = rewritten
+		}
+	}
+	return nil
+}
+
+// deployedImage returns the name the image of the default system images is deployed as, prefixed with the default
+// private registry and rewritten like the system images
+func (c *Cluster) deployedImage(image string) (string, error) {
+	return rewriteImage(d(image, c.defaultPrivateRegistryURL()), c.ImageRewrites)
+}
+
+// LoadImageBundle loads the image bundle in the Docker daemon of the cluster hosts, the images are tagged with the
+// names they are deployed as
+func (c *Cluster) LoadImageBundle(ctx context.Context, bundlePath string) error {
+	var tags []string
+	if err := docker.ReadImageBundle(bundlePath, func(r io.Reader) error {
+		var err error
+		tags, err = docker.GetImageBundleTags(r)
+		return err
+	}); err != nil {
+		return err
+	}
+	names := map[string]string{}
+	for _, tag := range tags {
+		name, err := c.deployedImage(tag)
+		if err != nil {
+			return err
+		}
+		if name != tag {
+			names[tag] = name
+		}
+	}
+	var errgrp errgroup.Group
+	hostList := hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts)
+	hostsQueue := util.GetObjectQueue(hostList)
+	for w := 0; w < WorkerThreads; w++ {
+		errgrp.Go(func() error {
+			var errList []error
+			for host := range hostsQueue {
+				runHost := host.(*hosts.Host)
+				log.Infof(ctx, "[images] Loading image bundle on host [%s]", runHost.Address)
+				err := docker.ReadImageBundle(bundlePath, func(r io.Reader) error {
+					return docker.LoadImageBundle(ctx, runHost.DClient, runHost.Address, r)
+				})
+				if err == nil {
+					err = docker.TagImages(ctx, runHost.DClient, runHost.Address, names)
+				}
+				if err != nil {
+					errList = append(errList, err)
+				}
+			}
+			return util.ErrList(errList)
+		})
+	}
+	return errgrp.Wait()
+}
+
+// unpinnedImage returns the image without the digest it is pinned to
+func unpinnedImage(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i]
+	}
+	return image
+}
+
+// pinImageDigests pins the system images to the digests locked in the state file by rke images lock, a tag changed
+// in the registry since the lock is not pulled
+func (c *Cluster) pinImageDigests(ctx context.Context) {
+	digests, ids, err := readLockedImages(ctx, c.StateFilePath)
+	if err != nil {
+		log.Warnf(ctx, "Failed to read the locked image digests of state file [%s], the images are not pinned: %v", c.StateFilePath, err)
+		return
+	}
+	if len(digests) == 0 {
+		return
+	}
+	pinned := 0
+	for _, image := range systemImageFields(&c.SystemImages) {
+		if *image == "" || strings.Contains(*image, "@") {
+			continue
+		}
+		if digest, ok := digests[*image]; ok {
+			pinnedImage := fmt.Sprintf("%s@%s", *image, digest)
+			if id := ids[*image]; id != "" {
+				if c.LockedImageIDs == nil {
+					c.LockedImageIDs = map[string]string{}
+				}
+				c.LockedImageIDs[pinnedImage] = id
+			}
+			*image = pinnedImage
+			pinned++
+		}
+	}
+	logrus.Debugf("Pinned %d system images to their locked digests", pinned)
+}
+
+// unpinLoadedImages deploys the pinned system images by their tag when the tag is the locked image on every host. The
+// images loaded with rke images load have no digest, the Docker daemon would pull them by digest.
+func (c *Cluster) unpinLoadedImages(ctx context.Context) {
+	hostList := hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts)
+	if len(c.LockedImageIDs) == 0 || len(hostList) == 0 {
+		return
+	}
+	unpinned := map[string]bool{}
+	for image, id := range c.LockedImageIDs {
+		if isImageLoaded(ctx, hostList, unpinnedImage(image), id) {
+			unpinned[image] = true
+		}
+	}
+	for _, image := range systemImageFields(&c.SystemImages) {
+		if unpinned[*image] {
+			logrus.Debugf("Deploying image [%s] by its tag, the tag is the locked image on all hosts", *image)
+			*image = unpinnedImage(*image)
+		}
+	}
+}
+
+// isImageLoaded returns true if the image has the ID on every host
+func isImageLoaded(ctx context.Context, hostList []*hosts.Host, image, id string) bool {
+	for _, host := range hostList {
+		if host.DClient == nil {
+			return false
+		}
+		inspect, _, err := host.DClient.ImageInspectWithRaw(ctx, image)
+		if err != nil || inspect.ID != id {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadImageDigests returns the image digests locked in the state file by image
+func ReadImageDigests(ctx context.Context, statePath string) (map[string]string, error) {
+	digests, _, err := readLockedImages(ctx, statePath)
+	return digests, err
+}
+
+// readLockedImages returns the image digests and the image IDs locked in the state file by image
+func readLockedImages(ctx context.Context, statePath string) (map[string]string, map[string]string, error) {
+	if statePath == "" {
+		return nil, nil, nil
+	}
+	exists, err := StateFileExists(ctx, statePath)
+	if err != nil || !exists {
+		return nil, nil, err
+	}
+	fullState, err := ReadStateFile(ctx, statePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fullState.ImageDigests, fullState.ImageIDs, nil
+}
+
+// CheckImageDigests warns about the pinned system images whose tag moved to another digest in the registry since they
+// were locked, the registry is queried by the Docker daemon of the first host
+func (c *Cluster) CheckImageDigests(ctx context.Context) {
+	var dClient docker.ContainerRuntime
+	for _, host := range hosts.GetUniqueHostList(c.EtcdHosts, c.ControlPlaneHosts, c.WorkerHosts) {
+		if host.DClient != nil {
+			dClient = host.DClient
+			break
+		}
+	}
+	if dClient == nil {
+		return
+	}
+	changes := c.movedImageTags(ctx, func(ctx context.Context, image string) (string, error) {
+		return docker.GetImageDigest(ctx, dClient, image, c.PrivateRegistriesMap)
+	})
+	for _, change := range changes {
+		log.Warnf(ctx, "[images] Tag of image [%s] moved from the locked digest [%s] to [%s], the locked digest is deployed until the images are locked again", change.Image, change.Locked, change.Current)
+	}
+}
+
+// movedImageTags resolves the tags of the pinned system images, the images that can't be resolved are skipped
+func (c *Cluster) movedImageTags(ctx context.Context, resolve ImageDigestResolver) []ImageDigestChange {
+	checked := map[string]bool{}
+	var changes []ImageDigestChange
+	for _, image := range systemImageFields(&c.SystemImages) {
+		named, err := ref.ParseNormalizedNamed(*image)
+		if err != nil {
+			continue
+		}
+		digested, ok := named.(ref.Digested)
+		if _, tagged := named.(ref.Tagged); !ok || !tagged {
+			continue
+		}
+		unpinned := unpinnedImage(*image)
+		if checked[unpinned] {
+			continue
+		}
+		checked[unpinned] = true
+		digest, err := resolve(ctx, unpinned)
+		if err != nil {
+			log.Warnf(ctx, "[images] Failed to check the locked digest of image [%s]: %v", unpinned, err)
+			continue
+		}
+		if digest != digested.Digest().String() {
+			changes = append(changes, ImageDigestChange{Image: unpinned, Locked: digested.Digest().String(), Current: digest})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Image < changes[j].Image })
+	return changes
+}
+
+// LockImageDigests resolves the digests of the system images of the cluster, it returns the digests by image and the
+// images whose digest changed since the previous lock
+func (c *Cluster) LockImageDigests(ctx context.Context, resolve ImageDigestResolver) (map[string]string, []ImageDigestChange, error) {
+	locked, err := ReadImageDigests(ctx, c.StateFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	images := map[string]bool{}
+	for _, image := range systemImageFields(&c.SystemImages) {
+		if *image != "" {
+			images[unpinnedImage(*image)] = true
+		}
+	}
+	var sortedImages []string
+	for image := range images {
+		sortedImages = append(sortedImages, image)
+	}
+	sort.Strings(sortedImages)
+
+	digests := make(map[string]string, len(sortedImages))
+	var changes []ImageDigestChange
+	for _, image := range sortedImages {
+		digest, err := resolve(ctx, image)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to resolve the digest of image [%s]: %v", image, err)
+		}
+		logrus.Debugf("Resolved image [%s] to digest [%s]", image, digest)
+		digests[image] = digest
+		if previous, ok := locked[image]; ok && previous != digest {
+			changes = append(changes, ImageDigestChange{Image: image, Locked: previous, Current: digest})
+		}
+	}
+	return digests, changes, nil
+}
+
+// LocalImageIDs returns the IDs of the locked images pulled in the Docker daemon by image, the images that are not in
+// the Docker daemon are skipped
+func LocalImageIDs(ctx context.Context, dClient docker.ContainerRuntime, digests map[string]string) map[string]string {
+	ids := map[string]string{}
+	for image, digest := range digests {
+		id, err := docker.GetLocalImageID(ctx, dClient, image, digest)
+		if err != nil {
+			log.Warnf(ctx, "[images] Failed to get the ID of image [%s], the image is only deployed by its digest: %v", image, err)
+			continue
+		}
+		if id != "" {
+			ids[image] = id
+		}
+	}
+	return ids
+}
+
+// WriteImageDigests records the locked image digests and the IDs of the locked images in the state file
+func WriteImageDigests(ctx context.Context, statePath string, digests, ids map[string]string) error {
+	fullState := &FullState{}
+	exists, err := StateFileExists(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if fullState, err = ReadStateFile(ctx, statePath); err != nil {
+			return err
+		}
+	}
+	fullState.ImageDigests = digests
+	fullState.ImageIDs = ids
+	return fullState.WriteStateFile(ctx, statePath)
+}
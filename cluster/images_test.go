@@ -0,0 +1,248 @@
+package cluster
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/docker/dockertest"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/metadata"
+	v3 "github.com/rancher/rke/types"
+	"github.com/rancher/rke/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteImage(t *testing.T) {
+	rules := []v3.ImageRewriteRule{
+		{Prefix: "rancher/hyperkube", Replacement: "mirror.local/k8s/hyperkube"},
+		{Regex: `^rancher/(mirrored-)?(.*)$`, Replacement: "mirror.local/rancher/$2"},
+	}
+	for image, expected := range map[string]string{
+		"rancher/hyperkube:v1.20.4-rancher1":  "mirror.local/k8s/hyperkube:v1.20.4-rancher1",
+		"rancher/mirrored-coreos-etcd:v3.4.3": "mirror.local/rancher/coreos-etcd:v3.4.3",
+		"rancher/rke-tools:v0.1.72":           "mirror.local/rancher/rke-tools:v0.1.72",
+		"quay.io/calico/node:v3.17.2":         "quay.io/calico/node:v3.17.2",
+	} {
+		rewritten, err := rewriteImage(image, rules)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, rewritten)
+	}
+
+	_, err := rewriteImage("rancher/rke-tools:v0.1.72", []v3.ImageRewriteRule{{Replacement: "mirror.local/"}})
+	assert.NotNil(t, err)
+	_, err = rewriteImage("rancher/rke-tools:v0.1.72", []v3.ImageRewriteRule{{Regex: "(", Replacement: "mirror.local/"}})
+	assert.NotNil(t, err)
+}
+
+func TestLockImageDigests(t *testing.T) {
+	ctx := context.Background()
+	c := &Cluster{StateFilePath: filepath.Join(t.TempDir(), "cluster.rkestate")}
+	c.SystemImages.Kubernetes = "rancher/hyperkube:v1.20.4-rancher1"
+	c.SystemImages.Etcd = "rancher/mirrored-coreos-etcd:v3.4.3"
+	c.SystemImages.Alpine = "rancher/rke-tools:v0.1.72"
+	c.SystemImages.CertDownloader = "rancher/rke-tools:v0.1.72"
+	registry := map[string]string{
+		"rancher/hyperkube:v1.20.4-rancher1":  "sha256:1111",
+		"rancher/mirrored-coreos-etcd:v3.4.3": "sha256:2222",
+		"rancher/rke-tools:v0.1.72":           "sha256:3333",
+	}
+	resolved := 0
+	resolve := func(ctx context.Context, image string) (string, error) {
+		resolved++
+		return registry[image], nil
+	}
+
+	digests, changes, err := c.LockImageDigests(ctx, resolve)
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, registry, digests)
+	assert.Equal(t, 3, resolved)
+	assert.Nil(t, WriteImageDigests(ctx, c.StateFilePath, digests, nil))
+
+	// the locked images are pinned, a state file written afterwards keeps the digests
+	c.pinImageDigests(ctx)
+	assert.Equal(t, "rancher/hyperkube:v1.20.4-rancher1@sha256:1111", c.SystemImages.Kubernetes)
+	assert.Equal(t, "rancher/rke-tools:v0.1.72@sha256:3333", c.SystemImages.CertDownloader)
+	c.pinImageDigests(ctx)
+	assert.Equal(t, "rancher/hyperkube:v1.20.4-rancher1@sha256:1111", c.SystemImages.Kubernetes)
+
+	// a mutated tag is reported against the locked digest
+	registry["rancher/rke-tools:v0.1.72"] = "sha256:4444"
+	_, changes, err = c.LockImageDigests(ctx, resolve)
+	assert.Nil(t, err)
+	assert.Equal(t, []ImageDigestChange{{Image: "rancher/rke-tools:v0.1.72", Locked: "sha256:3333", Current: "sha256:4444"}}, changes)
+}
+
+func writeImageBundle(t *testing.T, bundlePath string, tags []string) {
+	f, err := os.Create(bundlePath)
+	assert.Nil(t, err)
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	manifest, err := json.Marshal([]map[string]interface{}{{"Config": "config.json", "RepoTags": tags}})
+	assert.Nil(t, err)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(manifest))}))
+	_, err = tw.Write(manifest)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gw.Close())
+}
+
+func TestLoadImageBundle(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, metadata.InitMetadata(ctx))
+	c := &Cluster{}
+	c.Version = metadata.DefaultK8sVersion
+	c.PrivateRegistries = []v3.PrivateRegistry{{URL: "registry.example.com", IsDefault: true}}
+	c.ImageRewrites = []v3.ImageRewriteRule{{Prefix: "registry.example.com/rancher/hyperkube", Replacement: "registry.example.com/k8s/hyperkube"}}
+	assert.Nil(t, c.setClusterImageDefaults())
+	assert.Nil(t, c.setImageRewrites())
+
+	// the bundle written by rke images save has the names of the default system images
+	defaults := metadata.K8sVersionToRKESystemImages[c.Version]
+	bundlePath := filepath.Join(t.TempDir(), "rke-images.tar.gz")
+	writeImageBundle(t, bundlePath, []string{defaults.Kubernetes, defaults.Alpine})
+
+	eventLog := &dockertest.EventLog{}
+	var runtimes []*dockertest.Runtime
+	for _, address := range []string{"10.0.0.1", "10.0.0.2"} {
+		runtime := dockertest.NewRuntime(address, eventLog)
+		runtimes = append(runtimes, runtime)
+		host := &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: address}, DClient: runtime}
+		c.ControlPlaneHosts = append(c.ControlPlaneHosts, host)
+		c.WorkerHosts = append(c.WorkerHosts, host)
+	}
+	assert.Nil(t, c.LoadImageBundle(ctx, bundlePath))
+
+	for _, runtime := range runtimes {
+		for _, image := range []string{c.SystemImages.Kubernetes, c.SystemImages.Alpine} {
+			assert.True(t, runtime.HasImage(image), image)
+			assert.Nil(t, docker.UseLocalOrPull(ctx, runtime, runtime.Hostname, image, "images", nil))
+		}
+	}
+	assert.True(t, strings.HasPrefix(c.SystemImages.Kubernetes, "registry.example.com/k8s/hyperkube:"))
+	for _, event := range eventLog.Events() {
+		assert.NotContains(t, event, " pull ")
+	}
+}
+
+func TestLoadThenLockImages(t *testing.T) {
+	ctx := context.Background()
+	kubernetes, alpine := "rancher/hyperkube:v1.20.4-rancher1", "rancher/rke-tools:v0.1.72"
+	kubernetesDigest := "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	alpineDigest := "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	ids := map[string]string{kubernetes: "sha256:aaaa", alpine: "sha256:bbbb"}
+	c := &Cluster{StateFilePath: filepath.Join(t.TempDir(), "cluster.rkestate")}
+	c.SystemImages.Kubernetes = kubernetes
+	c.SystemImages.Alpine = alpine
+
+	// the air-gapped hosts load the bundle saved from the images pulled where rke images lock runs
+	bundlePath := filepath.Join(t.TempDir(), "rke-images.tar.gz")
+	writeImageBundle(t, bundlePath, []string{kubernetes, alpine})
+	eventLog := &dockertest.EventLog{}
+	var runtimes []*dockertest.Runtime
+	for _, address := range []string{"10.0.0.1", "10.0.0.2"} {
+		runtime := dockertest.NewRuntime(address, eventLog)
+		for image, id := range ids {
+			runtime.ImageIDs[image] = id
+		}
+		runtimes = append(runtimes, runtime)
+		c.WorkerHosts = append(c.WorkerHosts, &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: address}, DClient: runtime})
+	}
+	assert.Nil(t, c.LoadImageBundle(ctx, bundlePath))
+
+	local := dockertest.NewRuntime("local", nil)
+	for image, digest := range map[string]string{kubernetes: kubernetesDigest, alpine: alpineDigest} {
+		local.AddImage(image)
+		local.Digests[image] = digest
+		local.ImageIDs[image] = ids[image]
+	}
+	digests, _, err := c.LockImageDigests(ctx, func(ctx context.Context, image string) (string, error) {
+		return docker.GetImageDigest(ctx, local, image, nil)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ids, LocalImageIDs(ctx, local, digests))
+	// the loaded images were not pulled with the digest
+	assert.Empty(t, LocalImageIDs(ctx, runtimes[0], digests))
+	assert.Nil(t, WriteImageDigests(ctx, c.StateFilePath, digests, LocalImageIDs(ctx, local, digests)))
+
+	// the loaded images have no digest, they're deployed by their tag once the hosts are tunneled
+	c.pinImageDigests(ctx)
+	assert.Equal(t, kubernetes+"@"+kubernetesDigest, c.SystemImages.Kubernetes)
+	c.unpinLoadedImages(ctx)
+	assert.Equal(t, kubernetes, c.SystemImages.Kubernetes)
+	assert.Equal(t, alpine, c.SystemImages.Alpine)
+	for _, runtime := range runtimes {
+		assert.Nil(t, docker.UseLocalOrPull(ctx, runtime, runtime.Hostname, c.SystemImages.Kubernetes, "images", nil))
+	}
+	for _, event := range eventLog.Events() {
+		assert.NotContains(t, event, " pull ")
+	}
+
+	// a loaded image that is not the locked one is pulled by the locked digest
+	runtimes[1].ImageIDs[alpine] = "sha256:cccc"
+	c.SystemImages.Kubernetes = kubernetes
+	c.SystemImages.Alpine = alpine
+	c.pinImageDigests(ctx)
+	c.unpinLoadedImages(ctx)
+	assert.Equal(t, kubernetes, c.SystemImages.Kubernetes)
+	assert.Equal(t, alpine+"@"+alpineDigest, c.SystemImages.Alpine)
+}
+
+func TestPinnedImageTags(t *testing.T) {
+	c := &Cluster{}
+	c.SystemImages.KubernetesServicesSidecar = "rancher/rke-tools:v0.1.10@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	assert.Equal(t, []string{LegacyToolsEntrypoint}, c.getRKEToolsLinuxEntryPoint())
+	c.SystemImages.KubernetesServicesSidecar = "registry.example.com:5000/rancher/rke-tools:v0.1.78@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	assert.Equal(t, []string{DefaultToolsEntrypoint}, c.getRKEToolsLinuxEntryPoint())
+
+	tag, err := util.GetImageTagFromImage("registry.example.com:5000/rancher/mirrored-metrics-server:v0.5.0@sha256:2222222222222222222222222222222222222222222222222222222222222222")
+	assert.Nil(t, err)
+	assert.Equal(t, "v0.5.0", tag)
+	_, err = util.GetImageTagFromImage("rancher/mirrored-metrics-server@sha256:2222222222222222222222222222222222222222222222222222222222222222")
+	assert.NotNil(t, err)
+}
+
+func TestCheckImageDigests(t *testing.T) {
+	ctx := context.Background()
+	c := &Cluster{}
+	c.SystemImages.Kubernetes = "rancher/hyperkube:v1.20.4-rancher1@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	c.SystemImages.Alpine = "rancher/rke-tools:v0.1.72@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	c.SystemImages.CertDownloader = "rancher/rke-tools:v0.1.72@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	c.SystemImages.Etcd = "rancher/mirrored-coreos-etcd:v3.4.3"
+	runtime := dockertest.NewRuntime("10.0.0.1", nil)
+	runtime.Digests["rancher/hyperkube:v1.20.4-rancher1"] = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	runtime.Digests["rancher/rke-tools:v0.1.72"] = "sha256:4444444444444444444444444444444444444444444444444444444444444444"
+	c.ControlPlaneHosts = []*hosts.Host{{RKEConfigNode: v3.RKEConfigNode{Address: "10.0.0.1"}, DClient: runtime}}
+
+	// only the pinned images are resolved, once
+	changes := c.movedImageTags(ctx, func(ctx context.Context, image string) (string, error) {
+		return docker.GetImageDigest(ctx, runtime, image, nil)
+	})
+	assert.Equal(t, []ImageDigestChange{{Image: "rancher/rke-tools:v0.1.72", Locked: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Current: "sha256:4444444444444444444444444444444444444444444444444444444444444444"}}, changes)
+	assert.Equal(t, []string{
+		"10.0.0.1 inspect-distribution rancher/hyperkube:v1.20.4-rancher1",
+		"10.0.0.1 inspect-distribution rancher/rke-tools:v0.1.72",
+	}, sortedEvents(runtime.Events()))
+
+	// a registry that can't be queried doesn't fail rke up
+	runtime.Errors["inspect-distribution rancher/rke-tools:v0.1.72"] = fmt.Errorf("registry unreachable")
+	assert.Empty(t, c.movedImageTags(ctx, func(ctx context.Context, image string) (string, error) {
+		return docker.GetImageDigest(ctx, runtime, image, nil)
+	}))
+	c.CheckImageDigests(ctx)
+}
+
+func sortedEvents(events []string) []string {
+	sort.Strings(events)
+	return events
+}
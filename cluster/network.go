@@ -600,7 +600,7 @@ func (c *Cluster) CheckClusterPorts(ctx context.Context, currentCluster *Cluster
 		return err
 	}
 	// Skip kubeapi check if we are using custom k8s dialer or bastion/jump host
-	if c.K8sWrapTransport == nil && len(c.BastionHost.Address) == 0 {
+	if c.K8sWrapTransport == nil && !c.usesBastionHost() {
 		if err := c.checkKubeAPIPort(ctx); err != nil {
 			return err
 		}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -287,10 +288,7 @@ func (c *Cluster) BuildKubeAPIProcess(host *hosts.Host, serviceOptions v3.Kubern
 		}
 	}
 
-	for arg, value := range CommandArgs {
-		cmd := fmt.Sprintf("--%s=%s", arg, value)
-		Command = append(Command, cmd)
-	}
+	Command = appendArgs(Command, CommandArgs)
 
 	Binds = append(Binds, c.Services.KubeAPI.ExtraBinds...)
 
@@ -308,6 +306,7 @@ func (c *Cluster) BuildKubeAPIProcess(host *hosts.Host, serviceOptions v3.Kubern
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
 		Image:                   c.Services.KubeAPI.Image,
+		Resources:               c.Services.KubeAPI.Resources,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -349,6 +348,11 @@ func (c *Cluster) BuildKubeControllerProcess(host *hosts.Host, serviceOptions v3
 			CommandArgs[k] = v
 		}
 	}
+	// the CA key signing the CSRs of the cluster is not available when the certificates are signed by a signer command
+	if pki.IsExternalSigner(&c.RancherKubernetesEngineConfig) {
+		delete(CommandArgs, "cluster-signing-cert-file")
+		delete(CommandArgs, "cluster-signing-key-file")
+	}
 
 	args := []string{}
 	if c.Authorization.Mode == services.RBACAuthorizationMode {
@@ -367,10 +371,7 @@ func (c *Cluster) BuildKubeControllerProcess(host *hosts.Host, serviceOptions v3
 		}
 	}
 
-	for arg, value := range CommandArgs {
-		cmd := fmt.Sprintf("--%s=%s", arg, value)
-		Command = append(Command, cmd)
-	}
+	Command = appendArgs(Command, CommandArgs)
 
 	Binds = append(Binds, c.Services.KubeController.ExtraBinds...)
 
@@ -389,6 +390,7 @@ func (c *Cluster) BuildKubeControllerProcess(host *hosts.Host, serviceOptions v3
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
 		Image:                   c.Services.KubeController.Image,
+		Resources:               c.Services.KubeController.Resources,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -524,7 +526,8 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, serviceOptions v3.Kubern
 		Env = append(Env,
 			fmt.Sprintf("%s=%s", CloudConfigSumEnv, getStringChecksum(c.CloudConfigFile)))
 	}
-	if len(c.PrivateRegistriesMap) > 0 {
+	// the credentials of the credential helpers change between runs, they are deployed in a file by SetUpHosts
+	if len(c.PrivateRegistriesMap) > 0 && !docker.HasExecCredentialPlugin(c.PrivateRegistriesMap) {
 		kubeletDockerConfig, _ := docker.GetKubeletDockerConfig(c.PrivateRegistriesMap)
 		Env = append(Env,
 			fmt.Sprintf("%s=%s", KubeletDockerConfigEnv,
@@ -561,6 +564,7 @@ func (c *Cluster) BuildKubeletProcess(host *hosts.Host, serviceOptions v3.Kubern
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
 		Image:                   kubelet.Image,
+		Resources:               kubelet.Resources,
 		PidMode:                 "host",
 		Privileged:              true,
 		HealthCheck:             healthCheck,
@@ -656,6 +660,7 @@ func (c *Cluster) BuildKubeProxyProcess(host *hosts.Host, serviceOptions v3.Kube
 		Privileged:              true,
 		HealthCheck:             healthCheck,
 		Image:                   kubeproxy.Image,
+		Resources:               kubeproxy.Resources,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
 			services.ContainerNameLabel: services.KubeproxyContainerName,
@@ -749,10 +754,7 @@ func (c *Cluster) BuildSchedulerProcess(host *hosts.Host, serviceOptions v3.Kube
 		}
 	}
 
-	for arg, value := range CommandArgs {
-		cmd := fmt.Sprintf("--%s=%s", arg, value)
-		Command = append(Command, cmd)
-	}
+	Command = appendArgs(Command, CommandArgs)
 
 	Binds = append(Binds, c.Services.Scheduler.ExtraBinds...)
 
@@ -769,6 +771,7 @@ func (c *Cluster) BuildSchedulerProcess(host *hosts.Host, serviceOptions v3.Kube
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
 		Image:                   c.Services.Scheduler.Image,
+		Resources:               c.Services.Scheduler.Resources,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -939,10 +942,7 @@ func (c *Cluster) BuildEtcdProcess(host *hosts.Host, etcdHosts []*hosts.Host, se
 		args = append(args, "--peer-client-cert-auth")
 	}
 
-	for arg, value := range CommandArgs {
-		cmd := fmt.Sprintf("--%s=%s", arg, value)
-		args = append(args, cmd)
-	}
+	args = appendArgs(args, CommandArgs)
 
 	Binds = append(Binds, c.Services.Etcd.ExtraBinds...)
 	healthCheck := v3.HealthCheck{
@@ -997,6 +997,7 @@ func (c *Cluster) BuildEtcdProcess(host *hosts.Host, etcdHosts []*hosts.Host, se
 		NetworkMode:             "host",
 		RestartPolicy:           "always",
 		Image:                   c.Services.Etcd.Image,
+		Resources:               c.Services.Etcd.Resources,
 		HealthCheck:             healthCheck,
 		ImageRegistryAuthConfig: registryAuthConfig,
 		Labels: map[string]string{
@@ -1102,9 +1103,15 @@ func getNetworkJSON(netconfig v3.NetworkConfig) string {
 	return string(ret)
 }
 
+// appendArgs appends the flags sorted by name, so the command of a process is the same on every run
 func appendArgs(command []string, args map[string]string) []string {
-	for arg, value := range args {
-		command = append(command, fmt.Sprintf("--%s=%s", arg, value))
+	names := make([]string, 0, len(args))
+	for arg := range args {
+		names = append(names, arg)
+	}
+	sort.Strings(names)
+	for _, arg := range names {
+		command = append(command, fmt.Sprintf("--%s=%s", arg, args[arg]))
 	}
 	return command
 }
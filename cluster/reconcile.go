@@ -132,7 +132,7 @@ func reconcileHost(ctx context.Context, toDeleteHost *hosts.Host, worker, etcd b
 	retries := 3
 	sleepSeconds := 3
 	for i := 0; i < retries; i++ {
-		if retryErr = toDeleteHost.TunnelUp(ctx, cluster.DockerDialerFactory, cluster.getPrefixPath(toDeleteHost.OS()), cluster.Version); retryErr != nil {
+		if retryErr = toDeleteHost.TunnelUp(ctx, cluster.DockerDialerFactory, cluster.RuntimeFactory, cluster.getPrefixPath(toDeleteHost.OS()), cluster.Version); retryErr != nil {
 			logrus.Debugf("Failed to dial the host %s trying again in %d seconds", toDeleteHost.Address, sleepSeconds)
 			time.Sleep(time.Second * time.Duration(sleepSeconds))
 			toDeleteHost.DClient = nil
@@ -172,7 +172,10 @@ func reconcileEtcd(ctx context.Context, currentCluster, kubeCluster *Cluster, ku
 	etcdToDelete := hosts.GetToDeleteHosts(currentCluster.EtcdHosts, kubeCluster.EtcdHosts, kubeCluster.InactiveHosts, false)
 	etcdToAdd := hosts.GetToAddHosts(currentCluster.EtcdHosts, kubeCluster.EtcdHosts)
 	clientCert := cert.EncodeCertPEM(currentCluster.Certificates[pki.KubeNodeCertName].Certificate)
-	clientKey := cert.EncodePrivateKeyPEM(currentCluster.Certificates[pki.KubeNodeCertName].Key)
+	clientKey, err := cert.EncodePrivateKeyPEM(currentCluster.Certificates[pki.KubeNodeCertName].Key)
+	if err != nil {
+		return fmt.Errorf("Failed to encode the key of [%s]: %v", pki.KubeNodeCertName, err)
+	}
 
 	// check if the whole etcd plane is replaced
 	if isEtcdPlaneReplaced(ctx, currentCluster, kubeCluster) {
@@ -369,6 +372,25 @@ func restartComponentsWhenCertChanges(ctx context.Context, currentCluster, kubeC
 			}
 		}
 	}
+
+	if kubeCluster.IsKubeletGenerateServingCertificateEnabled() {
+		for _, host := range allHosts {
+			kubeletCertName := pki.GetCrtNameForHost(host, pki.KubeletCertName)
+			// kubelet was already restarted for the CA or the node certificate, or is new on the host
+			if currentCluster.Certificates[kubeletCertName].Certificate == nil || AllCertsMap[pki.CACertName] || AllCertsMap[pki.KubeNodeCertName] {
+				continue
+			}
+			certMap := map[string]bool{
+				kubeletCertName: false,
+			}
+			checkCertificateChanges(ctx, currentCluster, kubeCluster, certMap)
+			if certMap[kubeletCertName] {
+				if err := services.RestartKubelet(ctx, host); err != nil {
+					return err
+				}
+			}
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/sirupsen/logrus"
+)
+
+// newHostKeyVerifier verifies the SSH host keys against the known hosts file of the cluster and the keys recorded in
+// the state file, the keys of the hosts connected to for the first time are recorded in the state file
+func (c *Cluster) newHostKeyVerifier(ctx context.Context) *hosts.HostKeyVerifier {
+	statePath := c.StateFilePath
+	return &hosts.HostKeyVerifier{
+		KnownHostsPath: c.SSHKnownHostsPath,
+		LoadKeys: func() (map[string]string, error) {
+			return readSSHHostKeys(ctx, statePath)
+		},
+		SaveKey: func(address, fingerprint string) error {
+			return saveSSHHostKey(ctx, statePath, address, fingerprint)
+		},
+	}
+}
+
+// AcceptSSHHostKey records the fingerprint of a host in the state file, replacing the key trusted on first use. Without
+// fingerprint the recorded key is removed and the key of the next connection is trusted.
+func AcceptSSHHostKey(ctx context.Context, statePath, address, fingerprint string) error {
+	if fingerprint != "" && !strings.HasPrefix(fingerprint, "SHA256:") {
+		return fmt.Errorf("fingerprint [%s] is not in the SHA256:<base64> format of ssh-keygen -l", fingerprint)
+	}
+	fullState, err := ReadStateFile(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	address = hosts.NormalizeHostKeyAddress(address)
+	if fingerprint == "" {
+		if _, ok := fullState.SSHHostKeys[address]; !ok {
+			return fmt.Errorf("No SSH host key is recorded for host [%s] in state file [%s]", address, statePath)
+		}
+		delete(fullState.SSHHostKeys, address)
+		log.Infof(ctx, "[state] Removed the SSH host key of host [%s], the key of the next connection is trusted", address)
+	} else {
+		if fullState.SSHHostKeys == nil {
+			fullState.SSHHostKeys = map[string]string{}
+		}
+		fullState.SSHHostKeys[address] = fingerprint
+		log.Infof(ctx, "[state] Recorded SSH host key [%s] of host [%s]", fingerprint, address)
+	}
+	// the state lock is held, the removed key must not be merged back
+	return fullState.writeStateFile(ctx, statePath)
+}
+
+func readSSHHostKeys(ctx context.Context, statePath string) (map[string]string, error) {
+	exists, err := StateFileExists(ctx, statePath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	fullState, err := ReadStateFile(ctx, statePath)
+	if err != nil {
+		return nil, err
+	}
+	return fullState.SSHHostKeys, nil
+}
+
+func saveSSHHostKey(ctx context.Context, statePath, address, fingerprint string) error {
+	exists, err := StateFileExists(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// recorded with the first state file written by the run
+		logrus.Debugf("State file [%s] doesn't exist yet, not recording the SSH host key of host [%s]", statePath, address)
+		return nil
+	}
+	fullState, err := ReadStateFile(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	if fullState.SSHHostKeys == nil {
+		fullState.SSHHostKeys = map[string]string{}
+	}
+	fullState.SSHHostKeys[address] = fingerprint
+	return fullState.WriteStateFile(ctx, statePath)
+}
+
+// mergeSSHHostKeys keeps the SSH host keys recorded in the state file since the state was read, the keys are only
+// recorded on first use
+func (s *FullState) mergeSSHHostKeys(ctx context.Context, backend StateBackend) {
+	buf, err := backend.Read(ctx)
+	if err != nil {
+		return
+	}
+	if buf, err = decryptState(buf); err != nil {
+		logrus.Debugf("Failed to read the SSH host keys of state file [%s]: %v", backend.Location(), err)
+		return
+	}
+	recorded := struct {
+		SSHHostKeys map[string]string `json:"sshHostKeys,omitempty"`
+	}{}
+	if err := json.Unmarshal(buf, &recorded); err != nil {
+		logrus.Debugf("Failed to read the SSH host keys of state file [%s]: %v", backend.Location(), err)
+		return
+	}
+	for address, fingerprint := range recorded.SSHHostKeys {
+		if _, ok := s.SSHHostKeys[address]; ok {
+			continue
+		}
+		if s.SSHHostKeys == nil {
+			s.SSHHostKeys = map[string]string{}
+		}
+		s.SSHHostKeys[address] = fingerprint
+	}
+}
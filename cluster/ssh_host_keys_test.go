@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveSSHHostKey(t *testing.T) {
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "cluster.rkestate")
+
+	// nothing is recorded without a state file
+	assert.Nil(t, saveSSHHostKey(ctx, statePath, "1.1.1.1", "SHA256:one"))
+	keys, err := readSSHHostKeys(ctx, statePath)
+	assert.Nil(t, err)
+	assert.Nil(t, keys)
+
+	fullState := &FullState{}
+	assert.Nil(t, fullState.WriteStateFile(ctx, statePath))
+	assert.Nil(t, saveSSHHostKey(ctx, statePath, "1.1.1.1", "SHA256:one"))
+	assert.Nil(t, saveSSHHostKey(ctx, statePath, "[2.2.2.2]:2222", "SHA256:two"))
+
+	// writing a state read before the keys were recorded keeps the keys
+	assert.Nil(t, fullState.WriteStateFile(ctx, statePath))
+	keys, err = readSSHHostKeys(ctx, statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1.1.1.1": "SHA256:one", "[2.2.2.2]:2222": "SHA256:two"}, keys)
+}
+
+func TestAcceptSSHHostKey(t *testing.T) {
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "cluster.rkestate")
+	assert.NotNil(t, AcceptSSHHostKey(ctx, statePath, "1.1.1.1", "SHA256:new"))
+
+	fullState := &FullState{SSHHostKeys: map[string]string{"1.1.1.1": "SHA256:one", "[2.2.2.2]:2222": "SHA256:two"}}
+	assert.Nil(t, fullState.WriteStateFile(ctx, statePath))
+	assert.Nil(t, AcceptSSHHostKey(ctx, statePath, "1.1.1.1:22", "SHA256:new"))
+	assert.NotNil(t, AcceptSSHHostKey(ctx, statePath, "1.1.1.1", "MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48"))
+	// without fingerprint the key of the next connection is trusted
+	assert.Nil(t, AcceptSSHHostKey(ctx, statePath, "2.2.2.2:2222", ""))
+	assert.NotNil(t, AcceptSSHHostKey(ctx, statePath, "3.3.3.3", ""))
+	keys, err := readSSHHostKeys(ctx, statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"1.1.1.1": "SHA256:new"}, keys)
+}
@@ -3,9 +3,9 @@ package cluster
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -19,6 +19,7 @@ import (
 	"github.com/rancher/rke/pki"
 	"github.com/rancher/rke/services"
 	v3 "github.com/rancher/rke/types"
+	"github.com/rancher/rke/util"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
@@ -30,8 +31,16 @@ const (
 )
 
 type FullState struct {
-	DesiredState State `json:"desiredState,omitempty"`
-	CurrentState State `json:"currentState,omitempty"`
+	DesiredState State       `json:"desiredState,omitempty"`
+	CurrentState State       `json:"currentState,omitempty"`
+	Checkpoint   *Checkpoint `json:"checkpoint,omitempty"`
+	// SSH host key fingerprints trusted on first use by host address
+	SSHHostKeys map[string]string `json:"sshHostKeys,omitempty"`
+	// system image digests locked by rke images lock by image
+	ImageDigests map[string]string `json:"imageDigests,omitempty"`
+	// IDs of the locked system images in the Docker daemon running rke images lock by image, the images loaded with
+	// rke images load have no digest and are matched by their ID
+	ImageIDs map[string]string `json:"imageIDs,omitempty"`
 }
 
 type State struct {
@@ -63,6 +72,7 @@ func (c *Cluster) GetClusterState(ctx context.Context, fullState *FullState) (*C
 	currentCluster.EncryptionConfig.EncryptionProviderFile = fullState.CurrentState.EncryptionConfig
 	// resetup dialers
 	dialerOptions := hosts.GetDialerOptions(c.DockerDialerFactory, c.LocalConnDialerFactory, c.K8sWrapTransport)
+	dialerOptions.RuntimeFactory = c.RuntimeFactory
 	if err := currentCluster.SetupDialers(ctx, dialerOptions); err != nil {
 		return nil, err
 	}
@@ -92,6 +102,15 @@ func SaveFullStateToKubernetes(ctx context.Context, kubeCluster *Cluster, fullSt
 	if err != nil {
 		return err
 	}
+	provider, err := GetStateKeyProvider()
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		if stateFile, err = encryptState(stateFile, provider); err != nil {
+			return err
+		}
+	}
 	timeout := make(chan bool, 1)
 	go func() {
 		for {
@@ -205,15 +224,37 @@ func RebuildState(ctx context.Context, kubeCluster *Cluster, oldState *FullState
 }
 
 func (s *FullState) WriteStateFile(ctx context.Context, statePath string) error {
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		return err
+	}
+	s.mergeSSHHostKeys(ctx, backend)
+	return s.writeStateFile(ctx, statePath)
+}
+
+func (s *FullState) writeStateFile(ctx context.Context, statePath string) error {
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		return err
+	}
 	stateFile, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return fmt.Errorf("Failed to Marshal state object: %v", err)
 	}
 	logrus.Tracef("Writing state file: %s", stateFile)
-	if err := ioutil.WriteFile(statePath, stateFile, 0600); err != nil {
+	provider, err := GetStateKeyProvider()
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		if stateFile, err = encryptState(stateFile, provider); err != nil {
+			return err
+		}
+	}
+	if err := backend.Write(ctx, stateFile); err != nil {
 		return fmt.Errorf("Failed to write state file: %v", err)
 	}
-	log.Infof(ctx, "Successfully Deployed state file at [%s]", statePath)
+	log.Infof(ctx, "Successfully Deployed state file at [%s]", backend.Location())
 	return nil
 }
 
@@ -250,7 +291,11 @@ func GetCertificateDirPath(configPath, configDir string) string {
 func StringToFullState(ctx context.Context, stateFileContent string) (*FullState, error) {
 	rkeFullState := &FullState{}
 	logrus.Tracef("stateFileContent: %s", stateFileContent)
-	if err := json.Unmarshal([]byte(stateFileContent), rkeFullState); err != nil {
+	stateFile, err := decryptState([]byte(stateFileContent))
+	if err != nil {
+		return rkeFullState, err
+	}
+	if err := json.Unmarshal(stateFile, rkeFullState); err != nil {
 		return rkeFullState, err
 	}
 	rkeFullState.DesiredState.CertificatesBundle = pki.TransformPEMToObject(rkeFullState.DesiredState.CertificatesBundle)
@@ -266,15 +311,20 @@ func ReadStateFile(ctx context.Context, statePath string) (*FullState, error) {
 	if err != nil {
 		return rkeFullState, fmt.Errorf("failed to lookup current directory name: %v", err)
 	}
-	file, err := os.Open(fp)
+	backend, err := GetStateBackend(fp)
 	if err != nil {
-		return rkeFullState, fmt.Errorf("Can not find RKE state file: %v", err)
+		return rkeFullState, err
 	}
-	defer file.Close()
-	buf, err := ioutil.ReadAll(file)
+	buf, err := backend.Read(ctx)
 	if err != nil {
+		if err == errStateNotFound {
+			return rkeFullState, fmt.Errorf("Can not find RKE state file [%s]: %w", backend.Location(), err)
+		}
 		return rkeFullState, fmt.Errorf("failed to read state file: %v", err)
 	}
+	if buf, err = decryptState(buf); err != nil {
+		return rkeFullState, err
+	}
 	if err := json.Unmarshal(buf, rkeFullState); err != nil {
 		return rkeFullState, fmt.Errorf("failed to unmarshal the state file: %v", err)
 	}
@@ -283,15 +333,109 @@ func ReadStateFile(ctx context.Context, statePath string) (*FullState, error) {
 	return rkeFullState, nil
 }
 
+// IsStateFileNotFound returns true if the error of ReadStateFile is caused by a state file that doesn't exist
+func IsStateFileNotFound(err error) bool {
+	return errors.Is(err, errStateNotFound)
+}
+
+// EncryptStateFile encrypts the state file with the key provider configured in the environment, an encrypted
+// state file is decrypted first so the data key is rotated
+func EncryptStateFile(ctx context.Context, statePath string) error {
+	provider, err := GetStateKeyProvider()
+	if err != nil {
+		return err
+	}
+	if provider == nil {
+		return fmt.Errorf("No state encryption key configured, set one of %s, %s or %s", StateEncryptionKeyEnv, StateEncryptionKeyFileEnv, StateEncryptionKeyCommandEnv)
+	}
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		return err
+	}
+	buf, err := backend.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %v", err)
+	}
+	if buf, err = decryptState(buf); err != nil {
+		return err
+	}
+	if buf, err = encryptState(buf, provider); err != nil {
+		return err
+	}
+	if err := backend.Write(ctx, buf); err != nil {
+		return fmt.Errorf("Failed to write state file: %v", err)
+	}
+	log.Infof(ctx, "Successfully encrypted state file at [%s] with %s key provider", backend.Location(), provider.Name())
+	return nil
+}
+
+// DecryptStateFile replaces the encrypted state file with the plain state file
+func DecryptStateFile(ctx context.Context, statePath string) error {
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		return err
+	}
+	buf, err := backend.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %v", err)
+	}
+	if !IsStateEncrypted(buf) {
+		log.Infof(ctx, "State file at [%s] is not encrypted", backend.Location())
+		return nil
+	}
+	if buf, err = decryptState(buf); err != nil {
+		return err
+	}
+	if err := backend.Write(ctx, buf); err != nil {
+		return fmt.Errorf("Failed to write state file: %v", err)
+	}
+	log.Infof(ctx, "Successfully decrypted state file at [%s]", backend.Location())
+	log.Warnf(ctx, "The state file is encrypted again the next time rke writes it, unless %s, %s and %s are unset", StateEncryptionKeyEnv, StateEncryptionKeyFileEnv, StateEncryptionKeyCommandEnv)
+	return nil
+}
+
 func RemoveStateFile(ctx context.Context, statePath string) {
-	log.Infof(ctx, "Removing state file: %s", statePath)
-	if err := os.Remove(statePath); err != nil {
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		logrus.Warningf("Failed to remove state file: %v", err)
+		return
+	}
+	log.Infof(ctx, "Removing state file: %s", backend.Location())
+	if err := backend.Remove(ctx); err != nil {
 		logrus.Warningf("Failed to remove state file: %v", err)
 		return
 	}
 	log.Infof(ctx, "State file removed successfully")
 }
 
+// BackupStateFile keeps a copy of the current state file in the working directory before it's replaced
+func BackupStateFile(ctx context.Context, statePath string) error {
+	if IsLocalStateBackend() {
+		return util.ReplaceFileWithBackup(statePath, "rkestate")
+	}
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		return err
+	}
+	buf, err := backend.Read(ctx)
+	if err == errStateNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %v", err)
+	}
+	backupFile, err := ioutil.TempFile(".", "rkestate")
+	if err != nil {
+		return err
+	}
+	defer backupFile.Close()
+	if _, err := backupFile.Write(buf); err != nil {
+		return err
+	}
+	logrus.Infof("Copied state file [%s] to new location [%s] as back-up", backend.Location(), backupFile.Name())
+	return nil
+}
+
 func GetStateFromNodes(ctx context.Context, kubeCluster *Cluster) *Cluster {
 	var currentCluster Cluster
 	var clusterFile string
@@ -338,13 +482,22 @@ func rebuildExistingState(ctx context.Context, kubeCluster *Cluster, oldState, n
 	pkiCertBundle := oldState.DesiredState.CertificatesBundle
 	// check for legacy clusters prior to requestheaderca
 	if pkiCertBundle[pki.RequestHeaderCACertName].Certificate == nil {
-		if err := pki.GenerateRKERequestHeaderCACert(ctx, pkiCertBundle, flags.ClusterFilePath, flags.ConfigDir); err != nil {
+		if err := pki.GenerateRKERequestHeaderCACert(ctx, pkiCertBundle, *rkeConfig, flags.ClusterFilePath, flags.ConfigDir); err != nil {
 			return err
 		}
 	}
+	if pki.ExternalCAChanged(pkiCertBundle, *rkeConfig) {
+		log.Warnf(ctx, "[certificates] The external CA is not the CA of the cluster yet, run rke cert rotate --rotate-ca to use it")
+	}
+	if keyType, changed := pki.KeyTypeChanged(pkiCertBundle, *rkeConfig); changed {
+		log.Warnf(ctx, "[certificates] The certificates of the cluster have [%s] keys, the keys of pki [key_type] are only generated by rke cert rotate (--rotate-ca for the CA)", keyType)
+	}
 	if err := pki.GenerateRKEServicesCerts(ctx, pkiCertBundle, *rkeConfig, flags.ClusterFilePath, flags.ConfigDir, false); err != nil {
 		return err
 	}
+	if err := renewExpiringCertificates(ctx, kubeCluster, pkiCertBundle, flags); err != nil {
+		return err
+	}
 	newState.DesiredState.CertificatesBundle = pkiCertBundle
 	err := updateEncryptionConfig(kubeCluster, oldState, newState)
 	return err
@@ -0,0 +1,801 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rancher/rke/backup"
+	"github.com/rancher/rke/k8s"
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// StateBackendEnv selects where the state file is stored when no state backend is set with SetStateBackend, the
+	// local file next to the cluster file is used when it's not set either. Supported locations are
+	// s3://<bucket>[/<folder>][?endpoint=<endpoint>&region=<region>&endpoint-ca=<file>] and
+	// configmap://<namespace>[/<name>][?kubeconfig=<file>]
+	StateBackendEnv = "RKE_STATE_BACKEND"
+
+	LocalStateBackendName     = "local"
+	S3StateBackendName        = "s3"
+	ConfigMapStateBackendName = "configmap"
+
+	stateLockExt          = ".lock"
+	stateConfigMapDataKey = "rkestate"
+	stateLockAnnotation   = "rke.cattle.io/state-lock"
+)
+
+var errStateNotFound = errors.New("state file does not exist")
+
+var (
+	// stateLockLeaseDuration is how long the lock is held without being renewed, the lock of a run that was killed
+	// is taken over once its lease expired
+	stateLockLeaseDuration = 5 * time.Minute
+	stateLockRenewInterval = time.Minute
+	// stateLockExit exits rke after the lock was released on SIGINT or SIGTERM
+	stateLockExit = os.Exit
+)
+
+// stateBackends caches the backends used during the command, the clients of the remote backends are only created once
+var stateBackends = struct {
+	sync.Mutex
+	location string
+	byKey    map[string]StateBackend
+}{byKey: map[string]StateBackend{}}
+
+// StateBackend stores the state file of a cluster and the lock that makes sure only one rke run changes it
+type StateBackend interface {
+	Name() string
+	// Location is the state file location used in logs
+	Location() string
+	// Read returns errStateNotFound if the state file doesn't exist
+	Read(ctx context.Context) ([]byte, error)
+	Write(ctx context.Context, stateFile []byte) error
+	// Remove doesn't return an error if the state file doesn't exist
+	Remove(ctx context.Context) error
+	// Lock returns a StateLockedError if the lock is held by another run, a lock whose lease expired is taken over
+	Lock(ctx context.Context, lock *StateLock) error
+	// Renew replaces the lock with lock if it's still held with the same lock ID, to extend its lease
+	Renew(ctx context.Context, lock *StateLock) error
+	// Unlock releases the lock if it's still held with the lock ID
+	Unlock(ctx context.Context, lockID string) error
+	// ForceUnlock releases the lock whoever holds it
+	ForceUnlock(ctx context.Context) error
+}
+
+// StateLock describes the run holding the state lock
+type StateLock struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Operation string    `json:"operation"`
+	Created   time.Time `json:"created"`
+	// Expires is the end of the lease of the lock, the locks of older rke versions don't have one and don't expire
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (l *StateLock) expired() bool {
+	return !l.Expires.IsZero() && time.Now().After(l.Expires)
+}
+
+// StateLockedError is returned when the state lock is held by another run
+type StateLockedError struct {
+	Location string
+	Lock     *StateLock
+}
+
+func (e *StateLockedError) Error() string {
+	if e.Lock == nil {
+		return fmt.Sprintf("State file [%s] is locked by another run of rke, if no other run is in progress re-run with --force-unlock", e.Location)
+	}
+	if !e.Lock.Expires.IsZero() {
+		return fmt.Sprintf("State file [%s] is locked by [%s] running [%s] since [%s] with lock ID [%s], the lock is taken over if it's not renewed by [%s]",
+			e.Location, e.Lock.Owner, e.Lock.Operation, e.Lock.Created.Format(time.RFC3339), e.Lock.ID, e.Lock.Expires.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("State file [%s] is locked by [%s] running [%s] since [%s] with lock ID [%s], if that run is no longer in progress re-run with --force-unlock",
+		e.Location, e.Lock.Owner, e.Lock.Operation, e.Lock.Created.Format(time.RFC3339), e.Lock.ID)
+}
+
+// IsStateLockedError returns true if err is a StateLockedError
+func IsStateLockedError(err error) bool {
+	_, ok := err.(*StateLockedError)
+	return ok
+}
+
+type localStateBackend struct {
+	path string
+}
+
+type s3StateBackend struct {
+	bucket string
+	key    string
+	client *s3.S3
+}
+
+type configMapStateBackend struct {
+	namespace string
+	name      string
+	client    kubernetes.Interface
+}
+
+// SetStateBackend sets the location of the state backend, from the state_backend option of the cluster file or the
+// --state-backend flag. StateBackendEnv is used when it's empty.
+func SetStateBackend(location string) {
+	stateBackends.Lock()
+	defer stateBackends.Unlock()
+	stateBackends.location = location
+}
+
+// ParseStateBackend returns the state_backend option of the cluster file, it's needed before the state file is read
+func ParseStateBackend(clusterFile string) (string, error) {
+	config := struct {
+		StateBackend string `yaml:"state_backend"`
+	}{}
+	if err := yaml.Unmarshal([]byte(clusterFile), &config); err != nil {
+		return "", fmt.Errorf("Failed to parse state_backend of the cluster file: %v", err)
+	}
+	return config.StateBackend, nil
+}
+
+func getStateBackendLocation() string {
+	stateBackends.Lock()
+	defer stateBackends.Unlock()
+	if stateBackends.location != "" {
+		return stateBackends.location
+	}
+	return os.Getenv(StateBackendEnv)
+}
+
+// GetStateBackend returns the configured backend for the state file, statePath is the state file path derived from
+// the cluster file, remote backends use its base name to store the states of several clusters
+func GetStateBackend(statePath string) (StateBackend, error) {
+	location := getStateBackendLocation()
+	if location == "" {
+		return &localStateBackend{path: statePath}, nil
+	}
+	stateBackends.Lock()
+	defer stateBackends.Unlock()
+	key := location + "|" + statePath
+	if backend, ok := stateBackends.byKey[key]; ok {
+		return backend, nil
+	}
+	backend, err := newStateBackend(location, statePath)
+	if err != nil {
+		return nil, err
+	}
+	stateBackends.byKey[key] = backend
+	return backend, nil
+}
+
+func newStateBackend(location, statePath string) (StateBackend, error) {
+	stateURL, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse state backend [%s]: %v", location, err)
+	}
+	stateName := filepath.Base(statePath)
+	folder := strings.Trim(stateURL.Path, "/")
+	query := stateURL.Query()
+	switch stateURL.Scheme {
+	case S3StateBackendName:
+		if stateURL.Host == "" {
+			return nil, fmt.Errorf("State backend [%s] has no bucket", location)
+		}
+		return newS3StateBackend(stateURL.Host, path.Join(folder, stateName), query)
+	case ConfigMapStateBackendName:
+		if stateURL.Host == "" {
+			return nil, fmt.Errorf("State backend [%s] has no namespace", location)
+		}
+		name := folder
+		if name == "" {
+			name = strings.ReplaceAll(strings.ToLower(stateName), "_", "-")
+		}
+		if strings.Contains(name, "/") {
+			return nil, fmt.Errorf("State backend [%s] must be configmap://<namespace>/<name>", location)
+		}
+		client, err := k8s.NewClient(query.Get("kubeconfig"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create kubernetes client for the state ConfigMap: %v", err)
+		}
+		return &configMapStateBackend{namespace: stateURL.Host, name: name, client: client}, nil
+	}
+	return nil, fmt.Errorf("Unsupported state backend [%s], supported schemes are %s:// and %s://", location, S3StateBackendName, ConfigMapStateBackendName)
+}
+
+// IsLocalStateBackend returns true if the state file is stored next to the cluster file
+func IsLocalStateBackend() bool {
+	return getStateBackendLocation() == ""
+}
+
+// StateFileExists returns true if the state file exists in the configured backend
+func StateFileExists(ctx context.Context, statePath string) (bool, error) {
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		return false, err
+	}
+	if _, err := backend.Read(ctx); err != nil {
+		if err == errStateNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// LockStateFile takes the state lock until the returned function is called, forceUnlock releases the lock left by a
+// run that didn't finish first. The lease of the lock is renewed in the background and the lock is released when rke
+// is interrupted or terminated.
+func LockStateFile(ctx context.Context, statePath, operation string, forceUnlock bool) (func(), error) {
+	backend, err := GetStateBackend(statePath)
+	if err != nil {
+		return nil, err
+	}
+	if forceUnlock {
+		log.Warnf(ctx, "[state] Force unlocking state file [%s]", backend.Location())
+		if err := backend.ForceUnlock(ctx); err != nil {
+			return nil, fmt.Errorf("Failed to force unlock state file [%s]: %v", backend.Location(), err)
+		}
+	}
+	lock, err := newStateLock(operation)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Lock(ctx, lock); err != nil {
+		return nil, err
+	}
+	logrus.Debugf("[state] Locked state file [%s] with lock ID [%s]", backend.Location(), lock.ID)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	// the lock isn't renewed once it's released
+	var mu sync.Mutex
+	released := false
+	unlock := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if released {
+			return
+		}
+		released = true
+		signal.Stop(signals)
+		close(done)
+		if err := backend.Unlock(ctx, lock.ID); err != nil {
+			log.Warnf(ctx, "[state] Failed to unlock state file [%s]: %v", backend.Location(), err)
+			return
+		}
+		logrus.Debugf("[state] Unlocked state file [%s]", backend.Location())
+	}
+	renewInterval, exit := stateLockRenewInterval, stateLockExit
+	go func() {
+		ticker := time.NewTicker(renewInterval)
+		defer ticker.Stop()
+		renewed := *lock
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				log.Warnf(ctx, "[state] Received signal [%v], unlocking state file [%s]", sig, backend.Location())
+				unlock()
+				exitCode := 1
+				if sysSig, ok := sig.(syscall.Signal); ok {
+					exitCode = 128 + int(sysSig)
+				}
+				exit(exitCode)
+				return
+			case <-ticker.C:
+				mu.Lock()
+				if !released {
+					renewed.Expires = time.Now().UTC().Add(stateLockLeaseDuration)
+					if err := backend.Renew(ctx, &renewed); err != nil {
+						log.Warnf(ctx, "[state] Failed to renew the lock of state file [%s]: %v", backend.Location(), err)
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+	return unlock, nil
+}
+
+func newStateLock(operation string) (*StateLock, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	owner := "unknown"
+	if currentUser, err := user.Current(); err == nil {
+		owner = currentUser.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		owner = fmt.Sprintf("%s@%s", owner, hostname)
+	}
+	created := time.Now().UTC()
+	return &StateLock{
+		ID:        hex.EncodeToString(id),
+		Owner:     owner,
+		Operation: operation,
+		Created:   created,
+		Expires:   created.Add(stateLockLeaseDuration),
+	}, nil
+}
+
+func parseStateLock(location string, content []byte) *StateLockedError {
+	lock := &StateLock{}
+	if err := json.Unmarshal(content, lock); err != nil {
+		return &StateLockedError{Location: location}
+	}
+	return &StateLockedError{Location: location, Lock: lock}
+}
+
+func (b *localStateBackend) Name() string {
+	return LocalStateBackendName
+}
+
+func (b *localStateBackend) Location() string {
+	return b.path
+}
+
+func (b *localStateBackend) Read(ctx context.Context) ([]byte, error) {
+	stateFile, err := ioutil.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil, errStateNotFound
+	}
+	return stateFile, err
+}
+
+func (b *localStateBackend) Write(ctx context.Context, stateFile []byte) error {
+	return ioutil.WriteFile(b.path, stateFile, 0600)
+}
+
+func (b *localStateBackend) Remove(ctx context.Context) error {
+	if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *localStateBackend) Lock(ctx context.Context, lock *StateLock) error {
+	content, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	lockFile, err := os.OpenFile(b.path+stateLockExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if !os.IsExist(err) {
+			return fmt.Errorf("Failed to create state lock file: %v", err)
+		}
+		current, _ := ioutil.ReadFile(b.path + stateLockExt)
+		lockedErr := parseStateLock(b.path, current)
+		if lockedErr.Lock == nil || !lockedErr.Lock.expired() {
+			return lockedErr
+		}
+		// the expired lock is only removed if another run didn't take it over since it was read
+		if again, _ := ioutil.ReadFile(b.path + stateLockExt); !bytes.Equal(again, current) {
+			return parseStateLock(b.path, again)
+		}
+		log.Warnf(ctx, "[state] Taking over the lock of state file [%s] held by [%s], its lease expired at [%s]", b.path, lockedErr.Lock.Owner, lockedErr.Lock.Expires.Format(time.RFC3339))
+		if err := os.Remove(b.path + stateLockExt); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove expired state lock file: %v", err)
+		}
+		if lockFile, err = os.OpenFile(b.path+stateLockExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			if os.IsExist(err) {
+				current, _ := ioutil.ReadFile(b.path + stateLockExt)
+				return parseStateLock(b.path, current)
+			}
+			return fmt.Errorf("Failed to create state lock file: %v", err)
+		}
+	}
+	defer lockFile.Close()
+	if _, err := lockFile.Write(content); err != nil {
+		os.Remove(b.path + stateLockExt)
+		return fmt.Errorf("Failed to write state lock file: %v", err)
+	}
+	return nil
+}
+
+// Renew writes the lock next to the lock file and renames it, the lock file is never seen half written
+func (b *localStateBackend) Renew(ctx context.Context, lock *StateLock) error {
+	current, err := ioutil.ReadFile(b.path + stateLockExt)
+	if err != nil {
+		return err
+	}
+	if lockedErr := parseStateLock(b.path, current); lockedErr.Lock == nil || lockedErr.Lock.ID != lock.ID {
+		return fmt.Errorf("the lock is no longer held by this run")
+	}
+	content, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	tmpPath := b.path + stateLockExt + "." + lock.ID
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, b.path+stateLockExt); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func (b *localStateBackend) Unlock(ctx context.Context, lockID string) error {
+	current, err := ioutil.ReadFile(b.path + stateLockExt)
+	if err != nil {
+		return err
+	}
+	if lockedErr := parseStateLock(b.path, current); lockedErr.Lock == nil || lockedErr.Lock.ID != lockID {
+		return fmt.Errorf("the lock is no longer held by this run")
+	}
+	return os.Remove(b.path + stateLockExt)
+}
+
+func (b *localStateBackend) ForceUnlock(ctx context.Context) error {
+	if err := os.Remove(b.path + stateLockExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func newS3StateBackend(bucket, key string, query url.Values) (*s3StateBackend, error) {
+	config := &v3.S3BackupConfig{
+		BucketName: bucket,
+		Endpoint:   query.Get("endpoint"),
+		Region:     query.Get("region"),
+	}
+	if endpointCA := query.Get("endpoint-ca"); endpointCA != "" {
+		ca, err := ioutil.ReadFile(endpointCA)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read S3 endpoint CA [%s]: %v", endpointCA, err)
+		}
+		config.CustomCA = string(ca)
+	}
+	sess, err := backup.NewS3Session(config)
+	if err != nil {
+		return nil, err
+	}
+	return &s3StateBackend{bucket: bucket, key: key, client: s3.New(sess)}, nil
+}
+
+func (b *s3StateBackend) Name() string {
+	return S3StateBackendName
+}
+
+func (b *s3StateBackend) Location() string {
+	return fmt.Sprintf("s3://%s/%s", b.bucket, b.key)
+}
+
+func (b *s3StateBackend) Read(ctx context.Context) ([]byte, error) {
+	return b.getObject(ctx, b.key)
+}
+
+func (b *s3StateBackend) Write(ctx context.Context, stateFile []byte) error {
+	_, err := b.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key),
+		Body:   bytes.NewReader(stateFile),
+	})
+	return err
+}
+
+func (b *s3StateBackend) Remove(ctx context.Context) error {
+	return b.deleteObject(ctx, b.key)
+}
+
+// Lock writes the lock object of the run and lists the lock objects: the run only holds the lock when no other run
+// holds one, the runs locking at the same time both fail. S3 doesn't support conditional writes, the lock objects of
+// the runs have their own key instead so no run replaces the lock of another.
+func (b *s3StateBackend) Lock(ctx context.Context, lock *StateLock) error {
+	if err := b.putLock(ctx, lock); err != nil {
+		return fmt.Errorf("Failed to write state lock: %v", err)
+	}
+	keys, err := b.listLocks(ctx)
+	if err != nil {
+		b.deleteObject(ctx, b.lockKey(lock.ID))
+		return fmt.Errorf("Failed to list state locks: %v", err)
+	}
+	for _, key := range keys {
+		if key == b.lockKey(lock.ID) {
+			continue
+		}
+		current, err := b.getObject(ctx, key)
+		if err == errStateNotFound {
+			continue
+		}
+		if err != nil {
+			b.deleteObject(ctx, b.lockKey(lock.ID))
+			return fmt.Errorf("Failed to read state lock: %v", err)
+		}
+		lockedErr := parseStateLock(b.Location(), current)
+		if lockedErr.Lock != nil && lockedErr.Lock.expired() {
+			log.Warnf(ctx, "[state] Removing the lock of state file [%s] held by [%s], its lease expired at [%s]", b.Location(), lockedErr.Lock.Owner, lockedErr.Lock.Expires.Format(time.RFC3339))
+			if err := b.deleteObject(ctx, key); err != nil {
+				b.deleteObject(ctx, b.lockKey(lock.ID))
+				return fmt.Errorf("Failed to remove expired state lock: %v", err)
+			}
+			continue
+		}
+		if err := b.deleteObject(ctx, b.lockKey(lock.ID)); err != nil {
+			log.Warnf(ctx, "[state] Failed to remove state lock [%s]: %v", b.lockKey(lock.ID), err)
+		}
+		return lockedErr
+	}
+	return nil
+}
+
+func (b *s3StateBackend) Renew(ctx context.Context, lock *StateLock) error {
+	if _, err := b.getObject(ctx, b.lockKey(lock.ID)); err != nil {
+		if err == errStateNotFound {
+			return fmt.Errorf("the lock is no longer held by this run")
+		}
+		return err
+	}
+	return b.putLock(ctx, lock)
+}
+
+func (b *s3StateBackend) Unlock(ctx context.Context, lockID string) error {
+	if _, err := b.getObject(ctx, b.lockKey(lockID)); err != nil {
+		if err == errStateNotFound {
+			return fmt.Errorf("the lock is no longer held by this run")
+		}
+		return err
+	}
+	return b.deleteObject(ctx, b.lockKey(lockID))
+}
+
+func (b *s3StateBackend) ForceUnlock(ctx context.Context) error {
+	keys, err := b.listLocks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.deleteObject(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockKey returns the key of the lock object of a run, the locks of older rke versions are stored at the key of the
+// state file with the lock extension
+func (b *s3StateBackend) lockKey(lockID string) string {
+	return b.key + stateLockExt + "/" + lockID
+}
+
+func (b *s3StateBackend) putLock(ctx context.Context, lock *StateLock) error {
+	content, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	_, err = b.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.lockKey(lock.ID)),
+		Body:   bytes.NewReader(content),
+	})
+	return err
+}
+
+// listLocks returns the keys of the lock objects, including the lock of older rke versions
+func (b *s3StateBackend) listLocks(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := b.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(b.key + stateLockExt),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+			if key == b.key+stateLockExt || strings.HasPrefix(key, b.key+stateLockExt+"/") {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	return keys, err
+}
+
+func (b *s3StateBackend) getObject(ctx context.Context, key string) ([]byte, error) {
+	output, err := b.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errStateNotFound
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+	return ioutil.ReadAll(output.Body)
+}
+
+func (b *s3StateBackend) deleteObject(ctx context.Context, key string) error {
+	_, err := b.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil
+	}
+	return err
+}
+
+func (b *configMapStateBackend) Name() string {
+	return ConfigMapStateBackendName
+}
+
+func (b *configMapStateBackend) Location() string {
+	return fmt.Sprintf("configmap://%s/%s", b.namespace, b.name)
+}
+
+func (b *configMapStateBackend) Read(ctx context.Context) ([]byte, error) {
+	configMap, err := b.client.CoreV1().ConfigMaps(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, errStateNotFound
+		}
+		return nil, err
+	}
+	stateFile, ok := configMap.Data[stateConfigMapDataKey]
+	if !ok {
+		return nil, errStateNotFound
+	}
+	return []byte(stateFile), nil
+}
+
+func (b *configMapStateBackend) Write(ctx context.Context, stateFile []byte) error {
+	configMaps := b.client.CoreV1().ConfigMaps(b.namespace)
+	configMap, err := configMaps.Get(ctx, b.name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		_, err = configMaps.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      b.name,
+				Namespace: b.namespace,
+			},
+			Data: map[string]string{stateConfigMapDataKey: string(stateFile)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[stateConfigMapDataKey] = string(stateFile)
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+func (b *configMapStateBackend) Remove(ctx context.Context) error {
+	err := b.client.CoreV1().ConfigMaps(b.namespace).Delete(ctx, b.name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Lock creates a Lease next to the ConfigMap, creating it fails if another run holds the lock. An expired Lease is
+// updated with the resource version it was read with, only one run takes it over.
+func (b *configMapStateBackend) Lock(ctx context.Context, lock *StateLock) error {
+	leases := b.client.CoordinationV1().Leases(b.namespace)
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      b.lockName(),
+			Namespace: b.namespace,
+		},
+	}
+	if err := setLeaseLock(lease, lock); err != nil {
+		return err
+	}
+	_, err := leases.Create(ctx, lease, metav1.CreateOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("Failed to create state lock: %v", err)
+	}
+	current, err := leases.Get(ctx, b.lockName(), metav1.GetOptions{})
+	if err != nil {
+		return &StateLockedError{Location: b.Location()}
+	}
+	lockedErr := parseStateLock(b.Location(), []byte(current.Annotations[stateLockAnnotation]))
+	if lockedErr.Lock == nil || !lockedErr.Lock.expired() {
+		return lockedErr
+	}
+	log.Warnf(ctx, "[state] Taking over the lock of state file [%s] held by [%s], its lease expired at [%s]", b.Location(), lockedErr.Lock.Owner, lockedErr.Lock.Expires.Format(time.RFC3339))
+	if err := setLeaseLock(current, lock); err != nil {
+		return err
+	}
+	if _, err := leases.Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsConflict(err) {
+			return &StateLockedError{Location: b.Location()}
+		}
+		return fmt.Errorf("Failed to update state lock: %v", err)
+	}
+	return nil
+}
+
+func (b *configMapStateBackend) Renew(ctx context.Context, lock *StateLock) error {
+	leases := b.client.CoordinationV1().Leases(b.namespace)
+	lease, err := leases.Get(ctx, b.lockName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != lock.ID {
+		return fmt.Errorf("the lock is no longer held by this run")
+	}
+	if err := setLeaseLock(lease, lock); err != nil {
+		return err
+	}
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// setLeaseLock sets the holder and the lease of the Lease from the lock, the lock is kept in an annotation
+func setLeaseLock(lease *coordinationv1.Lease, lock *StateLock) error {
+	content, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[stateLockAnnotation] = string(content)
+	acquireTime := metav1.NewMicroTime(lock.Created)
+	renewTime := metav1.NewMicroTime(time.Now())
+	lease.Spec.HolderIdentity = aws.String(lock.ID)
+	lease.Spec.AcquireTime = &acquireTime
+	lease.Spec.RenewTime = &renewTime
+	if !lock.Expires.IsZero() {
+		lease.Spec.LeaseDurationSeconds = aws.Int32(int32(time.Until(lock.Expires).Seconds()))
+	}
+	return nil
+}
+
+func (b *configMapStateBackend) Unlock(ctx context.Context, lockID string) error {
+	leases := b.client.CoordinationV1().Leases(b.namespace)
+	lease, err := leases.Get(ctx, b.lockName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != lockID {
+		return fmt.Errorf("the lock is no longer held by this run")
+	}
+	return leases.Delete(ctx, b.lockName(), metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &lease.UID}})
+}
+
+func (b *configMapStateBackend) ForceUnlock(ctx context.Context) error {
+	err := b.client.CoordinationV1().Leases(b.namespace).Delete(ctx, b.lockName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *configMapStateBackend) lockName() string {
+	return b.name + stateLockExt
+}
@@ -0,0 +1,321 @@
+package cluster
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rancher/rke/backup"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testStateBackend(t *testing.T, backend StateBackend) {
+	ctx := context.Background()
+	stateFile := []byte(`{"desiredState":{}}`)
+
+	_, err := backend.Read(ctx)
+	assert.Equal(t, errStateNotFound, err)
+	assert.Nil(t, backend.Write(ctx, stateFile))
+	// writing again replaces the state file
+	assert.Nil(t, backend.Write(ctx, stateFile))
+	read, err := backend.Read(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, stateFile, read)
+
+	lock, err := newStateLock("up")
+	assert.Nil(t, err)
+	assert.Nil(t, backend.Lock(ctx, lock))
+	otherLock, err := newStateLock("remove")
+	assert.Nil(t, err)
+	err = backend.Lock(ctx, otherLock)
+	if assert.True(t, IsStateLockedError(err)) {
+		assert.Equal(t, lock.ID, err.(*StateLockedError).Lock.ID)
+		assert.Equal(t, "up", err.(*StateLockedError).Lock.Operation)
+	}
+	assert.NotNil(t, backend.Unlock(ctx, otherLock.ID))
+	assert.Nil(t, backend.Unlock(ctx, lock.ID))
+	assert.Nil(t, backend.Lock(ctx, otherLock))
+
+	// the lock left by a run that didn't finish is only released by force
+	assert.Nil(t, backend.ForceUnlock(ctx))
+	assert.Nil(t, backend.ForceUnlock(ctx))
+	assert.Nil(t, backend.Lock(ctx, lock))
+	assert.Nil(t, backend.Unlock(ctx, lock.ID))
+
+	// the lock of a run that is gone is taken over once its lease expired
+	expiredLock, err := newStateLock("up")
+	assert.Nil(t, err)
+	expiredLock.Expires = time.Now().UTC().Add(-time.Second)
+	assert.Nil(t, backend.Lock(ctx, expiredLock))
+	assert.Nil(t, backend.Lock(ctx, lock))
+	assert.NotNil(t, backend.Renew(ctx, expiredLock))
+	assert.NotNil(t, backend.Unlock(ctx, expiredLock.ID))
+	// the run holding the lock renews its lease
+	renewed := *lock
+	renewed.Expires = lock.Expires.Add(time.Hour)
+	assert.Nil(t, backend.Renew(ctx, &renewed))
+	err = backend.Lock(ctx, otherLock)
+	if assert.True(t, IsStateLockedError(err)) {
+		assert.True(t, renewed.Expires.Equal(err.(*StateLockedError).Lock.Expires))
+	}
+	assert.Nil(t, backend.Unlock(ctx, lock.ID))
+
+	assert.Nil(t, backend.Remove(ctx))
+	// removing a missing state file is not an error
+	assert.Nil(t, backend.Remove(ctx))
+	_, err = backend.Read(ctx)
+	assert.Equal(t, errStateNotFound, err)
+}
+
+func TestLocalStateBackend(t *testing.T) {
+	backend, err := GetStateBackend(filepath.Join(t.TempDir(), "cluster.rkestate"))
+	assert.Nil(t, err)
+	assert.Equal(t, LocalStateBackendName, backend.Name())
+	testStateBackend(t, backend)
+}
+
+func TestConfigMapStateBackend(t *testing.T) {
+	testStateBackend(t, &configMapStateBackend{
+		namespace: "rke",
+		name:      "cluster.rkestate",
+		client:    fake.NewSimpleClientset(),
+	})
+}
+
+// TestS3StateBackend runs against a MinIO server, for example:
+// docker run -p 9000:9000 minio/minio server /data
+// RKE_TEST_S3_STATE_BACKEND='s3://rke-test/states?endpoint=http://127.0.0.1:9000' go test ./cluster
+func TestS3StateBackend(t *testing.T) {
+	location := os.Getenv("RKE_TEST_S3_STATE_BACKEND")
+	if location == "" {
+		t.Skip("RKE_TEST_S3_STATE_BACKEND is not set")
+	}
+	setStateEnv(t, StateBackendEnv, location)
+	backend, err := GetStateBackend("cluster.rkestate")
+	assert.Nil(t, err)
+	testStateBackend(t, backend)
+}
+
+// fakeS3Server stores the objects of a bucket in memory, it implements the requests of the S3 state backend
+type fakeS3Server struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+type fakeS3ListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Name     string
+	KeyCount int
+	Contents []fakeS3Object
+}
+
+type fakeS3Object struct {
+	Key  string
+	Size int
+}
+
+func (s *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+s.bucket), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		result := fakeS3ListResult{Name: s.bucket}
+		for objectKey, content := range s.objects {
+			if strings.HasPrefix(objectKey, r.URL.Query().Get("prefix")) {
+				result.Contents = append(result.Contents, fakeS3Object{Key: objectKey, Size: len(content)})
+			}
+		}
+		result.KeyCount = len(result.Contents)
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		content, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = content
+	case r.Method == http.MethodGet:
+		content, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3StateBackendLock(t *testing.T) {
+	ctx := context.Background()
+	server := &fakeS3Server{bucket: "rke-test", objects: map[string][]byte{}}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	sess, err := backup.NewS3Session(&v3.S3BackupConfig{Endpoint: httpServer.URL, AccessKey: "access", SecretKey: "secret"})
+	assert.Nil(t, err)
+	backend := &s3StateBackend{bucket: server.bucket, key: "states/cluster.rkestate", client: s3.New(sess)}
+	testStateBackend(t, backend)
+
+	// a run writing its lock object while another run locks makes it fail, neither replaces the lock of the other
+	lock, err := newStateLock("up")
+	assert.Nil(t, err)
+	otherLock, err := newStateLock("up")
+	assert.Nil(t, err)
+	assert.Nil(t, backend.putLock(ctx, otherLock))
+	err = backend.Lock(ctx, lock)
+	if assert.True(t, IsStateLockedError(err)) {
+		assert.Equal(t, otherLock.ID, err.(*StateLockedError).Lock.ID)
+	}
+	assert.NotContains(t, server.objects, backend.lockKey(lock.ID))
+	assert.Contains(t, server.objects, backend.lockKey(otherLock.ID))
+
+	// the lock of older rke versions is still honored and removed by force
+	assert.Nil(t, backend.Unlock(ctx, otherLock.ID))
+	server.objects[backend.key+stateLockExt] = []byte(`{"id":"legacy"}`)
+	err = backend.Lock(ctx, lock)
+	if assert.True(t, IsStateLockedError(err)) {
+		assert.Equal(t, "legacy", err.(*StateLockedError).Lock.ID)
+	}
+	assert.Nil(t, backend.ForceUnlock(ctx))
+	assert.Nil(t, backend.Lock(ctx, lock))
+	assert.Nil(t, backend.Unlock(ctx, lock.ID))
+	assert.Empty(t, server.objects)
+}
+
+func TestGetStateBackend(t *testing.T) {
+	setStateEnv(t, StateBackendEnv, "s3://rke-states/prod?endpoint=minio.example.com&region=eu-west-1")
+	backend, err := GetStateBackend("/home/rke/cluster.rkestate")
+	assert.Nil(t, err)
+	assert.Equal(t, "s3://rke-states/prod/cluster.rkestate", backend.Location())
+	assert.False(t, IsLocalStateBackend())
+
+	kubeconfig := filepath.Join(t.TempDir(), "kube_config")
+	assert.Nil(t, os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: local
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: local
+  context:
+    cluster: local
+current-context: local
+`), 0600))
+	setStateEnv(t, StateBackendEnv, "configmap://rke-system?kubeconfig="+kubeconfig)
+	backend, err = GetStateBackend("/home/rke/My_Cluster.rkestate")
+	assert.Nil(t, err)
+	assert.Equal(t, "configmap://rke-system/my-cluster.rkestate", backend.Location())
+	// the kubernetes client is created once for the command
+	cached, err := GetStateBackend("/home/rke/My_Cluster.rkestate")
+	assert.Nil(t, err)
+	assert.True(t, backend == cached)
+
+	// the cluster file or the --state-backend flag override the environment
+	SetStateBackend("s3://rke-states/cluster-file?endpoint=minio.example.com")
+	t.Cleanup(func() { SetStateBackend("") })
+	backend, err = GetStateBackend("/home/rke/cluster.rkestate")
+	assert.Nil(t, err)
+	assert.Equal(t, "s3://rke-states/cluster-file/cluster.rkestate", backend.Location())
+	SetStateBackend("")
+
+	for _, location := range []string{"s3:///states", "configmap:///", "configmap://rke-system/a/b", "gcs://bucket"} {
+		setStateEnv(t, StateBackendEnv, location)
+		_, err = GetStateBackend("cluster.rkestate")
+		assert.NotNil(t, err, location)
+	}
+}
+
+func TestLockStateFile(t *testing.T) {
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "cluster.rkestate")
+
+	unlock, err := LockStateFile(ctx, statePath, "up", false)
+	assert.Nil(t, err)
+	_, err = LockStateFile(ctx, statePath, "up", false)
+	assert.True(t, IsStateLockedError(err))
+	unlock()
+	unlock, err = LockStateFile(ctx, statePath, "up", false)
+	assert.Nil(t, err)
+
+	// the first run is gone without releasing the lock
+	forcedUnlock, err := LockStateFile(ctx, statePath, "up", true)
+	assert.Nil(t, err)
+	forcedUnlock()
+	_, err = os.Stat(statePath + stateLockExt)
+	assert.True(t, os.IsNotExist(err))
+	// releasing a lock that was taken over by force doesn't remove the new lock
+	newUnlock, err := LockStateFile(ctx, statePath, "up", false)
+	assert.Nil(t, err)
+	unlock()
+	_, err = os.Stat(statePath + stateLockExt)
+	assert.Nil(t, err)
+	newUnlock()
+}
+
+func TestLockStateFileLease(t *testing.T) {
+	defer func(interval time.Duration, exit func(int)) {
+		stateLockRenewInterval, stateLockExit = interval, exit
+	}(stateLockRenewInterval, stateLockExit)
+	stateLockRenewInterval = 10 * time.Millisecond
+	exitCodes := make(chan int, 1)
+	stateLockExit = func(code int) { exitCodes <- code }
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "cluster.rkestate")
+	readLock := func() *StateLock {
+		content, err := ioutil.ReadFile(statePath + stateLockExt)
+		if err != nil {
+			return nil
+		}
+		return parseStateLock(statePath, content).Lock
+	}
+
+	unlock, err := LockStateFile(ctx, statePath, "up", false)
+	assert.Nil(t, err)
+	defer unlock()
+	lock := readLock()
+	if !assert.NotNil(t, lock) {
+		return
+	}
+	assert.Eventually(t, func() bool {
+		renewed := readLock()
+		return renewed != nil && renewed.ID == lock.ID && renewed.Expires.After(lock.Expires)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the lock is released when rke is terminated
+	process, err := os.FindProcess(os.Getpid())
+	assert.Nil(t, err)
+	assert.Nil(t, process.Signal(syscall.SIGTERM))
+	select {
+	case code := <-exitCodes:
+		assert.Equal(t, 128+int(syscall.SIGTERM), code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock was not released on SIGTERM")
+	}
+	_, err = os.Stat(statePath + stateLockExt)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseStateBackend(t *testing.T) {
+	location, err := ParseStateBackend("nodes: []\nstate_backend: configmap://rke-system/prod\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "configmap://rke-system/prod", location)
+	location, err = ParseStateBackend("nodes: []\n")
+	assert.Nil(t, err)
+	assert.Empty(t, location)
+	_, err = ParseStateBackend("state_backend: [")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,296 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+const (
+	StateEncryptionKeyEnv        = "RKE_STATE_ENCRYPTION_KEY"
+	StateEncryptionKeyFileEnv    = "RKE_STATE_ENCRYPTION_KEY_FILE"
+	StateEncryptionKeyCommandEnv = "RKE_STATE_ENCRYPTION_KEY_COMMAND"
+
+	StateKeyPluginAPIVersion = "rke.cattle.io/v1"
+	stateKeyRequestKind      = "StateKeyRequest"
+	stateKeyResponseKind     = "StateKeyResponse"
+	stateKeyWrapOperation    = "wrap"
+	stateKeyUnwrapOperation  = "unwrap"
+
+	localStateKeyProviderName = "aesgcm"
+	execStateKeyProviderName  = "exec"
+	stateKeySize              = 32
+)
+
+// StateKeyProvider protects the data key of an encrypted state file. The state is encrypted with a random data key,
+// only the data key is sent to the provider, so the key encryption key never has to be on the machine running rke.
+type StateKeyProvider interface {
+	// Name is stored in the state file to find the provider that can unwrap the data key
+	Name() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(encryptedKey []byte) ([]byte, error)
+}
+
+// encryptedFullState is the content of an encrypted state file, the state is still a JSON document
+type encryptedFullState struct {
+	EncryptedState *stateEnvelope `json:"encryptedState,omitempty"`
+}
+
+type stateEnvelope struct {
+	Provider     string `json:"provider"`
+	EncryptedKey []byte `json:"encryptedKey"`
+	Nonce        []byte `json:"nonce"`
+	Data         []byte `json:"data"`
+}
+
+// localStateKeyProvider wraps the data key with a key from an env var or a file
+type localStateKeyProvider struct {
+	key []byte
+}
+
+// execStateKeyProvider wraps the data key with a plugin, the plugin reads a StateKeyRequest on stdin and writes a
+// StateKeyResponse on stdout, for example to call a KMS
+type execStateKeyProvider struct {
+	command []string
+}
+
+type stateKeyMessage struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Operation  string `json:"operation,omitempty"`
+	Key        []byte `json:"key"`
+}
+
+// GetStateKeyProvider returns the provider configured in the environment, or nil if state files aren't encrypted
+func GetStateKeyProvider() (StateKeyProvider, error) {
+	var configured []string
+	for _, env := range []string{StateEncryptionKeyEnv, StateEncryptionKeyFileEnv, StateEncryptionKeyCommandEnv} {
+		if os.Getenv(env) != "" {
+			configured = append(configured, env)
+		}
+	}
+	if len(configured) == 0 {
+		return nil, nil
+	}
+	if len(configured) > 1 {
+		return nil, fmt.Errorf("Only one state encryption key source can be set, found [%s]", strings.Join(configured, ", "))
+	}
+	switch configured[0] {
+	case StateEncryptionKeyEnv:
+		return newLocalStateKeyProvider(os.Getenv(StateEncryptionKeyEnv), StateEncryptionKeyEnv)
+	case StateEncryptionKeyFileEnv:
+		keyFile := os.Getenv(StateEncryptionKeyFileEnv)
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read state encryption key file [%s]: %v", keyFile, err)
+		}
+		return newLocalStateKeyProvider(string(key), keyFile)
+	}
+	// the command is split like a shell would, quoted arguments can contain spaces
+	command, err := shlex.Split(os.Getenv(StateEncryptionKeyCommandEnv))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", StateEncryptionKeyCommandEnv, err)
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("%s is empty", StateEncryptionKeyCommandEnv)
+	}
+	return &execStateKeyProvider{command: command}, nil
+}
+
+// IsStateEncrypted returns true if the state file content is encrypted
+func IsStateEncrypted(stateFileContent []byte) bool {
+	encrypted := &encryptedFullState{}
+	if err := json.Unmarshal(stateFileContent, encrypted); err != nil {
+		return false
+	}
+	return encrypted.EncryptedState != nil
+}
+
+func encryptState(stateFileContent []byte, provider StateKeyProvider) ([]byte, error) {
+	dataKey := make([]byte, stateKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	nonce, data, err := sealAESGCM(dataKey, stateFileContent)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt state file key with %s provider: %v", provider.Name(), err)
+	}
+	return json.MarshalIndent(encryptedFullState{
+		EncryptedState: &stateEnvelope{
+			Provider:     provider.Name(),
+			EncryptedKey: encryptedKey,
+			Nonce:        nonce,
+			Data:         data,
+		},
+	}, "", "  ")
+}
+
+// StateDecryptionError is returned when an encrypted state file can't be decrypted, unlike a missing state file
+// it must never be handled as a new cluster
+type StateDecryptionError struct {
+	Err error
+}
+
+func (e *StateDecryptionError) Error() string {
+	return e.Err.Error()
+}
+
+// IsStateDecryptionError returns true if err is a StateDecryptionError
+func IsStateDecryptionError(err error) bool {
+	_, ok := err.(*StateDecryptionError)
+	return ok
+}
+
+// decryptState returns the content of a plain state file as is
+func decryptState(stateFileContent []byte) ([]byte, error) {
+	encrypted := &encryptedFullState{}
+	if err := json.Unmarshal(stateFileContent, encrypted); err != nil || encrypted.EncryptedState == nil {
+		return stateFileContent, nil
+	}
+	envelope := encrypted.EncryptedState
+	provider, err := GetStateKeyProvider()
+	if err != nil {
+		return nil, &StateDecryptionError{Err: err}
+	}
+	if provider == nil {
+		return nil, &StateDecryptionError{Err: fmt.Errorf("State file is encrypted, set one of %s, %s or %s to decrypt it", StateEncryptionKeyEnv, StateEncryptionKeyFileEnv, StateEncryptionKeyCommandEnv)}
+	}
+	if provider.Name() != envelope.Provider {
+		return nil, &StateDecryptionError{Err: fmt.Errorf("State file is encrypted with the %s key provider, but the %s key provider is configured", envelope.Provider, provider.Name())}
+	}
+	dataKey, err := provider.UnwrapKey(envelope.EncryptedKey)
+	if err != nil {
+		return nil, &StateDecryptionError{Err: fmt.Errorf("Failed to decrypt state file key with %s provider: %v", provider.Name(), err)}
+	}
+	stateFile, err := openAESGCM(dataKey, envelope.Nonce, envelope.Data)
+	if err != nil {
+		return nil, &StateDecryptionError{Err: fmt.Errorf("Failed to decrypt state file: %v", err)}
+	}
+	return stateFile, nil
+}
+
+func newLocalStateKeyProvider(encodedKey, source string) (*localStateKeyProvider, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, fmt.Errorf("State encryption key from [%s] is not base64 encoded: %v", source, err)
+	}
+	if len(key) != stateKeySize {
+		return nil, fmt.Errorf("State encryption key from [%s] must be %d bytes, found %d bytes", source, stateKeySize, len(key))
+	}
+	return &localStateKeyProvider{key: key}, nil
+}
+
+func (p *localStateKeyProvider) Name() string {
+	return localStateKeyProviderName
+}
+
+func (p *localStateKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	nonce, encryptedKey, err := sealAESGCM(p.key, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, encryptedKey...), nil
+}
+
+func (p *localStateKeyProvider) UnwrapKey(encryptedKey []byte) ([]byte, error) {
+	gcm, err := newAESGCM(p.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptedKey) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted key is too short")
+	}
+	dataKey, err := gcm.Open(nil, encryptedKey[:gcm.NonceSize()], encryptedKey[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("the state file was encrypted with a different key")
+	}
+	return dataKey, nil
+}
+
+func (p *execStateKeyProvider) Name() string {
+	return execStateKeyProviderName
+}
+
+func (p *execStateKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	return p.run(stateKeyWrapOperation, dataKey)
+}
+
+func (p *execStateKeyProvider) UnwrapKey(encryptedKey []byte) ([]byte, error) {
+	return p.run(stateKeyUnwrapOperation, encryptedKey)
+}
+
+func (p *execStateKeyProvider) run(operation string, key []byte) ([]byte, error) {
+	request, err := json.Marshal(stateKeyMessage{
+		APIVersion: StateKeyPluginAPIVersion,
+		Kind:       stateKeyRequestKind,
+		Operation:  operation,
+		Key:        key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	stdout := &bytes.Buffer{}
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("state key plugin [%s] failed to %s the key: %v", p.command[0], operation, err)
+	}
+	response := &stateKeyMessage{}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return nil, fmt.Errorf("Failed to parse state key plugin [%s] response: %v", p.command[0], err)
+	}
+	if response.APIVersion != StateKeyPluginAPIVersion || response.Kind != stateKeyResponseKind {
+		return nil, fmt.Errorf("state key plugin [%s] returned [%s, Kind=%s], expected [%s, Kind=%s]", p.command[0], response.APIVersion, response.Kind, StateKeyPluginAPIVersion, stateKeyResponseKind)
+	}
+	if len(response.Key) == 0 {
+		return nil, fmt.Errorf("state key plugin [%s] returned an empty key", p.command[0])
+	}
+	return response.Key, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealAESGCM(key, plaintext []byte) ([]byte, []byte, error) {
+	gcm, err := newAESGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key, nonce, ciphertext []byte) ([]byte, error) {
+	gcm, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size")
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const stateKeyPluginHelperEnv = "RKE_TEST_STATE_KEY_PLUGIN"
+
+// TestStateKeyPluginHelper is the state key plugin used by TestStateEncryptionWithPlugin, it "wraps" the key by
+// reversing it
+func TestStateKeyPluginHelper(t *testing.T) {
+	if os.Getenv(stateKeyPluginHelperEnv) == "" {
+		return
+	}
+	request := &stateKeyMessage{}
+	if err := json.NewDecoder(os.Stdin).Decode(request); err != nil {
+		os.Exit(1)
+	}
+	key := make([]byte, len(request.Key))
+	for i := range request.Key {
+		key[len(key)-1-i] = request.Key[i]
+	}
+	json.NewEncoder(os.Stdout).Encode(stateKeyMessage{
+		APIVersion: StateKeyPluginAPIVersion,
+		Kind:       stateKeyResponseKind,
+		Key:        key,
+	})
+	os.Exit(0)
+}
+
+func TestStateEncryption(t *testing.T) {
+	ctx := context.Background()
+	statePath := filepath.Join(t.TempDir(), "cluster.rkestate")
+	fullState := &FullState{DesiredState: State{EncryptionConfig: "secret encryption config"}}
+
+	// plain state files are still read when a key is configured
+	setStateEnv(t, StateEncryptionKeyEnv, testStateKey(t))
+	assert.Nil(t, ioutil.WriteFile(statePath, []byte(`{"desiredState":{"encryptionConfig":"plain"}}`), 0600))
+	readState, err := ReadStateFile(ctx, statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", readState.DesiredState.EncryptionConfig)
+
+	assert.Nil(t, fullState.WriteStateFile(ctx, statePath))
+	stateFile, err := ioutil.ReadFile(statePath)
+	assert.Nil(t, err)
+	assert.True(t, IsStateEncrypted(stateFile))
+	assert.NotContains(t, string(stateFile), "secret encryption config")
+
+	readState, err = ReadStateFile(ctx, statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, fullState.DesiredState.EncryptionConfig, readState.DesiredState.EncryptionConfig)
+	readState, err = StringToFullState(ctx, string(stateFile))
+	assert.Nil(t, err)
+	assert.Equal(t, fullState.DesiredState.EncryptionConfig, readState.DesiredState.EncryptionConfig)
+
+	setStateEnv(t, StateEncryptionKeyEnv, testStateKey(t))
+	_, err = ReadStateFile(ctx, statePath)
+	assert.True(t, IsStateDecryptionError(err))
+	// only a missing state file is a new cluster
+	assert.False(t, IsStateFileNotFound(err))
+	_, err = ReadStateFile(ctx, statePath+".missing")
+	assert.True(t, IsStateFileNotFound(err))
+
+	os.Unsetenv(StateEncryptionKeyEnv)
+	_, err = ReadStateFile(ctx, statePath)
+	assert.True(t, IsStateDecryptionError(err))
+}
+
+func TestEncryptDecryptStateFile(t *testing.T) {
+	ctx := context.Background()
+	keyFile := filepath.Join(t.TempDir(), "state.key")
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte(testStateKey(t)+"\n"), 0600))
+	statePath := filepath.Join(t.TempDir(), "cluster.rkestate")
+	plainState := []byte(`{"desiredState":{"encryptionConfig":"plain"}}`)
+	assert.Nil(t, ioutil.WriteFile(statePath, plainState, 0600))
+
+	assert.NotNil(t, EncryptStateFile(ctx, statePath))
+
+	setStateEnv(t, StateEncryptionKeyFileEnv, keyFile)
+	assert.Nil(t, EncryptStateFile(ctx, statePath))
+	stateFile, err := ioutil.ReadFile(statePath)
+	assert.Nil(t, err)
+	assert.True(t, IsStateEncrypted(stateFile))
+
+	assert.Nil(t, DecryptStateFile(ctx, statePath))
+	stateFile, err = ioutil.ReadFile(statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, plainState, stateFile)
+}
+
+func TestStateEncryptionWithPlugin(t *testing.T) {
+	setStateEnv(t, StateEncryptionKeyCommandEnv, fmt.Sprintf("%s -test.run=TestStateKeyPluginHelper", os.Args[0]))
+	setStateEnv(t, stateKeyPluginHelperEnv, "1")
+	provider, err := GetStateKeyProvider()
+	assert.Nil(t, err)
+
+	encrypted, err := encryptState([]byte(`{"desiredState":{}}`), provider)
+	assert.Nil(t, err)
+	decrypted, err := decryptState(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"desiredState":{}}`, string(decrypted))
+
+	setStateEnv(t, StateEncryptionKeyEnv, testStateKey(t))
+	_, err = GetStateKeyProvider()
+	assert.NotNil(t, err)
+}
+
+func TestStateKeyCommandArguments(t *testing.T) {
+	setStateEnv(t, StateEncryptionKeyCommandEnv, `kms-plugin --key-id "projects/rke/keys/state key" --region='us east'`)
+	provider, err := GetStateKeyProvider()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kms-plugin", "--key-id", "projects/rke/keys/state key", "--region=us east"}, provider.(*execStateKeyProvider).command)
+
+	setStateEnv(t, StateEncryptionKeyCommandEnv, `kms-plugin --key-id "unterminated`)
+	_, err = GetStateKeyProvider()
+	assert.NotNil(t, err)
+}
+
+func testStateKey(t *testing.T) string {
+	key := make([]byte, stateKeySize)
+	_, err := rand.Read(key)
+	assert.Nil(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func setStateEnv(t *testing.T, env, value string) {
+	os.Setenv(env, value)
+	t.Cleanup(func() { os.Unsetenv(env) })
+}
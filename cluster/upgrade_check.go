@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	mVersion "github.com/mcuadros/go-version"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/metadata"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
+	"github.com/rancher/rke/util"
+)
+
+const (
+	UpgradeCheckError   = "error"
+	UpgradeCheckWarning = "warning"
+
+	UpgradeCheckVersion           = "version"
+	UpgradeCheckVersionSkew       = "version-skew"
+	UpgradeCheckDockerVersion     = "docker-version"
+	UpgradeCheckRemovedFlag       = "removed-flag"
+	UpgradeCheckPodSecurityPolicy = "pod-security-policy"
+
+	podSecurityPolicyAdmissionPlugin = "PodSecurityPolicy"
+	enableAdmissionPluginsArg        = "enable-admission-plugins"
+)
+
+// removedServiceFlags are the flags removed from the kubernetes services, by service and by the minor version the
+// flag was removed in. Flags set in extra_args prevent the service from starting after the upgrade.
+var removedServiceFlags = map[string]map[string][]string{
+	services.KubeAPIContainerName: {
+		"v1.14": {"enable-swagger-ui", "repair-malformed-updates"},
+		"v1.19": {"basic-auth-file"},
+		"v1.24": {"address", "insecure-bind-address", "insecure-port", "port"},
+	},
+	services.KubeControllerContainerName: {
+		"v1.24": {"address", "port"},
+	},
+	services.KubeletContainerName: {
+		"v1.12": {"cadvisor-port"},
+		"v1.15": {"allow-privileged"},
+		"v1.24": {"cni-bin-dir", "cni-conf-dir", "docker-endpoint", "image-pull-progress-deadline", "network-plugin"},
+	},
+	services.KubeproxyContainerName: {
+		"v1.16": {"resource-container"},
+	},
+}
+
+// UpgradeCheckResult is an issue found by the upgrade check, errors are expected to make the upgrade fail
+type UpgradeCheckResult struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Host     string `json:"host,omitempty"`
+	Message  string `json:"message"`
+}
+
+// UpgradeCheckReport is the result of the upgrade check from the current to the target kubernetes version
+type UpgradeCheckReport struct {
+	CurrentVersion string               `json:"currentVersion"`
+	TargetVersion  string               `json:"targetVersion"`
+	Results        []UpgradeCheckResult `json:"results"`
+}
+
+func (r *UpgradeCheckReport) HasErrors() bool {
+	return r.hasSeverity(UpgradeCheckError)
+}
+
+func (r *UpgradeCheckReport) HasWarnings() bool {
+	return r.hasSeverity(UpgradeCheckWarning)
+}
+
+func (r *UpgradeCheckReport) hasSeverity(severity string) bool {
+	for _, result := range r.Results {
+		if result.Severity == severity {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *UpgradeCheckReport) add(check, severity, host, format string, args ...interface{}) {
+	r.Results = append(r.Results, UpgradeCheckResult{
+		Check:    check,
+		Severity: severity,
+		Host:     host,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// CheckUpgrade reports the issues of upgrading the cluster from currentConfig to targetConfig, the Docker versions of
+// clusterHosts are checked if their Docker info was retrieved by tunneling the hosts
+func CheckUpgrade(currentConfig, targetConfig *v3.RancherKubernetesEngineConfig, clusterHosts, inactiveHosts []*hosts.Host) (*UpgradeCheckReport, error) {
+	targetVersion := targetConfig.Version
+	if targetVersion == "" {
+		targetVersion = metadata.DefaultK8sVersion
+	}
+	report := &UpgradeCheckReport{
+		CurrentVersion: currentConfig.Version,
+		TargetVersion:  targetVersion,
+	}
+	currentSemVer, err := util.StrToSemVer(currentConfig.Version)
+	if err != nil {
+		return nil, fmt.Errorf("current version %s is not valid semver", currentConfig.Version)
+	}
+	targetSemVer, err := util.StrToSemVer(targetVersion)
+	if err != nil {
+		return nil, fmt.Errorf("target version %s is not valid semver", targetVersion)
+	}
+	checkTargetVersion(report, targetVersion)
+	checkVersionSkew(report, currentSemVer, targetSemVer)
+	checkDockerVersions(report, clusterHosts, targetSemVer, targetConfig.IgnoreDockerVersion != nil && *targetConfig.IgnoreDockerVersion)
+	for _, host := range inactiveHosts {
+		report.add(UpgradeCheckDockerVersion, UpgradeCheckWarning, host.Address, "Host is not reachable, its Docker version was not checked")
+	}
+	checkRemovedFlags(report, targetConfig.Services, currentSemVer, targetSemVer)
+	checkPodSecurityPolicy(report, targetConfig.Services.KubeAPI, targetSemVer)
+	return report, nil
+}
+
+// HasVersionErrors returns true if the target version can't be deployed by this RKE version
+func (r *UpgradeCheckReport) HasVersionErrors() bool {
+	for _, result := range r.Results {
+		if result.Check == UpgradeCheckVersion && result.Severity == UpgradeCheckError {
+			return true
+		}
+	}
+	return false
+}
+
+func checkTargetVersion(report *UpgradeCheckReport, version string) {
+	if metadata.K8sBadVersions[version] {
+		report.add(UpgradeCheckVersion, UpgradeCheckError, "", "Kubernetes version %s is deprecated in RKE %s", version, metadata.RKEVersion)
+		return
+	}
+	if _, ok := metadata.K8sVersionToRKESystemImages[version]; !ok {
+		versionInfo := metadata.K8sVersionToVersionInfo[version]
+		if versionInfo.MinRKEVersion != "" && mVersion.Compare(metadata.RKEVersion, versionInfo.MinRKEVersion, "<") {
+			report.add(UpgradeCheckVersion, UpgradeCheckError, "", "Kubernetes version %s requires RKE %s or later, this is RKE %s", version, versionInfo.MinRKEVersion, metadata.RKEVersion)
+			return
+		}
+		report.add(UpgradeCheckVersion, UpgradeCheckError, "", "Kubernetes version %s is not supported by RKE %s, see 'rke config --list-version --all' for the supported versions", version, metadata.RKEVersion)
+		return
+	}
+	for _, currentVersion := range metadata.K8sVersionsCurrent {
+		if util.GetTagMajorVersion(currentVersion) == util.GetTagMajorVersion(version) && mVersion.Compare(currentVersion, version, ">") {
+			report.add(UpgradeCheckVersion, UpgradeCheckWarning, "", "Kubernetes version %s is available with newer patches than %s", currentVersion, version)
+		}
+	}
+}
+
+func checkVersionSkew(report *UpgradeCheckReport, current, target *semver.Version) {
+	if target.Major != current.Major {
+		report.add(UpgradeCheckVersionSkew, UpgradeCheckError, "", "Upgrading from Kubernetes v%d.%d to v%d.%d is not supported", current.Major, current.Minor, target.Major, target.Minor)
+		return
+	}
+	if target.LessThan(*current) {
+		report.add(UpgradeCheckVersionSkew, UpgradeCheckError, "", "Kubernetes version v%s is older than the current version v%s, downgrades are not supported", target, current)
+		return
+	}
+	if target.Minor > current.Minor+1 {
+		var skipped []string
+		for minor := current.Minor + 1; minor < target.Minor; minor++ {
+			skipped = append(skipped, fmt.Sprintf("v%d.%d", current.Major, minor))
+		}
+		report.add(UpgradeCheckVersionSkew, UpgradeCheckError, "", "Upgrading from v%d.%d to v%d.%d skips %s, upgrade one minor version at a time", current.Major, current.Minor, target.Major, target.Minor, strings.Join(skipped, ", "))
+	}
+}
+
+func checkDockerVersions(report *UpgradeCheckReport, uniqueHosts []*hosts.Host, target *semver.Version, ignoreDockerVersion bool) {
+	targetMinor := fmt.Sprintf("%d.%d", target.Major, target.Minor)
+	supported := metadata.K8sVersionToDockerVersions[targetMinor]
+	if len(supported) == 0 {
+		return
+	}
+	severity := UpgradeCheckError
+	if ignoreDockerVersion {
+		severity = UpgradeCheckWarning
+	}
+	for _, host := range uniqueHosts {
+		if host.DockerInfo.ServerVersion == "" || host.IsWindows() {
+			continue
+		}
+		isSupported, err := docker.IsSupportedDockerVersion(host.DockerInfo, targetMinor)
+		if err != nil {
+			report.add(UpgradeCheckDockerVersion, UpgradeCheckWarning, host.Address, "Can't parse Docker version [%s]: %v", host.DockerInfo.ServerVersion, err)
+			continue
+		}
+		if !isSupported {
+			report.add(UpgradeCheckDockerVersion, severity, host.Address, "Docker version [%s] is not supported by Kubernetes v%s, supported versions are %v", host.DockerInfo.ServerVersion, targetMinor, supported)
+		}
+	}
+}
+
+func checkRemovedFlags(report *UpgradeCheckReport, rkeServices v3.RKEConfigServices, current, target *semver.Version) {
+	serviceArgs := map[string]map[string]string{
+		services.KubeAPIContainerName:        rkeServices.KubeAPI.ExtraArgs,
+		services.KubeControllerContainerName: rkeServices.KubeController.ExtraArgs,
+		services.KubeletContainerName:        rkeServices.Kubelet.ExtraArgs,
+		services.KubeproxyContainerName:      rkeServices.Kubeproxy.ExtraArgs,
+	}
+	for _, service := range []string{services.KubeAPIContainerName, services.KubeControllerContainerName, services.KubeletContainerName, services.KubeproxyContainerName} {
+		var removedIn []string
+		for version := range removedServiceFlags[service] {
+			removedIn = append(removedIn, version)
+		}
+		sort.Strings(removedIn)
+		for _, version := range removedIn {
+			removedSemVer, err := util.StrToSemVer(version + ".0")
+			if err != nil {
+				continue
+			}
+			// only the flags removed by this upgrade, the service already runs without the older ones
+			if !olderMinorVersion(current, removedSemVer) || olderMinorVersion(target, removedSemVer) {
+				continue
+			}
+			for _, flag := range removedServiceFlags[service][version] {
+				if _, ok := serviceArgs[service][flag]; ok {
+					report.add(UpgradeCheckRemovedFlag, UpgradeCheckError, "", "Flag [--%s] in %s extra_args was removed in Kubernetes %s", flag, service, version)
+				}
+			}
+		}
+	}
+}
+
+func checkPodSecurityPolicy(report *UpgradeCheckReport, kubeAPI v3.KubeAPIService, target *semver.Version) {
+	enabled := kubeAPI.PodSecurityPolicy
+	for _, plugin := range strings.Split(kubeAPI.ExtraArgs[enableAdmissionPluginsArg], ",") {
+		if strings.TrimSpace(plugin) == podSecurityPolicyAdmissionPlugin {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return
+	}
+	switch {
+	case target.Major == 1 && target.Minor >= 25:
+		report.add(UpgradeCheckPodSecurityPolicy, UpgradeCheckError, "", "PodSecurityPolicy was removed in Kubernetes v1.25, disable pod_security_policy and migrate to Pod Security Admission before upgrading")
+	case target.Major == 1 && target.Minor >= 21:
+		report.add(UpgradeCheckPodSecurityPolicy, UpgradeCheckWarning, "", "PodSecurityPolicy is deprecated since Kubernetes v1.21 and removed in v1.25")
+	}
+}
+
+// olderMinorVersion compares the minor versions only, v1.24.0-rancher1-1 is a v1.24 version
+func olderMinorVersion(version, than *semver.Version) bool {
+	if version.Major != than.Major {
+		return version.Major < than.Major
+	}
+	return version.Minor < than.Minor
+}
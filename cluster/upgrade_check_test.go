@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/metadata"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkUpgradeResults(t *testing.T, current, target string, targetConfig *v3.RancherKubernetesEngineConfig, clusterHosts []*hosts.Host, check string) []UpgradeCheckResult {
+	targetConfig.Version = target
+	report, err := CheckUpgrade(&v3.RancherKubernetesEngineConfig{Version: current}, targetConfig, clusterHosts, nil)
+	assert.Nil(t, err)
+	var results []UpgradeCheckResult
+	for _, result := range report.Results {
+		if result.Check == check {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func TestCheckUpgradeVersion(t *testing.T) {
+	assert.Nil(t, metadata.InitMetadata(context.Background()))
+
+	report, err := CheckUpgrade(&v3.RancherKubernetesEngineConfig{Version: "v1.20.8-rancher1-1"}, &v3.RancherKubernetesEngineConfig{Version: "v1.99.0-rancher1-1"}, nil, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.HasVersionErrors())
+	assert.True(t, report.HasErrors())
+
+	report, err = CheckUpgrade(&v3.RancherKubernetesEngineConfig{Version: "v1.20.8-rancher1-1"}, &v3.RancherKubernetesEngineConfig{}, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, metadata.DefaultK8sVersion, report.TargetVersion)
+
+	_, err = CheckUpgrade(&v3.RancherKubernetesEngineConfig{Version: "latest"}, &v3.RancherKubernetesEngineConfig{}, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestCheckUpgradeVersionSkew(t *testing.T) {
+	assert.Nil(t, metadata.InitMetadata(context.Background()))
+
+	assert.Len(t, checkUpgradeResults(t, "v1.20.8-rancher1-1", "v1.21.2-rancher1-1", &v3.RancherKubernetesEngineConfig{}, nil, UpgradeCheckVersionSkew), 0)
+	assert.Len(t, checkUpgradeResults(t, "v1.20.8-rancher1-1", "v1.20.8-rancher1-1", &v3.RancherKubernetesEngineConfig{}, nil, UpgradeCheckVersionSkew), 0)
+
+	results := checkUpgradeResults(t, "v1.18.20-rancher1-1", "v1.21.2-rancher1-1", &v3.RancherKubernetesEngineConfig{}, nil, UpgradeCheckVersionSkew)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, UpgradeCheckError, results[0].Severity)
+		assert.Contains(t, results[0].Message, "v1.19, v1.20")
+	}
+	results = checkUpgradeResults(t, "v1.21.2-rancher1-1", "v1.20.8-rancher1-1", &v3.RancherKubernetesEngineConfig{}, nil, UpgradeCheckVersionSkew)
+	if assert.Len(t, results, 1) {
+		assert.Contains(t, results[0].Message, "downgrades are not supported")
+	}
+}
+
+func TestCheckUpgradeDockerVersions(t *testing.T) {
+	assert.Nil(t, metadata.InitMetadata(context.Background()))
+	clusterHosts := []*hosts.Host{
+		{RKEConfigNode: v3.RKEConfigNode{Address: "1.1.1.1"}, DockerInfo: types.Info{ServerVersion: "20.10.7", OSType: "linux"}},
+		{RKEConfigNode: v3.RKEConfigNode{Address: "2.2.2.2"}, DockerInfo: types.Info{ServerVersion: "1.11.2", OSType: "linux"}},
+		// the Docker info of unreachable hosts isn't retrieved
+		{RKEConfigNode: v3.RKEConfigNode{Address: "3.3.3.3"}},
+	}
+
+	results := checkUpgradeResults(t, "v1.19.10-rancher1-1", "v1.20.8-rancher1-1", &v3.RancherKubernetesEngineConfig{}, clusterHosts, UpgradeCheckDockerVersion)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "2.2.2.2", results[0].Host)
+		assert.Equal(t, UpgradeCheckError, results[0].Severity)
+	}
+
+	ignoreDockerVersion := true
+	results = checkUpgradeResults(t, "v1.19.10-rancher1-1", "v1.20.8-rancher1-1", &v3.RancherKubernetesEngineConfig{IgnoreDockerVersion: &ignoreDockerVersion}, clusterHosts, UpgradeCheckDockerVersion)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, UpgradeCheckWarning, results[0].Severity)
+	}
+}
+
+func TestCheckUpgradeRemovedFlags(t *testing.T) {
+	assert.Nil(t, metadata.InitMetadata(context.Background()))
+	targetConfig := &v3.RancherKubernetesEngineConfig{
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{BaseService: v3.BaseService{ExtraArgs: map[string]string{"insecure-port": "0", "audit-log-maxage": "5"}}},
+			Kubelet: v3.KubeletService{BaseService: v3.BaseService{ExtraArgs: map[string]string{"allow-privileged": "true"}}},
+		},
+	}
+
+	results := checkUpgradeResults(t, "v1.23.7-rancher1-1", "v1.24.2-rancher1-1", targetConfig, nil, UpgradeCheckRemovedFlag)
+	if assert.Len(t, results, 1) {
+		assert.Contains(t, results[0].Message, "--insecure-port")
+	}
+	// a rancher build of the first patch release is still in the minor version that removed the flag
+	assert.Len(t, checkUpgradeResults(t, "v1.23.7-rancher1-1", "v1.24.0-rancher1-1", targetConfig, nil, UpgradeCheckRemovedFlag), 1)
+	// the flags removed before the current version are already handled
+	assert.Len(t, checkUpgradeResults(t, "v1.20.8-rancher1-1", "v1.21.2-rancher1-1", targetConfig, nil, UpgradeCheckRemovedFlag), 0)
+
+	results = checkUpgradeResults(t, "v1.14.10-rancher1-1", "v1.15.12-rancher1-1", targetConfig, nil, UpgradeCheckRemovedFlag)
+	if assert.Len(t, results, 1) {
+		assert.Contains(t, results[0].Message, "--allow-privileged")
+	}
+}
+
+func TestCheckUpgradePodSecurityPolicy(t *testing.T) {
+	assert.Nil(t, metadata.InitMetadata(context.Background()))
+	pspConfig := &v3.RancherKubernetesEngineConfig{
+		Services: v3.RKEConfigServices{KubeAPI: v3.KubeAPIService{PodSecurityPolicy: true}},
+	}
+
+	results := checkUpgradeResults(t, "v1.24.2-rancher1-1", "v1.25.1-rancher1-1", pspConfig, nil, UpgradeCheckPodSecurityPolicy)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, UpgradeCheckError, results[0].Severity)
+	}
+	results = checkUpgradeResults(t, "v1.21.2-rancher1-1", "v1.22.4-rancher1-1", pspConfig, nil, UpgradeCheckPodSecurityPolicy)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, UpgradeCheckWarning, results[0].Severity)
+	}
+	assert.Len(t, checkUpgradeResults(t, "v1.20.8-rancher1-1", "v1.21.2-rancher1-1", &v3.RancherKubernetesEngineConfig{}, nil, UpgradeCheckPodSecurityPolicy), 0)
+
+	admissionPluginConfig := &v3.RancherKubernetesEngineConfig{
+		Services: v3.RKEConfigServices{KubeAPI: v3.KubeAPIService{BaseService: v3.BaseService{ExtraArgs: map[string]string{
+			"enable-admission-plugins": "NodeRestriction, PodSecurityPolicy",
+		}}}},
+	}
+	assert.Len(t, checkUpgradeResults(t, "v1.24.2-rancher1-1", "v1.25.1-rancher1-1", admissionPluginConfig, nil, UpgradeCheckPodSecurityPolicy), 1)
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/blang/semver"
+	"github.com/rancher/rke/backup"
+	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/metadata"
 	"github.com/rancher/rke/pki"
 	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
 	"github.com/rancher/rke/util"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
@@ -59,6 +64,11 @@ func (c *Cluster) ValidateCluster(ctx context.Context) error {
 		return err
 	}
 
+	// validate upgrade strategy
+	if err := validateUpgradeStrategy(c); err != nil {
+		return err
+	}
+
 	// validate services options
 	return validateServicesOptions(c)
 }
@@ -76,6 +86,28 @@ func validateAuthOptions(c *Cluster) error {
 	if !c.AuthnStrategies[AuthnX509Provider] {
 		return fmt.Errorf("Authentication strategy must contain [%s]", AuthnX509Provider)
 	}
+	if c.CertificateRenewal != nil && c.CertificateRenewal.RenewBeforeDays < 0 {
+		return fmt.Errorf("Certificate renewal [renew_before_days] must not be negative")
+	}
+	opts, err := pki.GetCertOptions(c.PKI)
+	if err != nil {
+		return err
+	}
+	if pki.IsExternalCA(&c.RancherKubernetesEngineConfig) {
+		if err := pki.ValidateExternalCA(c.PKI.ExternalCA); err != nil {
+			return err
+		}
+	}
+	if pki.IsExternalSigner(&c.RancherKubernetesEngineConfig) && c.Services.KubeController.ExtraArgs["cluster-signing-key-file"] == "" {
+		logrus.Warnf("pki [external_ca] has a [signer_command] and no [ca_key], kube-controller-manager runs without [cluster-signing-cert-file] and [cluster-signing-key-file] and does not sign the certificate signing requests of the cluster")
+	}
+	if c.CertificateRenewal != nil && !c.CertificateRenewal.Disabled &&
+		time.Duration(c.CertificateRenewal.RenewBeforeDays)*24*time.Hour >= opts.CertValidity {
+		return fmt.Errorf("Certificate renewal [renew_before_days] must be shorter than pki [cert_validity]")
+	}
+	if opts.CertValidity > opts.CAValidity {
+		logrus.Warnf("pki [cert_validity] is longer than [ca_validity], the certificates expire with the CA certificate")
+	}
 	return nil
 }
 
@@ -290,8 +322,17 @@ func validateHostsOptions(c *Cluster) error {
 		if len(host.Address) == 0 {
 			return fmt.Errorf("Address for host (%d) is not provided", i+1)
 		}
-		if len(host.User) == 0 {
-			return fmt.Errorf("User for host (%d) is not provided", i+1)
+		switch host.Transport {
+		case "", hosts.SSHTransport:
+			if len(host.User) == 0 {
+				return fmt.Errorf("User for host (%d) is not provided", i+1)
+			}
+		case hosts.DockerTLSTransport:
+			if err := validateDockerTLS(host.DockerTLS); err != nil {
+				return fmt.Errorf("Docker TLS for host (%d) is not valid: %v", i+1, err)
+			}
+		default:
+			return fmt.Errorf("Transport [%s] for host (%d) is not supported, supported transports are %s and %s", host.Transport, i+1, hosts.SSHTransport, hosts.DockerTLSTransport)
 		}
 		if len(host.Role) == 0 {
 			return fmt.Errorf("Role for host (%d) is not provided", i+1)
@@ -304,6 +345,65 @@ func validateHostsOptions(c *Cluster) error {
 				return fmt.Errorf("Role [%s] for host (%d) is not recognized", role, i+1)
 			}
 		}
+		if host.SSHHostKey != "" {
+			if err := hosts.ValidateHostKeyFingerprint(host.SSHHostKey); err != nil {
+				return fmt.Errorf("SSH host key for host (%d) is not valid: %v", i+1, err)
+			}
+		}
+		if host.BastionHost != nil {
+			if err := validateBastionHost(*host.BastionHost); err != nil {
+				return fmt.Errorf("Bastion host for host (%d) is not valid: %v", i+1, err)
+			}
+		}
+	}
+	if err := validateBastionHost(c.BastionHost); err != nil {
+		return fmt.Errorf("Bastion host is not valid: %v", err)
+	}
+	return nil
+}
+
+func validateDockerTLS(dockerTLS *v3.DockerTLSConfig) error {
+	if dockerTLS == nil {
+		return fmt.Errorf("docker_tls is required with the %s transport", hosts.DockerTLSTransport)
+	}
+	if len(dockerTLS.CACert) == 0 && len(dockerTLS.CACertPath) == 0 {
+		return fmt.Errorf("ca_cert or ca_cert_path is required")
+	}
+	if len(dockerTLS.Cert) == 0 && len(dockerTLS.CertPath) == 0 {
+		return fmt.Errorf("cert or cert_path is required")
+	}
+	if len(dockerTLS.Key) == 0 && len(dockerTLS.KeyPath) == 0 {
+		return fmt.Errorf("key or key_path is required")
+	}
+	if len(dockerTLS.Port) > 0 {
+		if _, err := strconv.Atoi(dockerTLS.Port); err != nil {
+			return fmt.Errorf("port [%s] is not a number", dockerTLS.Port)
+		}
+	}
+	return nil
+}
+
+func validateBastionHost(bastionHost v3.BastionHost) error {
+	if len(bastionHost.Address) == 0 {
+		if len(bastionHost.Hops) > 0 {
+			return fmt.Errorf("address is required with hops, the hops are reached through the bastion host")
+		}
+		return nil
+	}
+	if bastionHost.SSHHostKey != "" {
+		if err := hosts.ValidateHostKeyFingerprint(bastionHost.SSHHostKey); err != nil {
+			return fmt.Errorf("SSH host key is not valid: %v", err)
+		}
+	}
+	for i, hop := range bastionHost.Hops {
+		if len(hop.Address) == 0 {
+			return fmt.Errorf("address for hop (%d) is not provided", i+1)
+		}
+		if hop.SSHHostKey != "" {
+			if err := hosts.ValidateHostKeyFingerprint(hop.SSHHostKey); err != nil {
+				return fmt.Errorf("SSH host key for hop (%d) is not valid: %v", i+1, err)
+			}
+		}
 	}
 	return nil
 }
@@ -344,11 +444,53 @@ func validateServicesOptions(c *Cluster) error {
 		}
 	}
 
+	if err := validateServicesResources(c); err != nil {
+		return err
+	}
+
 	// validate etcd s3 backup backend configurations
 	return validateEtcdBackupOptions(c)
 }
 
+func validateServicesResources(c *Cluster) error {
+	serviceResources := map[string]*v3.ContainerResources{
+		services.EtcdContainerName:           c.Services.Etcd.Resources,
+		services.KubeAPIContainerName:        c.Services.KubeAPI.Resources,
+		services.KubeControllerContainerName: c.Services.KubeController.Resources,
+		services.SchedulerContainerName:      c.Services.Scheduler.Resources,
+		services.KubeletContainerName:        c.Services.Kubelet.Resources,
+		services.KubeproxyContainerName:      c.Services.Kubeproxy.Resources,
+	}
+	for serviceName, r := range serviceResources {
+		if r == nil {
+			continue
+		}
+		resources, err := services.GetContainerResources(r)
+		if err != nil {
+			return fmt.Errorf("Failed to validate the resources of service [%s]: %v", serviceName, err)
+		}
+		if resources.NanoCPUs < 0 || resources.CPUShares < 0 || resources.Memory < 0 || resources.MemoryReservation < 0 || r.PidsLimit < 0 {
+			return fmt.Errorf("Failed to validate the resources of service [%s]: resources can't be negative", serviceName)
+		}
+		if resources.Memory > 0 && resources.MemoryReservation > resources.Memory {
+			return fmt.Errorf("Failed to validate the resources of service [%s]: memory reservation [%s] is greater than memory limit [%s]", serviceName, r.MemoryReservation, r.MemoryLimit)
+		}
+		if r.OOMScoreAdj < -1000 || r.OOMScoreAdj > 1000 {
+			return fmt.Errorf("Failed to validate the resources of service [%s]: oom score adj [%d] must be between -1000 and 1000", serviceName, r.OOMScoreAdj)
+		}
+	}
+	return nil
+}
+
 func validateEtcdBackupOptions(c *Cluster) error {
+	if err := backup.Validate(c.Services.Etcd.BackupConfig); err != nil {
+		return err
+	}
+	if c.Services.Etcd.Snapshot != nil && *c.Services.Etcd.Snapshot &&
+		c.Services.Etcd.BackupConfig != nil && c.Services.Etcd.BackupConfig.FilesystemBackupConfig != nil {
+		return errors.New("etcd filesystem backup backend is on the machine running rke and can't store recurring snapshots, " +
+			"set services.etcd.snapshot to false and use rke etcd snapshot-save")
+	}
 	if c.Services.Etcd.BackupConfig != nil {
 		if c.Services.Etcd.BackupConfig.S3BackupConfig != nil {
 			if len(c.Services.Etcd.BackupConfig.S3BackupConfig.Endpoint) == 0 {
@@ -419,6 +561,22 @@ func ValidateHostCount(c *Cluster) error {
 	return nil
 }
 
+// validateEtcdSnapshotUploader checks that the rke binary can run on the etcd hosts when it encrypts or uploads the
+// snapshots there, the hosts have to be tunneled to know their architecture
+func validateEtcdSnapshotUploader(c *Cluster) error {
+	bc := c.Services.Etcd.BackupConfig
+	recurring := c.Services.Etcd.Snapshot != nil && *c.Services.Etcd.Snapshot && backup.HasSnapshotUploader(bc)
+	if !recurring && !(backup.IsTransferredByRKE(bc) && bc.Encryption != nil) {
+		return nil
+	}
+	for _, host := range c.EtcdHosts {
+		if err := services.CheckEtcdSnapshotUploader(host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func validateDuplicateNodes(c *Cluster) error {
 	addresses := make(map[string]struct{}, len(c.Nodes))
 	hostnames := make(map[string]struct{}, len(c.Nodes))
@@ -662,6 +820,10 @@ func validateRegistryAuthPlugin(c *Cluster) error {
 				switch credPluginType {
 				case "ecr":
 					logrus.Debugf("Plugin type %s is valid", credPluginType)
+				case util.ExecCredentialPluginType:
+					if !util.IsExecCredentialPlugin(pr.CredentialPlugin) {
+						return fmt.Errorf("credential helper is not set in %s of the registry plugin for %s", util.ExecCredentialPluginKey, pr.URL)
+					}
 				default:
 					return fmt.Errorf("invalid registry plugin helper provided for %s", pr.URL)
 				}
@@ -670,3 +832,42 @@ func validateRegistryAuthPlugin(c *Cluster) error {
 	}
 	return nil
 }
+
+func validateUpgradeStrategy(c *Cluster) error {
+	if c.UpgradeStrategy == nil {
+		return nil
+	}
+	for _, canaryNode := range c.UpgradeStrategy.CanaryNodes {
+		found := false
+		for _, host := range c.Nodes {
+			if canaryNode == host.Address || canaryNode == host.HostnameOverride {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Canary node [%s] of upgrade_strategy is not a node of the cluster", canaryNode)
+		}
+	}
+	if waves := c.UpgradeStrategy.Waves; waves != nil {
+		if errs := validation.IsQualifiedName(waves.Label); len(errs) > 0 {
+			return fmt.Errorf("Label [%s] of upgrade_strategy waves is not valid: %v", waves.Label, errs)
+		}
+		seen := make(map[string]bool)
+		for _, value := range waves.Order {
+			if seen[value] {
+				return fmt.Errorf("Label value [%s] is listed more than once in the order of upgrade_strategy waves", value)
+			}
+			seen[value] = true
+		}
+	}
+	if pause := c.UpgradeStrategy.Pause; pause != nil {
+		if pause.HealthCommand == "" && !pause.Confirm {
+			return fmt.Errorf("Pause of upgrade_strategy requires a health_command or confirm")
+		}
+		if pause.Timeout < 0 {
+			return fmt.Errorf("Timeout [%d] of upgrade_strategy pause can not be negative", pause.Timeout)
+		}
+	}
+	return nil
+}
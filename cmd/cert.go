@@ -17,6 +17,16 @@ import (
 	"github.com/urfave/cli"
 )
 
+// DefaultCertWarnDays is the default number of days before expiry rke cert check reports the certificates
+const DefaultCertWarnDays = 30
+
+const (
+	// certCheckErrorExitCode is returned when a certificate expired, is missing, misses SANs or drifted from the state file
+	certCheckErrorExitCode = 2
+	// certCheckWarningExitCode is returned when the only problem is certificates expiring within the warning period
+	certCheckWarningExitCode = 3
+)
+
 func CertificateCommand() cli.Command {
 	rotateFlags := []cli.Flag{
 		cli.StringFlag{
@@ -41,7 +51,25 @@ func CertificateCommand() cli.Command {
 			Usage: "Rotate all certificates including CA certs",
 		},
 	}
-	rotateFlags = append(rotateFlags, commonFlags...)
+	rotateFlags = append(append(rotateFlags, stateLockFlags...), commonFlags...)
+	checkFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Specify an alternate cluster YAML file",
+			Value:  pki.ClusterConfig,
+			EnvVar: "RKE_CONFIG",
+		},
+		cli.IntFlag{
+			Name:  "warn-days",
+			Usage: "Report the certificates expiring within this number of days",
+			Value: DefaultCertWarnDays,
+		},
+		cli.BoolFlag{
+			Name:  "skip-nodes",
+			Usage: "Only check the state file and the kubeconfig, without connecting to the nodes",
+		},
+	}
+	checkFlags = append(checkFlags, commonFlags...)
 	return cli.Command{
 		Name:  "cert",
 		Usage: "Certificates management for RKE cluster",
@@ -52,6 +80,13 @@ func CertificateCommand() cli.Command {
 				Action: rotateRKECertificatesFromCli,
 				Flags:  rotateFlags,
 			},
+			cli.Command{
+				Name: "check",
+				Usage: fmt.Sprintf("Report the expiry, the missing SANs and the drift from the state file of RKE cluster certificates, exits with %d if a certificate has a problem and with %d if certificates are only expiring",
+					certCheckErrorExitCode, certCheckWarningExitCode),
+				Action: checkRKECertificatesFromCli,
+				Flags:  checkFlags,
+			},
 			cli.Command{
 				Name:   "generate-csr",
 				Usage:  "Generate certificate sign requests for k8s components",
@@ -69,6 +104,23 @@ func CertificateCommand() cli.Command {
 					},
 				},
 			},
+			cli.Command{
+				Name:   "import",
+				Usage:  "Import the certificates signed for the CSRs of generate-csr and report the missing or invalid ones",
+				Action: importCertificatesFromCli,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "config",
+						Usage:  "Specify an alternate cluster YAML file",
+						Value:  pki.ClusterConfig,
+						EnvVar: "RKE_CONFIG",
+					},
+					cli.StringFlag{
+						Name:  "cert-dir",
+						Usage: "Specify a certificate dir path",
+					},
+				},
+			},
 		},
 	}
 }
@@ -97,6 +149,11 @@ func rotateRKECertificatesFromCli(ctx *cli.Context) error {
 		CACertificates: rotateCACerts,
 		Services:       k8sComponents,
 	}
+	unlock, err := lockStateFile(context.Background(), ctx, externalFlags, "cert rotate")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	if err := ClusterInit(context.Background(), rkeConfig, hosts.DialersOptions{}, externalFlags); err != nil {
 		return err
 	}
@@ -104,6 +161,74 @@ func rotateRKECertificatesFromCli(ctx *cli.Context) error {
 	return err
 }
 
+func checkRKECertificatesFromCli(ctx *cli.Context) error {
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	externalFlags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	warnWithin := time.Duration(ctx.Int("warn-days")) * 24 * time.Hour
+	return CheckRKECertificates(context.Background(), rkeConfig, hosts.DialersOptions{}, externalFlags, warnWithin, !ctx.Bool("skip-nodes"))
+}
+
+// CheckRKECertificates logs the certificate checks of the cluster, it fails if a certificate has a problem
+func CheckRKECertificates(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags, warnWithin time.Duration, checkNodes bool) error {
+	defer hosts.UseSSHConnPool()()
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig.DeepCopy(), flags, "")
+	if err != nil {
+		return err
+	}
+	fullState, err := cluster.ReadStateFile(ctx, kubeCluster.StateFilePath)
+	if err != nil {
+		return err
+	}
+	if checkNodes {
+		if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+			return err
+		}
+		if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+			return err
+		}
+	}
+	checks, err := kubeCluster.CheckCertificates(ctx, fullState, warnWithin, checkNodes)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var failing, expiring int
+	for _, check := range checks {
+		if len(check.Problems) == 0 {
+			log.Infof(ctx, "[certificates] Certificate [%s] of [%s] expires in %d days", check.Name, check.Location, check.DaysLeft(now))
+			continue
+		}
+		for _, problem := range check.Problems {
+			log.Warnf(ctx, "[certificates] Certificate [%s] of [%s]: %s", check.Name, check.Location, problem)
+		}
+		if check.HasErrors() {
+			failing++
+		} else {
+			expiring++
+		}
+	}
+	if failing > 0 {
+		return cli.NewExitError(fmt.Sprintf("Found problems with %d of %d certificates", failing+expiring, len(checks)), certCheckErrorExitCode)
+	}
+	if expiring > 0 {
+		return cli.NewExitError(fmt.Sprintf("Found %d of %d certificates expiring within %d days", expiring, len(checks), int(warnWithin.Hours()/24)), certCheckWarningExitCode)
+	}
+	log.Infof(ctx, "[certificates] Checked %d certificates, no problems found", len(checks))
+	return nil
+}
+
 func generateCSRFromCli(ctx *cli.Context) error {
 	logrus.Infof("Running RKE version: %v", ctx.App.Version)
 	clusterFile, filePath, err := resolveClusterFile(ctx)
@@ -127,6 +252,65 @@ func generateCSRFromCli(ctx *cli.Context) error {
 	return GenerateRKECSRs(context.Background(), rkeConfig, externalFlags)
 }
 
+func importCertificatesFromCli(ctx *cli.Context) error {
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	externalFlags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	externalFlags.CertificateDir = ctx.String("cert-dir")
+
+	return ImportRKECertificates(context.Background(), rkeConfig, externalFlags)
+}
+
+// ImportRKECertificates imports the signed certificates of the CSRs of the certificate dir, it fails if a certificate
+// is missing or invalid, or if the imported certificates are not a valid custom certificates bundle
+func ImportRKECertificates(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, flags cluster.ExternalFlags) error {
+	log.Infof(ctx, "Importing signed Kubernetes cluster certificates")
+	if len(flags.CertificateDir) == 0 {
+		flags.CertificateDir = cluster.GetCertificateDirPath(flags.ClusterFilePath, flags.ConfigDir)
+	}
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, "")
+	if err != nil {
+		return err
+	}
+	checks, err := pki.ImportSignedCertificates(kubeCluster.CertificateDir, &kubeCluster.RancherKubernetesEngineConfig, time.Now())
+	if err != nil {
+		return err
+	}
+	problems := 0
+	for _, check := range checks {
+		for _, problem := range check.Problems {
+			log.Warnf(ctx, "[certificates] Certificate [%s] of [%s]: %s", check.Name, check.Location, problem)
+		}
+		if len(check.Problems) > 0 {
+			problems++
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("Found problems with %d of %d certificates", problems, len(checks))
+	}
+	certBundle, err := pki.ReadCertsAndKeysFromDir(kubeCluster.CertificateDir)
+	if err != nil {
+		return err
+	}
+	if err := pki.ValidateBundleContent(&kubeCluster.RancherKubernetesEngineConfig, certBundle, flags.ClusterFilePath, flags.ConfigDir); err != nil {
+		return fmt.Errorf("Failed to validate custom certificates bundle: %v", err)
+	}
+	log.Infof(ctx, "[certificates] Imported %d certificates to [%s], run `rke up --custom-certs` to use them", len(checks), kubeCluster.CertificateDir)
+	return nil
+}
+
 func rebuildClusterWithRotatedCertificates(ctx context.Context,
 	dialersOptions hosts.DialersOptions,
 	flags cluster.ExternalFlags, svcOptionData map[string]*v3.KubernetesServicesOptions) (string, string, string, string, map[string]pki.CertificatePKI, error) {
@@ -156,7 +340,11 @@ func rebuildClusterWithRotatedCertificates(ctx context.Context,
 		APIURL = fmt.Sprintf("https://%s:6443", kubeCluster.ControlPlaneHosts[0].Address)
 	}
 	clientCert = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Certificate))
-	clientKey = string(cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key))
+	encodedKey, err := cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key)
+	if err != nil {
+		return APIURL, caCrt, clientCert, clientKey, nil, fmt.Errorf("Failed to encode the key of [%s]: %v", pki.KubeAdminCertName, err)
+	}
+	clientKey = string(encodedKey)
 	caCrt = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.CACertName].Certificate))
 
 	if err := kubeCluster.SetUpHosts(ctx, flags); err != nil {
@@ -236,6 +424,8 @@ func rotateRKECertificates(ctx context.Context, kubeCluster *cluster.Cluster, fl
 		return nil, fmt.Errorf("Failed to rotate certificates: can't find old certificates")
 	}
 	currentCluster.RotateCertificates = kubeCluster.RotateCertificates
+	// the rotated certificates follow the pki options of the configuration, like its external CA
+	currentCluster.PKI = kubeCluster.PKI
 	if !kubeCluster.RotateCertificates.CACertificates {
 		caCertPKI, ok := rkeFullState.CurrentState.CertificatesBundle[pki.CACertName]
 		if !ok {
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestCheckRKECertificatesExitCodes(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCluster(t, "v1.20.7")
+	filePath := filepath.Join(c.dir, "cluster.yml")
+	clusterFile := clusterFile("v1.20.7-rancher1-1", "node2")
+	assert.Nil(t, ioutil.WriteFile(filePath, []byte(clusterFile), 0600))
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	assert.Nil(t, err)
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	assert.Nil(t, ClusterInit(ctx, rkeConfig, c.dialersOptions(), flags))
+
+	assert.Nil(t, CheckRKECertificates(ctx, rkeConfig, c.dialersOptions(), flags, 30*24*time.Hour, false))
+
+	// the certificates expire within 20 years
+	err = CheckRKECertificates(ctx, rkeConfig, c.dialersOptions(), flags, 20*365*24*time.Hour, false)
+	if exitErr, ok := err.(cli.ExitCoder); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, certCheckWarningExitCode, exitErr.ExitCode())
+	}
+
+	// a SAN added to the cluster file is missing from the kube-apiserver certificate
+	rkeConfig.Authentication.SANs = []string{"api.example.com"}
+	err = CheckRKECertificates(ctx, rkeConfig, c.dialersOptions(), flags, 20*365*24*time.Hour, false)
+	if exitErr, ok := err.(cli.ExitCoder); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, certCheckErrorExitCode, exitErr.ExitCode())
+	}
+}
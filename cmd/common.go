@@ -18,6 +18,11 @@ import (
 	"github.com/urfave/cli"
 )
 
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
 var commonFlags = []cli.Flag{
 	cli.BoolFlag{
 		Name:  "ssh-agent-auth",
@@ -29,6 +34,20 @@ var commonFlags = []cli.Flag{
 	},
 }
 
+// stateLockFlags are the flags of the commands that change the state file
+var stateLockFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "force-unlock",
+		Usage: "Release the state file lock left by a run that didn't finish",
+	},
+}
+
+// lockStateFile takes the state file lock for the run, the returned function releases it
+func lockStateFile(ctx context.Context, cliCtx *cli.Context, flags cluster.ExternalFlags, operation string) (func(), error) {
+	statePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
+	return cluster.LockStateFile(ctx, statePath, operation, cliCtx.Bool("force-unlock"))
+}
+
 func resolveClusterFile(ctx *cli.Context) (string, string, error) {
 	clusterFile := ctx.String("config")
 	fp, err := filepath.Abs(clusterFile)
@@ -45,6 +64,14 @@ func resolveClusterFile(ctx *cli.Context) (string, string, error) {
 		return "", "", fmt.Errorf("failed to read file: %v", err)
 	}
 	clusterFileBuff := string(buf)
+	// the --state-backend flag overrides the cluster file
+	if len(ctx.GlobalString("state-backend")) == 0 {
+		stateBackend, err := cluster.ParseStateBackend(clusterFileBuff)
+		if err != nil {
+			return "", "", err
+		}
+		cluster.SetStateBackend(stateBackend)
+	}
 	return clusterFileBuff, clusterFile, nil
 }
 
@@ -66,14 +93,35 @@ func setOptionsFromCLI(c *cli.Context, rkeConfig *v3.RancherKubernetesEngineConf
 	return rkeConfig, nil
 }
 
+// getOutputContext returns the context carrying the logger for the requested output format
+func getOutputContext(c *cli.Context) (context.Context, error) {
+	ctx := context.Background()
+	switch output := c.String("output"); output {
+	case "", outputText:
+		return ctx, nil
+	case outputJSON:
+		// keep stdout for the JSON lines, the messages logged with logrus go to stderr
+		if logrus.StandardLogger().Out != ioutil.Discard {
+			logrus.SetOutput(os.Stderr)
+		}
+		return log.SetLogger(ctx, log.NewJSONLogger(os.Stdout)), nil
+	default:
+		return ctx, fmt.Errorf("Unsupported output format [%s], supported formats are [%s, %s]", output, outputText, outputJSON)
+	}
+}
+
 func ClusterInit(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags) error {
+	defer hosts.UseSSHConnPool()()
 	log.Infof(ctx, "Initiating Kubernetes cluster")
 	var fullState *cluster.FullState
 	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
 	if len(flags.CertificateDir) == 0 {
 		flags.CertificateDir = cluster.GetCertificateDirPath(flags.ClusterFilePath, flags.ConfigDir)
 	}
-	rkeFullState, _ := cluster.ReadStateFile(ctx, stateFilePath)
+	rkeFullState, err := cluster.ReadStateFile(ctx, stateFilePath)
+	if cluster.IsStateDecryptionError(err) {
+		return err
+	}
 	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, rkeFullState.DesiredState.EncryptionConfig)
 	if err != nil {
 		return err
@@ -108,6 +156,12 @@ func ClusterInit(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfi
 	rkeState := cluster.FullState{
 		DesiredState: fullState.DesiredState,
 		CurrentState: fullState.CurrentState,
+		// kept for rke up --resume, it's discarded there if the desired state changed
+		Checkpoint:   rkeFullState.Checkpoint,
+		ImageDigests: rkeFullState.ImageDigests,
+		ImageIDs:     rkeFullState.ImageIDs,
+		// the SSH host keys trusted before the state file existed
+		SSHHostKeys: kubeCluster.HostKeyVerifier.Keys(),
 	}
 	return rkeState.WriteStateFile(ctx, stateFilePath)
 }
@@ -151,7 +205,7 @@ func setS3OptionsFromCLI(c *cli.Context) *v3.S3BackupConfig {
 }
 
 func checkLegacyCluster(ctx context.Context, kubeCluster *cluster.Cluster, fullState *cluster.FullState, flags cluster.ExternalFlags) error {
-	stateFileExists, err := util.IsFileExists(kubeCluster.StateFilePath)
+	stateFileExists, err := cluster.StateFileExists(ctx, kubeCluster.StateFilePath)
 	if err != nil {
 		return err
 	}
@@ -23,7 +23,7 @@ func EncryptionCommand() cli.Command {
 			EnvVar: "RKE_CONFIG",
 		},
 	}
-	encryptFlags = append(encryptFlags, commonFlags...)
+	encryptFlags = append(append(encryptFlags, stateLockFlags...), commonFlags...)
 	return cli.Command{
 		Name:  "encrypt",
 		Usage: "Manage cluster encryption provider keys",
@@ -57,6 +57,11 @@ func rotateEncryptionKeyFromCli(ctx *cli.Context) error {
 
 	// setting up the flags
 	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "encrypt rotate-key")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	_, _, _, _, _, err = RotateEncryptionKey(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
 	return err
@@ -68,12 +73,16 @@ func RotateEncryptionKey(
 	dialersOptions hosts.DialersOptions,
 	flags cluster.ExternalFlags,
 ) (string, string, string, string, map[string]pki.CertificatePKI, error) {
+	defer hosts.UseSSHConnPool()()
 	log.Infof(ctx, "Rotating cluster secrets encryption key")
 
 	var APIURL, caCrt, clientCert, clientKey string
 
 	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
-	rkeFullState, _ := cluster.ReadStateFile(ctx, stateFilePath)
+	rkeFullState, err := cluster.ReadStateFile(ctx, stateFilePath)
+	if cluster.IsStateDecryptionError(err) {
+		return APIURL, caCrt, clientCert, clientKey, nil, err
+	}
 
 	// We generate the first encryption config in ClusterInit, to store it ASAP. It's written to the DesiredState
 	stateEncryptionConfig := rkeFullState.DesiredState.EncryptionConfig
@@ -105,7 +114,11 @@ func RotateEncryptionKey(
 		APIURL = fmt.Sprintf("https://%s:6443", kubeCluster.ControlPlaneHosts[0].Address)
 	}
 	clientCert = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Certificate))
-	clientKey = string(cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key))
+	encodedKey, err := cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key)
+	if err != nil {
+		return APIURL, caCrt, clientCert, clientKey, nil, fmt.Errorf("Failed to encode the key of [%s]: %v", pki.KubeAdminCertName, err)
+	}
+	clientKey = string(encodedKey)
 	caCrt = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.CACertName].Certificate))
 
 	err = kubeCluster.RotateEncryptionKey(ctx, rkeFullState)
@@ -114,7 +127,10 @@ func RotateEncryptionKey(
 	}
 
 	// make sure we have the latest state
-	rkeFullState, _ = cluster.ReadStateFile(ctx, stateFilePath)
+	rkeFullState, err = cluster.ReadStateFile(ctx, stateFilePath)
+	if cluster.IsStateDecryptionError(err) {
+		return APIURL, caCrt, clientCert, clientKey, nil, err
+	}
 
 	log.Infof(ctx, "Reconciling cluster state")
 	if err := kubeCluster.ReconcileDesiredStateEncryptionConfig(ctx, rkeFullState); err != nil {
@@ -3,11 +3,17 @@ package cmd
 import (
 	"context"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/pkg/errors"
+	"github.com/rancher/rke/backup"
 	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
@@ -81,8 +87,17 @@ func EtcdCommand() cli.Command {
 			Name:  "use-local-state",
 			Usage: "Use local state file (do not check or use snapshot archive for state file)",
 		},
+		cli.BoolFlag{
+			Name:  "allow-unencrypted",
+			Usage: "Restore a snapshot that is not encrypted from a backup backend with encryption configured",
+		},
 	}
-	snapshotRestoreFlags = append(append(snapshotFlags, snapshotRestoreFlags...), commonFlags...)
+	snapshotRestoreFlags = append(append(append(snapshotFlags, snapshotRestoreFlags...), stateLockFlags...), commonFlags...)
+
+	// the first snapshot flag is the snapshot name
+	snapshotListFlags := append(snapshotFlags[1:], commonFlags...)
+
+	snapshotInspectFlags := append(snapshotFlags, commonFlags...)
 
 	return cli.Command{
 		Name:  "etcd",
@@ -100,6 +115,39 @@ func EtcdCommand() cli.Command {
 				Flags:  snapshotRestoreFlags,
 				Action: RestoreEtcdSnapshotFromCli,
 			},
+			{
+				Name:   "snapshot-list",
+				Usage:  "List snapshots on all etcd hosts and in the backup backend",
+				Flags:  snapshotListFlags,
+				Action: ListEtcdSnapshotsFromCli,
+			},
+			{
+				Name:   "snapshot-inspect",
+				Usage:  "Show the cluster state included in a snapshot",
+				Flags:  snapshotInspectFlags,
+				Action: InspectEtcdSnapshotFromCli,
+			},
+			{
+				// runs in the etcd-snapshot-uploader container on the etcd hosts
+				Name:   "snapshot-upload",
+				Usage:  "Upload the recurring snapshots of the etcd host to the backup backend",
+				Hidden: true,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "config",
+						Usage: "Path of the backup configuration",
+					},
+					cli.StringFlag{
+						Name:  "dir",
+						Usage: "Snapshot directory",
+					},
+					cli.StringFlag{
+						Name:  "name",
+						Usage: "Upload the snapshot once instead of the recurring snapshots",
+					},
+				},
+				Action: UploadEtcdSnapshotsFromCli,
+			},
 		},
 	}
 }
@@ -109,6 +157,7 @@ func SnapshotSaveEtcdHosts(
 	rkeConfig *v3.RancherKubernetesEngineConfig,
 	dialersOptions hosts.DialersOptions,
 	flags cluster.ExternalFlags, snapshotName string) error {
+	defer hosts.UseSSHConnPool()()
 
 	log.Infof(ctx, "Starting saving snapshot on etcd hosts")
 
@@ -145,6 +194,7 @@ func RestoreEtcdSnapshot(
 	flags cluster.ExternalFlags,
 	data map[string]interface{},
 	snapshotName string) (string, string, string, string, map[string]pki.CertificatePKI, error) {
+	defer hosts.UseSSHConnPool()()
 	var APIURL, caCrt, clientCert, clientKey string
 
 	rkeFullState := &cluster.FullState{}
@@ -171,7 +221,10 @@ func RestoreEtcdSnapshot(
 		// If state file is not in snapshot (or can't be retrieved), fallback to local state file
 		if err != nil {
 			logrus.Infof("Could not extract state file from snapshot [%s] on any host, falling back to local state file: %v", snapshotName, err)
-			rkeFullState, _ = cluster.ReadStateFile(ctx, stateFilePath)
+			rkeFullState, err = cluster.ReadStateFile(ctx, stateFilePath)
+			if cluster.IsStateDecryptionError(err) {
+				return APIURL, caCrt, clientCert, clientKey, nil, err
+			}
 		} else {
 			// Parse extracted state file to FullState struct
 			rkeFullState, err = cluster.StringToFullState(ctx, stateFile)
@@ -345,6 +398,9 @@ func RestoreEtcdSnapshotFromCli(ctx *cli.Context) error {
 	if strings.HasSuffix(etcdSnapshotName, ".zip") {
 		logrus.Warnf("The snapshot name [%s] ends with the file extension (.zip) which is not needed, the snapshot name should be provided without the extension", etcdSnapshotName)
 	}
+	if ctx.Bool("allow-unencrypted") && rkeConfig.Services.Etcd.BackupConfig != nil && rkeConfig.Services.Etcd.BackupConfig.Encryption != nil {
+		rkeConfig.Services.Etcd.BackupConfig.Encryption.AllowUnencrypted = true
+	}
 	// setting up the flags
 	// flag to use local state file
 	useLocalState := ctx.Bool("use-local-state")
@@ -353,6 +409,11 @@ func RestoreEtcdSnapshotFromCli(ctx *cli.Context) error {
 	// Custom certificates and certificate dir flags
 	flags.CertificateDir = ctx.String("cert-dir")
 	flags.CustomCerts = ctx.Bool("custom-certs")
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "etcd snapshot-restore")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	_, _, _, _, _, err = RestoreEtcdSnapshot(context.Background(), rkeConfig, hosts.DialersOptions{}, flags, map[string]interface{}{}, etcdSnapshotName)
 	return err
@@ -363,6 +424,7 @@ func SnapshotRemoveFromEtcdHosts(
 	rkeConfig *v3.RancherKubernetesEngineConfig,
 	dialersOptions hosts.DialersOptions,
 	flags cluster.ExternalFlags, snapshotName string) error {
+	defer hosts.UseSSHConnPool()()
 
 	log.Infof(ctx, "Starting snapshot remove on etcd hosts")
 	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, "")
@@ -384,3 +446,202 @@ func SnapshotRemoveFromEtcdHosts(
 	log.Infof(ctx, "Finished removing snapshot [%s] from all etcd hosts", snapshotName)
 	return nil
 }
+
+func ListEtcdSnapshots(
+	ctx context.Context,
+	rkeConfig *v3.RancherKubernetesEngineConfig,
+	dialersOptions hosts.DialersOptions,
+	flags cluster.ExternalFlags) ([]*cluster.EtcdSnapshot, error) {
+	defer hosts.UseSSHConnPool()()
+
+	log.Infof(ctx, "Listing snapshots on etcd hosts")
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return nil, err
+	}
+	return kubeCluster.ListEtcdSnapshots(ctx)
+}
+
+func InspectEtcdSnapshot(
+	ctx context.Context,
+	rkeConfig *v3.RancherKubernetesEngineConfig,
+	dialersOptions hosts.DialersOptions,
+	flags cluster.ExternalFlags, snapshotName string) (*cluster.FullState, error) {
+	defer hosts.UseSSHConnPool()()
+
+	log.Infof(ctx, "Extracting state file from snapshot [%s]", snapshotName)
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return nil, err
+	}
+	stateFile, err := kubeCluster.GetStateFileFromSnapshot(ctx, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.StringToFullState(ctx, stateFile)
+}
+
+func ListEtcdSnapshotsFromCli(ctx *cli.Context) error {
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse cluster file: %v", err)
+	}
+
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	// setting up the flags
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+
+	snapshots, err := ListEtcdSnapshots(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
+	if err != nil {
+		return err
+	}
+	printEtcdSnapshots(snapshots)
+	return nil
+}
+
+func InspectEtcdSnapshotFromCli(ctx *cli.Context) error {
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse cluster file: %v", err)
+	}
+
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	etcdSnapshotName := strings.TrimSuffix(ctx.String("name"), ".zip")
+	if etcdSnapshotName == "" {
+		return fmt.Errorf("you must specify the snapshot name to inspect")
+	}
+	// setting up the flags
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+
+	snapshotState, err := InspectEtcdSnapshot(context.Background(), rkeConfig, hosts.DialersOptions{}, flags, etcdSnapshotName)
+	if err != nil {
+		return err
+	}
+	printEtcdSnapshotState(etcdSnapshotName, snapshotState)
+	return nil
+}
+
+func printEtcdSnapshots(snapshots []*cluster.EtcdSnapshot) {
+	if len(snapshots) == 0 {
+		fmt.Println("No snapshots found.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tCREATED\tHOSTS\tBACKEND\tCHECKSUM\tSTATE FILE")
+	for _, snapshot := range snapshots {
+		checksum := "-"
+		if snapshot.ChecksumConsistent != nil {
+			checksum = "consistent"
+			if !*snapshot.ChecksumConsistent {
+				checksum = "mismatch"
+			}
+		}
+		stateFile := "unknown"
+		if snapshot.StateFile != nil {
+			stateFile = "no"
+			if *snapshot.StateFile {
+				stateFile = "yes"
+			}
+		}
+		backend := snapshot.Backend
+		if backend == "" {
+			backend = "-"
+		}
+		hostList := strings.Join(snapshot.Hosts, ",")
+		if hostList == "" {
+			hostList = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			snapshot.Name,
+			units.BytesSize(float64(snapshot.Size)),
+			snapshot.Created.Format(time.RFC3339),
+			hostList,
+			backend,
+			checksum,
+			stateFile)
+	}
+	w.Flush()
+}
+
+func printEtcdSnapshotState(snapshotName string, snapshotState *cluster.FullState) {
+	rkeConfig := snapshotState.CurrentState.RancherKubernetesEngineConfig
+	if rkeConfig == nil {
+		rkeConfig = snapshotState.DesiredState.RancherKubernetesEngineConfig
+	}
+	if rkeConfig == nil {
+		fmt.Printf("Snapshot [%s] state file has no cluster configuration\n", snapshotName)
+		return
+	}
+	fmt.Printf("Snapshot: %s\n", snapshotName)
+	fmt.Printf("Kubernetes version: %s\n", rkeConfig.Version)
+	fmt.Printf("Nodes:\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "  ADDRESS\tHOSTNAME\tROLES")
+	for _, node := range rkeConfig.Nodes {
+		hostname := node.HostnameOverride
+		if hostname == "" {
+			hostname = "-"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", node.Address, hostname, strings.Join(node.Role, ","))
+	}
+	w.Flush()
+}
+
+func UploadEtcdSnapshotsFromCli(ctx *cli.Context) error {
+	configPath := ctx.String("config")
+	configBytes, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("Failed to read backup configuration [%s]: %v", configPath, err)
+	}
+	var backupConfig v3.BackupConfig
+	if err := json.Unmarshal(configBytes, &backupConfig); err != nil {
+		return fmt.Errorf("Failed to parse backup configuration [%s]: %v", configPath, err)
+	}
+	backend, err := backup.NewBackend(&backupConfig)
+	if err != nil {
+		return err
+	}
+	if backend == nil {
+		return fmt.Errorf("No backup backend is configured in [%s]", configPath)
+	}
+	uploader := &backup.SnapshotUploader{
+		Backend:    backend,
+		Dir:        ctx.String("dir"),
+		Retention:  time.Duration(backupConfig.Retention*backupConfig.IntervalHours) * time.Hour,
+		SettleTime: time.Minute,
+	}
+	if name := ctx.String("name"); name != "" {
+		return uploader.UploadSnapshot(context.Background(), name)
+	}
+	return uploader.Run(context.Background(), time.Minute)
+}
@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/metadata"
+	"github.com/rancher/rke/pki"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	DefaultImageBundle = "rke-images.tar.gz"
+	localDockerHost    = "localhost"
+)
+
+func ImagesCommand() cli.Command {
+	loadFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Specify an alternate cluster YAML file",
+			Value:  pki.ClusterConfig,
+			EnvVar: "RKE_CONFIG",
+		},
+		cli.StringFlag{
+			Name:  "input,i",
+			Usage: "Image bundle written by rke images save",
+			Value: DefaultImageBundle,
+		},
+		cli.StringFlag{
+			Name:  "registry",
+			Usage: "Push the images to the private registry instead of loading them on the cluster hosts, the credentials are read from private_registries of the cluster file",
+		},
+	}
+	loadFlags = append(loadFlags, commonFlags...)
+	lockFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Specify an alternate cluster YAML file",
+			Value:  pki.ClusterConfig,
+			EnvVar: "RKE_CONFIG",
+		},
+		cli.BoolFlag{
+			Name:  "check",
+			Usage: "Fail without updating the state file if a tag points to another digest than the locked one",
+		},
+	}
+	lockFlags = append(lockFlags, stateLockFlags...)
+	return cli.Command{
+		Name:  "images",
+		Usage: "System images bundle for air-gapped clusters",
+		Subcommands: cli.Commands{
+			cli.Command{
+				Name:   "save",
+				Usage:  "Save the system images of a kubernetes version from the local Docker daemon in a single tarball",
+				Action: saveImagesFromCli,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "version",
+						Usage: "Kubernetes version of the system images, default is the default kubernetes version",
+					},
+					cli.StringFlag{
+						Name:  "output,o",
+						Usage: "Image bundle path, gzip compressed with the .gz extension",
+						Value: DefaultImageBundle,
+					},
+				},
+			},
+			cli.Command{
+				Name:   "load",
+				Usage:  "Load an image bundle on the cluster hosts or push it to a private registry",
+				Action: loadImagesFromCli,
+				Flags:  loadFlags,
+			},
+			cli.Command{
+				Name:  "lock",
+				Usage: "Resolve the system images of the cluster to their digests and pin them in the state file",
+				Description: "rke up pulls the system images by their locked digests and warns when a tag moved to another digest. " +
+					"The next rke up after a lock that changed digests, like the first lock, recreates the containers of these images. " +
+					"The IDs of the images pulled in the local Docker daemon are locked too, the images loaded on the hosts with rke images load are deployed by their tag when they have the locked ID.",
+				Action: lockImagesFromCli,
+				Flags:  lockFlags,
+			},
+		},
+	}
+}
+
+func saveImagesFromCli(ctx *cli.Context) error {
+	if metadata.K8sVersionToRKESystemImages == nil {
+		if err := metadata.InitMetadata(context.Background()); err != nil {
+			return err
+		}
+	}
+	version := ctx.String("version")
+	if len(version) == 0 {
+		version = metadata.DefaultK8sVersion
+	}
+	images, err := getSystemImagesForVersion(version)
+	if err != nil {
+		return err
+	}
+	dClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the local Docker daemon: %v", err)
+	}
+	return SaveImages(context.Background(), dClient, images, ctx.String("output"))
+}
+
+// SaveImages writes the images of the local Docker daemon to the image bundle
+func SaveImages(ctx context.Context, dClient *client.Client, images []string, bundlePath string) error {
+	log.Infof(ctx, "Saving %d system images to [%s]", len(images), bundlePath)
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		return fmt.Errorf("Failed to create image bundle [%s]: %v", bundlePath, err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var gw *gzip.Writer
+	if strings.HasSuffix(bundlePath, ".gz") {
+		gw = gzip.NewWriter(f)
+		w = gw
+	}
+	err = docker.SaveImageBundle(ctx, dClient, localDockerHost, images, nil, w)
+	if err == nil && gw != nil {
+		err = gw.Close()
+	}
+	if err != nil {
+		os.Remove(bundlePath)
+		return err
+	}
+	log.Infof(ctx, "Saved system images to [%s]", bundlePath)
+	return nil
+}
+
+func getSystemImagesForVersion(version string) ([]string, error) {
+	if _, ok := metadata.K8sBadVersions[version]; ok {
+		return nil, fmt.Errorf("k8s version [%s] is not supported", version)
+	}
+	rkeSystemImages, ok := metadata.K8sVersionToRKESystemImages[version]
+	if !ok || rkeSystemImages == (v3.RKESystemImages{}) {
+		return nil, fmt.Errorf("k8s version [%s] is not supported", version)
+	}
+	var images []string
+	for _, image := range getUniqueSystemImageList(rkeSystemImages) {
+		if image != "" {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+func loadImagesFromCli(ctx *cli.Context) error {
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	registry := ctx.String("registry")
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		// the cluster file is only needed for the credentials of the registry
+		if registry == "" {
+			return fmt.Errorf("Failed to resolve cluster file: %v", err)
+		}
+		clusterFile = ""
+	}
+	var rkeConfig *v3.RancherKubernetesEngineConfig
+	if clusterFile != "" {
+		if rkeConfig, err = cluster.ParseConfig(clusterFile); err != nil {
+			return fmt.Errorf("Failed to parse cluster file: %v", err)
+		}
+		if rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig); err != nil {
+			return err
+		}
+	}
+	bundlePath := ctx.String("input")
+	if registry != "" {
+		prsMap := map[string]v3.PrivateRegistry{}
+		if rkeConfig != nil {
+			for _, pr := range rkeConfig.PrivateRegistries {
+				prsMap[pr.URL] = pr
+			}
+		}
+		return PushImages(context.Background(), bundlePath, registry, prsMap)
+	}
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	return LoadImages(context.Background(), rkeConfig, hosts.DialersOptions{}, flags, bundlePath)
+}
+
+// LoadImages loads the image bundle in the Docker daemon of the cluster hosts over their tunnel, the images are tagged
+// with the names they are deployed as
+func LoadImages(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags, bundlePath string) error {
+	defer hosts.UseSSHConnPool()()
+	if _, err := os.Stat(bundlePath); err != nil {
+		return fmt.Errorf("Failed to read image bundle [%s]: %v", bundlePath, err)
+	}
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig.DeepCopy(), flags, "")
+	if err != nil {
+		return err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return err
+	}
+	if err := kubeCluster.LoadImageBundle(ctx, bundlePath); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[images] Loaded image bundle [%s] on the cluster hosts", bundlePath)
+	return nil
+}
+
+// PushImages loads the image bundle in the local Docker daemon and pushes the images to the registry, the images are
+// prefixed with the registry like the images of a default private registry
+func PushImages(ctx context.Context, bundlePath, registry string, prsMap map[string]v3.PrivateRegistry) error {
+	var tags []string
+	if err := docker.ReadImageBundle(bundlePath, func(r io.Reader) error {
+		var err error
+		tags, err = docker.GetImageBundleTags(r)
+		return err
+	}); err != nil {
+		return err
+	}
+	dClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the local Docker daemon: %v", err)
+	}
+	log.Infof(ctx, "[images] Loading image bundle [%s] in the local Docker daemon", bundlePath)
+	if err := docker.ReadImageBundle(bundlePath, func(r io.Reader) error {
+		return docker.LoadImageBundle(ctx, dClient, localDockerHost, r)
+	}); err != nil {
+		return err
+	}
+	registry = strings.TrimSuffix(registry, "/")
+	for _, tag := range tags {
+		image := fmt.Sprintf("%s/%s", registry, tag)
+		log.Infof(ctx, "[images] Pushing image [%s]", image)
+		if err := dClient.ImageTag(ctx, tag, image); err != nil {
+			return fmt.Errorf("Failed to tag image [%s] as [%s]: %v", tag, image, err)
+		}
+		if err := docker.PushImage(ctx, dClient, localDockerHost, image, prsMap); err != nil {
+			return err
+		}
+	}
+	log.Infof(ctx, "[images] Pushed %d images to registry [%s]", len(tags), registry)
+	return nil
+}
+
+func lockImagesFromCli(ctx *cli.Context) error {
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "images lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return LockImages(context.Background(), rkeConfig, flags, ctx.Bool("check"))
+}
+
+// LockImages records the digests of the system images in the state file, rke up pulls the images by these digests
+func LockImages(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, flags cluster.ExternalFlags, check bool) error {
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig.DeepCopy(), flags, "")
+	if err != nil {
+		return err
+	}
+	dClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the local Docker daemon: %v", err)
+	}
+	digests, changes, err := kubeCluster.LockImageDigests(ctx, func(ctx context.Context, image string) (string, error) {
+		return docker.GetImageDigest(ctx, dClient, image, kubeCluster.PrivateRegistriesMap)
+	})
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		log.Warnf(ctx, "[images] Tag of image [%s] changed from digest [%s] to [%s]", change.Image, change.Locked, change.Current)
+	}
+	if check && len(changes) > 0 {
+		return fmt.Errorf("%d system images changed since they were locked", len(changes))
+	}
+	locked, err := cluster.ReadImageDigests(ctx, kubeCluster.StateFilePath)
+	if err != nil {
+		return err
+	}
+	// the images loaded with rke images load have no digest, they're deployed when their ID is the locked image ID
+	ids := cluster.LocalImageIDs(ctx, dClient, digests)
+	if err := cluster.WriteImageDigests(ctx, kubeCluster.StateFilePath, digests, ids); err != nil {
+		return err
+	}
+	log.Infof(ctx, "[images] Locked %d system images in state file [%s]", len(digests), kubeCluster.StateFilePath)
+	repinned := 0
+	for image, digest := range digests {
+		if locked[image] != digest {
+			repinned++
+		}
+	}
+	if repinned > 0 {
+		log.Warnf(ctx, "[images] The next rke up recreates the containers of the %d system images pinned to a new digest", repinned)
+	}
+	return nil
+}
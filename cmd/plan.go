@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/pki"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func PlanCommand() cli.Command {
+	planFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Specify an alternate cluster YAML file",
+			Value:  pki.ClusterConfig,
+			EnvVar: "RKE_CONFIG",
+		},
+	}
+	planFlags = append(planFlags, commonFlags...)
+	return cli.Command{
+		Name:   "plan",
+		Usage:  "Show the changes rke up would apply to the cluster without connecting to any node",
+		Action: clusterPlanFromCli,
+		Flags:  planFlags,
+	}
+}
+
+func clusterPlanFromCli(ctx *cli.Context) error {
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	// setting up the flags
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+
+	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
+	clusterState, err := cluster.ReadStateFile(context.Background(), stateFilePath)
+	if err != nil {
+		if !cluster.IsStateFileNotFound(err) {
+			return err
+		}
+		logrus.Infof("State file [%s] does not exist, planning a new cluster", stateFilePath)
+	}
+	// The desired state is what rke up would write in ClusterInit, the cluster file is not applied yet
+	clusterState.DesiredState.RancherKubernetesEngineConfig = rkeConfig
+	if clusterState.DesiredState.EncryptionConfig == "" {
+		clusterState.DesiredState.EncryptionConfig = clusterState.CurrentState.EncryptionConfig
+	}
+
+	planDiff, err := cluster.GetPlanDiff(context.Background(), clusterState, map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	printPlanDiff(planDiff)
+	return nil
+}
+
+func printPlanDiff(planDiff *cluster.PlanDiff) {
+	if planDiff.IsEmpty() {
+		fmt.Println("No changes. The cluster matches the configuration.")
+		return
+	}
+	if len(planDiff.AddedHosts) > 0 {
+		fmt.Printf("Hosts to add: %s\n", strings.Join(planDiff.AddedHosts, ", "))
+	}
+	if len(planDiff.RemovedHosts) > 0 {
+		fmt.Printf("Hosts to remove: %s\n", strings.Join(planDiff.RemovedHosts, ", "))
+	}
+	if len(planDiff.EtcdMembersToAdd) > 0 {
+		fmt.Printf("Etcd members to add: %s\n", strings.Join(planDiff.EtcdMembersToAdd, ", "))
+	}
+	if len(planDiff.EtcdMembersToDelete) > 0 {
+		fmt.Printf("Etcd members to delete: %s\n", strings.Join(planDiff.EtcdMembersToDelete, ", "))
+	}
+	for _, node := range planDiff.Nodes {
+		fmt.Printf("\n[%s] host [%s]\n", node.Action, node.Address)
+		for _, process := range node.Processes {
+			fmt.Printf("  [%s] %s\n", process.Action, process.Name)
+			for _, change := range process.Changes {
+				fmt.Printf("      %s\n", change)
+			}
+		}
+	}
+	if len(planDiff.Addons) > 0 {
+		fmt.Printf("\nAddons to redeploy: %s\n", strings.Join(planDiff.Addons, ", "))
+	}
+}
@@ -39,7 +39,7 @@ func RemoveCommand() cli.Command {
 		},
 	}
 
-	removeFlags = append(removeFlags, commonFlags...)
+	removeFlags = append(append(removeFlags, stateLockFlags...), commonFlags...)
 
 	return cli.Command{
 		Name:   "remove",
@@ -54,6 +54,7 @@ func ClusterRemove(
 	rkeConfig *v3.RancherKubernetesEngineConfig,
 	dialersOptions hosts.DialersOptions,
 	flags cluster.ExternalFlags) error {
+	defer hosts.UseSSHConnPool()()
 
 	log.Infof(ctx, "Tearing down Kubernetes cluster")
 
@@ -117,6 +118,11 @@ func clusterRemoveFromCli(ctx *cli.Context) error {
 
 	// setting up the flags
 	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "remove")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	return ClusterRemove(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
 }
@@ -141,6 +147,11 @@ func clusterRemoveLocal(ctx *cli.Context) error {
 	}
 	// setting up the flags
 	flags := cluster.GetExternalFlags(true, false, false, false, "", filePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "remove")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	return ClusterRemove(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
 }
@@ -161,6 +172,12 @@ func clusterRemoveDind(ctx *cli.Context) error {
 		return err
 	}
 
+	unlock, err := lockStateFile(context.Background(), ctx, cluster.GetExternalFlags(false, false, false, false, "", filePath), "remove")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	for _, node := range rkeConfig.Nodes {
 		if err = dind.RmoveDindContainer(context.Background(), node.Address); err != nil {
 			return err
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/pki"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterRemove(t *testing.T) {
+	c := newTestCluster(t, "v1.20.7", "node1", "node2")
+	clusterFile := clusterFile("v1.20.7-rancher1-1", "node2")
+	if !assert.Nil(t, c.up(t, clusterFile)) {
+		return
+	}
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	assert.Nil(t, err)
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filepath.Join(c.dir, "cluster.yml"))
+	assert.Nil(t, ClusterRemove(context.Background(), rkeConfig, c.dialersOptions(), flags))
+
+	events := c.eventLog.Events()
+	for _, r := range c.runtimes {
+		// the exited container that read the legacy state file of the host is left by rke up
+		assert.Equal(t, []string{pki.StateDeployerContainerName}, r.ContainerNames(), r.Hostname)
+	}
+	// the data of the hosts is cleaned once the containers are removed
+	assert.Less(t, eventIndex(events, "node1 remove etcd"), eventIndex(events, "node1 start kube-cleaner"))
+	assert.Less(t, eventIndex(events, "node2 remove kubelet"), eventIndex(events, "node2 start kube-cleaner"))
+}
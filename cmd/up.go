@@ -65,9 +65,18 @@ func UpCommand() cli.Command {
 			Name:  "custom-certs",
 			Usage: "Use custom certificates from a cert dir",
 		},
+		cli.BoolFlag{
+			Name:  "resume",
+			Usage: "Skip the phases a previous failed run of up completed for the same configuration",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "Output format of the progress log, json prints newline delimited events (text, json)",
+			Value: outputText,
+		},
 	}
 
-	upFlags = append(upFlags, commonFlags...)
+	upFlags = append(append(upFlags, stateLockFlags...), commonFlags...)
 
 	return cli.Command{
 		Name:   "up",
@@ -78,6 +87,8 @@ func UpCommand() cli.Command {
 }
 
 func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags, data map[string]interface{}) (string, string, string, string, map[string]pki.CertificatePKI, error) {
+	// the SSH connections are closed when the last command using them is done
+	defer hosts.UseSSHConnPool()()
 	var APIURL, caCrt, clientCert, clientKey string
 	var reconcileCluster, restore bool
 
@@ -97,6 +108,10 @@ func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags c
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
+	kubeCluster.Checkpoint, err = clusterState.GetCheckpoint(ctx, flags.Resume)
+	if err != nil {
+		return APIURL, caCrt, clientCert, clientKey, nil, err
+	}
 	svcOptionsData := cluster.GetServiceOptionData(data)
 	// check if rotate certificates is triggered
 	if kubeCluster.RancherKubernetesEngineConfig.RotateCertificates != nil {
@@ -112,10 +127,13 @@ func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags c
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
-	err = kubeCluster.TunnelHosts(ctx, flags)
+	phaseCtx, finish := log.StartEvent(ctx, log.Event{Phase: cluster.PhaseTunnelHosts})
+	err = kubeCluster.TunnelHosts(phaseCtx, flags)
+	finish(err)
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
+	kubeCluster.CheckImageDigests(ctx)
 	currentCluster, err := kubeCluster.GetClusterState(ctx, clusterState)
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
@@ -126,24 +144,33 @@ func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags c
 		}
 	}
 
-	err = cluster.SetUpAuthentication(ctx, kubeCluster, currentCluster, clusterState)
+	phaseCtx, finish = log.StartEvent(ctx, log.Event{Phase: cluster.PhaseCertificates})
+	err = cluster.SetUpAuthentication(phaseCtx, kubeCluster, currentCluster, clusterState)
 	if err != nil {
+		finish(err)
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
 	if len(kubeCluster.ControlPlaneHosts) > 0 {
 		APIURL = fmt.Sprintf("https://%s:6443", kubeCluster.ControlPlaneHosts[0].Address)
 	}
 	clientCert = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Certificate))
-	clientKey = string(cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key))
+	encodedKey, err := cert.EncodePrivateKeyPEM(kubeCluster.Certificates[pki.KubeAdminCertName].Key)
+	if err != nil {
+		return APIURL, caCrt, clientCert, clientKey, nil, fmt.Errorf("Failed to encode the key of [%s]: %v", pki.KubeAdminCertName, err)
+	}
+	clientKey = string(encodedKey)
 	caCrt = string(cert.EncodeCertPEM(kubeCluster.Certificates[pki.CACertName].Certificate))
 
 	// moved deploying certs before reconcile to remove all unneeded certs generation from reconcile
-	err = kubeCluster.SetUpHosts(ctx, flags)
+	err = kubeCluster.SetUpHostsWithCheckpoint(phaseCtx, flags, clusterState)
+	finish(err)
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
 
-	err = cluster.ReconcileCluster(ctx, kubeCluster, currentCluster, flags, svcOptionsData)
+	phaseCtx, finish = log.StartEvent(ctx, log.Event{Phase: cluster.PhaseReconcile})
+	err = cluster.ReconcileCluster(phaseCtx, kubeCluster, currentCluster, flags, svcOptionsData)
+	finish(err)
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
@@ -191,33 +218,64 @@ func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags c
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
 
-	if err := kubeCluster.PrePullK8sImages(ctx); err != nil {
-		return APIURL, caCrt, clientCert, clientKey, nil, err
-	}
-
-	errMsgMaxUnavailableNotFailedCtrl, err := kubeCluster.DeployControlPlane(ctx, svcOptionsData, reconcileCluster)
-	if err != nil {
-		return APIURL, caCrt, clientCert, clientKey, nil, err
-	}
-	// Apply Authz configuration after deploying controlplane
-	err = cluster.ApplyAuthzResources(ctx, kubeCluster.RancherKubernetesEngineConfig, flags, dialersOptions)
+	phaseCtx, finish = log.StartEvent(ctx, log.Event{Phase: cluster.PhasePrePullImages})
+	err = kubeCluster.PrePullK8sImages(phaseCtx)
+	finish(err)
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
 
-	err = kubeCluster.UpdateClusterCurrentState(ctx, clusterState)
-	if err != nil {
-		return APIURL, caCrt, clientCert, clientKey, nil, err
+	var errMsgMaxUnavailableNotFailedCtrl, errMsgMaxUnavailableNotFailedWrkr string
+	if !kubeCluster.Checkpoint.IsComplete(cluster.PhaseControlPlane) {
+		phaseCtx, finish = log.StartEvent(ctx, log.Event{Phase: cluster.PhaseControlPlane})
+		errMsgMaxUnavailableNotFailedCtrl, err = kubeCluster.DeployControlPlane(phaseCtx, svcOptionsData, reconcileCluster)
+		if err != nil {
+			finish(err)
+			return APIURL, caCrt, clientCert, clientKey, nil, err
+		}
+		// Apply Authz configuration after deploying controlplane
+		err = cluster.ApplyAuthzResources(phaseCtx, kubeCluster.RancherKubernetesEngineConfig, flags, dialersOptions)
+		if err == nil {
+			err = kubeCluster.SaveCheckpoint(phaseCtx, clusterState, cluster.PhaseControlPlane)
+		}
+		finish(err)
+		if err != nil {
+			return APIURL, caCrt, clientCert, clientKey, nil, err
+		}
 	}
 
-	err = cluster.SaveFullStateToKubernetes(ctx, kubeCluster, clusterState)
-	if err != nil {
-		return APIURL, caCrt, clientCert, clientKey, nil, err
+	if !kubeCluster.Checkpoint.IsComplete(cluster.PhaseSaveState) {
+		phaseCtx, finish = log.StartEvent(ctx, log.Event{Phase: cluster.PhaseSaveState})
+		err = kubeCluster.UpdateClusterCurrentState(phaseCtx, clusterState)
+		if err != nil {
+			finish(err)
+			return APIURL, caCrt, clientCert, clientKey, nil, err
+		}
+
+		err = cluster.SaveFullStateToKubernetes(phaseCtx, kubeCluster, clusterState)
+		if err == nil {
+			err = kubeCluster.SaveCheckpoint(phaseCtx, clusterState, cluster.PhaseSaveState)
+		}
+		finish(err)
+		if err != nil {
+			return APIURL, caCrt, clientCert, clientKey, nil, err
+		}
 	}
 
-	errMsgMaxUnavailableNotFailedWrkr, err := kubeCluster.DeployWorkerPlane(ctx, svcOptionsData, reconcileCluster)
-	if err != nil {
-		return APIURL, caCrt, clientCert, clientKey, nil, err
+	if !kubeCluster.Checkpoint.IsComplete(cluster.PhaseWorkerPlane) {
+		phaseCtx, finish = log.StartEvent(ctx, log.Event{Phase: cluster.PhaseWorkerPlane})
+		errMsgMaxUnavailableNotFailedWrkr, err = kubeCluster.DeployWorkerPlane(phaseCtx, svcOptionsData, reconcileCluster)
+		// record the hosts that made it through the worker plane, even if some of the others failed
+		if checkpointErr := kubeCluster.SaveWorkerCheckpoint(phaseCtx, clusterState); checkpointErr != nil {
+			logrus.Warnf("[checkpoint] Failed to save worker hosts checkpoint: %v", checkpointErr)
+		}
+		if err == nil {
+			err = kubeCluster.SaveCheckpoint(phaseCtx, clusterState, cluster.PhaseWorkerPlane)
+		}
+		finish(err)
+		if err != nil {
+			return APIURL, caCrt, clientCert, clientKey, nil, err
+		}
 	}
 
 	if err = kubeCluster.CleanDeadLogs(ctx); err != nil {
@@ -229,9 +287,16 @@ func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags c
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
 
-	err = cluster.ConfigureCluster(ctx, kubeCluster.RancherKubernetesEngineConfig, kubeCluster.Certificates, flags, dialersOptions, data, false)
-	if err != nil {
-		return APIURL, caCrt, clientCert, clientKey, nil, err
+	if !kubeCluster.Checkpoint.IsComplete(cluster.PhaseAddons) {
+		phaseCtx, finish = log.StartEvent(ctx, log.Event{Phase: cluster.PhaseAddons})
+		err = cluster.ConfigureCluster(phaseCtx, kubeCluster.RancherKubernetesEngineConfig, kubeCluster.Certificates, flags, dialersOptions, data, false)
+		if err == nil {
+			err = kubeCluster.SaveCheckpoint(phaseCtx, clusterState, cluster.PhaseAddons)
+		}
+		finish(err)
+		if err != nil {
+			return APIURL, caCrt, clientCert, clientKey, nil, err
+		}
 	}
 	if kubeCluster.EncryptionConfig.RewriteSecrets {
 		if err = kubeCluster.RewriteSecrets(ctx); err != nil {
@@ -246,6 +311,9 @@ func ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags c
 	if errMsgMaxUnavailableNotFailedCtrl != "" || errMsgMaxUnavailableNotFailedWrkr != "" {
 		return APIURL, caCrt, clientCert, clientKey, nil, fmt.Errorf(errMsgMaxUnavailableNotFailedCtrl + errMsgMaxUnavailableNotFailedWrkr)
 	}
+	if err = kubeCluster.ClearCheckpoint(ctx, clusterState); err != nil {
+		return APIURL, caCrt, clientCert, clientKey, nil, err
+	}
 	log.Infof(ctx, "Finished building Kubernetes cluster successfully")
 	return APIURL, caCrt, clientCert, clientKey, kubeCluster.Certificates, nil
 }
@@ -267,12 +335,16 @@ func checkAllIncluded(cluster *cluster.Cluster) error {
 }
 
 func clusterUpFromCli(ctx *cli.Context) error {
+	outputCtx, err := getOutputContext(ctx)
+	if err != nil {
+		return err
+	}
 	logrus.Infof("Running RKE version: %v", ctx.App.Version)
 	if ctx.Bool("local") {
-		return clusterUpLocal(ctx)
+		return clusterUpLocal(outputCtx, ctx)
 	}
 	if ctx.Bool("dind") {
-		return clusterUpDind(ctx)
+		return clusterUpDind(outputCtx, ctx)
 	}
 	clusterFile, filePath, err := resolveClusterFile(ctx)
 	if err != nil {
@@ -295,22 +367,28 @@ func clusterUpFromCli(ctx *cli.Context) error {
 	// Custom certificates and certificate dir flags
 	flags.CertificateDir = ctx.String("cert-dir")
 	flags.CustomCerts = ctx.Bool("custom-certs")
+	flags.Resume = ctx.Bool("resume")
+	unlock, err := lockStateFile(outputCtx, ctx, flags, "up")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	if ctx.Bool("init") {
-		return ClusterInit(context.Background(), rkeConfig, hosts.DialersOptions{}, flags)
+		return ClusterInit(outputCtx, rkeConfig, hosts.DialersOptions{}, flags)
 	}
-	if err := ClusterInit(context.Background(), rkeConfig, hosts.DialersOptions{}, flags); err != nil {
+	if err := ClusterInit(outputCtx, rkeConfig, hosts.DialersOptions{}, flags); err != nil {
 		return err
 	}
 
-	_, _, _, _, _, err = ClusterUp(context.Background(), hosts.DialersOptions{}, flags, map[string]interface{}{})
+	_, _, _, _, _, err = ClusterUp(outputCtx, hosts.DialersOptions{}, flags, map[string]interface{}{})
 	return err
 }
 
-func clusterUpLocal(ctx *cli.Context) error {
+func clusterUpLocal(outputCtx context.Context, ctx *cli.Context) error {
 	var rkeConfig *v3.RancherKubernetesEngineConfig
 	clusterFile, filePath, err := resolveClusterFile(ctx)
 	if err != nil {
-		log.Infof(context.Background(), "Failed to resolve cluster file, using default cluster instead")
+		log.Infof(outputCtx, "Failed to resolve cluster file, using default cluster instead")
 		rkeConfig = cluster.GetLocalRKEConfig()
 	} else {
 		rkeConfig, err = cluster.ParseConfig(clusterFile)
@@ -327,25 +405,31 @@ func clusterUpLocal(ctx *cli.Context) error {
 	dialers := hosts.GetDialerOptions(nil, hosts.LocalHealthcheckFactory, nil)
 	// setting up the flags
 	flags := cluster.GetExternalFlags(true, false, false, false, "", filePath)
+	flags.Resume = ctx.Bool("resume")
+	unlock, err := lockStateFile(outputCtx, ctx, flags, "up")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	if ctx.Bool("init") {
-		return ClusterInit(context.Background(), rkeConfig, dialers, flags)
+		return ClusterInit(outputCtx, rkeConfig, dialers, flags)
 	}
-	if err := ClusterInit(context.Background(), rkeConfig, dialers, flags); err != nil {
+	if err := ClusterInit(outputCtx, rkeConfig, dialers, flags); err != nil {
 		return err
 	}
-	_, _, _, _, _, err = ClusterUp(context.Background(), dialers, flags, map[string]interface{}{})
+	_, _, _, _, _, err = ClusterUp(outputCtx, dialers, flags, map[string]interface{}{})
 	return err
 }
 
-func clusterUpDind(ctx *cli.Context) error {
+func clusterUpDind(outputCtx context.Context, ctx *cli.Context) error {
 	// get dind config
 	rkeConfig, disablePortCheck, dindStorageDriver, filePath, dindDNS, err := getDindConfig(ctx)
 	if err != nil {
 		return err
 	}
 	// setup dind environment
-	if err = createDINDEnv(context.Background(), rkeConfig, dindStorageDriver, dindDNS); err != nil {
+	if err = createDINDEnv(outputCtx, rkeConfig, dindStorageDriver, dindDNS); err != nil {
 		return err
 	}
 
@@ -354,15 +438,21 @@ func clusterUpDind(ctx *cli.Context) error {
 	// setting up flags
 	flags := cluster.GetExternalFlags(false, false, disablePortCheck, false, "", filePath)
 	flags.DinD = true
+	flags.Resume = ctx.Bool("resume")
+	unlock, err := lockStateFile(outputCtx, ctx, flags, "up")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	if ctx.Bool("init") {
-		return ClusterInit(context.Background(), rkeConfig, dialers, flags)
+		return ClusterInit(outputCtx, rkeConfig, dialers, flags)
 	}
-	if err := ClusterInit(context.Background(), rkeConfig, dialers, flags); err != nil {
+	if err := ClusterInit(outputCtx, rkeConfig, dialers, flags); err != nil {
 		return err
 	}
 	// start cluster
-	_, _, _, _, _, err = ClusterUp(context.Background(), dialers, flags, map[string]interface{}{})
+	_, _, _, _, _, err = ClusterUp(outputCtx, dialers, flags, map[string]interface{}{})
 	return err
 }
 
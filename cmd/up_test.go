@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/docker/dockertest"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/k8s/k8stest"
+	"github.com/rancher/rke/metadata"
+	"github.com/rancher/rke/pki"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// testCluster runs rke against fake hosts: the Docker API of the hosts is served by in-memory runtimes, the ports of
+// the hosts by a local server answering the health checks and the Kubernetes API by an in-memory API server
+type testCluster struct {
+	dir       string
+	eventLog  *dockertest.EventLog
+	apiServer *k8stest.APIServer
+	server    *httptest.Server
+
+	mu       sync.Mutex
+	runtimes map[string]*dockertest.Runtime
+}
+
+func newTestCluster(t *testing.T, k8sVersion string, hostnames ...string) *testCluster {
+	assert.Nil(t, metadata.InitMetadata(context.Background()))
+	c := &testCluster{
+		dir:       t.TempDir(),
+		eventLog:  &dockertest.EventLog{},
+		apiServer: k8stest.NewAPIServer(k8sVersion),
+		runtimes:  map[string]*dockertest.Runtime{},
+	}
+	// the kubelets register the nodes
+	for _, hostname := range hostnames {
+		assert.Nil(t, c.apiServer.AddReadyNode(hostname))
+	}
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey("localhost", nil, nil)
+	assert.Nil(t, err)
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.Nil(t, err)
+	c.server = httptest.NewUnstartedServer(http.HandlerFunc(serveHostPort))
+	c.server.Listener = &sniffingListener{Listener: c.server.Listener, config: &tls.Config{Certificates: []tls.Certificate{certificate}}}
+	c.server.Start()
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+// serveHostPort answers the health checks of the Kubernetes components and of etcd
+func serveHostPort(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/healthz":
+		fmt.Fprint(w, "ok")
+	case "/health":
+		fmt.Fprint(w, `{"health":"true"}`)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// sniffingListener serves TLS and plain HTTP on the same port, like the ports of the different components
+type sniffingListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (l *sniffingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	sniffed := &sniffedConn{Conn: conn, reader: bufio.NewReader(conn)}
+	// a TLS connection starts with a handshake record
+	if first, err := sniffed.reader.Peek(1); err == nil && first[0] == 0x16 {
+		return tls.Server(sniffed, l.config), nil
+	}
+	return sniffed, nil
+}
+
+func (c *testCluster) runtime(h *hosts.Host) (docker.ContainerRuntime, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.runtimes[h.Address]
+	if !ok {
+		r = dockertest.NewRuntime(h.HostnameOverride, c.eventLog)
+		c.runtimes[h.Address] = r
+	}
+	return r, nil
+}
+
+func (c *testCluster) dialersOptions() hosts.DialersOptions {
+	return hosts.DialersOptions{
+		RuntimeFactory: c.runtime,
+		LocalConnDialerFactory: func(h *hosts.Host) (func(network, address string) (net.Conn, error), error) {
+			return func(network, address string) (net.Conn, error) {
+				return net.Dial("tcp", c.server.Listener.Addr().String())
+			}, nil
+		},
+		K8sWrapTransport: c.apiServer.WrapTransport,
+	}
+}
+
+// up runs rke up with the cluster file
+func (c *testCluster) up(t *testing.T, clusterFile string) error {
+	return c.upWithContext(context.Background(), t, clusterFile)
+}
+
+func (c *testCluster) upWithContext(ctx context.Context, t *testing.T, clusterFile string) error {
+	filePath := filepath.Join(c.dir, "cluster.yml")
+	assert.Nil(t, ioutil.WriteFile(filePath, []byte(clusterFile), 0600))
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return err
+	}
+	flags := cluster.GetExternalFlags(false, false, true, false, "", filePath)
+	if err := ClusterInit(ctx, rkeConfig, c.dialersOptions(), flags); err != nil {
+		return err
+	}
+	_, _, _, _, _, err = ClusterUp(ctx, c.dialersOptions(), flags, map[string]interface{}{})
+	return err
+}
+
+// upgradeEvents returns the index of the first and the last event replacing the containers on the host
+func upgradeEvents(events []string, hostname string, containers ...string) (int, int) {
+	first, last := -1, -1
+	for i, event := range events {
+		for _, name := range containers {
+			if event != fmt.Sprintf("%s stop %s", hostname, name) && event != fmt.Sprintf("%s remove old-%s", hostname, name) {
+				continue
+			}
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	return first, last
+}
+
+func eventIndex(events []string, event string) int {
+	for i, e := range events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+// clusterFile returns the cluster file of a cluster with node1 as etcd and control plane host, the address of the
+// workers is 10.0.0.<number of the node>
+func clusterFile(k8sVersion string, workers ...string) string {
+	nodes := "- address: 10.0.0.1\n  hostname_override: node1\n  user: rke\n  role: [etcd, controlplane]\n"
+	for _, worker := range workers {
+		nodes += fmt.Sprintf("- address: 10.0.0.%s\n  hostname_override: %s\n  user: rke\n  role: [worker]\n", strings.TrimPrefix(worker, "node"), worker)
+	}
+	return fmt.Sprintf("kubernetes_version: %s\nupgrade_strategy:\n  max_unavailable_worker: 1\nnodes:\n%s", k8sVersion, nodes)
+}
+
+func TestClusterUp(t *testing.T) {
+	c := newTestCluster(t, "v1.20.7", "node1", "node2", "node3")
+	if !assert.Nil(t, c.up(t, clusterFile("v1.20.7-rancher1-1", "node2", "node3"))) {
+		return
+	}
+	assert.Equal(t, []string{pki.StateDeployerContainerName, "etcd", "etcd-rolling-snapshots", "kube-apiserver", "kube-controller-manager", "kube-proxy", "kube-scheduler", "kubelet", "service-sidekick"}, c.runtimes["10.0.0.1"].ContainerNames())
+	assert.Equal(t, []string{pki.StateDeployerContainerName, "kube-proxy", "kubelet", "nginx-proxy", "service-sidekick"}, c.runtimes["10.0.0.2"].ContainerNames())
+	for _, r := range c.runtimes {
+		kubelet, _ := r.Container("kubelet")
+		assert.True(t, kubelet.Running, r.Hostname)
+	}
+	// the planes are deployed in order
+	events := c.eventLog.Events()
+	assert.Less(t, eventIndex(events, "node1 start etcd"), eventIndex(events, "node1 start kube-apiserver"))
+	assert.Less(t, eventIndex(events, "node1 start kube-apiserver"), eventIndex(events, "node2 start kubelet"))
+
+	clusterState, err := cluster.ReadStateFile(context.Background(), filepath.Join(c.dir, "cluster.rkestate"))
+	assert.Nil(t, err)
+	assert.Len(t, clusterState.CurrentState.RancherKubernetesEngineConfig.Nodes, 3)
+	assert.FileExists(t, filepath.Join(c.dir, "kube_config_cluster.yml"))
+}
+
+func TestClusterUpJSONOutput(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	assert.Nil(t, err)
+	stderrReader, stderrWriter, err := os.Pipe()
+	assert.Nil(t, err)
+	os.Stdout, os.Stderr = stdoutWriter, stderrWriter
+	logrus.SetOutput(os.Stdout)
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		logrus.SetOutput(os.Stderr)
+	}()
+	readAll := func(r io.Reader) <-chan []byte {
+		ch := make(chan []byte, 1)
+		go func() {
+			b, _ := ioutil.ReadAll(r)
+			ch <- b
+		}()
+		return ch
+	}
+	stdoutCh, stderrCh := readAll(stdoutReader), readAll(stderrReader)
+
+	set := flag.NewFlagSet("up", flag.ContinueOnError)
+	set.String("output", outputJSON, "")
+	ctx, err := getOutputContext(cli.NewContext(nil, set, nil))
+	assert.Nil(t, err)
+	logrus.Infof("Running RKE version: %v", "dev")
+	c := newTestCluster(t, "v1.20.7", "node1", "node2")
+	assert.Nil(t, c.upWithContext(ctx, t, clusterFile("v1.20.7-rancher1-1", "node2")))
+	stdoutWriter.Close()
+	stderrWriter.Close()
+
+	// every line of stdout is a JSON object, the messages logged with logrus are on stderr
+	lines := strings.Split(strings.TrimSpace(string(<-stdoutCh)), "\n")
+	assert.NotEmpty(t, lines)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry), line)
+	}
+	assert.Contains(t, string(<-stderrCh), "Running RKE version: dev")
+}
+
+func TestClusterUpgrade(t *testing.T) {
+	c := newTestCluster(t, "v1.20.7", "node1", "node2", "node3")
+	if !assert.Nil(t, c.up(t, clusterFile("v1.20.7-rancher1-1", "node2", "node3"))) {
+		return
+	}
+	upgradeStart := len(c.eventLog.Events())
+	if !assert.Nil(t, c.up(t, clusterFile("v1.21.1-rancher2-1", "node2", "node3"))) {
+		return
+	}
+	events := c.eventLog.Events()[upgradeStart:]
+
+	// etcd, then the control plane, then the workers
+	_, etcdDone := upgradeEvents(events, "node1", "etcd")
+	controlStart, controlDone := upgradeEvents(events, "node1", "kube-apiserver", "kube-controller-manager", "kube-scheduler")
+	node2Start, node2Done := upgradeEvents(events, "node2", "kubelet", "kube-proxy")
+	node3Start, node3Done := upgradeEvents(events, "node3", "kubelet", "kube-proxy")
+	assert.NotEqual(t, -1, etcdDone)
+	assert.Less(t, etcdDone, controlStart)
+	assert.Less(t, controlDone, node2Start)
+	assert.Less(t, controlDone, node3Start)
+	// one worker is unavailable at a time
+	assert.True(t, node2Done < node3Start || node3Done < node2Start, "workers upgraded at the same time: %v", events)
+
+	for _, r := range c.runtimes {
+		kubelet, ok := r.Container("kubelet")
+		assert.True(t, ok)
+		assert.Contains(t, kubelet.Config.Image, "v1.21.1-rancher2")
+	}
+	// the workers are uncordoned once upgraded
+	for _, hostname := range []string{"node2", "node3"} {
+		node, err := c.apiServer.Node(hostname)
+		assert.Nil(t, err)
+		assert.False(t, node.Spec.Unschedulable)
+	}
+}
+
+func TestClusterReconcile(t *testing.T) {
+	c := newTestCluster(t, "v1.20.7", "node1", "node2", "node3", "node4")
+	if !assert.Nil(t, c.up(t, clusterFile("v1.20.7-rancher1-1", "node2", "node3"))) {
+		return
+	}
+	addStart := len(c.eventLog.Events())
+	if !assert.Nil(t, c.up(t, clusterFile("v1.20.7-rancher1-1", "node2", "node3", "node4"))) {
+		return
+	}
+	events := c.eventLog.Events()[addStart:]
+	assert.Contains(t, events, "node4 start kubelet")
+	// the existing hosts are left as is
+	assert.NotContains(t, events, "node2 stop kubelet")
+	assert.NotContains(t, events, "node1 stop kube-apiserver")
+
+	if !assert.Nil(t, c.up(t, clusterFile("v1.20.7-rancher1-1", "node2", "node4"))) {
+		return
+	}
+	assert.Equal(t, []string{pki.StateDeployerContainerName}, c.runtimes["10.0.0.3"].ContainerNames())
+	_, err := c.apiServer.Node("node3")
+	assert.True(t, apierrors.IsNotFound(err))
+	clusterState, err := cluster.ReadStateFile(context.Background(), filepath.Join(c.dir, "cluster.rkestate"))
+	assert.Nil(t, err)
+	var hostnames []string
+	for _, node := range clusterState.CurrentState.RancherKubernetesEngineConfig.Nodes {
+		hostnames = append(hostnames, node.HostnameOverride)
+	}
+	assert.Equal(t, []string{"node1", "node2", "node4"}, hostnames)
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/metadata"
+	"github.com/rancher/rke/pki"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	// upgradeCheckErrorExitCode is returned when the upgrade is expected to fail
+	upgradeCheckErrorExitCode = 2
+	// upgradeCheckWarningExitCode is returned when the upgrade has only warnings
+	upgradeCheckWarningExitCode = 3
+)
+
+func UpgradeCheckCommand() cli.Command {
+	upgradeCheckFlags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Specify an alternate cluster YAML file",
+			Value:  pki.ClusterConfig,
+			EnvVar: "RKE_CONFIG",
+		},
+		cli.BoolFlag{
+			Name:  "skip-hosts",
+			Usage: "Don't connect to the hosts, the Docker versions of the hosts are not checked",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "Output format of the report (text, json)",
+			Value: outputText,
+		},
+	}
+	upgradeCheckFlags = append(upgradeCheckFlags, commonFlags...)
+
+	return cli.Command{
+		Name: "upgrade-check",
+		Usage: fmt.Sprintf("Check the upgrade from the Kubernetes version in the state file to the version in the cluster file, exits with %d if the upgrade is expected to fail and with %d if there are only warnings",
+			upgradeCheckErrorExitCode, upgradeCheckWarningExitCode),
+		Action: upgradeCheckFromCli,
+		Flags:  upgradeCheckFlags,
+	}
+}
+
+func UpgradeCheck(
+	ctx context.Context,
+	rkeConfig *v3.RancherKubernetesEngineConfig,
+	dialersOptions hosts.DialersOptions,
+	flags cluster.ExternalFlags,
+	skipHosts bool) (*cluster.UpgradeCheckReport, error) {
+	defer hosts.UseSSHConnPool()()
+
+	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
+	clusterState, err := cluster.ReadStateFile(ctx, stateFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if clusterState.CurrentState.RancherKubernetesEngineConfig == nil {
+		return nil, fmt.Errorf("No current cluster state found in [%s], the cluster must be provisioned with rke up first", stateFilePath)
+	}
+
+	if metadata.K8sVersionToRKESystemImages == nil {
+		if err := metadata.InitMetadata(ctx); err != nil {
+			return nil, err
+		}
+	}
+	currentConfig := clusterState.CurrentState.RancherKubernetesEngineConfig
+	report, err := cluster.CheckUpgrade(currentConfig, rkeConfig, nil, nil)
+	// the cluster can't be initialized with a version this RKE doesn't support
+	if err != nil || skipHosts || report.HasVersionErrors() {
+		return report, err
+	}
+
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig.DeepCopy(), flags, "")
+	if err != nil {
+		return nil, err
+	}
+	log.Infof(ctx, "Retrieving Docker info of the cluster hosts")
+	// the Docker versions are part of the report, they must not fail the tunnel
+	for _, host := range hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts) {
+		host.IgnoreDockerVersion = true
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return nil, err
+	}
+	// the hosts that can't be reached are part of the report
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		log.Warnf(ctx, "%v", err)
+	}
+	clusterHosts := hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts)
+	return cluster.CheckUpgrade(currentConfig, rkeConfig, clusterHosts, kubeCluster.InactiveHosts)
+}
+
+func upgradeCheckFromCli(ctx *cli.Context) error {
+	output := ctx.String("output")
+	switch output {
+	case outputText:
+	case outputJSON:
+		// keep stdout for the report
+		logrus.SetOutput(os.Stderr)
+	default:
+		return fmt.Errorf("Unsupported output format [%s], supported formats are [%s, %s]", output, outputText, outputJSON)
+	}
+	logrus.Infof("Running RKE version: %v", ctx.App.Version)
+	clusterFile, filePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve cluster file: %v", err)
+	}
+
+	rkeConfig, err := cluster.ParseConfig(clusterFile)
+	if err != nil {
+		return fmt.Errorf("Failed to parse cluster file: %v", err)
+	}
+
+	rkeConfig, err = setOptionsFromCLI(ctx, rkeConfig)
+	if err != nil {
+		return err
+	}
+	// setting up the flags
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+
+	report, err := UpgradeCheck(context.Background(), rkeConfig, hosts.DialersOptions{}, flags, ctx.Bool("skip-hosts"))
+	if err != nil {
+		return err
+	}
+	if output == outputJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printUpgradeCheckReport(report)
+	}
+	if report.HasErrors() {
+		return cli.NewExitError("", upgradeCheckErrorExitCode)
+	}
+	if report.HasWarnings() {
+		return cli.NewExitError("", upgradeCheckWarningExitCode)
+	}
+	return nil
+}
+
+func printUpgradeCheckReport(report *cluster.UpgradeCheckReport) {
+	fmt.Printf("Current version: %s\nTarget version:  %s\n\n", report.CurrentVersion, report.TargetVersion)
+	if len(report.Results) == 0 {
+		fmt.Println("No upgrade issues found.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tCHECK\tHOST\tMESSAGE")
+	for _, result := range report.Results {
+		host := result.Host
+		if host == "" {
+			host = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Severity, result.Check, host, result.Message)
+	}
+	w.Flush()
+}
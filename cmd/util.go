@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"github.com/rancher/rke/cluster"
@@ -25,6 +24,7 @@ func UtilCommand() cli.Command {
 		},
 	}
 	utilFlags := append(utilCfgFlags, commonFlags...)
+	stateFileFlags := append(utilCfgFlags, stateLockFlags...)
 
 	return cli.Command{
 		Name:  "util",
@@ -34,7 +34,7 @@ func UtilCommand() cli.Command {
 				Name:   "get-state-file",
 				Usage:  "Retrieve state file from cluster",
 				Action: getStateFile,
-				Flags:  utilFlags,
+				Flags:  append(utilFlags, stateLockFlags...),
 			},
 			cli.Command{
 				Name:   "get-kubeconfig",
@@ -42,6 +42,33 @@ func UtilCommand() cli.Command {
 				Action: getKubeconfigFile,
 				Flags:  utilFlags,
 			},
+			cli.Command{
+				Name:   "encrypt-state",
+				Usage:  "Encrypt the state file with the key from " + cluster.StateEncryptionKeyEnv + ", " + cluster.StateEncryptionKeyFileEnv + " or " + cluster.StateEncryptionKeyCommandEnv,
+				Action: encryptStateFile,
+				Flags:  stateFileFlags,
+			},
+			cli.Command{
+				Name:   "accept-ssh-host-key",
+				Usage:  "Accept a new SSH host key of a host whose key is recorded in the state file",
+				Action: acceptSSHHostKey,
+				Flags: append(stateFileFlags,
+					cli.StringFlag{
+						Name:  "address",
+						Usage: "Address of the host, with the port if it isn't 22",
+					},
+					cli.StringFlag{
+						Name:  "fingerprint",
+						Usage: "SHA256 fingerprint of the new host key, the key of the next connection is trusted if it's not set",
+					},
+				),
+			},
+			cli.Command{
+				Name:   "decrypt-state",
+				Usage:  "Decrypt the state file with the key from " + cluster.StateEncryptionKeyEnv + ", " + cluster.StateEncryptionKeyFileEnv + " or " + cluster.StateEncryptionKeyCommandEnv,
+				Action: decryptStateFile,
+				Flags:  stateFileFlags,
+			},
 		},
 	}
 }
@@ -97,6 +124,11 @@ func getStateFile(ctx *cli.Context) error {
 	}
 	// setting up the flags
 	flags := cluster.GetExternalFlags(false, false, false, false, "", clusterFilePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "util get-state-file")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// not going to use a k8s dialer here.. this is a CLI command
 	serverVersion, err := cluster.GetK8sVersion(localKubeConfig, nil)
@@ -132,14 +164,14 @@ func getStateFile(ctx *cli.Context) error {
 		return err
 	}
 	clusterData := cfgMap.Data[cluster.FullStateConfigMapName]
-	rkeFullState := &cluster.FullState{}
-	if err = json.Unmarshal([]byte(clusterData), rkeFullState); err != nil {
+	rkeFullState, err := cluster.StringToFullState(context.Background(), clusterData)
+	if err != nil {
 		return err
 	}
 
 	// Move current state file
 	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
-	err = util.ReplaceFileWithBackup(stateFilePath, "rkestate")
+	err = cluster.BackupStateFile(context.Background(), stateFilePath)
 	if err != nil {
 		return err
 	}
@@ -159,6 +191,7 @@ func RetrieveClusterStateConfigMap(
 	dialersOptions hosts.DialersOptions,
 	flags cluster.ExternalFlags,
 	data map[string]interface{}) (string, string, string, string, map[string]pki.CertificatePKI, error) {
+	defer hosts.UseSSHConnPool()()
 	var APIURL, caCrt, clientCert, clientKey string
 
 	rkeFullState := &cluster.FullState{}
@@ -188,7 +221,7 @@ func RetrieveClusterStateConfigMap(
 
 	// Move current state file
 	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
-	err = util.ReplaceFileWithBackup(stateFilePath, "rkestate")
+	err = cluster.BackupStateFile(ctx, stateFilePath)
 	if err != nil {
 		return APIURL, caCrt, clientCert, clientKey, nil, err
 	}
@@ -211,3 +244,49 @@ func RetrieveClusterStateConfigMap(
 
 	return APIURL, caCrt, clientCert, clientKey, nil, nil
 }
+
+func encryptStateFile(ctx *cli.Context) error {
+	_, clusterFilePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve cluster file: %v", err)
+	}
+	flags := cluster.GetExternalFlags(false, false, false, false, "", clusterFilePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "util encrypt-state")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return cluster.EncryptStateFile(context.Background(), cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+}
+
+func decryptStateFile(ctx *cli.Context) error {
+	_, clusterFilePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve cluster file: %v", err)
+	}
+	flags := cluster.GetExternalFlags(false, false, false, false, "", clusterFilePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "util decrypt-state")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return cluster.DecryptStateFile(context.Background(), cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir))
+}
+
+func acceptSSHHostKey(ctx *cli.Context) error {
+	address := ctx.String("address")
+	if address == "" {
+		return fmt.Errorf("--address is required")
+	}
+	_, clusterFilePath, err := resolveClusterFile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve cluster file: %v", err)
+	}
+	flags := cluster.GetExternalFlags(false, false, false, false, "", clusterFilePath)
+	unlock, err := lockStateFile(context.Background(), ctx, flags, "util accept-ssh-host-key")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return cluster.AcceptSSHHostKey(context.Background(), cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir), address, ctx.String("fingerprint"))
+}
@@ -49,7 +49,15 @@ type dockerConfig struct {
 
 type authConfig types.AuthConfig
 
-func DoRunContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig,
+func DoRunContainer(ctx context.Context, dClient ContainerRuntime, imageCfg *container.Config, hostCfg *container.HostConfig,
+	containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry) error {
+	ctx, finish := log.StartEvent(ctx, log.Event{Phase: plane, Host: hostname, Container: containerName})
+	err := doRunContainer(ctx, dClient, imageCfg, hostCfg, containerName, hostname, plane, prsMap)
+	finish(err)
+	return err
+}
+
+func doRunContainer(ctx context.Context, dClient ContainerRuntime, imageCfg *container.Config, hostCfg *container.HostConfig,
 	containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry) error {
 	if dClient == nil {
 		return fmt.Errorf("[%s] Failed to run container: docker client is nil for container [%s] on host [%s]",
@@ -103,7 +111,8 @@ func DoRunContainer(ctx context.Context, dClient *client.Client, imageCfg *conta
 	return nil
 }
 
-func DoRunOnetimeContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry) error {
+func DoRunOnetimeContainer(ctx context.Context, dClient ContainerRuntime, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string, prsMap map[string]v3.PrivateRegistry) error {
+	ctx = log.WithFields(ctx, log.Fields{Phase: plane, Host: hostname, Container: containerName})
 	if dClient == nil {
 		return fmt.Errorf("[%s] Failed to run container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
 	}
@@ -142,7 +151,7 @@ func DoRunOnetimeContainer(ctx context.Context, dClient *client.Client, imageCfg
 	return err
 }
 
-func DoCopyToContainer(ctx context.Context, dClient *client.Client, plane, containerName, hostname, destinationDir string, tarFile io.Reader) error {
+func DoCopyToContainer(ctx context.Context, dClient ContainerRuntime, plane, containerName, hostname, destinationDir string, tarFile io.Reader) error {
 	if dClient == nil {
 		return fmt.Errorf("[%s] Failed to run container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
 	}
@@ -159,7 +168,8 @@ func DoCopyToContainer(ctx context.Context, dClient *client.Client, plane, conta
 	return nil
 }
 
-func DoRollingUpdateContainer(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName, hostname, plane string, prsMap map[string]v3.PrivateRegistry) error {
+func DoRollingUpdateContainer(ctx context.Context, dClient ContainerRuntime, imageCfg *container.Config, hostCfg *container.HostConfig, containerName, hostname, plane string, prsMap map[string]v3.PrivateRegistry) error {
+	ctx = log.WithFields(ctx, log.Fields{Phase: plane, Host: hostname, Container: containerName})
 	if dClient == nil {
 		return fmt.Errorf("[%s] Failed rolling update of container: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
 	}
@@ -195,7 +205,8 @@ func DoRollingUpdateContainer(ctx context.Context, dClient *client.Client, image
 	return err
 }
 
-func DoRemoveContainer(ctx context.Context, dClient *client.Client, containerName, hostname string) error {
+func DoRemoveContainer(ctx context.Context, dClient ContainerRuntime, containerName, hostname string) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: hostname, Container: containerName})
 	if dClient == nil {
 		return fmt.Errorf("Failed to remove container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -217,7 +228,7 @@ func DoRemoveContainer(ctx context.Context, dClient *client.Client, containerNam
 	return nil
 }
 
-func IsContainerRunning(ctx context.Context, dClient *client.Client, hostname string, containerName string, all bool) (bool, error) {
+func IsContainerRunning(ctx context.Context, dClient ContainerRuntime, hostname string, containerName string, all bool) (bool, error) {
 	if dClient == nil {
 		return false, fmt.Errorf("Failed to check if container is running: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -243,7 +254,7 @@ func IsContainerRunning(ctx context.Context, dClient *client.Client, hostname st
 	return false, nil
 }
 
-func localImageExists(ctx context.Context, dClient *client.Client, hostname string, containerImage string) error {
+func localImageExists(ctx context.Context, dClient ContainerRuntime, hostname string, containerImage string) error {
 	var err error
 	for i := 1; i <= RetryCount; i++ {
 		logrus.Debugf("Checking if image [%s] exists on host [%s], try #%d", containerImage, hostname, i)
@@ -262,7 +273,7 @@ func localImageExists(ctx context.Context, dClient *client.Client, hostname stri
 	return fmt.Errorf("Error checking if image [%s] exists on host [%s]: %v", containerImage, hostname, err)
 }
 
-func pullImage(ctx context.Context, dClient *client.Client, hostname string, containerImage string,
+func pullImage(ctx context.Context, dClient ContainerRuntime, hostname string, containerImage string,
 	prsMap map[string]v3.PrivateRegistry) error {
 	var out io.ReadCloser
 	var err error
@@ -297,7 +308,7 @@ func pullImage(ctx context.Context, dClient *client.Client, hostname string, con
 	return err
 }
 
-func UseLocalOrPull(ctx context.Context, dClient *client.Client, hostname string, containerImage string, plane string,
+func UseLocalOrPull(ctx context.Context, dClient ContainerRuntime, hostname string, containerImage string, plane string,
 	prsMap map[string]v3.PrivateRegistry) error {
 	if dClient == nil {
 		return fmt.Errorf("[%s] Failed to use local image or pull: docker client is nil for container [%s] on host [%s]", plane, containerImage, hostname)
@@ -337,7 +348,7 @@ func UseLocalOrPull(ctx context.Context, dClient *client.Client, hostname string
 	return err
 }
 
-func RemoveContainer(ctx context.Context, dClient *client.Client, hostname string, containerName string) error {
+func RemoveContainer(ctx context.Context, dClient ContainerRuntime, hostname string, containerName string) error {
 	if dClient == nil {
 		return fmt.Errorf("Failed to remove container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -355,7 +366,7 @@ func RemoveContainer(ctx context.Context, dClient *client.Client, hostname strin
 	return err
 }
 
-func RestartContainer(ctx context.Context, dClient *client.Client, hostname, containerName string) error {
+func RestartContainer(ctx context.Context, dClient ContainerRuntime, hostname, containerName string) error {
 	if dClient == nil {
 		return fmt.Errorf("Failed to restart container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -373,7 +384,7 @@ func RestartContainer(ctx context.Context, dClient *client.Client, hostname, con
 	}
 	return err
 }
-func StopContainer(ctx context.Context, dClient *client.Client, hostname string, containerName string) error {
+func StopContainer(ctx context.Context, dClient ContainerRuntime, hostname string, containerName string) error {
 	if dClient == nil {
 		return fmt.Errorf("Failed to stop container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -393,7 +404,7 @@ func StopContainer(ctx context.Context, dClient *client.Client, hostname string,
 	return err
 }
 
-func RenameContainer(ctx context.Context, dClient *client.Client, hostname string, oldContainerName string, newContainerName string) error {
+func RenameContainer(ctx context.Context, dClient ContainerRuntime, hostname string, oldContainerName string, newContainerName string) error {
 	if dClient == nil {
 		return fmt.Errorf("Failed to rename container: docker client is nil for container [%s] on host [%s]", oldContainerName, hostname)
 	}
@@ -411,7 +422,7 @@ func RenameContainer(ctx context.Context, dClient *client.Client, hostname strin
 	return err
 }
 
-func StartContainer(ctx context.Context, dClient *client.Client, hostname string, containerName string) error {
+func StartContainer(ctx context.Context, dClient ContainerRuntime, hostname string, containerName string) error {
 	if dClient == nil {
 		return fmt.Errorf("Failed to start container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -432,7 +443,7 @@ func StartContainer(ctx context.Context, dClient *client.Client, hostname string
 	return err
 }
 
-func CreateContainer(ctx context.Context, dClient *client.Client, hostname string, containerName string, imageCfg *container.Config, hostCfg *container.HostConfig) (container.ContainerCreateCreatedBody, error) {
+func CreateContainer(ctx context.Context, dClient ContainerRuntime, hostname string, containerName string, imageCfg *container.Config, hostCfg *container.HostConfig) (container.ContainerCreateCreatedBody, error) {
 	if dClient == nil {
 		return container.ContainerCreateCreatedBody{}, fmt.Errorf("Failed to create container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -450,7 +461,7 @@ func CreateContainer(ctx context.Context, dClient *client.Client, hostname strin
 	return container.ContainerCreateCreatedBody{}, fmt.Errorf("Failed to create Docker container [%s] on host [%s]: %v", containerName, hostname, err)
 }
 
-func InspectContainer(ctx context.Context, dClient *client.Client, hostname string, containerName string) (types.ContainerJSON, error) {
+func InspectContainer(ctx context.Context, dClient ContainerRuntime, hostname string, containerName string) (types.ContainerJSON, error) {
 	if dClient == nil {
 		return types.ContainerJSON{}, fmt.Errorf("Failed to inspect container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -471,7 +482,7 @@ func InspectContainer(ctx context.Context, dClient *client.Client, hostname stri
 	return types.ContainerJSON{}, fmt.Errorf("Failed to inspect Docker container [%s] on host [%s]: %v", containerName, hostname, err)
 }
 
-func StopRenameContainer(ctx context.Context, dClient *client.Client, hostname string, oldContainerName string, newContainerName string) error {
+func StopRenameContainer(ctx context.Context, dClient ContainerRuntime, hostname string, oldContainerName string, newContainerName string) error {
 	if dClient == nil {
 		return fmt.Errorf("Failed to stop and rename container: docker client is nil for container [%s] on host [%s]", oldContainerName, hostname)
 	}
@@ -495,7 +506,8 @@ func StopRenameContainer(ctx context.Context, dClient *client.Client, hostname s
 
 }
 
-func WaitForContainer(ctx context.Context, dClient *client.Client, hostname string, containerName string) (int64, error) {
+func WaitForContainer(ctx context.Context, dClient ContainerRuntime, hostname string, containerName string) (int64, error) {
+	ctx = log.WithFields(ctx, log.Fields{Host: hostname, Container: containerName})
 	if dClient == nil {
 		return 1, fmt.Errorf("Failed waiting for container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -531,7 +543,7 @@ func WaitForContainer(ctx context.Context, dClient *client.Client, hostname stri
 	return 1, fmt.Errorf("Container [%s] did not exit in time on host [%s]: stderr: [%s], stdout: [%s]", containerName, hostname, stderr, stdout)
 }
 
-func IsContainerUpgradable(ctx context.Context, dClient *client.Client, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string) (bool, error) {
+func IsContainerUpgradable(ctx context.Context, dClient ContainerRuntime, imageCfg *container.Config, hostCfg *container.HostConfig, containerName string, hostname string, plane string) (bool, error) {
 	if dClient == nil {
 		return false, fmt.Errorf("[%s] Failed checking if container is upgradable: docker client is nil for container [%s] on host [%s]", plane, containerName, hostname)
 	}
@@ -556,7 +568,8 @@ func IsContainerUpgradable(ctx context.Context, dClient *client.Client, imageCfg
 		!sliceEqualsIgnoreOrder(containerInspect.Config.Cmd, imageCfg.Cmd) ||
 		!isContainerEnvChanged(containerInspect.Config.Env, imageCfg.Env, imageInspect.Config.Env) ||
 		!sliceEqualsIgnoreOrder(containerInspect.HostConfig.Binds, hostCfg.Binds) ||
-		!securityOptsliceEqualsIgnoreOrder(containerInspect.HostConfig.SecurityOpt, hostCfg.SecurityOpt) {
+		!securityOptsliceEqualsIgnoreOrder(containerInspect.HostConfig.SecurityOpt, hostCfg.SecurityOpt) ||
+		!resourcesEqual(containerInspect.HostConfig, hostCfg) {
 		logrus.Debugf("[%s] Container [%s] is eligible for upgrade on host [%s]", plane, containerName, hostname)
 		return true, nil
 	}
@@ -573,6 +586,28 @@ func sliceEqualsIgnoreOrder(left, right []string) bool {
 	return true
 }
 
+// resourcesEqual compares the resource limits and reservations set by rke, an unset pids limit is reported as nil or 0
+func resourcesEqual(current, desired *container.HostConfig) bool {
+	pidsLimit := func(limit *int64) int64 {
+		if limit == nil || *limit < 0 {
+			return 0
+		}
+		return *limit
+	}
+	if current.NanoCPUs != desired.NanoCPUs ||
+		current.CPUShares != desired.CPUShares ||
+		current.Memory != desired.Memory ||
+		current.MemoryReservation != desired.MemoryReservation ||
+		current.OomScoreAdj != desired.OomScoreAdj ||
+		pidsLimit(current.PidsLimit) != pidsLimit(desired.PidsLimit) {
+		logrus.Debugf("resources are not equal, old: cpus [%d] shares [%d] memory [%d] reservation [%d] oom score adj [%d] pids [%d], new: cpus [%d] shares [%d] memory [%d] reservation [%d] oom score adj [%d] pids [%d]",
+			current.NanoCPUs, current.CPUShares, current.Memory, current.MemoryReservation, current.OomScoreAdj, pidsLimit(current.PidsLimit),
+			desired.NanoCPUs, desired.CPUShares, desired.Memory, desired.MemoryReservation, desired.OomScoreAdj, pidsLimit(desired.PidsLimit))
+		return false
+	}
+	return true
+}
+
 func securityOptsliceEqualsIgnoreOrder(left, right []string) bool {
 	if equal := sets.NewString(left...).Equal(sets.NewString(right...)); !equal {
 		logrus.Debugf("slice is not equal, showing data in new value which is not in old value: %v", sets.NewString(right...).Difference(sets.NewString(left...)))
@@ -607,7 +642,7 @@ func IsSupportedDockerVersion(info types.Info, K8sVersion string) (bool, error)
 	return false, nil
 }
 
-func ReadFileFromContainer(ctx context.Context, dClient *client.Client, hostname, container, filePath string) (string, error) {
+func ReadFileFromContainer(ctx context.Context, dClient ContainerRuntime, hostname, container, filePath string) (string, error) {
 	if dClient == nil {
 		return "", fmt.Errorf("Failed reading file from container: docker client is nil for container [%s] on host [%s]", container, hostname)
 	}
@@ -627,7 +662,27 @@ func ReadFileFromContainer(ctx context.Context, dClient *client.Client, hostname
 	return string(file), nil
 }
 
-func ReadContainerLogs(ctx context.Context, dClient *client.Client, containerName string, follow bool, tail string) (io.ReadCloser, error) {
+// CopyFileFromContainer streams the content of filePath in the container to writer, it works on stopped containers too
+func CopyFileFromContainer(ctx context.Context, dClient ContainerRuntime, hostname, container, filePath string, writer io.Writer) error {
+	if dClient == nil {
+		return fmt.Errorf("Failed copying file from container: docker client is nil for container [%s] on host [%s]", container, hostname)
+	}
+	reader, _, err := dClient.CopyFromContainer(ctx, container, filePath)
+	if err != nil {
+		return fmt.Errorf("Failed to copy file [%s] from container [%s] on host [%s]: %v", filePath, container, hostname, err)
+	}
+	defer reader.Close()
+	tarReader := tar.NewReader(reader)
+	if _, err := tarReader.Next(); err != nil {
+		return fmt.Errorf("Failed to copy file [%s] from container [%s] on host [%s]: %v", filePath, container, hostname, err)
+	}
+	if _, err := io.Copy(writer, tarReader); err != nil {
+		return fmt.Errorf("Failed to copy file [%s] from container [%s] on host [%s]: %v", filePath, container, hostname, err)
+	}
+	return nil
+}
+
+func ReadContainerLogs(ctx context.Context, dClient ContainerRuntime, containerName string, follow bool, tail string) (io.ReadCloser, error) {
 	if dClient == nil {
 		return nil, fmt.Errorf("Failed reading container logs: docker client is nil for container [%s]", containerName)
 	}
@@ -644,7 +699,7 @@ func ReadContainerLogs(ctx context.Context, dClient *client.Client, containerNam
 	return nil, err
 }
 
-func GetContainerLogsStdoutStderr(ctx context.Context, dClient *client.Client, containerName, tail string, follow bool) (string, string, error) {
+func GetContainerLogsStdoutStderr(ctx context.Context, dClient ContainerRuntime, containerName, tail string, follow bool) (string, string, error) {
 	if dClient == nil {
 		return "", "", fmt.Errorf("Failed to get container logs stdout and stderr: docker client is nil for container [%s]", containerName)
 	}
@@ -673,7 +728,13 @@ func getRegistryAuth(pr v3.PrivateRegistry) (string, error) {
 	var authConfig types.AuthConfig
 	var err error
 	if len(pr.User) == 0 && len(pr.Password) == 0 && len(pr.CredentialPlugin) != 0 {
-		if regType, ok := pr.CredentialPlugin["type"]; ok {
+		if util.IsExecCredentialPlugin(pr.CredentialPlugin) {
+			// run the docker credential helper
+			authConfig, err = util.ExecCredentialPlugin(pr.CredentialPlugin, pr.URL)
+			if err != nil {
+				return "", err
+			}
+		} else if regType, ok := pr.CredentialPlugin["type"]; ok {
 			switch regType {
 			case "ecr":
 				// generate ecr authConfig
@@ -757,12 +818,34 @@ func isContainerEnvChanged(containerEnv, imageConfigEnv, dockerfileEnv []string)
 	return sliceEqualsIgnoreOrder(allImageEnv, containerEnv)
 }
 
+// HasExecCredentialPlugin returns whether the credentials of a private registry are given by a docker credential helper
+func HasExecCredentialPlugin(prsMap map[string]v3.PrivateRegistry) bool {
+	for _, pr := range prsMap {
+		if util.IsExecCredentialPlugin(pr.CredentialPlugin) {
+			return true
+		}
+	}
+	return false
+}
+
 func GetKubeletDockerConfig(prsMap map[string]v3.PrivateRegistry) (string, error) {
 	auths := map[string]authConfig{}
 	credHelper := make(map[string]string)
 	for url, pr := range prsMap {
 		if len(pr.CredentialPlugin) != 0 {
-			if credPluginType, ok := pr.CredentialPlugin["type"]; ok {
+			if util.IsExecCredentialPlugin(pr.CredentialPlugin) {
+				// the kubelet gets the credentials of the helper at deploy time
+				creds, err := util.ExecCredentialPlugin(pr.CredentialPlugin, pr.URL)
+				if err != nil {
+					return "", err
+				}
+				if creds.IdentityToken != "" {
+					auths[url] = authConfig{IdentityToken: creds.IdentityToken}
+					continue
+				}
+				auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", creds.Username, creds.Password)))
+				auths[url] = authConfig{Auth: auth}
+			} else if credPluginType, ok := pr.CredentialPlugin["type"]; ok {
 				if credPluginType == "ecr" {
 					credHelper[pr.URL] = "ecr-login"
 				}
@@ -779,7 +862,8 @@ func GetKubeletDockerConfig(prsMap map[string]v3.PrivateRegistry) (string, error
 	return string(cfg), nil
 }
 
-func DoRestartContainer(ctx context.Context, dClient *client.Client, containerName, hostname string) error {
+func DoRestartContainer(ctx context.Context, dClient ContainerRuntime, containerName, hostname string) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: hostname, Container: containerName})
 	if dClient == nil {
 		return fmt.Errorf("Failed to restart container: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
@@ -801,7 +885,7 @@ func DoRestartContainer(ctx context.Context, dClient *client.Client, containerNa
 	return nil
 }
 
-func GetContainerOutput(ctx context.Context, dClient *client.Client, containerName, hostname string) (int64, string, string, error) {
+func GetContainerOutput(ctx context.Context, dClient ContainerRuntime, containerName, hostname string) (int64, string, string, error) {
 	if dClient == nil {
 		return 1, "", "", fmt.Errorf("Failed to get container output: docker client is nil for container [%s] on host [%s]", containerName, hostname)
 	}
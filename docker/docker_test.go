@@ -1,8 +1,16 @@
 package docker
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/docker/docker/api/types"
+
 	v3 "github.com/rancher/rke/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -57,3 +65,72 @@ func TestGetKubeletDockerConfig(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, c, e)
 }
+
+func TestExecCredentialPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential-helper")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+	helper := filepath.Join(dir, "docker-credential-test")
+	script := `#!/bin/sh
+[ "$1" = get ] || exit 1
+read url
+echo $url >> ` + runs + `
+echo "{\"ServerURL\":\"$url\",\"Username\":\"helper\",\"Secret\":\"s3cret\"}"
+`
+	assert.Nil(t, ioutil.WriteFile(helper, []byte(script), 0700))
+
+	pr := v3.PrivateRegistry{
+		URL:              "helper.example.com",
+		CredentialPlugin: map[string]string{"exec": helper},
+	}
+	auth, err := getRegistryAuth(pr)
+	assert.Nil(t, err)
+	decoded, err := base64.URLEncoding.DecodeString(auth)
+	assert.Nil(t, err)
+	var authConfig types.AuthConfig
+	assert.Nil(t, json.Unmarshal(decoded, &authConfig))
+	assert.Equal(t, "helper", authConfig.Username)
+	assert.Equal(t, "s3cret", authConfig.Password)
+
+	// the kubelet gets the same credentials, the helper runs once for the batch
+	c, err := GetKubeletDockerConfig(map[string]v3.PrivateRegistry{pr.URL: pr})
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"auths\":{\"helper.example.com\":{\"auth\":\"aGVscGVyOnMzY3JldA==\"}}}", c)
+	output, err := ioutil.ReadFile(runs)
+	assert.Nil(t, err)
+	assert.Equal(t, "helper.example.com\n", string(output))
+
+	pr = v3.PrivateRegistry{
+		URL:              "missing.example.com",
+		CredentialPlugin: map[string]string{"type": "exec", "exec": filepath.Join(dir, "docker-credential-missing")},
+	}
+	_, err = getRegistryAuth(pr)
+	assert.NotNil(t, err)
+}
+
+func TestExecCredentialPluginArguments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential helper")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	helper := filepath.Join(dir, "docker-credential-test")
+	script := `#!/bin/sh
+[ "$1" = "--profile" ] && [ "$2" = "prod account" ] && [ "$3" = get ] || exit 1
+read url
+echo "{\"ServerURL\":\"$url\",\"Username\":\"helper\",\"Secret\":\"s3cret\"}"
+`
+	assert.Nil(t, ioutil.WriteFile(helper, []byte(script), 0700))
+
+	// the helper and its arguments are quoted like in a shell
+	pr := v3.PrivateRegistry{
+		URL:              "arguments.example.com",
+		CredentialPlugin: map[string]string{"exec": fmt.Sprintf(`'%s' --profile "prod account"`, helper)},
+	}
+	c, err := GetKubeletDockerConfig(map[string]v3.PrivateRegistry{pr.URL: pr})
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"auths\":{\"arguments.example.com\":{\"auth\":\"aGVscGVyOnMzY3JldA==\"}}}", c)
+
+	pr.CredentialPlugin["exec"] = fmt.Sprintf(`'%s --profile`, helper)
+	_, err = GetKubeletDockerConfig(map[string]v3.PrivateRegistry{pr.URL: pr})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,541 @@
+// Package dockertest provides an in-memory container runtime to test the container lifecycle without Docker hosts
+package dockertest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rancher/rke/docker"
+)
+
+// EventLog records the operations of runtimes in order, it is shared by the runtimes of the hosts of a cluster to
+// check the ordering across hosts
+type EventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *EventLog) add(hostname, op, name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf("%s %s %s", hostname, op, name))
+}
+
+// Events returns the recorded operations as "<hostname> <operation> <container or image>"
+func (l *EventLog) Events() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.events...)
+}
+
+// Container is a container of Runtime
+type Container struct {
+	ID         string
+	Name       string
+	Config     container.Config
+	HostConfig container.HostConfig
+	Running    bool
+	ExitCode   int
+	Stdout     string
+	Stderr     string
+	// Files by absolute path
+	Files map[string][]byte
+}
+
+// Runtime is an in-memory docker.ContainerRuntime, the containers don't run anything: the containers with a restart
+// policy keep running once started and the others exit at once with the exit code set in ExitCodes
+type Runtime struct {
+	Hostname string
+	// ExitCodes of the containers without restart policy by name, default is 0
+	ExitCodes map[string]int
+	// Errors returned by an operation by "<operation> <container or image>", like "start kubelet"
+	Errors map[string]error
+	// Digests of the images in their registry by image, the local images are pulled with these digests
+	Digests map[string]string
+	// ImageIDs of the local images by image, an image without ID is its own ID
+	ImageIDs map[string]string
+	// HostFiles of the host by absolute path, they're copied to the containers bind mounting their directory
+	HostFiles map[string][]byte
+	// AttachHandler serves the attached streams of a container, the output written to the connection is multiplexed
+	// like the output of a container without TTY. Attaching fails when it's not set.
+	AttachHandler func(c Container, conn net.Conn)
+
+	info       types.Info
+	eventLog   *EventLog
+	mu         sync.Mutex
+	nextID     int
+	containers map[string]*Container
+	images     map[string]bool
+}
+
+// NewRuntime returns an empty runtime for the host, the operations are recorded in the event log
+func NewRuntime(hostname string, eventLog *EventLog) *Runtime {
+	if eventLog == nil {
+		eventLog = &EventLog{}
+	}
+	return &Runtime{
+		Hostname:   hostname,
+		ExitCodes:  map[string]int{},
+		Errors:     map[string]error{},
+		Digests:    map[string]string{},
+		ImageIDs:   map[string]string{},
+		HostFiles:  map[string][]byte{},
+		info:       types.Info{ServerVersion: "20.10.6", Name: hostname},
+		eventLog:   eventLog,
+		containers: map[string]*Container{},
+		images:     map[string]bool{},
+	}
+}
+
+// Events returns the operations recorded in the event log of the runtime
+func (r *Runtime) Events() []string {
+	return r.eventLog.Events()
+}
+
+// AddImage makes the image available without pulling it
+func (r *Runtime) AddImage(image string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images[image] = true
+}
+
+// HasImage returns whether the image was pulled or loaded
+func (r *Runtime) HasImage(image string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.images[image]
+}
+
+// Container returns a copy of the container by name
+func (r *Runtime) Container(name string) (Container, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.containers[name]
+	if !ok {
+		return Container{}, false
+	}
+	return *c, true
+}
+
+// ContainerNames returns the sorted names of the containers
+func (r *Runtime) ContainerNames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for name := range r.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// record logs the operation and returns the error set for it
+func (r *Runtime) record(op, name string) error {
+	r.eventLog.add(r.Hostname, op, name)
+	return r.Errors[op+" "+name]
+}
+
+// lookup returns the container by name or ID, called with the lock held
+func (r *Runtime) lookup(nameOrID string) (*Container, error) {
+	nameOrID = strings.TrimPrefix(nameOrID, "/")
+	if c, ok := r.containers[nameOrID]; ok {
+		return c, nil
+	}
+	for _, c := range r.containers {
+		if c.ID == nameOrID {
+			return c, nil
+		}
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("No such container: %s", nameOrID))
+}
+
+func (r *Runtime) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.ContainerCreateCreatedBody, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("create", containerName); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+	if _, ok := r.containers[containerName]; ok {
+		return container.ContainerCreateCreatedBody{}, errdefs.Conflict(fmt.Errorf("container name [%s] is already in use", containerName))
+	}
+	if !r.images[config.Image] {
+		return container.ContainerCreateCreatedBody{}, errdefs.NotFound(fmt.Errorf("No such image: %s", config.Image))
+	}
+	r.nextID++
+	c := &Container{
+		ID:     fmt.Sprintf("%064x", r.nextID),
+		Name:   containerName,
+		Config: *config,
+		Files:  map[string][]byte{},
+	}
+	if hostConfig != nil {
+		c.HostConfig = *hostConfig
+	}
+	for _, bind := range c.HostConfig.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) < 2 {
+			continue
+		}
+		source := path.Clean(parts[0])
+		for filePath, content := range r.HostFiles {
+			if strings.HasPrefix(filePath, source+"/") {
+				c.Files[path.Join(parts[1], strings.TrimPrefix(filePath, source))] = content
+			}
+		}
+	}
+	r.containers[containerName] = c
+	return container.ContainerCreateCreatedBody{ID: c.ID}, nil
+}
+
+func (r *Runtime) ContainerStart(ctx context.Context, containerName string, options types.ContainerStartOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return err
+	}
+	if err := r.record("start", c.Name); err != nil {
+		return err
+	}
+	r.run(c)
+	return nil
+}
+
+// run starts the container, called with the lock held
+func (r *Runtime) run(c *Container) {
+	if c.HostConfig.RestartPolicy.Name != "" && c.HostConfig.RestartPolicy.Name != "no" {
+		c.Running = true
+		c.ExitCode = 0
+		return
+	}
+	c.Running = false
+	c.ExitCode = r.ExitCodes[c.Name]
+	if c.HostConfig.AutoRemove {
+		delete(r.containers, c.Name)
+	}
+}
+
+func (r *Runtime) ContainerStop(ctx context.Context, containerName string, timeout *time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return err
+	}
+	if err := r.record("stop", c.Name); err != nil {
+		return err
+	}
+	c.Running = false
+	return nil
+}
+
+func (r *Runtime) ContainerRestart(ctx context.Context, containerName string, timeout *time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return err
+	}
+	if err := r.record("restart", c.Name); err != nil {
+		return err
+	}
+	r.run(c)
+	return nil
+}
+
+func (r *Runtime) ContainerRename(ctx context.Context, containerName, newContainerName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return err
+	}
+	if err := r.record("rename", c.Name+" "+newContainerName); err != nil {
+		return err
+	}
+	if _, ok := r.containers[newContainerName]; ok {
+		return errdefs.Conflict(fmt.Errorf("container name [%s] is already in use", newContainerName))
+	}
+	delete(r.containers, c.Name)
+	c.Name = newContainerName
+	r.containers[newContainerName] = c
+	return nil
+}
+
+func (r *Runtime) ContainerRemove(ctx context.Context, containerName string, options types.ContainerRemoveOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return err
+	}
+	if err := r.record("remove", c.Name); err != nil {
+		return err
+	}
+	if c.Running && !options.Force {
+		return errdefs.Conflict(fmt.Errorf("container [%s] is running, stop the container before removing or force remove", c.Name))
+	}
+	delete(r.containers, c.Name)
+	return nil
+}
+
+func (r *Runtime) ContainerInspect(ctx context.Context, containerName string) (types.ContainerJSON, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	config := c.Config
+	hostConfig := c.HostConfig
+	status := "exited"
+	if c.Running {
+		status = "running"
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    c.ID,
+			Name:  "/" + c.Name,
+			Image: c.Config.Image,
+			State: &types.ContainerState{
+				Status:   status,
+				Running:  c.Running,
+				ExitCode: c.ExitCode,
+			},
+			HostConfig: &hostConfig,
+		},
+		Config: &config,
+	}, nil
+}
+
+func (r *Runtime) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var containers []types.Container
+	for _, c := range r.containers {
+		if !c.Running && !options.All {
+			continue
+		}
+		state := "exited"
+		if c.Running {
+			state = "running"
+		}
+		containers = append(containers, types.Container{
+			ID:     c.ID,
+			Names:  []string{"/" + c.Name},
+			Image:  c.Config.Image,
+			State:  state,
+			Labels: c.Config.Labels,
+		})
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Names[0] < containers[j].Names[0] })
+	return containers, nil
+}
+
+// ContainerLogs returns the logs multiplexed like the logs of a container without TTY
+func (r *Runtime) ContainerLogs(ctx context.Context, containerName string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if options.ShowStdout && c.Stdout != "" {
+		stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte(c.Stdout))
+	}
+	if options.ShowStderr && c.Stderr != "" {
+		stdcopy.NewStdWriter(&buf, stdcopy.Stderr).Write([]byte(c.Stderr))
+	}
+	return ioutil.NopCloser(&buf), nil
+}
+
+func (r *Runtime) ContainerAttach(ctx context.Context, containerName string, options types.ContainerAttachOptions) (types.HijackedResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+	if err := r.record("attach", c.Name); err != nil {
+		return types.HijackedResponse{}, err
+	}
+	if r.AttachHandler == nil {
+		return types.HijackedResponse{}, fmt.Errorf("attaching to container [%s] is not supported", c.Name)
+	}
+	conn, serverConn := net.Pipe()
+	go r.AttachHandler(*c, serverConn)
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(conn)}, nil
+}
+
+func (r *Runtime) ContainerStatPath(ctx context.Context, containerName, filePath string) (types.ContainerPathStat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return types.ContainerPathStat{}, err
+	}
+	content, ok := c.Files[path.Clean(filePath)]
+	if !ok {
+		return types.ContainerPathStat{}, errdefs.NotFound(fmt.Errorf("Could not find the file %s in container %s", filePath, c.Name))
+	}
+	return types.ContainerPathStat{Name: path.Base(filePath), Size: int64(len(content)), Mode: 0644}, nil
+}
+
+// CopyToContainer extracts the regular files of the tar archive in the directory
+func (r *Runtime) CopyToContainer(ctx context.Context, containerName, dstPath string, content io.Reader, options types.CopyToContainerOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return err
+	}
+	if err := r.record("copy-to", c.Name+" "+dstPath); err != nil {
+		return err
+	}
+	tr := tar.NewReader(content)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		buf, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		c.Files[path.Join(dstPath, header.Name)] = buf
+	}
+}
+
+// CopyFromContainer returns the file as a tar archive
+func (r *Runtime) CopyFromContainer(ctx context.Context, containerName, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := r.lookup(containerName)
+	if err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	content, ok := c.Files[path.Clean(srcPath)]
+	if !ok {
+		return nil, types.ContainerPathStat{}, errdefs.NotFound(fmt.Errorf("Could not find the file %s in container %s", srcPath, c.Name))
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: path.Base(srcPath), Mode: 0644, Size: int64(len(content))}); err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	stat := types.ContainerPathStat{Name: path.Base(srcPath), Size: int64(len(content)), Mode: 0644}
+	return ioutil.NopCloser(&buf), stat, nil
+}
+
+func (r *Runtime) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.images[image] {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("No such image: %s", image))
+	}
+	inspect := types.ImageInspect{ID: image, RepoTags: []string{image}, Config: &container.Config{}}
+	if id, ok := r.ImageIDs[image]; ok {
+		inspect.ID = id
+	}
+	if imageDigest, ok := r.Digests[image]; ok {
+		if named, err := reference.ParseNormalizedNamed(image); err == nil {
+			inspect.RepoDigests = []string{reference.FamiliarName(named) + "@" + imageDigest}
+		}
+	}
+	return inspect, nil, nil
+}
+
+func (r *Runtime) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("pull", ref); err != nil {
+		return nil, err
+	}
+	r.images[ref] = true
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+// ImageLoad makes the images tagged in the docker save tarball available
+func (r *Runtime) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	tags, err := docker.GetImageBundleTags(input)
+	if err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("load", strings.Join(tags, ",")); err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	for _, tag := range tags {
+		r.images[tag] = true
+	}
+	return types.ImageLoadResponse{Body: ioutil.NopCloser(strings.NewReader("")), JSON: true}, nil
+}
+
+func (r *Runtime) ImageTag(ctx context.Context, source, target string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("tag", source+" "+target); err != nil {
+		return err
+	}
+	if !r.images[source] {
+		return errdefs.NotFound(fmt.Errorf("No such image: %s", source))
+	}
+	r.images[target] = true
+	if id, ok := r.ImageIDs[source]; ok {
+		r.ImageIDs[target] = id
+	}
+	return nil
+}
+
+func (r *Runtime) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("inspect-distribution", image); err != nil {
+		return registry.DistributionInspect{}, err
+	}
+	imageDigest, ok := r.Digests[image]
+	if !ok {
+		return registry.DistributionInspect{}, errdefs.NotFound(fmt.Errorf("manifest unknown: %s", image))
+	}
+	return registry.DistributionInspect{Descriptor: specs.Descriptor{Digest: digest.Digest(imageDigest)}}, nil
+}
+
+func (r *Runtime) Info(ctx context.Context) (types.Info, error) {
+	return r.info, nil
+}
+
+var _ docker.ContainerRuntime = &Runtime{}
@@ -0,0 +1,89 @@
+package dockertest
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rancher/rke/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeRollingUpdate(t *testing.T) {
+	ctx := context.Background()
+	f := NewRuntime("1.1.1.1", nil)
+	hostCfg := &container.HostConfig{RestartPolicy: container.RestartPolicy{Name: "always"}}
+
+	imageCfg := &container.Config{Image: "rancher/hyperkube:v1.20.4-rancher1", Cmd: []string{"kubelet"}}
+	assert.Nil(t, docker.DoRunContainer(ctx, f, imageCfg, hostCfg, "kubelet", f.Hostname, "worker", nil))
+	// running the same container again is a no-op
+	assert.Nil(t, docker.DoRunContainer(ctx, f, imageCfg, hostCfg, "kubelet", f.Hostname, "worker", nil))
+	assert.Equal(t, []string{
+		"1.1.1.1 pull rancher/hyperkube:v1.20.4-rancher1",
+		"1.1.1.1 create kubelet",
+		"1.1.1.1 start kubelet",
+	}, f.Events())
+
+	// the new container is started before the old one is removed
+	upgradeCfg := &container.Config{Image: "rancher/hyperkube:v1.20.5-rancher1", Cmd: []string{"kubelet"}}
+	assert.Nil(t, docker.DoRunContainer(ctx, f, upgradeCfg, hostCfg, "kubelet", f.Hostname, "worker", nil))
+	assert.Equal(t, []string{
+		"1.1.1.1 pull rancher/hyperkube:v1.20.5-rancher1",
+		"1.1.1.1 stop kubelet",
+		"1.1.1.1 rename kubelet old-kubelet",
+		"1.1.1.1 create kubelet",
+		"1.1.1.1 start kubelet",
+		"1.1.1.1 remove old-kubelet",
+	}, f.Events()[3:])
+	kubelet, ok := f.Container("kubelet")
+	assert.True(t, ok)
+	assert.True(t, kubelet.Running)
+	assert.Equal(t, upgradeCfg.Image, kubelet.Config.Image)
+	assert.Equal(t, []string{"kubelet"}, f.ContainerNames())
+
+	// a new memory limit rolls the container
+	limitedCfg := *hostCfg
+	limitedCfg.Memory = 1 << 30
+	upgradable, err := docker.IsContainerUpgradable(ctx, f, upgradeCfg, &limitedCfg, "kubelet", f.Hostname, "worker")
+	assert.Nil(t, err)
+	assert.True(t, upgradable)
+	upgradable, err = docker.IsContainerUpgradable(ctx, f, upgradeCfg, hostCfg, "kubelet", f.Hostname, "worker")
+	assert.Nil(t, err)
+	assert.False(t, upgradable)
+
+	assert.Nil(t, docker.DoRemoveContainer(ctx, f, "kubelet", f.Hostname))
+	assert.Nil(t, docker.DoRemoveContainer(ctx, f, "kubelet", f.Hostname))
+	assert.Empty(t, f.ContainerNames())
+}
+
+func TestRuntimeOnetimeContainer(t *testing.T) {
+	ctx := context.Background()
+	f := NewRuntime("1.1.1.1", nil)
+	f.AddImage("rancher/rke-tools:v0.1.72")
+	imageCfg := &container.Config{Image: "rancher/rke-tools:v0.1.72"}
+
+	assert.Nil(t, docker.DoRunOnetimeContainer(ctx, f, imageCfg, &container.HostConfig{}, "cert-deployer", f.Hostname, "certificates", nil))
+	deployer, ok := f.Container("cert-deployer")
+	assert.True(t, ok)
+	assert.False(t, deployer.Running)
+
+	f.ExitCodes["file-deployer"] = 1
+	err := docker.DoRunOnetimeContainer(ctx, f, imageCfg, &container.HostConfig{}, "file-deployer", f.Hostname, "certificates", nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "non-zero exit code [1]")
+	}
+
+	// files copied to the container can be read back
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "kube-ca.pem", Mode: 0644, Size: 4}))
+	_, err = tw.Write([]byte("cert"))
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, docker.DoCopyToContainer(ctx, f, "certificates", "cert-deployer", f.Hostname, "/etc/kubernetes/ssl", &buf))
+	content, err := docker.ReadFileFromContainer(ctx, f, f.Hostname, "cert-deployer", "/etc/kubernetes/ssl/kube-ca.pem")
+	assert.Nil(t, err)
+	assert.Equal(t, "cert", content)
+}
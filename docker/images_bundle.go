@@ -0,0 +1,175 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	ref "github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+const imageBundleManifest = "manifest.json"
+
+// SaveImageBundle writes the images, pulled first if missing, as a single docker save tarball
+func SaveImageBundle(ctx context.Context, dClient *client.Client, hostname string, images []string, prsMap map[string]v3.PrivateRegistry, w io.Writer) error {
+	for _, image := range images {
+		if err := UseLocalOrPull(ctx, dClient, hostname, image, "images", prsMap); err != nil {
+			return fmt.Errorf("Failed to pull image [%s]: %v", image, err)
+		}
+	}
+	logrus.Debugf("Saving %d images on host [%s]", len(images), hostname)
+	out, err := dClient.ImageSave(ctx, images)
+	if err != nil {
+		return fmt.Errorf("Failed to save images on host [%s]: %v", hostname, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(w, out); err != nil {
+		return fmt.Errorf("Failed to save images on host [%s]: %v", hostname, err)
+	}
+	return nil
+}
+
+// LoadImageBundle loads a docker save tarball in the Docker daemon of the host
+func LoadImageBundle(ctx context.Context, dClient ContainerRuntime, hostname string, r io.Reader) error {
+	if dClient == nil {
+		return fmt.Errorf("Failed to load images: docker client is nil for host [%s]", hostname)
+	}
+	resp, err := dClient.ImageLoad(ctx, r, true)
+	if err != nil {
+		return fmt.Errorf("Failed to load images on host [%s]: %v", hostname, err)
+	}
+	defer resp.Body.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(resp.Body, ioutil.Discard, 0, false, nil); err != nil {
+		return fmt.Errorf("Failed to load images on host [%s]: %v", hostname, err)
+	}
+	return nil
+}
+
+// TagImages tags the images of the host with their new names by current name
+func TagImages(ctx context.Context, dClient ContainerRuntime, hostname string, names map[string]string) error {
+	if dClient == nil {
+		return fmt.Errorf("Failed to tag images: docker client is nil for host [%s]", hostname)
+	}
+	for image, name := range names {
+		logrus.Debugf("Tagging image [%s] as [%s] on host [%s]", image, name, hostname)
+		if err := dClient.ImageTag(ctx, image, name); err != nil {
+			return fmt.Errorf("Failed to tag image [%s] as [%s] on host [%s]: %v", image, name, hostname, err)
+		}
+	}
+	return nil
+}
+
+// ReadImageBundle opens the image bundle, decompressed if it is gzip compressed
+func ReadImageBundle(bundlePath string, read func(io.Reader) error) error {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return fmt.Errorf("Failed to read image bundle [%s]: %v", bundlePath, err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("Failed to read image bundle [%s]: %v", bundlePath, err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	return read(r)
+}
+
+// GetImageBundleTags returns the image tags of a docker save tarball
+func GetImageBundleTags(r io.Reader) ([]string, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in the image bundle", imageBundleManifest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the image bundle: %v", err)
+		}
+		if header.Name != imageBundleManifest {
+			continue
+		}
+		var manifest []struct {
+			RepoTags []string
+		}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("Failed to read %s of the image bundle: %v", imageBundleManifest, err)
+		}
+		var tags []string
+		for _, image := range manifest {
+			tags = append(tags, image.RepoTags...)
+		}
+		return tags, nil
+	}
+}
+
+// GetLocalImageID returns the ID of the image in the Docker daemon if it was pulled with the digest, it's empty when
+// the image is not in the Docker daemon
+func GetLocalImageID(ctx context.Context, dClient ContainerRuntime, image, digest string) (string, error) {
+	named, err := ref.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	inspect, _, err := dClient.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("Failed to inspect image [%s]: %v", image, err)
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		pulled, err := ref.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if digested, ok := pulled.(ref.Digested); ok && pulled.Name() == named.Name() && digested.Digest().String() == digest {
+			return inspect.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// PushImage pushes the image with the credentials of its private registry
+func PushImage(ctx context.Context, dClient *client.Client, hostname, image string, prsMap map[string]v3.PrivateRegistry) error {
+	regAuth, _, err := GetImageRegistryConfig(image, prsMap)
+	if err != nil {
+		return err
+	}
+	out, err := dClient.ImagePush(ctx, image, types.ImagePushOptions{RegistryAuth: regAuth})
+	if err != nil {
+		return fmt.Errorf("Failed to push image [%s] on host [%s]: %v", image, hostname, err)
+	}
+	defer out.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(out, ioutil.Discard, 0, false, nil); err != nil {
+		return fmt.Errorf("Failed to push image [%s] on host [%s]: %v", image, hostname, err)
+	}
+	return nil
+}
+
+// GetImageDigest returns the digest of the image in its registry, with the credentials of its private registry
+func GetImageDigest(ctx context.Context, dClient ContainerRuntime, image string, prsMap map[string]v3.PrivateRegistry) (string, error) {
+	regAuth, _, err := GetImageRegistryConfig(image, prsMap)
+	if err != nil {
+		return "", err
+	}
+	inspect, err := dClient.DistributionInspect(ctx, image, regAuth)
+	if err != nil {
+		return "", fmt.Errorf("Failed to inspect image [%s] in its registry: %v", image, err)
+	}
+	return inspect.Descriptor.Digest.String(), nil
+}
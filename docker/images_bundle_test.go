@@ -0,0 +1,30 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetImageBundleTags(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	layer := []byte("layer")
+	manifest := []byte(`[{"Config":"a.json","RepoTags":["rancher/rke-tools:v0.1.78"]},{"Config":"b.json","RepoTags":["rancher/hyperkube:v1.21.5-rancher1","rancher/hyperkube:latest"]}]`)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "a/layer.tar", Mode: 0644, Size: int64(len(layer))}))
+	_, err := tw.Write(layer)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: imageBundleManifest, Mode: 0644, Size: int64(len(manifest))}))
+	_, err = tw.Write(manifest)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+
+	tags, err := GetImageBundleTags(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"rancher/rke-tools:v0.1.78", "rancher/hyperkube:v1.21.5-rancher1", "rancher/hyperkube:latest"}, tags)
+
+	_, err = GetImageBundleTags(bytes.NewReader(nil))
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,7 @@
 package hosts
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,7 +10,9 @@ import (
 
 	"k8s.io/client-go/transport"
 
+	"github.com/rancher/rke/docker"
 	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -19,6 +22,9 @@ const (
 
 type DialerFactory func(h *Host) (func(network, address string) (net.Conn, error), error)
 
+// RuntimeFactory returns the container runtime of the host, used instead of the Docker API over the dialer of the host
+type RuntimeFactory func(h *Host) (docker.ContainerRuntime, error)
+
 type dialer struct {
 	signer          ssh.Signer
 	sshKeyString    string
@@ -28,13 +34,24 @@ type dialer struct {
 	netConn         string
 	dockerSocket    string
 	useSSHAgentAuth bool
-	bastionDialer   *dialer
+	hostKeyCallback ssh.HostKeyCallback
+	keyPassphrase   *keyPassphrase
+	// hostKeyFingerprint is the pinned fingerprint the connection is verified with
+	hostKeyFingerprint string
+	isBastion          bool
+	// bastionDialers are the jump hosts to the dialer address in order
+	bastionDialers []*dialer
+
+	// sshKeyCommand gets the key and the certificate on every handshake, its certificates are short lived
+	sshKeyCommand    string
+	sshKeyCommandEnv []string
 }
 
 type DialersOptions struct {
 	DockerDialerFactory    DialerFactory
 	LocalConnDialerFactory DialerFactory
 	K8sWrapTransport       transport.WrapperFunc
+	RuntimeFactory         RuntimeFactory
 }
 
 func GetDialerOptions(d, l DialerFactory, w transport.WrapperFunc) DialersOptions {
@@ -47,56 +64,27 @@ func GetDialerOptions(d, l DialerFactory, w transport.WrapperFunc) DialersOption
 
 func newDialer(h *Host, kind string) (*dialer, error) {
 	// Check for Bastion host connection
-	var bastionDialer *dialer
-	if len(h.BastionHost.Address) > 0 {
-		bastionDialer = &dialer{
-			sshAddress:      fmt.Sprintf("%s:%s", h.BastionHost.Address, h.BastionHost.Port),
-			username:        h.BastionHost.User,
-			sshKeyString:    h.BastionHost.SSHKey,
-			sshCertString:   h.BastionHost.SSHCert,
-			netConn:         "tcp",
-			useSSHAgentAuth: h.SSHAgentAuth,
-		}
-		if bastionDialer.sshKeyString == "" && !bastionDialer.useSSHAgentAuth {
-			var err error
-			bastionDialer.sshKeyString, err = privateKeyPath(h.BastionHost.SSHKeyPath)
-			if err != nil {
-				return nil, err
-			}
-
-			if bastionDialer.sshCertString == "" && len(h.BastionHost.SSHCertPath) > 0 {
-				bastionDialer.sshCertString, err = certificatePath(h.BastionHost.SSHCertPath)
-				if err != nil {
-					return nil, err
-				}
-			}
-		}
+	bastionDialers, err := newBastionDialers(h.BastionHost, h.HostKeyVerifier)
+	if err != nil {
+		return nil, err
 	}
 
 	dialer := &dialer{
-		sshAddress:      fmt.Sprintf("%s:%s", h.Address, h.Port),
-		username:        h.User,
-		dockerSocket:    h.DockerSocket,
-		sshKeyString:    h.SSHKey,
-		sshCertString:   h.SSHCert,
-		netConn:         "unix",
-		useSSHAgentAuth: h.SSHAgentAuth,
-		bastionDialer:   bastionDialer,
-	}
-
-	if dialer.sshKeyString == "" && !dialer.useSSHAgentAuth {
-		var err error
-		dialer.sshKeyString, err = privateKeyPath(h.SSHKeyPath)
-		if err != nil {
-			return nil, err
-		}
+		sshAddress:         fmt.Sprintf("%s:%s", h.Address, h.Port),
+		username:           h.User,
+		dockerSocket:       h.DockerSocket,
+		sshKeyString:       h.SSHKey,
+		sshCertString:      h.SSHCert,
+		netConn:            "unix",
+		useSSHAgentAuth:    h.SSHAgentAuth,
+		hostKeyCallback:    hostKeyCallback(h.HostKeyVerifier, h.SSHHostKey),
+		hostKeyFingerprint: h.SSHHostKey,
+		keyPassphrase:      newKeyPassphrase(h),
+		bastionDialers:     bastionDialers,
+	}
 
-		if dialer.sshCertString == "" && len(h.SSHCertPath) > 0 {
-			dialer.sshCertString, err = certificatePath(h.SSHCertPath)
-			if err != nil {
-				return nil, err
-			}
-		}
+	if err := dialer.resolveSSHKey(h.Address, h.SSHKeyPath, h.SSHCertPath, h.SSHKeyCommand, sshKeyCommandEnv(h)); err != nil {
+		return nil, err
 	}
 
 	switch kind {
@@ -117,6 +105,13 @@ func SSHFactory(h *Host) (func(network, address string) (net.Conn, error), error
 }
 
 func LocalConnFactory(h *Host) (func(network, address string) (net.Conn, error), error) {
+	if h.Transport == DockerTLSTransport {
+		forwarder, err := newDockerPortForwarder(h)
+		if err != nil {
+			return nil, err
+		}
+		return forwarder.Dial, nil
+	}
 	dialer, err := newDialer(h, "network")
 	return dialer.Dial, err
 }
@@ -130,24 +125,9 @@ func (d *dialer) DialLocalConn(network, addr string) (net.Conn, error) {
 }
 
 func (d *dialer) Dial(network, addr string) (net.Conn, error) {
-	var conn *ssh.Client
-	var err error
-	if d.bastionDialer != nil {
-		conn, err = d.getBastionHostTunnelConn()
-	} else {
-		conn, err = d.getSSHTunnelConnection()
-	}
+	conn, err := d.getSSHConn()
 	if err != nil {
-		if strings.Contains(err.Error(), "no key found") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the configured key or specified key file is a valid SSH Private Key. Error: %v", d.sshAddress, err)
-		} else if strings.Contains(err.Error(), "no supported methods remain") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if you are able to SSH to the node using the specified SSH Private Key and if you have configured the correct SSH username. Error: %v", d.sshAddress, err)
-		} else if strings.Contains(err.Error(), "cannot decode encrypted private keys") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Using encrypted private keys is only supported using ssh-agent. Please configure the option `ssh_agent_auth: true` in the configuration file or use --ssh-agent-auth as a parameter when running RKE. This will use the `SSH_AUTH_SOCK` environment variable. Error: %v", d.sshAddress, err)
-		} else if strings.Contains(err.Error(), "operation timed out") {
-			return nil, fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the node is up and is accepting SSH connections or check network policies and firewall rules. Error: %v", d.sshAddress, err)
-		}
-		return nil, fmt.Errorf("Failed to dial ssh using address [%s]: %v", d.sshAddress, err)
+		return nil, d.sshError(err)
 	}
 
 	// Docker Socket....
@@ -156,7 +136,17 @@ func (d *dialer) Dial(network, addr string) (net.Conn, error) {
 		network = d.netConn
 	}
 
-	remote, err := conn.Dial(network, addr)
+	remote, err := conn.dial(network, addr)
+	if _, ok := err.(*ssh.OpenChannelError); err != nil && !ok {
+		// the pooled connection is broken, the channel wasn't rejected by the host
+		logrus.Debugf("[dialer] Reconnecting to [%s] after failing to dial %s: %v", d.sshAddress, addr, err)
+		conn.close()
+		sshPool.count(d.sshAddress, func(stats *SSHConnStats) { stats.Reconnects++ })
+		if conn, err = d.getSSHConn(); err != nil {
+			return nil, d.sshError(err)
+		}
+		remote, err = conn.dial(network, addr)
+	}
 	if err != nil {
 		if strings.Contains(err.Error(), "connect failed") {
 			return nil, fmt.Errorf("Unable to access the service on %s. The service might be still starting up. Error: %v", addr, err)
@@ -168,8 +158,46 @@ func (d *dialer) Dial(network, addr string) (net.Conn, error) {
 	return remote, err
 }
 
+func (d *dialer) sshError(err error) error {
+	if strings.Contains(err.Error(), "no key found") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the configured key or specified key file is a valid SSH Private Key. Error: %v", d.sshAddress, err)
+	} else if strings.Contains(err.Error(), "no supported methods remain") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if you are able to SSH to the node using the specified SSH Private Key and if you have configured the correct SSH username. Error: %v", d.sshAddress, err)
+	} else if strings.Contains(err.Error(), "passphrase protected") || strings.Contains(err.Error(), "Failed to decrypt SSH key") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. The SSH Private Key is passphrase protected, please configure `ssh_key_passphrase_command`, set the %s environment variable, run RKE in a terminal to be prompted for the passphrase or use `ssh_agent_auth: true`. Error: %v", d.sshAddress, SSHKeyPassphraseEnv, err)
+	} else if strings.Contains(err.Error(), "operation timed out") {
+		return fmt.Errorf("Unable to access node with address [%s] using SSH. Please check if the node is up and is accepting SSH connections or check network policies and firewall rules. Error: %v", d.sshAddress, err)
+	}
+	return fmt.Errorf("Failed to dial ssh using address [%s]: %v", d.sshAddress, err)
+}
+
+// getSSHConn returns the pooled SSH connection to the dialer address, the connections to the jump hosts are pooled too
+func (d *dialer) getSSHConn() (*pooledSSHConn, error) {
+	if len(d.bastionDialers) == 0 {
+		connect := d.getSSHTunnelConnection
+		if d.isBastion {
+			connect = func() (*ssh.Client, error) {
+				return d.connectThrough(nil)
+			}
+		}
+		return sshPool.get(sshPoolKey("", d), d.sshAddress, connect)
+	}
+	lastHop := d.bastionDialers[len(d.bastionDialers)-1]
+	lastHopConn, err := lastHop.getSSHConn()
+	if err != nil {
+		return nil, err
+	}
+	return sshPool.get(sshPoolKey(lastHopConn.key, d), d.sshAddress, func() (*ssh.Client, error) {
+		return d.connectThrough(lastHopConn)
+	})
+}
+
 func (d *dialer) getSSHTunnelConnection() (*ssh.Client, error) {
-	cfg, err := getSSHConfig(d.username, d.sshKeyString, d.sshCertString, d.useSSHAgentAuth)
+	sshKey, sshCert, err := d.getSSHKey()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := getSSHConfig(d.username, sshKey, sshCert, d.useSSHAgentAuth, d.hostKeyCallback, d.keyPassphrase)
 	if err != nil {
 		return nil, fmt.Errorf("Error configuring SSH: %v", err)
 	}
@@ -181,6 +209,9 @@ func (h *Host) newHTTPClient(dialerFactory DialerFactory) (*http.Client, error)
 	factory := dialerFactory
 	if factory == nil {
 		factory = SSHFactory
+		if h.Transport == DockerTLSTransport {
+			factory = DockerTLSFactory
+		}
 	}
 
 	dialer, err := factory(h)
@@ -190,7 +221,10 @@ func (h *Host) newHTTPClient(dialerFactory DialerFactory) (*http.Client, error)
 	dockerDialerTimeout := time.Second * DockerDialerTimeout
 	return &http.Client{
 		Transport: &http.Transport{
-			Dial:                  dialer,
+			// the Docker client dials the attached streams of the containers with DialContext
+			DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
+				return dialer(network, addr)
+			},
 			TLSHandshakeTimeout:   dockerDialerTimeout,
 			IdleConnTimeout:       dockerDialerTimeout,
 			ResponseHeaderTimeout: dockerDialerTimeout,
@@ -198,63 +232,166 @@ func (h *Host) newHTTPClient(dialerFactory DialerFactory) (*http.Client, error)
 	}, nil
 }
 
-func (d *dialer) getBastionHostTunnelConn() (*ssh.Client, error) {
-	bastionCfg, err := getSSHConfig(d.bastionDialer.username, d.bastionDialer.sshKeyString, d.bastionDialer.sshCertString, d.bastionDialer.useSSHAgentAuth)
-	if err != nil {
-		return nil, fmt.Errorf("Error configuring SSH for bastion host [%s]: %v", d.bastionDialer.sshAddress, err)
+// connectThrough establishes the SSH connection to the dialer address through the connection to the previous jump host,
+// the connection is an active channel of the jump host connection until it's closed
+func (d *dialer) connectThrough(lastHopConn *pooledSSHConn) (*ssh.Client, error) {
+	kind := "host"
+	if d.isBastion {
+		kind = "bastion host"
 	}
-	bastionClient, err := ssh.Dial("tcp", d.bastionDialer.sshAddress, bastionCfg)
+	sshKey, sshCert, err := d.getSSHKey()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to connect to the bastion host [%s]: %v", d.bastionDialer.sshAddress, err)
+		return nil, err
 	}
-	conn, err := bastionClient.Dial(d.bastionDialer.netConn, d.sshAddress)
+	cfg, err := getSSHConfig(d.username, sshKey, sshCert, d.useSSHAgentAuth, d.hostKeyCallback, d.keyPassphrase)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to connect to the host [%s]: %v", d.sshAddress, err)
+		return nil, fmt.Errorf("Error configuring SSH for %s [%s]: %v", kind, d.sshAddress, err)
 	}
-	cfg, err := getSSHConfig(d.username, d.sshKeyString, d.sshCertString, d.useSSHAgentAuth)
+	if lastHopConn == nil {
+		newClient, err := ssh.Dial("tcp", d.sshAddress, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to connect to the %s [%s]: %v", kind, d.sshAddress, err)
+		}
+		return newClient, nil
+	}
+	conn, err := lastHopConn.dial("tcp", d.sshAddress)
 	if err != nil {
-		return nil, fmt.Errorf("Error configuring SSH for host [%s]: %v", d.sshAddress, err)
+		return nil, fmt.Errorf("Failed to connect to the %s [%s]: %v", kind, d.sshAddress, err)
 	}
 	newClientConn, channels, sshRequest, err := ssh.NewClientConn(conn, d.sshAddress, cfg)
 	if err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("Failed to establish new ssh client conn [%s]: %v", d.sshAddress, err)
 	}
 	return ssh.NewClient(newClientConn, channels, sshRequest), nil
 }
 
-func BastionHostWrapTransport(bastionHost v3.BastionHost) (transport.WrapperFunc, error) {
+// resolveSSHKey gets the SSH key and certificate that are not set in the configuration from the key and certificate
+// files, or makes sure the key command returns a key
+func (d *dialer) resolveSSHKey(address, keyPath, certPath, keyCommand string, keyCommandEnv []string) error {
+	var err error
+	if d.sshKeyString == "" && !d.useSSHAgentAuth && keyCommand != "" {
+		d.sshKeyCommand, d.sshKeyCommandEnv = keyCommand, keyCommandEnv
+		if _, _, err := d.getSSHKey(); err != nil {
+			return fmt.Errorf("Failed to get SSH key of host [%s]: %v", address, err)
+		}
+	}
+	if d.sshKeyString == "" && d.sshKeyCommand == "" && !d.useSSHAgentAuth {
+		if d.sshKeyString, err = privateKeyPath(keyPath); err != nil {
+			return err
+		}
+	}
+	if d.sshCertString == "" && len(certPath) > 0 {
+		if d.sshCertString, err = certificatePath(certPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	bastionDialer := &dialer{
-		sshAddress:      fmt.Sprintf("%s:%s", bastionHost.Address, bastionHost.Port),
-		username:        bastionHost.User,
-		sshKeyString:    bastionHost.SSHKey,
-		sshCertString:   bastionHost.SSHCert,
-		netConn:         "tcp",
-		useSSHAgentAuth: bastionHost.SSHAgentAuth,
+// getSSHKey returns the SSH key and certificate of the handshake, the output of the key command is cached until its
+// certificate is about to expire
+func (d *dialer) getSSHKey() (string, string, error) {
+	if d.sshKeyCommand == "" {
+		return d.sshKeyString, d.sshCertString, nil
+	}
+	sshKey, sshCert, err := getSSHKeyFromCommand(d.sshKeyCommand, d.sshKeyCommandEnv)
+	if err != nil {
+		return "", "", err
+	}
+	if sshCert == "" {
+		sshCert = d.sshCertString
 	}
+	return sshKey, sshCert, nil
+}
 
-	if bastionDialer.sshKeyString == "" && !bastionDialer.useSSHAgentAuth {
-		var err error
-		bastionDialer.sshKeyString, err = privateKeyPath(bastionHost.SSHKeyPath)
-		if err != nil {
+// newBastionDialers returns the dialers of the bastion host and its hops in order, none without bastion host
+func newBastionDialers(bastionHost v3.BastionHost, verifier *HostKeyVerifier) ([]*dialer, error) {
+	if len(bastionHost.Address) == 0 {
+		return nil, nil
+	}
+	hops := []v3.BastionHop{{
+		Address:                 bastionHost.Address,
+		Port:                    bastionHost.Port,
+		User:                    bastionHost.User,
+		SSHAgentAuth:            bastionHost.SSHAgentAuth,
+		SSHKey:                  bastionHost.SSHKey,
+		SSHKeyPath:              bastionHost.SSHKeyPath,
+		SSHCert:                 bastionHost.SSHCert,
+		SSHCertPath:             bastionHost.SSHCertPath,
+		SSHKeyCommand:           bastionHost.SSHKeyCommand,
+		SSHKeyPassphraseCommand: bastionHost.SSHKeyPassphraseCommand,
+		SSHHostKey:              bastionHost.SSHHostKey,
+	}}
+	var bastionDialers []*dialer
+	for _, hop := range append(hops, bastionHost.Hops...) {
+		bastionDialer := &dialer{
+			sshAddress:         fmt.Sprintf("%s:%s", hop.Address, hop.Port),
+			username:           hop.User,
+			sshKeyString:       hop.SSHKey,
+			sshCertString:      hop.SSHCert,
+			netConn:            "tcp",
+			useSSHAgentAuth:    hop.SSHAgentAuth,
+			hostKeyCallback:    hostKeyCallback(verifier, hop.SSHHostKey),
+			hostKeyFingerprint: hop.SSHHostKey,
+			isBastion:          true,
+		}
+		// the jump hosts unlock and get their keys like the nodes
+		env := []string{
+			fmt.Sprintf("%s=%s", SSHKeyCommandAddressEnv, hop.Address),
+			fmt.Sprintf("%s=%s", SSHKeyCommandHostnameEnv, hop.Address),
+			fmt.Sprintf("%s=%s", SSHKeyCommandUserEnv, hop.User),
+		}
+		bastionDialer.keyPassphrase = &keyPassphrase{address: hop.Address, command: hop.SSHKeyPassphraseCommand, env: env}
+		if err := bastionDialer.resolveSSHKey(hop.Address, hop.SSHKeyPath, hop.SSHCertPath, hop.SSHKeyCommand, env); err != nil {
 			return nil, err
 		}
+		// the first hop is connected to directly, the next hops through the previous ones
+		bastionDialer.bastionDialers = bastionDialers
+		bastionDialers = append(bastionDialers, bastionDialer)
+	}
+	return bastionDialers, nil
+}
 
+// newBastionChainDialer returns the dialer connecting through all the hops of the bastion host, nil without bastion host
+func newBastionChainDialer(bastionHost v3.BastionHost, verifier *HostKeyVerifier) (*dialer, error) {
+	bastionDialers, err := newBastionDialers(bastionHost, verifier)
+	if err != nil || len(bastionDialers) == 0 {
+		return nil, err
 	}
+	return bastionDialers[len(bastionDialers)-1], nil
+}
 
-	if bastionDialer.sshCertString == "" && len(bastionHost.SSHCertPath) > 0 {
-		var err error
-		bastionDialer.sshCertString, err = certificatePath(bastionHost.SSHCertPath)
-		if err != nil {
+// BastionHostWrapTransport dials the kubernetes API through the bastion host of the control plane host it is served
+// by, and through the bastion host of the cluster for the other addresses
+func BastionHostWrapTransport(bastionHost v3.BastionHost, controlHosts []*Host, verifier *HostKeyVerifier) (transport.WrapperFunc, error) {
+	defaultDialer, err := newBastionChainDialer(bastionHost, verifier)
+	if err != nil {
+		return nil, err
+	}
+	hostDialers := make(map[string]*dialer)
+	for _, host := range controlHosts {
+		if hostDialers[host.Address], err = newBastionChainDialer(host.BastionHost, verifier); err != nil {
 			return nil, err
 		}
-
+	}
+	dial := func(network, addr string) (net.Conn, error) {
+		bastionDialer := defaultDialer
+		if address, _, err := net.SplitHostPort(addr); err == nil {
+			if hostDialer, ok := hostDialers[address]; ok {
+				bastionDialer = hostDialer
+			}
+		}
+		if bastionDialer == nil {
+			return net.Dial(network, addr)
+		}
+		return bastionDialer.Dial(network, addr)
 	}
 	return func(rt http.RoundTripper) http.RoundTripper {
 		if ht, ok := rt.(*http.Transport); ok {
 			ht.DialContext = nil
 			ht.DialTLS = nil
-			ht.Dial = bastionDialer.Dial
+			ht.Dial = dial
 		}
 		return rt
 	}, nil
@@ -0,0 +1,50 @@
+package hosts
+
+import (
+	"testing"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDialerBastionHops(t *testing.T) {
+	host := &Host{
+		RKEConfigNode: v3.RKEConfigNode{Address: "10.0.0.1", Port: "22", SSHKey: "node-key"},
+		BastionHost: v3.BastionHost{
+			Address: "bastion.example.com",
+			Port:    "22",
+			SSHKey:  "bastion-key",
+			Hops: []v3.BastionHop{
+				{Address: "rack-1.example.com", Port: "2222", User: "jump", SSHAgentAuth: true},
+			},
+		},
+	}
+	d, err := newDialer(host, "docker")
+	assert.Nil(t, err)
+	if assert.Len(t, d.bastionDialers, 2) {
+		assert.Equal(t, "bastion.example.com:22", d.bastionDialers[0].sshAddress)
+		assert.Equal(t, "bastion-key", d.bastionDialers[0].sshKeyString)
+		assert.Equal(t, "rack-1.example.com:2222", d.bastionDialers[1].sshAddress)
+		assert.Equal(t, "jump", d.bastionDialers[1].username)
+		assert.True(t, d.bastionDialers[1].useSSHAgentAuth)
+		assert.True(t, d.bastionDialers[1].isBastion)
+	}
+	assert.Equal(t, "10.0.0.1:22", d.sshAddress)
+	assert.False(t, d.isBastion)
+
+	// the kubernetes API is dialed from the last hop
+	chainDialer, err := newBastionChainDialer(host.BastionHost, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "rack-1.example.com:2222", chainDialer.sshAddress)
+	if assert.Len(t, chainDialer.bastionDialers, 1) {
+		assert.Equal(t, "bastion.example.com:22", chainDialer.bastionDialers[0].sshAddress)
+	}
+
+	host.BastionHost = v3.BastionHost{}
+	d, err = newDialer(host, "docker")
+	assert.Nil(t, err)
+	assert.Len(t, d.bastionDialers, 0)
+	chainDialer, err = newBastionChainDialer(host.BastionHost, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, chainDialer)
+}
@@ -0,0 +1,271 @@
+package hosts
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/rancher/rke/docker"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SSHTransport         = "ssh"
+	DockerTLSTransport   = "docker-tls"
+	DefaultDockerTLSPort = "2376"
+
+	PortForwardContainerName = "rke-port-forward"
+	portForwardTimeout       = 30 * time.Second
+)
+
+// DockerTLSFactory dials the TLS protected Docker API of the host, through the bastion host if there is one
+func DockerTLSFactory(h *Host) (func(network, address string) (net.Conn, error), error) {
+	tlsConfig, err := getDockerTLSConfig(h)
+	if err != nil {
+		return nil, err
+	}
+	bastionDialer, err := newBastionChainDialer(h.BastionHost, h.HostKeyVerifier)
+	if err != nil {
+		return nil, err
+	}
+	port := DefaultDockerTLSPort
+	if h.DockerTLS.Port != "" {
+		port = h.DockerTLS.Port
+	}
+	dockerAddress := net.JoinHostPort(h.Address, port)
+	return func(network, address string) (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		if bastionDialer != nil {
+			conn, err = bastionDialer.Dial("tcp", dockerAddress)
+		} else {
+			conn, err = net.DialTimeout("tcp", dockerAddress, DockerDialerTimeout*time.Second)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to dial the Docker API [%s] of host [%s]: %v", dockerAddress, h.Address, err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Failed to establish TLS connection to the Docker API [%s] of host [%s]: %v", dockerAddress, h.Address, err)
+		}
+		return tlsConn, nil
+	}, nil
+}
+
+func getDockerTLSConfig(h *Host) (*tls.Config, error) {
+	if h.DockerTLS == nil {
+		return nil, fmt.Errorf("docker_tls is not configured for host [%s]", h.Address)
+	}
+	caCert, err := dockerTLSFile(h.DockerTLS.CACert, h.DockerTLS.CACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Docker TLS CA certificate of host [%s]: %v", h.Address, err)
+	}
+	cert, err := dockerTLSFile(h.DockerTLS.Cert, h.DockerTLS.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Docker TLS certificate of host [%s]: %v", h.Address, err)
+	}
+	key, err := dockerTLSFile(h.DockerTLS.Key, h.DockerTLS.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Docker TLS key of host [%s]: %v", h.Address, err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM([]byte(caCert)) {
+		return nil, fmt.Errorf("Failed to parse Docker TLS CA certificate of host [%s]", h.Address)
+	}
+	keyPair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse Docker TLS certificate and key of host [%s]: %v", h.Address, err)
+	}
+	serverName := h.DockerTLS.ServerName
+	if serverName == "" {
+		serverName = h.Address
+	}
+	return &tls.Config{
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{keyPair},
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func dockerTLSFile(content, path string) (string, error) {
+	if content != "" {
+		return content, nil
+	}
+	if path == "" {
+		return "", fmt.Errorf("neither the content nor the path is set")
+	}
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(userHome(), path[2:])
+	}
+	buff, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(buff), nil
+}
+
+// dockerPortForwarder emulates the connections from the host of the SSH transport with containers in the host network
+// namespace, the connection is forwarded by nc over the attached streams of the container
+type dockerPortForwarder struct {
+	dClient   docker.ContainerRuntime
+	hostname  string
+	image     string
+	prsMap    map[string]v3.PrivateRegistry
+	mu        sync.Mutex
+	imageUsed bool
+}
+
+func newDockerPortForwarder(h *Host) (*dockerPortForwarder, error) {
+	if h.DClient == nil {
+		return nil, fmt.Errorf("Failed to forward connections on host [%s]: Docker API of the host is not connected", h.Address)
+	}
+	if h.PortForwardImage == "" {
+		return nil, fmt.Errorf("Failed to forward connections on host [%s]: port forward image is not set", h.Address)
+	}
+	return &dockerPortForwarder{
+		dClient:  h.DClient,
+		hostname: h.Address,
+		image:    h.PortForwardImage,
+		prsMap:   h.PortForwardRegistries,
+	}, nil
+}
+
+func (f *dockerPortForwarder) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("Failed to dial address [%s] on host [%s]: network [%s] is not supported by the docker-tls transport", addr, f.hostname, network)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to dial address [%s] on host [%s]: %v", addr, f.hostname, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), portForwardTimeout)
+	defer cancel()
+	if err := f.useImage(ctx); err != nil {
+		return nil, err
+	}
+	containerName := fmt.Sprintf("%s-%s", PortForwardContainerName, randomSuffix())
+	imageCfg := &container.Config{
+		Image:       f.image,
+		Cmd:         []string{"nc", host, port},
+		OpenStdin:   true,
+		StdinOnce:   true,
+		AttachStdin: true,
+	}
+	hostCfg := &container.HostConfig{
+		NetworkMode: "host",
+		AutoRemove:  true,
+	}
+	created, err := docker.CreateContainer(ctx, f.dClient, f.hostname, containerName, imageCfg, hostCfg)
+	if err != nil {
+		return nil, err
+	}
+	remove := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), portForwardTimeout)
+		defer cancel()
+		if err := f.dClient.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+			logrus.Debugf("[dialer] Failed to remove port forward container [%s] on host [%s]: %v", containerName, f.hostname, err)
+		}
+	}
+	// attach before starting the container to not miss the output
+	hijacked, err := f.dClient.ContainerAttach(ctx, created.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		remove()
+		return nil, fmt.Errorf("Failed to attach to port forward container [%s] on host [%s]: %v", containerName, f.hostname, err)
+	}
+	if err := f.dClient.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		hijacked.Close()
+		remove()
+		return nil, fmt.Errorf("Failed to start port forward container [%s] on host [%s]: %v", containerName, f.hostname, err)
+	}
+	logrus.Debugf("[dialer] Forwarding connection to [%s] on host [%s] with container [%s]", addr, f.hostname, containerName)
+	return newPortForwardConn(hijacked, addr, remove), nil
+}
+
+func (f *dockerPortForwarder) useImage(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.imageUsed {
+		return nil
+	}
+	if err := docker.UseLocalOrPull(ctx, f.dClient, f.hostname, f.image, PortForwardContainerName, f.prsMap); err != nil {
+		return err
+	}
+	f.imageUsed = true
+	return nil
+}
+
+func randomSuffix() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// portForwardConn writes to the stdin of the port forward container and reads its demultiplexed stdout
+type portForwardConn struct {
+	net.Conn
+	hijacked types.HijackedResponse
+	reader   *io.PipeReader
+	remove   func()
+	once     sync.Once
+}
+
+func newPortForwardConn(hijacked types.HijackedResponse, addr string, remove func()) *portForwardConn {
+	reader, writer := io.Pipe()
+	go func() {
+		var stderr bytes.Buffer
+		_, err := stdcopy.StdCopy(writer, &stderr, hijacked.Reader)
+		if err == nil && stderr.Len() > 0 {
+			err = fmt.Errorf("Failed to dial address [%s]: %s", addr, strings.TrimSpace(stderr.String()))
+		}
+		// a nil error is an EOF for the reader
+		writer.CloseWithError(err)
+	}()
+	return &portForwardConn{
+		Conn:     hijacked.Conn,
+		hijacked: hijacked,
+		reader:   reader,
+		remove:   remove,
+	}
+}
+
+func (c *portForwardConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *portForwardConn) CloseWrite() error {
+	return c.hijacked.CloseWrite()
+}
+
+func (c *portForwardConn) Close() error {
+	c.once.Do(func() {
+		c.hijacked.Close()
+		c.reader.Close()
+		c.remove()
+	})
+	return nil
+}
@@ -0,0 +1,154 @@
+package hosts
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/rancher/rke/docker/dockertest"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClientCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rke"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+func TestDockerTLSFactory(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	cert, key := newTestClientCert(t)
+
+	h := &Host{RKEConfigNode: v3.RKEConfigNode{
+		Address:   host,
+		Transport: DockerTLSTransport,
+		DockerTLS: &v3.DockerTLSConfig{Port: port, CACert: caCert, Cert: cert, Key: key},
+	}}
+	httpClient, err := h.newHTTPClient(nil)
+	assert.Nil(t, err)
+	// the Docker API address is dialed whatever the requested address is
+	resp, err := httpClient.Get("http://docker/_ping")
+	if assert.Nil(t, err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "OK", string(body))
+	}
+
+	// the certificate of the Docker API is verified
+	h.DockerTLS.ServerName = "docker.example.org"
+	dial, err := DockerTLSFactory(h)
+	assert.Nil(t, err)
+	_, err = dial("tcp", "")
+	assert.NotNil(t, err)
+
+	h.DockerTLS.Key = ""
+	_, err = DockerTLSFactory(h)
+	assert.NotNil(t, err)
+}
+
+func TestPortForwardConn(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	removed := false
+	conn := newPortForwardConn(types.HijackedResponse{Conn: clientConn, Reader: bufio.NewReader(clientConn)}, "127.0.0.1:10250", func() {
+		removed = true
+	})
+	// the container echoes its stdin on the multiplexed stdout
+	go func() {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(serverConn, buf); err != nil {
+			return
+		}
+		stdcopy.NewStdWriter(serverConn, stdcopy.Stdout).Write(buf)
+		serverConn.Close()
+	}()
+	_, err := conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	reply, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(reply))
+	assert.Nil(t, conn.Close())
+	assert.True(t, removed)
+
+	// the errors of nc are returned by the reads
+	clientConn, serverConn = net.Pipe()
+	conn = newPortForwardConn(types.HijackedResponse{Conn: clientConn, Reader: bufio.NewReader(clientConn)}, "127.0.0.1:10250", func() {})
+	go func() {
+		stdcopy.NewStdWriter(serverConn, stdcopy.Stderr).Write([]byte("nc: can't connect to remote host (127.0.0.1): Connection refused\n"))
+		serverConn.Close()
+	}()
+	_, err = ioutil.ReadAll(conn)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Connection refused")
+	}
+	conn.Close()
+}
+
+func TestDockerPortForwarder(t *testing.T) {
+	runtime := dockertest.NewRuntime("10.0.0.1", nil)
+	var dialed []string
+	// nc echoes what it reads
+	runtime.AttachHandler = func(c dockertest.Container, conn net.Conn) {
+		dialed = append(dialed, strings.Join(c.Config.Cmd, " "))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		stdcopy.NewStdWriter(conn, stdcopy.Stdout).Write(buf)
+		conn.Close()
+	}
+	h := &Host{RKEConfigNode: v3.RKEConfigNode{Address: "10.0.0.1"}, DClient: runtime}
+	h.PortForwardImage = "rancher/rke-tools:v0.1.78"
+	forwarder, err := newDockerPortForwarder(h)
+	assert.Nil(t, err)
+	conn, err := forwarder.Dial("tcp", "127.0.0.1:10250")
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	reply, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(reply))
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, []string{"nc 127.0.0.1 10250"}, dialed)
+	// the auto removed container is gone
+	assert.Empty(t, runtime.ContainerNames())
+}
@@ -0,0 +1,174 @@
+package hosts
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sha256FingerprintPrefix = "SHA256:"
+	md5FingerprintPrefix    = "MD5:"
+
+	stateHostKeySource = "sshHostKeys of the state file"
+)
+
+// HostKeyVerifier verifies the SSH host keys of the nodes and the bastion host against the pinned fingerprints, the
+// known_hosts file and the host keys recorded on first use
+type HostKeyVerifier struct {
+	// KnownHostsPath is an OpenSSH known_hosts file, optional
+	KnownHostsPath string
+	// LoadKeys returns the fingerprints recorded on first use by normalized address
+	LoadKeys func() (map[string]string, error)
+	// SaveKey records the fingerprint of a host connected to for the first time
+	SaveKey func(address, fingerprint string) error
+
+	once       sync.Once
+	loadErr    error
+	knownHosts ssh.HostKeyCallback
+	mu         sync.Mutex
+	keys       map[string]string
+}
+
+// HostKeyMismatchError is returned when a host presents another key than the trusted one
+type HostKeyMismatchError struct {
+	Address  string
+	Source   string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	fix := "update the " + e.Source
+	if e.Source == stateHostKeySource {
+		fix = fmt.Sprintf("accept the new key with 'rke util accept-ssh-host-key --address %s --fingerprint %s'", e.Address, e.Actual)
+	}
+	return fmt.Sprintf("SSH host key of host [%s] does not match the %s: expected [%s], got [%s]. The connection may be intercepted, if the host was reinstalled %s",
+		e.Address, e.Source, e.Expected, e.Actual, fix)
+}
+
+// NormalizeHostKeyAddress returns the address the SSH host keys are recorded with, the port is only kept if it isn't 22
+func NormalizeHostKeyAddress(address string) string {
+	return knownhosts.Normalize(address)
+}
+
+// ValidateHostKeyFingerprint checks the format of a pinned fingerprint, SHA256:<base64> or MD5:<hex pairs>
+func ValidateHostKeyFingerprint(fingerprint string) error {
+	switch {
+	case strings.HasPrefix(fingerprint, sha256FingerprintPrefix) && len(fingerprint) > len(sha256FingerprintPrefix):
+		return nil
+	case strings.HasPrefix(fingerprint, md5FingerprintPrefix) && len(strings.Split(strings.TrimPrefix(fingerprint, md5FingerprintPrefix), ":")) == 16:
+		return nil
+	}
+	return fmt.Errorf("fingerprint [%s] is not in the SHA256:<base64> or MD5:<hex> format of ssh-keygen -l", fingerprint)
+}
+
+func fingerprintMatches(key ssh.PublicKey, fingerprint string) bool {
+	if strings.HasPrefix(fingerprint, md5FingerprintPrefix) {
+		return strings.EqualFold(ssh.FingerprintLegacyMD5(key), strings.TrimPrefix(fingerprint, md5FingerprintPrefix))
+	}
+	return ssh.FingerprintSHA256(key) == fingerprint
+}
+
+// hostKeyCallback returns the callback verifying a host, the host keys are not verified without pinned fingerprint
+// and verifier
+func hostKeyCallback(verifier *HostKeyVerifier, pinnedFingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		address := knownhosts.Normalize(hostname)
+		if pinnedFingerprint != "" {
+			if !fingerprintMatches(key, pinnedFingerprint) {
+				return &HostKeyMismatchError{Address: address, Source: "ssh_host_key", Expected: pinnedFingerprint, Actual: ssh.FingerprintSHA256(key)}
+			}
+			return nil
+		}
+		if verifier == nil {
+			return nil
+		}
+		return verifier.verify(hostname, remote, key)
+	}
+}
+
+func (v *HostKeyVerifier) load() error {
+	v.once.Do(func() {
+		if v.KnownHostsPath != "" {
+			path := v.KnownHostsPath
+			if strings.HasPrefix(path, "~/") {
+				path = filepath.Join(userHome(), path[2:])
+			}
+			if v.knownHosts, v.loadErr = knownhosts.New(path); v.loadErr != nil {
+				v.loadErr = fmt.Errorf("Failed to read SSH known hosts file [%s]: %v", v.KnownHostsPath, v.loadErr)
+				return
+			}
+		}
+		if v.LoadKeys != nil {
+			v.keys, v.loadErr = v.LoadKeys()
+		}
+		if v.keys == nil {
+			v.keys = map[string]string{}
+		}
+	})
+	return v.loadErr
+}
+
+// Keys returns the fingerprints recorded on first use and the ones trusted during this run, they're written to the
+// state file when it didn't exist yet when the hosts were connected to
+func (v *HostKeyVerifier) Keys() map[string]string {
+	if v == nil || v.load() != nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.keys) == 0 {
+		return nil
+	}
+	keys := make(map[string]string, len(v.keys))
+	for address, fingerprint := range v.keys {
+		keys[address] = fingerprint
+	}
+	return keys
+}
+
+func (v *HostKeyVerifier) verify(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if err := v.load(); err != nil {
+		return err
+	}
+	address := knownhosts.Normalize(hostname)
+	fingerprint := ssh.FingerprintSHA256(key)
+	if v.knownHosts != nil {
+		err := v.knownHosts(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return fmt.Errorf("SSH host key of host [%s] is rejected by the known hosts file [%s]: %v", address, v.KnownHostsPath, err)
+		}
+		if len(keyErr.Want) > 0 {
+			return &HostKeyMismatchError{Address: address, Source: "known hosts file " + v.KnownHostsPath, Expected: ssh.FingerprintSHA256(keyErr.Want[0].Key), Actual: fingerprint}
+		}
+		// not in the known hosts file, trusted on first use
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if recorded, ok := v.keys[address]; ok {
+		if recorded != fingerprint {
+			return &HostKeyMismatchError{Address: address, Source: stateHostKeySource, Expected: recorded, Actual: fingerprint}
+		}
+		return nil
+	}
+	logrus.Infof("[dialer] Trusting SSH host key [%s] of host [%s] on first use", fingerprint, address)
+	if v.SaveKey != nil {
+		if err := v.SaveKey(address, fingerprint); err != nil {
+			return fmt.Errorf("Failed to record SSH host key of host [%s]: %v", address, err)
+		}
+	}
+	v.keys[address] = fingerprint
+	return nil
+}
@@ -0,0 +1,82 @@
+package hosts
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	key, err := ssh.NewPublicKey(public)
+	assert.Nil(t, err)
+	return key
+}
+
+func TestHostKeyCallbackPinned(t *testing.T) {
+	key := newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("1.1.1.1"), Port: 22}
+
+	assert.Nil(t, hostKeyCallback(nil, "")("1.1.1.1:22", remote, key))
+	assert.Nil(t, hostKeyCallback(nil, ssh.FingerprintSHA256(key))("1.1.1.1:22", remote, key))
+	assert.Nil(t, hostKeyCallback(nil, "MD5:"+ssh.FingerprintLegacyMD5(key))("1.1.1.1:22", remote, key))
+
+	err := hostKeyCallback(nil, ssh.FingerprintSHA256(newTestHostKey(t)))("1.1.1.1:22", remote, key)
+	if assert.IsType(t, &HostKeyMismatchError{}, err) {
+		assert.Contains(t, err.Error(), "[1.1.1.1]")
+	}
+
+	assert.Nil(t, ValidateHostKeyFingerprint(ssh.FingerprintSHA256(key)))
+	assert.Nil(t, ValidateHostKeyFingerprint("MD5:"+ssh.FingerprintLegacyMD5(key)))
+	assert.NotNil(t, ValidateHostKeyFingerprint(ssh.FingerprintLegacyMD5(key)))
+}
+
+func TestHostKeyVerifier(t *testing.T) {
+	key := newTestHostKey(t)
+	knownKey := newTestHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("1.1.1.1"), Port: 2222}
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	assert.Nil(t, os.WriteFile(knownHostsPath, []byte(knownhosts.Line([]string{"2.2.2.2"}, knownKey)+"\n"), 0600))
+
+	saved := map[string]string{}
+	verifier := &HostKeyVerifier{
+		KnownHostsPath: knownHostsPath,
+		LoadKeys: func() (map[string]string, error) {
+			return map[string]string{"3.3.3.3": ssh.FingerprintSHA256(knownKey)}, nil
+		},
+		SaveKey: func(address, fingerprint string) error {
+			saved[address] = fingerprint
+			return nil
+		},
+	}
+	callback := hostKeyCallback(verifier, "")
+
+	// known hosts file
+	assert.Nil(t, callback("2.2.2.2:22", remote, knownKey))
+	assert.IsType(t, &HostKeyMismatchError{}, callback("2.2.2.2:22", remote, key))
+	// recorded in the state file
+	assert.Nil(t, callback("3.3.3.3:22", remote, knownKey))
+	assert.IsType(t, &HostKeyMismatchError{}, callback("3.3.3.3:22", remote, key))
+	// trusted on first use
+	assert.Nil(t, callback("1.1.1.1:2222", remote, key))
+	assert.Equal(t, map[string]string{"[1.1.1.1]:2222": ssh.FingerprintSHA256(key)}, saved)
+	assert.Nil(t, callback("1.1.1.1:2222", remote, key))
+	err := callback("1.1.1.1:2222", remote, knownKey)
+	if assert.IsType(t, &HostKeyMismatchError{}, err) {
+		assert.Contains(t, err.Error(), "[[1.1.1.1]:2222]")
+		assert.Contains(t, err.Error(), "rke util accept-ssh-host-key --address [1.1.1.1]:2222 --fingerprint "+ssh.FingerprintSHA256(knownKey))
+	}
+	// the keys trusted before the state file existed are written with the first state
+	assert.Equal(t, map[string]string{"3.3.3.3": ssh.FingerprintSHA256(knownKey), "[1.1.1.1]:2222": ssh.FingerprintSHA256(key)}, verifier.Keys())
+	assert.Nil(t, (*HostKeyVerifier)(nil).Keys())
+	// a pinned fingerprint takes precedence
+	assert.Nil(t, hostKeyCallback(verifier, ssh.FingerprintSHA256(knownKey))("1.1.1.1:2222", remote, knownKey))
+}
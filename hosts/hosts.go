@@ -10,7 +10,6 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/sirupsen/logrus"
 
-	"github.com/docker/docker/client"
 	"github.com/rancher/rke/docker"
 	"github.com/rancher/rke/k8s"
 	"github.com/rancher/rke/log"
@@ -21,7 +20,7 @@ import (
 
 type Host struct {
 	v3.RKEConfigNode
-	DClient             *client.Client
+	DClient             docker.ContainerRuntime
 	LocalConnPort       int
 	IsControl           bool
 	IsWorker            bool
@@ -38,6 +37,11 @@ type Host struct {
 	UpdateWorker        bool
 	PrefixPath          string
 	BastionHost         v3.BastionHost
+	WorkerDeployed      bool
+	HostKeyVerifier     *HostKeyVerifier
+	// PortForwardImage runs the containers forwarding the local connections of the docker-tls transport
+	PortForwardImage      string
+	PortForwardRegistries map[string]v3.PrivateRegistry
 }
 
 const (
@@ -0,0 +1,193 @@
+package hosts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	SSHKeyPassphraseEnv = "RKE_SSH_KEY_PASSPHRASE"
+
+	SSHKeyCommandAddressEnv  = "RKE_NODE_ADDRESS"
+	SSHKeyCommandHostnameEnv = "RKE_NODE_HOSTNAME"
+	SSHKeyCommandUserEnv     = "RKE_NODE_USER"
+
+	sshKeyCommandTimeout = 60 * time.Second
+	// the certificates returned by the key command are renewed before they expire
+	sshCertRenewBefore = time.Minute
+)
+
+var errNoTerminal = errors.New("stdin is not a terminal")
+
+// readPassphrase prompts for the passphrase on the terminal
+var readPassphrase = func(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errNoTerminal
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	return terminal.ReadPassword(fd)
+}
+
+// sshKeyPassphrases caches the passphrases by key so a key shared by the hosts is unlocked once
+var sshKeyPassphrases = struct {
+	sync.Mutex
+	byKey map[string][]byte
+}{byKey: map[string][]byte{}}
+
+// sshKeyCommandOutputs caches the keys returned by the key commands until the certificates are about to expire
+var sshKeyCommandOutputs = struct {
+	sync.Mutex
+	byCommand map[string]sshKeyCommandOutput
+}{byCommand: map[string]sshKeyCommandOutput{}}
+
+// keyPassphrase unlocks the encrypted SSH key of a host with the saved passphrase, the passphrase command, the
+// RKE_SSH_KEY_PASSPHRASE environment variable or the terminal prompt in that order
+type keyPassphrase struct {
+	address string
+	saved   string
+	command string
+	env     []string
+}
+
+type sshKeyCommandOutput struct {
+	SSHKey  string `json:"ssh_key"`
+	SSHCert string `json:"ssh_cert"`
+	expires time.Time
+}
+
+func newKeyPassphrase(h *Host) *keyPassphrase {
+	return &keyPassphrase{
+		address: h.Address,
+		saved:   h.SavedKeyPhrase,
+		command: h.SSHKeyPassphraseCommand,
+		env:     sshKeyCommandEnv(h),
+	}
+}
+
+func sshKeyID(keyString string) string {
+	sum := sha256.Sum256([]byte(keyString))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *keyPassphrase) get(keyString string) ([]byte, error) {
+	id := sshKeyID(keyString)
+	sshKeyPassphrases.Lock()
+	defer sshKeyPassphrases.Unlock()
+	if passphrase, ok := sshKeyPassphrases.byKey[id]; ok {
+		return passphrase, nil
+	}
+	var passphrase []byte
+	switch {
+	case p.saved != "":
+		passphrase = []byte(p.saved)
+	case p.command != "":
+		output, err := runSSHKeyCommand(p.command, p.env)
+		if err != nil {
+			return nil, fmt.Errorf("ssh_key_passphrase_command failed: %v", err)
+		}
+		passphrase = bytes.TrimRight(output, "\r\n")
+	case os.Getenv(SSHKeyPassphraseEnv) != "":
+		passphrase = []byte(os.Getenv(SSHKeyPassphraseEnv))
+	default:
+		var err error
+		passphrase, err = readPassphrase(fmt.Sprintf("Enter passphrase for the SSH key of host [%s]: ", p.address))
+		if err == errNoTerminal {
+			return nil, fmt.Errorf("the SSH key is passphrase protected, configure ssh_key_passphrase_command or set %s", SSHKeyPassphraseEnv)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the passphrase: %v", err)
+		}
+	}
+	sshKeyPassphrases.byKey[id] = passphrase
+	return passphrase, nil
+}
+
+func (p *keyPassphrase) forget(keyString string) {
+	sshKeyPassphrases.Lock()
+	defer sshKeyPassphrases.Unlock()
+	delete(sshKeyPassphrases.byKey, sshKeyID(keyString))
+}
+
+// getSSHKeyFromCommand returns the private key and the optional certificate printed by the key command, either a PEM
+// private key or a JSON object with the ssh_key and ssh_cert fields
+func getSSHKeyFromCommand(command string, env []string) (string, string, error) {
+	cacheKey := strings.Join(append([]string{command}, env...), "\x00")
+	sshKeyCommandOutputs.Lock()
+	defer sshKeyCommandOutputs.Unlock()
+	if cached, ok := sshKeyCommandOutputs.byCommand[cacheKey]; ok && (cached.expires.IsZero() || time.Now().Before(cached.expires)) {
+		return cached.SSHKey, cached.SSHCert, nil
+	}
+	output, err := runSSHKeyCommand(command, env)
+	if err != nil {
+		return "", "", fmt.Errorf("ssh_key_command failed: %v", err)
+	}
+	var result sshKeyCommandOutput
+	if trimmed := bytes.TrimSpace(output); bytes.HasPrefix(trimmed, []byte("{")) {
+		if err := json.Unmarshal(trimmed, &result); err != nil {
+			return "", "", fmt.Errorf("Failed to parse the output of ssh_key_command: %v", err)
+		}
+	} else {
+		result.SSHKey = string(output)
+	}
+	if strings.TrimSpace(result.SSHKey) == "" {
+		return "", "", fmt.Errorf("ssh_key_command returned no SSH private key")
+	}
+	if result.SSHCert != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(result.SSHCert))
+		if err != nil {
+			return "", "", fmt.Errorf("Unable to parse SSH certificate returned by ssh_key_command: %v", err)
+		}
+		if cert, ok := key.(*ssh.Certificate); ok && cert.ValidBefore != ssh.CertTimeInfinity {
+			result.expires = time.Unix(int64(cert.ValidBefore), 0).Add(-sshCertRenewBefore)
+		}
+	}
+	sshKeyCommandOutputs.byCommand[cacheKey] = result
+	return result.SSHKey, result.SSHCert, nil
+}
+
+func runSSHKeyCommand(command string, env []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sshKeyCommandTimeout)
+	defer cancel()
+	args, err := shlex.Split(command)
+	if err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("Failed to parse command [%s]: %v", command, err)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	logrus.Debugf("[dialer] Running SSH key command [%s]", command)
+	output, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+func sshKeyCommandEnv(h *Host) []string {
+	return []string{
+		fmt.Sprintf("%s=%s", SSHKeyCommandAddressEnv, h.Address),
+		fmt.Sprintf("%s=%s", SSHKeyCommandHostnameEnv, h.HostnameOverride),
+		fmt.Sprintf("%s=%s", SSHKeyCommandUserEnv, h.User),
+	}
+}
@@ -0,0 +1,240 @@
+package hosts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestEncryptedSSHKey(t *testing.T, passphrase string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte(passphrase), x509.PEMCipherAES256)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(block))
+}
+
+func resetSSHKeySources(t *testing.T) {
+	prompt := readPassphrase
+	t.Cleanup(func() {
+		readPassphrase = prompt
+		sshKeyPassphrases.byKey = map[string][]byte{}
+		sshKeyCommandOutputs.byCommand = map[string]sshKeyCommandOutput{}
+	})
+	sshKeyPassphrases.byKey = map[string][]byte{}
+	sshKeyCommandOutputs.byCommand = map[string]sshKeyCommandOutput{}
+}
+
+func TestParsePrivateKeyPassphrase(t *testing.T) {
+	resetSSHKeySources(t)
+	key := newTestEncryptedSSHKey(t, "secret")
+	prompts := 0
+	readPassphrase = func(string) ([]byte, error) {
+		prompts++
+		return []byte("secret"), nil
+	}
+
+	// the passphrase is prompted once per key
+	_, err := parsePrivateKey(key, &keyPassphrase{address: "1.1.1.1"})
+	assert.Nil(t, err)
+	_, err = parsePrivateKey(key, &keyPassphrase{address: "2.2.2.2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, prompts)
+
+	// a wrong passphrase is not kept
+	key = newTestEncryptedSSHKey(t, "secret")
+	_, err = parsePrivateKey(key, &keyPassphrase{address: "1.1.1.1", command: "echo wrong"})
+	assert.NotNil(t, err)
+	_, err = parsePrivateKey(key, &keyPassphrase{address: "1.1.1.1", command: "echo secret"})
+	assert.Nil(t, err)
+
+	key = newTestEncryptedSSHKey(t, "secret")
+	os.Setenv(SSHKeyPassphraseEnv, "secret")
+	defer os.Unsetenv(SSHKeyPassphraseEnv)
+	_, err = parsePrivateKey(key, &keyPassphrase{address: "1.1.1.1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, prompts)
+
+	// without terminal
+	readPassphrase = func(string) ([]byte, error) {
+		return nil, errNoTerminal
+	}
+	os.Unsetenv(SSHKeyPassphraseEnv)
+	_, err = parsePrivateKey(newTestEncryptedSSHKey(t, "secret"), &keyPassphrase{address: "1.1.1.1"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), SSHKeyPassphraseEnv)
+	}
+}
+
+func TestGetSSHKeyFromCommand(t *testing.T) {
+	resetSSHKeySources(t)
+	key := newTestSSHKey(t)
+	dir, err := ioutil.TempDir("", "ssh-key-command")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte(key), 0600))
+	runs := filepath.Join(dir, "runs")
+
+	h := &Host{}
+	h.Address = "1.1.1.1"
+	h.HostnameOverride = "node1"
+	h.User = "rke"
+	h.SSHKeyCommand = fmt.Sprintf("sh -c 'echo $%s >> %s; cat %s'", SSHKeyCommandHostnameEnv, runs, keyFile)
+	d, err := newDialer(h, "docker")
+	assert.Nil(t, err)
+	sshKey, _, err := d.getSSHKey()
+	assert.Nil(t, err)
+	assert.Equal(t, key, sshKey)
+	_, err = newDialer(h, "network")
+	assert.Nil(t, err)
+	// the output of the command is reused
+	output, err := ioutil.ReadFile(runs)
+	assert.Nil(t, err)
+	assert.Equal(t, "node1\n", string(output))
+
+	jsonKey := strings.Replace(strings.TrimSpace(key), "\n", "\\n", -1)
+	jsonFile := filepath.Join(dir, "key.json")
+	assert.Nil(t, ioutil.WriteFile(jsonFile, []byte(fmt.Sprintf(`{"ssh_key": "%s"}`, jsonKey)), 0600))
+	h.SSHKeyCommand = "cat " + jsonFile
+	d, err = newDialer(h, "docker")
+	assert.Nil(t, err)
+	sshKey, _, err = d.getSSHKey()
+	assert.Nil(t, err)
+	assert.Equal(t, strings.TrimSpace(key), sshKey)
+
+	h.SSHKeyCommand = "sh -c 'echo denied >&2; exit 1'"
+	_, err = newDialer(h, "docker")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "denied")
+	}
+
+	// the command is split like the other exec plugins, it's not run by a shell
+	h.SSHKeyCommand = fmt.Sprintf("cat %s; echo", keyFile)
+	_, err = newDialer(h, "docker")
+	assert.NotNil(t, err)
+
+	h.SSHKeyCommand = "cat 'unterminated"
+	_, err = newDialer(h, "docker")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "Failed to parse command")
+	}
+}
+
+func TestBastionHopSSHKeyCommand(t *testing.T) {
+	resetSSHKeySources(t)
+	key := newTestSSHKey(t)
+	dir, err := ioutil.TempDir("", "ssh-key-command")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte(key), 0600))
+
+	host := &Host{
+		RKEConfigNode: v3.RKEConfigNode{Address: "10.0.0.1", Port: "22", SSHKey: "node-key"},
+		BastionHost: v3.BastionHost{
+			Address:                 "bastion.example.com",
+			Port:                    "22",
+			User:                    "jump",
+			SSHKeyCommand:           fmt.Sprintf("sh -c 'test $%s = bastion.example.com && cat %s'", SSHKeyCommandAddressEnv, keyFile),
+			SSHKeyPassphraseCommand: "echo bastion",
+			Hops: []v3.BastionHop{
+				{
+					Address:                 "rack-1.example.com",
+					Port:                    "22",
+					User:                    "jump",
+					SSHKeyCommand:           fmt.Sprintf("sh -c 'test $%s = jump && cat %s'", SSHKeyCommandUserEnv, keyFile),
+					SSHKeyPassphraseCommand: "echo rack",
+				},
+			},
+		},
+	}
+	d, err := newDialer(host, "docker")
+	assert.Nil(t, err)
+	if assert.Len(t, d.bastionDialers, 2) {
+		for _, bastionDialer := range d.bastionDialers {
+			sshKey, _, err := bastionDialer.getSSHKey()
+			assert.Nil(t, err)
+			assert.Equal(t, key, sshKey)
+		}
+		assert.Equal(t, "echo bastion", d.bastionDialers[0].keyPassphrase.command)
+		assert.Equal(t, "echo rack", d.bastionDialers[1].keyPassphrase.command)
+	}
+
+	// the encrypted key of a hop is unlocked with its passphrase command
+	_, err = parsePrivateKey(newTestEncryptedSSHKey(t, "rack"), d.bastionDialers[1].keyPassphrase)
+	assert.Nil(t, err)
+
+	host.BastionHost.Hops[0].SSHKeyCommand = "sh -c 'echo denied >&2; exit 1'"
+	_, err = newDialer(host, "docker")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "rack-1.example.com")
+	}
+}
+
+func TestSSHKeyCommandCertificateRenewal(t *testing.T) {
+	resetSSHKeySources(t)
+	useTestSSHPool(t)
+	sshAddress, connections := startTestSSHServer(t)
+	echoAddress := startTestEchoServer(t)
+	dir := t.TempDir()
+	// the certificate returned by the command expires before the next handshake
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	assert.Nil(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	publicKey, err := ssh.NewPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+	certificate := &ssh.Certificate{
+		Key:             publicKey,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"rke"},
+		ValidBefore:     uint64(time.Now().Add(sshCertRenewBefore / 2).Unix()),
+	}
+	assert.Nil(t, certificate.SignCert(rand.Reader, caSigner))
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	output, err := json.Marshal(sshKeyCommandOutput{
+		SSHKey:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		SSHCert: string(ssh.MarshalAuthorizedKey(certificate)),
+	})
+	assert.Nil(t, err)
+	outputFile := filepath.Join(dir, "output")
+	assert.Nil(t, ioutil.WriteFile(outputFile, output, 0600))
+	runs := filepath.Join(dir, "runs")
+
+	host, port, _ := net.SplitHostPort(sshAddress)
+	h := &Host{RKEConfigNode: v3.RKEConfigNode{Address: host, Port: port, User: "rke", SSHKeyCommand: fmt.Sprintf("sh -c 'echo >> %s; cat %s'", runs, outputFile)}}
+	d, err := newDialer(h, "network")
+	assert.Nil(t, err)
+	assertEcho(t, d.Dial, echoAddress)
+	conn, err := d.getSSHConn()
+	assert.Nil(t, err)
+	conn.close()
+	assertEcho(t, d.Dial, echoAddress)
+	assert.Equal(t, int32(2), atomic.LoadInt32(connections))
+	// the dialer checked the command, then both handshakes got a new certificate
+	runsOutput, err := ioutil.ReadFile(runs)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(runsOutput), "\n"))
+}
@@ -0,0 +1,288 @@
+package hosts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const sshKeepAliveRequest = "keepalive@openssh.com"
+
+var (
+	sshKeepAliveInterval = 30 * time.Second
+	// sshIdleTimeout closes the connections without open channels, the connections to the hosts behind a jump host
+	// are channels of the connection to the jump host
+	sshIdleTimeout = 5 * time.Minute
+)
+
+// sshPool shares the SSH connections to the hosts and the bastion hosts between the dialers of all the phases, a
+// connection is only established once per host and credentials. The connections are closed when the last command
+// using the pool releases it.
+var sshPool = newSSHConnPool()
+
+// SSHConnStats counts the SSH handshakes and the reuses of the pooled connection of a host
+type SSHConnStats struct {
+	Handshakes int
+	Reused     int
+	Reconnects int
+}
+
+type sshConnPool struct {
+	mu sync.Mutex
+	// users counts the commands using the pool
+	users   int
+	entries map[string]*sshPoolEntry
+	stats   map[string]*SSHConnStats
+}
+
+type sshPoolEntry struct {
+	// serializes the handshakes to the same host
+	mu sync.Mutex
+	// conn is set holding both the entry and the pool lock, it's read holding either
+	conn *pooledSSHConn
+}
+
+type pooledSSHConn struct {
+	pool     *sshConnPool
+	key      string
+	address  string
+	client   *ssh.Client
+	done     chan struct{}
+	once     sync.Once
+	active   int32
+	lastUsed int64
+}
+
+type pooledChannelConn struct {
+	net.Conn
+	once   sync.Once
+	parent *pooledSSHConn
+}
+
+func newSSHConnPool() *sshConnPool {
+	return &sshConnPool{
+		entries: map[string]*sshPoolEntry{},
+		stats:   map[string]*SSHConnStats{},
+	}
+}
+
+// get returns the pooled connection of the key, or establishes it with connect
+func (p *sshConnPool) get(key, address string, connect func() (*ssh.Client, error)) (*pooledSSHConn, error) {
+	p.mu.Lock()
+	entry, ok := p.entries[key]
+	if !ok {
+		entry = &sshPoolEntry{}
+		p.entries[key] = entry
+	}
+	p.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.conn != nil && entry.conn.alive() {
+		atomic.StoreInt64(&entry.conn.lastUsed, time.Now().UnixNano())
+		p.count(address, func(stats *SSHConnStats) { stats.Reused++ })
+		return entry.conn, nil
+	}
+	client, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	p.count(address, func(stats *SSHConnStats) { stats.Handshakes++ })
+	logrus.Debugf("[dialer] Established SSH connection to [%s]", address)
+	conn := &pooledSSHConn{
+		pool:     p,
+		key:      key,
+		address:  address,
+		client:   client,
+		done:     make(chan struct{}),
+		lastUsed: time.Now().UnixNano(),
+	}
+	p.mu.Lock()
+	entry.conn = conn
+	p.mu.Unlock()
+	go func() {
+		// the connection is closed by the remote end or by the network
+		_ = client.Wait()
+		conn.close()
+	}()
+	go conn.keepAlive()
+	return conn, nil
+}
+
+func (p *sshConnPool) count(address string, update func(*SSHConnStats)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats, ok := p.stats[address]
+	if !ok {
+		stats = &SSHConnStats{}
+		p.stats[address] = stats
+	}
+	update(stats)
+}
+
+// UseSSHConnPool registers a command using the pooled SSH connections, the returned function releases the pool. When
+// the last command releases it, the statistics are logged and the connections are closed, so library users running
+// several clusters in the same process don't keep them open.
+func UseSSHConnPool() func() {
+	sshPool.mu.Lock()
+	sshPool.users++
+	sshPool.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(sshPool.release)
+	}
+}
+
+func (p *sshConnPool) release() {
+	p.mu.Lock()
+	p.users--
+	if p.users > 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.users = 0
+	stats := p.statsLocked()
+	conns := p.resetLocked()
+	p.mu.Unlock()
+	logSSHConnStats(stats)
+	for _, conn := range conns {
+		conn.close()
+	}
+}
+
+func (p *sshConnPool) closeAll() {
+	p.mu.Lock()
+	conns := p.resetLocked()
+	p.mu.Unlock()
+	for _, conn := range conns {
+		conn.close()
+	}
+}
+
+// resetLocked empties the pool and returns its connections, the connections established from then on are pooled again
+func (p *sshConnPool) resetLocked() []*pooledSSHConn {
+	var conns []*pooledSSHConn
+	for _, entry := range p.entries {
+		if entry.conn != nil {
+			conns = append(conns, entry.conn)
+		}
+	}
+	p.entries = map[string]*sshPoolEntry{}
+	p.stats = map[string]*SSHConnStats{}
+	return conns
+}
+
+// remove drops the entry of a closed connection, the entries of the hosts that are not used anymore don't pile up
+func (p *sshConnPool) remove(conn *pooledSSHConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.entries[conn.key]; ok && entry.conn == conn {
+		delete(p.entries, conn.key)
+	}
+}
+
+func (p *sshConnPool) statsLocked() map[string]SSHConnStats {
+	stats := make(map[string]SSHConnStats, len(p.stats))
+	for address, hostStats := range p.stats {
+		stats[address] = *hostStats
+	}
+	return stats
+}
+
+func (c *pooledSSHConn) alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *pooledSSHConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.client.Close()
+		c.pool.remove(c)
+		logrus.Debugf("[dialer] Closed SSH connection to [%s]", c.address)
+	})
+}
+
+// dial opens a channel to the address on the remote end of the connection
+func (c *pooledSSHConn) dial(network, addr string) (net.Conn, error) {
+	conn, err := c.client.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&c.active, 1)
+	atomic.StoreInt64(&c.lastUsed, time.Now().UnixNano())
+	return &pooledChannelConn{Conn: conn, parent: c}, nil
+}
+
+func (c *pooledSSHConn) keepAlive() {
+	interval, idleTimeout := sshKeepAliveInterval, sshIdleTimeout
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if atomic.LoadInt32(&c.active) == 0 && time.Since(time.Unix(0, atomic.LoadInt64(&c.lastUsed))) > idleTimeout {
+				logrus.Debugf("[dialer] SSH connection to [%s] is idle", c.address)
+				c.close()
+				return
+			}
+			if _, _, err := c.client.SendRequest(sshKeepAliveRequest, true, nil); err != nil {
+				logrus.Debugf("[dialer] SSH keepalive to [%s] failed: %v", c.address, err)
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+func (c *pooledChannelConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt32(&c.parent.active, -1)
+		atomic.StoreInt64(&c.parent.lastUsed, time.Now().UnixNano())
+	})
+	return c.Conn.Close()
+}
+
+// sshPoolKey identifies the connections established with the same credentials through the same jump hosts
+func sshPoolKey(parentKey string, d *dialer) string {
+	hash := sha256.New()
+	for _, value := range []string{parentKey, d.sshAddress, d.username, d.sshKeyString, d.sshCertString, d.sshKeyCommand, d.hostKeyFingerprint, fmt.Sprint(d.useSSHAgentAuth)} {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// GetSSHConnStats returns the statistics of the pooled SSH connections by host address
+func GetSSHConnStats() map[string]SSHConnStats {
+	sshPool.mu.Lock()
+	defer sshPool.mu.Unlock()
+	return sshPool.statsLocked()
+}
+
+// logSSHConnStats logs the statistics of the pooled SSH connections in debug
+func logSSHConnStats(stats map[string]SSHConnStats) {
+	var addresses []string
+	for address := range stats {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		logrus.Debugf("[dialer] SSH connections to [%s]: %d handshakes, %d reused, %d reconnects",
+			address, stats[address].Handshakes, stats[address].Reused, stats[address].Reconnects)
+	}
+}
@@ -0,0 +1,271 @@
+package hosts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestSSHServer starts an SSH server forwarding the direct-tcpip channels, it returns the server address and
+// the counter of the SSH connections
+func startTestSSHServer(t *testing.T) (string, *int32) {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	assert.Nil(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var connections int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+			go serveTestSSHConn(conn, config)
+		}
+	}()
+	return listener.Addr().String(), &connections
+}
+
+func serveTestSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+		targetConn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			targetConn.Close()
+			continue
+		}
+		go ssh.DiscardRequests(channelRequests)
+		go func() {
+			io.Copy(channel, targetConn)
+			channel.Close()
+		}()
+		go func() {
+			io.Copy(targetConn, channel)
+			targetConn.Close()
+		}()
+	}
+}
+
+func startTestEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newTestSSHKey(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func useTestSSHPool(t *testing.T) {
+	pool := sshPool
+	sshPool = newSSHConnPool()
+	t.Cleanup(func() {
+		sshPool.closeAll()
+		sshPool = pool
+	})
+}
+
+func assertEcho(t *testing.T, dial func(network, address string) (net.Conn, error), address string) {
+	conn, err := dial("tcp", address)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(reply))
+}
+
+func TestSSHConnPool(t *testing.T) {
+	useTestSSHPool(t)
+	sshAddress, connections := startTestSSHServer(t)
+	echoAddress := startTestEchoServer(t)
+	host, port, _ := net.SplitHostPort(sshAddress)
+	h := &Host{RKEConfigNode: v3.RKEConfigNode{Address: host, Port: port, SSHKey: newTestSSHKey(t)}}
+
+	dockerDialer, err := newDialer(h, "network")
+	assert.Nil(t, err)
+	healthDialer, err := newDialer(h, "health")
+	assert.Nil(t, err)
+	assertEcho(t, dockerDialer.Dial, echoAddress)
+	assertEcho(t, healthDialer.Dial, echoAddress)
+	assert.Equal(t, int32(1), atomic.LoadInt32(connections))
+	assert.Equal(t, SSHConnStats{Handshakes: 1, Reused: 1}, GetSSHConnStats()[sshAddress])
+
+	// the pooled connection is broken
+	conn, err := dockerDialer.getSSHConn()
+	assert.Nil(t, err)
+	conn.client.Close()
+	assertEcho(t, dockerDialer.Dial, echoAddress)
+	assert.Equal(t, int32(2), atomic.LoadInt32(connections))
+
+	// rejected channels don't reconnect
+	_, err = dockerDialer.Dial("tcp", "127.0.0.1:1")
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(connections))
+}
+
+func TestSSHConnPoolBastionHops(t *testing.T) {
+	useTestSSHPool(t)
+	sshAddress, connections := startTestSSHServer(t)
+	echoAddress := startTestEchoServer(t)
+	host, port, _ := net.SplitHostPort(sshAddress)
+	sshKey := newTestSSHKey(t)
+	h := &Host{
+		RKEConfigNode: v3.RKEConfigNode{Address: host, Port: port, SSHKey: sshKey},
+		BastionHost: v3.BastionHost{
+			Address: host,
+			Port:    port,
+			SSHKey:  sshKey,
+			Hops:    []v3.BastionHop{{Address: host, Port: port, SSHKey: sshKey}},
+		},
+	}
+
+	d, err := newDialer(h, "network")
+	assert.Nil(t, err)
+	assertEcho(t, d.Dial, echoAddress)
+	// the bastion host, the hop through the bastion host and the node through the hop
+	assert.Equal(t, int32(3), atomic.LoadInt32(connections))
+	assertEcho(t, d.Dial, echoAddress)
+	assert.Equal(t, int32(3), atomic.LoadInt32(connections))
+
+	// the kubernetes API dialer shares the connections to the jump hosts
+	chainDialer, err := newBastionChainDialer(h.BastionHost, nil)
+	assert.Nil(t, err)
+	assertEcho(t, chainDialer.Dial, echoAddress)
+	assert.Equal(t, int32(3), atomic.LoadInt32(connections))
+}
+
+func TestUseSSHConnPool(t *testing.T) {
+	useTestSSHPool(t)
+	sshAddress, connections := startTestSSHServer(t)
+	echoAddress := startTestEchoServer(t)
+	host, port, _ := net.SplitHostPort(sshAddress)
+	h := &Host{RKEConfigNode: v3.RKEConfigNode{Address: host, Port: port, SSHKey: newTestSSHKey(t)}}
+	d, err := newDialer(h, "network")
+	assert.Nil(t, err)
+
+	// two clusters are deployed in the same process
+	releaseFirst := UseSSHConnPool()
+	releaseSecond := UseSSHConnPool()
+	assertEcho(t, d.Dial, echoAddress)
+	conn, err := d.getSSHConn()
+	assert.Nil(t, err)
+	releaseFirst()
+	releaseFirst()
+	assert.True(t, conn.alive())
+	assertEcho(t, d.Dial, echoAddress)
+	assert.Equal(t, int32(1), atomic.LoadInt32(connections))
+
+	// the last one closes the connections and drops the statistics
+	releaseSecond()
+	assert.False(t, conn.alive())
+	assert.Empty(t, GetSSHConnStats())
+	sshPool.mu.Lock()
+	assert.Empty(t, sshPool.entries)
+	sshPool.mu.Unlock()
+
+	// a closed connection is dropped from the pool
+	assertEcho(t, d.Dial, echoAddress)
+	conn, err = d.getSSHConn()
+	assert.Nil(t, err)
+	conn.close()
+	sshPool.mu.Lock()
+	assert.Empty(t, sshPool.entries)
+	sshPool.mu.Unlock()
+}
+
+func TestSSHConnPoolIdleBastionHost(t *testing.T) {
+	useTestSSHPool(t)
+	keepAliveInterval, idleTimeout := sshKeepAliveInterval, sshIdleTimeout
+	sshKeepAliveInterval, sshIdleTimeout = 10*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() { sshKeepAliveInterval, sshIdleTimeout = keepAliveInterval, idleTimeout })
+	sshAddress, connections := startTestSSHServer(t)
+	echoAddress := startTestEchoServer(t)
+	host, port, _ := net.SplitHostPort(sshAddress)
+	sshKey := newTestSSHKey(t)
+	h := &Host{
+		RKEConfigNode: v3.RKEConfigNode{Address: host, Port: port, SSHKey: sshKey},
+		BastionHost:   v3.BastionHost{Address: host, Port: port, SSHKey: sshKey},
+	}
+	d, err := newDialer(h, "network")
+	assert.Nil(t, err)
+	conn, err := d.Dial("tcp", echoAddress)
+	if !assert.Nil(t, err) {
+		return
+	}
+	bastionConn, err := d.bastionDialers[0].getSSHConn()
+	assert.Nil(t, err)
+
+	// the bastion host connection carries the node connection, it's not idle while the node connection is used
+	time.Sleep(5 * sshIdleTimeout)
+	assert.True(t, bastionConn.alive())
+	assertEcho(t, d.Dial, echoAddress)
+	assert.Equal(t, int32(2), atomic.LoadInt32(connections))
+
+	// both are closed once the node connection is idle
+	conn.Close()
+	assert.Eventually(t, func() bool { return !bastionConn.alive() }, 5*time.Second, 10*time.Millisecond)
+}
@@ -18,10 +18,23 @@ import (
 	"golang.org/x/crypto/ssh/agent"
 )
 
-func (h *Host) TunnelUp(ctx context.Context, dialerFactory DialerFactory, clusterPrefixPath string, clusterVersion string) error {
+func (h *Host) TunnelUp(ctx context.Context, dialerFactory DialerFactory, runtimeFactory RuntimeFactory, clusterPrefixPath string, clusterVersion string) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: h.Address})
 	if h.DClient != nil {
 		return nil
 	}
+	if runtimeFactory != nil {
+		dClient, err := runtimeFactory(h)
+		if err != nil {
+			return fmt.Errorf("Can't get the container runtime of host [%s]: %v", h.Address, err)
+		}
+		h.DClient = dClient
+		if err := checkDockerVersion(ctx, h, clusterVersion); err != nil {
+			return err
+		}
+		h.SetPrefixPath(clusterPrefixPath)
+		return nil
+	}
 	log.Infof(ctx, "[dialer] Setup tunnel for host [%s]", h.Address)
 	httpClient, err := h.newHTTPClient(dialerFactory)
 	if err != nil {
@@ -29,12 +42,13 @@ func (h *Host) TunnelUp(ctx context.Context, dialerFactory DialerFactory, cluste
 	}
 	// set Docker client
 	logrus.Debugf("Connecting to Docker API for host [%s]", h.Address)
-	h.DClient, err = client.NewClientWithOpts(
+	dClient, err := client.NewClientWithOpts(
 		client.WithAPIVersionNegotiation(),
 		client.WithHTTPClient(httpClient))
 	if err != nil {
 		return fmt.Errorf("Can't initiate NewClient: %v", err)
 	}
+	h.DClient = dClient
 	if err := checkDockerVersion(ctx, h, clusterVersion); err != nil {
 		return err
 	}
@@ -43,16 +57,16 @@ func (h *Host) TunnelUp(ctx context.Context, dialerFactory DialerFactory, cluste
 }
 
 func (h *Host) TunnelUpLocal(ctx context.Context, clusterVersion string) error {
-	var err error
 	if h.DClient != nil {
 		return nil
 	}
 	// set Docker client
 	logrus.Debugf("Connecting to Docker API for host [%s]", h.Address)
-	h.DClient, err = client.NewEnvClient()
+	dClient, err := client.NewEnvClient()
 	if err != nil {
 		return fmt.Errorf("Can't initiate NewClient: %v", err)
 	}
+	h.DClient = dClient
 	return checkDockerVersion(ctx, h, clusterVersion)
 }
 
@@ -82,14 +96,31 @@ func checkDockerVersion(ctx context.Context, h *Host, clusterVersion string) err
 	return nil
 }
 
-func parsePrivateKey(keyBuff string) (ssh.Signer, error) {
-	return ssh.ParsePrivateKey([]byte(keyBuff))
+func parsePrivateKey(keyBuff string, passphrase *keyPassphrase) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(keyBuff))
+	if _, ok := err.(*ssh.PassphraseMissingError); !ok || passphrase == nil {
+		return signer, err
+	}
+	phrase, err := passphrase.get(keyBuff)
+	if err != nil {
+		return nil, err
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(keyBuff), phrase)
+	if err != nil {
+		// don't keep a wrong passphrase
+		passphrase.forget(keyBuff)
+		return nil, fmt.Errorf("Failed to decrypt SSH key: %v", err)
+	}
+	return signer, nil
 }
 
-func getSSHConfig(username, sshPrivateKeyString string, sshCertificateString string, useAgentAuth bool) (*ssh.ClientConfig, error) {
+func getSSHConfig(username, sshPrivateKeyString string, sshCertificateString string, useAgentAuth bool, hostKeyCallback ssh.HostKeyCallback, passphrase *keyPassphrase) (*ssh.ClientConfig, error) {
+	if hostKeyCallback == nil {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	}
 	config := &ssh.ClientConfig{
 		User:            username,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
 	}
 
 	// Kind of a double check now.
@@ -107,7 +138,7 @@ func getSSHConfig(username, sshPrivateKeyString string, sshCertificateString str
 		}
 	}
 
-	signer, err := parsePrivateKey(sshPrivateKeyString)
+	signer, err := parsePrivateKey(sshPrivateKeyString, passphrase)
 	if err != nil {
 		return config, err
 	}
@@ -0,0 +1,212 @@
+// Package k8stest provides an in-memory Kubernetes API server to test the cluster operations without a cluster
+package k8stest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var nodesResource = corev1.SchemeGroupVersion.WithResource("nodes")
+
+// APIServer serves the REST API of the built-in resources from memory, it's used as the transport of the clients.
+// The jobs complete as soon as they're created.
+type APIServer struct {
+	Version version.Info
+
+	tracker k8stesting.ObjectTracker
+	kinds   map[schema.GroupVersionResource]schema.GroupVersionKind
+	mu      sync.Mutex
+	actions []string
+}
+
+// NewAPIServer returns an API server without objects, reporting the Kubernetes version
+func NewAPIServer(gitVersion string) *APIServer {
+	kinds := map[schema.GroupVersionResource]schema.GroupVersionKind{}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		kinds[plural] = gvk
+	}
+	return &APIServer{
+		Version: version.Info{GitVersion: gitVersion},
+		tracker: k8stesting.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder()),
+		kinds:   kinds,
+	}
+}
+
+// WrapTransport replaces the transport of a client with the API server
+func (s *APIServer) WrapTransport(http.RoundTripper) http.RoundTripper {
+	return s
+}
+
+// RoundTrip serves the request
+func (s *APIServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+// AddReadyNode registers the node of the host like its kubelet does
+func (s *APIServer) AddReadyNode(hostname string) error {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   hostname,
+			Labels: map[string]string{corev1.LabelHostname: hostname},
+			// set by the kubelet
+			Annotations: map[string]string{"volumes.kubernetes.io/controller-managed-attach-detach": "true"},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	return s.tracker.Create(nodesResource, node, "")
+}
+
+// Node returns the node by name
+func (s *APIServer) Node(name string) (*corev1.Node, error) {
+	obj, err := s.tracker.Get(nodesResource, "", name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*corev1.Node), nil
+}
+
+// Object returns the object of the resource, like "configmaps", by namespace and name
+func (s *APIServer) Object(gvr schema.GroupVersionResource, namespace, name string) (runtime.Object, error) {
+	return s.tracker.Get(gvr, namespace, name)
+}
+
+// Actions returns the served requests as "<method> <resource> <namespace>/<name>"
+func (s *APIServer) Actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.actions...)
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/version" {
+		writeJSON(w, http.StatusOK, s.Version)
+		return
+	}
+	gvr, namespace, name, err := s.parsePath(req.URL.Path)
+	if err != nil {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{}, req.URL.Path))
+		return
+	}
+	s.mu.Lock()
+	s.actions = append(s.actions, fmt.Sprintf("%s %s %s/%s", req.Method, gvr.Resource, namespace, name))
+	s.mu.Unlock()
+
+	gvk := s.kinds[gvr]
+	var obj runtime.Object
+	switch {
+	case req.Method == http.MethodGet && name == "":
+		obj, err = s.tracker.List(gvr, gvk, namespace)
+		if err == nil {
+			gvk.Kind += "List"
+		}
+	case req.Method == http.MethodGet:
+		obj, err = s.tracker.Get(gvr, namespace, name)
+	case req.Method == http.MethodPost || req.Method == http.MethodPut:
+		obj, err = s.decode(req)
+		if err != nil {
+			break
+		}
+		if req.Method == http.MethodPost {
+			completeJob(obj)
+			err = s.tracker.Create(gvr, obj, namespace)
+		} else {
+			err = s.tracker.Update(gvr, obj, namespace)
+		}
+	case req.Method == http.MethodDelete:
+		err = s.tracker.Delete(gvr, namespace, name)
+		obj = &metav1.Status{Status: metav1.StatusSuccess}
+		gvk = metav1.SchemeGroupVersion.WithKind("Status")
+	default:
+		err = apierrors.NewMethodNotSupported(gvr.GroupResource(), req.Method)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	writeJSON(w, http.StatusOK, obj)
+}
+
+// parsePath returns the resource, namespace and name of /api/v1/... and /apis/<group>/<version>/... paths
+func (s *APIServer) parsePath(path string) (schema.GroupVersionResource, string, string, error) {
+	var gv schema.GroupVersion
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		gv, parts = schema.GroupVersion{Version: parts[1]}, parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		gv, parts = schema.GroupVersion{Group: parts[1], Version: parts[2]}, parts[3:]
+	default:
+		return schema.GroupVersionResource{}, "", "", fmt.Errorf("unknown path %s", path)
+	}
+	var namespace, name string
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	gvr := gv.WithResource(parts[0])
+	if _, ok := s.kinds[gvr]; !ok {
+		return schema.GroupVersionResource{}, "", "", fmt.Errorf("unknown resource %s", gvr)
+	}
+	return gvr, namespace, name, nil
+}
+
+func (s *APIServer) decode(req *http.Request) (runtime.Object, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(body, nil, nil)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return obj, nil
+}
+
+// completeJob marks the job as completed, the other objects are left unchanged
+func completeJob(obj runtime.Object) {
+	if job, ok := obj.(*batchv1.Job); ok {
+		job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue})
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := metav1.Status{Status: metav1.StatusFailure, Code: http.StatusInternalServerError, Message: err.Error()}
+	if apiStatus, ok := err.(apierrors.APIStatus); ok {
+		status = apiStatus.Status()
+	}
+	status.Kind, status.APIVersion = "Status", "v1"
+	writeJSON(w, int(status.Code), status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
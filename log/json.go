@@ -0,0 +1,82 @@
+package log
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JSONLogger writes log lines and events as newline delimited JSON objects, the phase, host, service and container
+// of the messages are the fields of the context they are logged with
+type JSONLogger struct {
+	logger *logrus.Logger
+	fields Fields
+}
+
+// NewJSONLogger returns a logger writing to out at the level of the standard logger
+func NewJSONLogger(out io.Writer) *JSONLogger {
+	logger := logrus.New()
+	logger.SetOutput(out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.GetLevel())
+	return &JSONLogger{logger: logger}
+}
+
+func (l *JSONLogger) withFields(fields Fields) logger {
+	merged := l.fields
+	setValue(&merged.Phase, fields.Phase)
+	setValue(&merged.Host, fields.Host)
+	setValue(&merged.Service, fields.Service)
+	setValue(&merged.Container, fields.Container)
+	return &JSONLogger{logger: l.logger, fields: merged}
+}
+
+func (l *JSONLogger) Debugf(msg string, args ...interface{}) {
+	l.logger.WithFields(l.logrusFields(Fields{})).Debugf(msg, args...)
+}
+
+func (l *JSONLogger) Infof(msg string, args ...interface{}) {
+	l.logger.WithFields(l.logrusFields(Fields{})).Infof(msg, args...)
+}
+
+func (l *JSONLogger) Warnf(msg string, args ...interface{}) {
+	l.logger.WithFields(l.logrusFields(Fields{})).Warnf(msg, args...)
+}
+
+func (l *JSONLogger) Event(event Event) {
+	fields := l.logrusFields(Fields{Phase: event.Phase, Host: event.Host, Service: event.Service, Container: event.Container})
+	fields["event"] = true
+	fields["outcome"] = event.Outcome
+	if event.Outcome != OutcomeStarted {
+		fields["durationSeconds"] = event.Duration.Seconds()
+	}
+	entry := l.logger.WithFields(fields)
+	if event.Outcome == OutcomeFailed {
+		entry.Error(event.Message)
+		return
+	}
+	entry.Info(event.Message)
+}
+
+// logrusFields returns the fields of the logger overridden by the fields set in overrides
+func (l *JSONLogger) logrusFields(overrides Fields) logrus.Fields {
+	fields := l.withFields(overrides).(*JSONLogger).fields
+	logrusFields := logrus.Fields{}
+	setField(logrusFields, "phase", fields.Phase)
+	setField(logrusFields, "host", fields.Host)
+	setField(logrusFields, "service", fields.Service)
+	setField(logrusFields, "container", fields.Container)
+	return logrusFields
+}
+
+func setValue(field *string, value string) {
+	if len(value) > 0 {
+		*field = value
+	}
+}
+
+func setField(fields logrus.Fields, key, value string) {
+	if len(value) > 0 {
+		fields[key] = value
+	}
+}
@@ -0,0 +1,72 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func readJSONLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var fields map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &fields), line)
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestJSONLogger(t *testing.T) {
+	formatter := logrus.StandardLogger().Formatter
+	var out bytes.Buffer
+	ctx := SetLogger(context.Background(), NewJSONLogger(&out))
+	// the standard logger is left as it is
+	assert.Equal(t, formatter, logrus.StandardLogger().Formatter)
+
+	Infof(ctx, "Building Kubernetes cluster")
+	phaseCtx := WithFields(ctx, Fields{Phase: "controlplane"})
+	// the fields don't depend on the wording of the message
+	Warnf(WithFields(phaseCtx, Fields{Host: "1.1.1.1"}), "Something about [%s]", "kube-apiserver")
+	hostCtx, finish := StartEvent(phaseCtx, Event{Host: "2.2.2.2", Container: "kubelet"})
+	Infof(hostCtx, "Starting")
+	finish(fmt.Errorf("container exited"))
+
+	lines := readJSONLines(t, &out)
+	if !assert.Len(t, lines, 5) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"level": "info", "msg": "Building Kubernetes cluster", "time": lines[0]["time"]}, lines[0])
+	assert.Equal(t, "warning", lines[1]["level"])
+	assert.Equal(t, "Something about [kube-apiserver]", lines[1]["msg"])
+	assert.Equal(t, "controlplane", lines[1]["phase"])
+	assert.Equal(t, "1.1.1.1", lines[1]["host"])
+	assert.Nil(t, lines[1]["service"])
+
+	// the started event, the message of the step and the failed event
+	assert.Equal(t, true, lines[2]["event"])
+	assert.Equal(t, OutcomeStarted, lines[2]["outcome"])
+	assert.Nil(t, lines[2]["durationSeconds"])
+	for _, line := range lines[2:] {
+		assert.Equal(t, "controlplane", line["phase"])
+		assert.Equal(t, "2.2.2.2", line["host"])
+		assert.Equal(t, "kubelet", line["container"])
+	}
+	assert.Equal(t, "Starting", lines[3]["msg"])
+	assert.Nil(t, lines[3]["event"])
+	assert.Equal(t, "error", lines[4]["level"])
+	assert.Equal(t, OutcomeFailed, lines[4]["outcome"])
+	assert.Equal(t, "container exited", lines[4]["msg"])
+	assert.NotNil(t, lines[4]["durationSeconds"])
+}
+
+func TestWithFieldsTextLogger(t *testing.T) {
+	// the standard logger doesn't support fields, the context is unchanged
+	ctx := context.Background()
+	assert.Equal(t, ctx, WithFields(ctx, Fields{Phase: "controlplane"}))
+}
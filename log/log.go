@@ -2,6 +2,8 @@ package log
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -10,6 +12,10 @@ type logKey string
 
 const (
 	key logKey = "rke-logger"
+
+	OutcomeStarted   = "started"
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
 )
 
 type logger interface {
@@ -18,6 +24,35 @@ type logger interface {
 	Warnf(msg string, args ...interface{})
 }
 
+// eventLogger is implemented by loggers that can record structured progress events
+type eventLogger interface {
+	Event(event Event)
+}
+
+// fieldLogger is implemented by loggers that record the fields of the context with every message
+type fieldLogger interface {
+	withFields(fields Fields) logger
+}
+
+// Fields are the phase, host, service and container the messages of a context are about
+type Fields struct {
+	Phase     string
+	Host      string
+	Service   string
+	Container string
+}
+
+// Event is a structured progress record of a provisioning step
+type Event struct {
+	Phase     string
+	Host      string
+	Service   string
+	Container string
+	Outcome   string
+	Duration  time.Duration
+	Message   string
+}
+
 func SetLogger(ctx context.Context, logger logger) context.Context {
 	return context.WithValue(ctx, key, logger)
 }
@@ -30,6 +65,16 @@ func getLogger(ctx context.Context) logger {
 	return logger
 }
 
+// WithFields returns a context whose logger records the fields with every message, the fields already set in the
+// context are kept unless they are set again. Loggers that don't support fields ignore them.
+func WithFields(ctx context.Context, fields Fields) context.Context {
+	fieldLogger, ok := getLogger(ctx).(fieldLogger)
+	if !ok {
+		return ctx
+	}
+	return SetLogger(ctx, fieldLogger.withFields(fields))
+}
+
 func Infof(ctx context.Context, msg string, args ...interface{}) {
 	getLogger(ctx).Infof(msg, args...)
 }
@@ -41,3 +86,31 @@ func Warnf(ctx context.Context, msg string, args ...interface{}) {
 func Debugf(ctx context.Context, msg string, args ...interface{}) {
 	getLogger(ctx).Debugf(msg, args...)
 }
+
+// EmitEvent sends the event to the context logger if it supports events, otherwise it's logged as debug text
+func EmitEvent(ctx context.Context, event Event) {
+	if eventLogger, ok := getLogger(ctx).(eventLogger); ok {
+		eventLogger.Event(event)
+		return
+	}
+	getLogger(ctx).Debugf("[%s] event: host [%s] service [%s] container [%s] outcome [%s] duration [%v]: %s",
+		event.Phase, event.Host, event.Service, event.Container, event.Outcome, event.Duration, event.Message)
+}
+
+// StartEvent emits a started event and returns the context of the step, whose messages carry the fields of the
+// event, and a function that emits the final outcome and duration of the step
+func StartEvent(ctx context.Context, event Event) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx = WithFields(ctx, Fields{Phase: event.Phase, Host: event.Host, Service: event.Service, Container: event.Container})
+	event.Outcome = OutcomeStarted
+	EmitEvent(ctx, event)
+	return ctx, func(err error) {
+		event.Duration = time.Since(start)
+		event.Outcome = OutcomeSucceeded
+		if err != nil {
+			event.Outcome = OutcomeFailed
+			event.Message = fmt.Sprintf("%v", err)
+		}
+		EmitEvent(ctx, event)
+	}
+}
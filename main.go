@@ -9,6 +9,7 @@ import (
 	"regexp"
 
 	"github.com/mattn/go-colorable"
+	"github.com/rancher/rke/cluster"
 	"github.com/rancher/rke/cmd"
 	"github.com/rancher/rke/metadata"
 	"github.com/sirupsen/logrus"
@@ -45,6 +46,7 @@ func mainErr() error {
 				logrus.Tracef("Loglevel set to [%v]", logrus.TraceLevel)
 			}
 		}
+		cluster.SetStateBackend(ctx.GlobalString("state-backend"))
 		if released.MatchString(app.Version) {
 			metadata.RKEVersion = app.Version
 			return nil
@@ -56,6 +58,7 @@ func mainErr() error {
 	app.Email = ""
 	app.Commands = []cli.Command{
 		cmd.UpCommand(),
+		cmd.PlanCommand(),
 		cmd.RemoveCommand(),
 		cmd.VersionCommand(),
 		cmd.ConfigCommand(),
@@ -63,6 +66,8 @@ func mainErr() error {
 		cmd.CertificateCommand(),
 		cmd.EncryptionCommand(),
 		cmd.UtilCommand(),
+		cmd.UpgradeCheckCommand(),
+		cmd.ImagesCommand(),
 	}
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -77,6 +82,11 @@ func mainErr() error {
 			Name:  "trace",
 			Usage: "Trace logging",
 		},
+		cli.StringFlag{
+			Name:   "state-backend",
+			Usage:  "Location of the state file, s3://<bucket>[/<folder>] or configmap://<namespace>[/<name>], overrides state_backend in the cluster file",
+			EnvVar: cluster.StateBackendEnv,
+		},
 	}
 	return app.Run(os.Args)
 }
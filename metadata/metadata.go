@@ -30,6 +30,7 @@ var (
 	K8sVersionToRKESystemImages map[string]v3.RKESystemImages
 	K8sVersionToServiceOptions  map[string]v3.KubernetesServicesOptions
 	K8sVersionToDockerVersions  map[string][]string
+	K8sVersionToVersionInfo     map[string]v3.K8sVersionInfo
 	K8sVersionsCurrent          []string
 	K8sBadVersions              = map[string]bool{}
 
@@ -107,6 +108,7 @@ func initDockerOptions(data kdm.Data) {
 
 func initK8sRKESystemImages(data kdm.Data) {
 	K8sVersionToRKESystemImages = map[string]v3.RKESystemImages{}
+	K8sVersionToVersionInfo = data.K8sVersionInfo
 	rkeData := data
 	// non released versions
 	if RKEVersion == "" {
@@ -17,6 +17,7 @@ limitations under the License.
 package cert
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
@@ -53,13 +54,28 @@ func EncodePublicKeyPEM(key *rsa.PublicKey) ([]byte, error) {
 	return pem.EncodeToMemory(&block), nil
 }
 
-// EncodePrivateKeyPEM returns PEM-encoded private key data
-func EncodePrivateKeyPEM(key *rsa.PrivateKey) []byte {
-	block := pem.Block{
-		Type:  RSAPrivateKeyBlockType,
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+// EncodePrivateKeyPEM returns PEM-encoded private key data of an RSA or an ECDSA key
+func EncodePrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	var block pem.Block
+	switch privateKey := key.(type) {
+	case *rsa.PrivateKey:
+		block = pem.Block{
+			Type:  RSAPrivateKeyBlockType,
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		block = pem.Block{
+			Type:  ECPrivateKeyBlockType,
+			Bytes: der,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
 	}
-	return pem.EncodeToMemory(&block)
+	return pem.EncodeToMemory(&block), nil
 }
 
 // EncodeCertPEM returns PEM-endcoded certificate data
@@ -93,9 +109,12 @@ func ParsePrivateKeyPEM(keyData []byte) (interface{}, error) {
 				return key, nil
 			}
 		case PrivateKeyBlockType:
-			// RSA or ECDSA Private Key in unencrypted PKCS#8 format
+			// RSA or ECDSA Private Key in unencrypted PKCS#8 format, the other keys can't be encoded back
 			if key, err := x509.ParsePKCS8PrivateKey(privateKeyPemBlock.Bytes); err == nil {
-				return key, nil
+				switch key.(type) {
+				case *rsa.PrivateKey, *ecdsa.PrivateKey:
+					return key, nil
+				}
 			}
 		}
 
@@ -0,0 +1,161 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/pki/cert"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StateLocation is the location of the certificates of the state file in the check reports
+	StateLocation = "state"
+	// CertCheckerContainer reads the certificates deployed on the nodes
+	CertCheckerContainer = "cert-checker"
+)
+
+// CertificateCheck is the expiry and consistency report of a certificate at a location: the state file, a node or
+// the kubeconfig
+type CertificateCheck struct {
+	Name     string
+	Location string
+	NotAfter time.Time
+	Problems []string
+	// Expiring is set when the certificate expires within the warning period, it's the only problem that is not an error
+	Expiring bool
+}
+
+// HasErrors returns true if the certificate has a problem other than expiring within the warning period
+func (c CertificateCheck) HasErrors() bool {
+	if c.Expiring {
+		return len(c.Problems) > 1
+	}
+	return len(c.Problems) > 0
+}
+
+// DaysLeft returns the number of days before the certificate expires, negative once it expired
+func (c CertificateCheck) DaysLeft(now time.Time) int {
+	return int(math.Floor(c.NotAfter.Sub(now).Hours() / 24))
+}
+
+// CheckCertificate reports the certificate expired or expiring within warnWithin, and the SANs of expected it misses
+func CheckCertificate(name, location string, certificate *x509.Certificate, expected *cert.AltNames, now time.Time, warnWithin time.Duration) CertificateCheck {
+	check := CertificateCheck{Name: name, Location: location}
+	if certificate == nil {
+		check.Problems = append(check.Problems, "certificate is missing")
+		return check
+	}
+	check.NotAfter = certificate.NotAfter
+	if now.After(certificate.NotAfter) {
+		check.Problems = append(check.Problems, fmt.Sprintf("expired on %s", certificate.NotAfter.Format(time.RFC3339)))
+	} else if certificate.NotAfter.Sub(now) < warnWithin {
+		check.Expiring = true
+		check.Problems = append(check.Problems, fmt.Sprintf("expires in %d days on %s", check.DaysLeft(now), certificate.NotAfter.Format(time.RFC3339)))
+	}
+	if now.Before(certificate.NotBefore) {
+		check.Problems = append(check.Problems, fmt.Sprintf("not valid before %s", certificate.NotBefore.Format(time.RFC3339)))
+	}
+	for _, san := range missingAltNames(expected, certificate) {
+		check.Problems = append(check.Problems, fmt.Sprintf("missing SAN %s", san))
+	}
+	return check
+}
+
+// CompareCertificate reports the certificate found at the location when it differs from the certificate of the state
+func CompareCertificate(check *CertificateCheck, certificate, stateCertificate *x509.Certificate) {
+	if certificate == nil || stateCertificate == nil || certificate.Equal(stateCertificate) {
+		return
+	}
+	check.Problems = append(check.Problems, fmt.Sprintf("differs from the state file: serial %s expiring on %s, state has serial %s expiring on %s",
+		certificate.SerialNumber, certificate.NotAfter.Format(time.RFC3339), stateCertificate.SerialNumber, stateCertificate.NotAfter.Format(time.RFC3339)))
+}
+
+// GetExpectedAltNames returns the SANs the certificate is generated with for the cluster configuration, nil if the
+// certificate has no SANs
+func GetExpectedAltNames(rkeConfig *v3.RancherKubernetesEngineConfig, certName string) (*cert.AltNames, error) {
+	switch {
+	case certName == KubeAPICertName:
+		kubernetesServiceIP, err := GetKubernetesServiceIP(rkeConfig.Services.KubeAPI.ServiceClusterIPRange)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get Kubernetes Service IP: %v", err)
+		}
+		cpHosts := hosts.NodesToHosts(rkeConfig.Nodes, controlRole)
+		return GetAltNames(cpHosts, rkeConfig.Services.Kubelet.ClusterDomain, kubernetesServiceIP, rkeConfig.Authentication.SANs), nil
+	case strings.HasPrefix(certName, EtcdCertName+"-") && certName != EtcdClientCACertName && certName != EtcdClientCertName:
+		kubernetesServiceIP, err := GetKubernetesServiceIP(rkeConfig.Services.KubeAPI.ServiceClusterIPRange)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get Kubernetes Service IP: %v", err)
+		}
+		etcdHosts := hosts.NodesToHosts(rkeConfig.Nodes, etcdRole)
+		return GetAltNames(etcdHosts, rkeConfig.Services.Kubelet.ClusterDomain, kubernetesServiceIP, []string{}), nil
+	case strings.HasPrefix(certName, KubeletCertName+"-"):
+		for _, host := range hosts.NodesToHosts(rkeConfig.Nodes, "") {
+			if GetCrtNameForHost(host, KubeletCertName) == certName {
+				return GetIPHostAltnamesForHost(host), nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func missingAltNames(expected *cert.AltNames, certificate *x509.Certificate) []string {
+	if expected == nil {
+		return nil
+	}
+	var missing []string
+	dnsNames := map[string]bool{}
+	for _, dnsName := range certificate.DNSNames {
+		dnsNames[dnsName] = true
+	}
+	for _, dnsName := range expected.DNSNames {
+		if !dnsNames[dnsName] {
+			missing = append(missing, dnsName)
+		}
+	}
+	for _, ip := range expected.IPs {
+		found := false
+		for _, certIP := range certificate.IPAddresses {
+			if ip.Equal(certIP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, ip.String())
+		}
+	}
+	return missing
+}
+
+// FetchDeployedCertificatesFromHost reads the certificates deployed on the host, a certificate not deployed is nil
+func FetchDeployedCertificatesFromHost(ctx context.Context, host *hosts.Host, certNames []string, image string, prsMap map[string]v3.PrivateRegistry) (map[string]*x509.Certificate, error) {
+	certificates := make(map[string]*x509.Certificate, len(certNames))
+	for _, certName := range certNames {
+		crt, err := FetchFileFromHost(ctx, GetCertPath(certName), image, host, prsMap, CertCheckerContainer, CertificatesServiceName)
+		if err != nil {
+			if isFileNotFoundErr(err) {
+				logrus.Debugf("[certificates] Certificate [%s] is not deployed on host [%s]", certName, host.Address)
+				certificates[certName] = nil
+				continue
+			}
+			return nil, err
+		}
+		parsedCert, err := cert.ParseCertsPEM([]byte(crt))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate [%s] of host [%s]: %v", certName, host.Address, err)
+		}
+		certificates[certName] = parsedCert[0]
+	}
+	if err := docker.DoRemoveContainer(ctx, host.DClient, CertCheckerContainer, host.Address); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
@@ -0,0 +1,67 @@
+package pki
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCertificate(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: FakeClusterCidr},
+			Kubelet: v3.KubeletService{ClusterDomain: FakeClusterDomain},
+		},
+	}
+	certs, err := GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	assert.Nil(t, err)
+	kubeAPICert := certs[KubeAPICertName].Certificate
+	expected, err := GetExpectedAltNames(&rkeConfig, KubeAPICertName)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	check := CheckCertificate(KubeAPICertName, StateLocation, kubeAPICert, expected, now, 30*24*time.Hour)
+	assert.Empty(t, check.Problems)
+	assert.False(t, check.HasErrors())
+	assert.True(t, check.DaysLeft(now) > 360)
+
+	// expiring within the threshold is a warning
+	check = CheckCertificate(KubeAPICertName, StateLocation, kubeAPICert, expected, kubeAPICert.NotAfter.Add(-15*24*time.Hour), 30*24*time.Hour)
+	assert.True(t, check.Expiring)
+	assert.False(t, check.HasErrors())
+
+	// a certificate expiring within the threshold and a SAN added to the configuration
+	expiry := kubeAPICert.NotAfter
+	rkeConfig.Authentication.SANs = []string{"api.example.com"}
+	expected, err = GetExpectedAltNames(&rkeConfig, KubeAPICertName)
+	assert.Nil(t, err)
+	check = CheckCertificate(KubeAPICertName, StateLocation, kubeAPICert, expected, expiry.Add(-15*24*time.Hour), 30*24*time.Hour)
+	if assert.Len(t, check.Problems, 2) {
+		assert.Contains(t, check.Problems[0], "expires in")
+		assert.Equal(t, "missing SAN api.example.com", check.Problems[1])
+	}
+	assert.True(t, check.HasErrors())
+	check = CheckCertificate(KubeAPICertName, StateLocation, kubeAPICert, nil, expiry.Add(24*time.Hour), 0)
+	if assert.Len(t, check.Problems, 1) {
+		assert.Contains(t, check.Problems[0], "expired on")
+	}
+	assert.False(t, check.Expiring)
+	assert.True(t, check.HasErrors())
+
+	// a certificate deployed on a node that is not the certificate of the state
+	CompareCertificate(&check, certs[KubeAdminCertName].Certificate, kubeAPICert)
+	if assert.Len(t, check.Problems, 2) {
+		assert.Contains(t, check.Problems[1], "differs from the state file")
+	}
+}
@@ -2,7 +2,7 @@ package pki
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -33,6 +33,7 @@ func DeployCertificatesOnPlaneHost(
 	prsMap map[string]v3.PrivateRegistry,
 	forceDeploy bool,
 	env []string) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: host.Address})
 	crtBundle := GenerateRKENodeCerts(ctx, rkeConfig, host.Address, crtMap)
 
 	// Strip CA key as its sensitive and unneeded on nodes without controlplane role
@@ -45,7 +46,11 @@ func DeployCertificatesOnPlaneHost(
 	}
 
 	for _, crt := range crtBundle {
-		env = append(env, crt.ToEnv()...)
+		crtEnv, err := crt.ToEnv()
+		if err != nil {
+			return err
+		}
+		env = append(env, crtEnv...)
 	}
 	if forceDeploy {
 		env = append(env, "FORCE_DEPLOY=true")
@@ -207,7 +212,11 @@ func DeployCertificatesOnHost(ctx context.Context, host *hosts.Host, crtMap map[
 		"CRTS_DEPLOY_PATH=" + certPath,
 	}
 	for _, crt := range crtMap {
-		env = append(env, crt.ToEnv()...)
+		crtEnv, err := crt.ToEnv()
+		if err != nil {
+			return err
+		}
+		env = append(env, crtEnv...)
 	}
 	return doRunDeployer(ctx, host, env, certDownloaderImage, prsMap)
 }
@@ -282,7 +291,7 @@ func FetchCertificatesFromHost(ctx context.Context, extraHosts []*hosts.Host, ho
 			return nil, err
 		}
 		certificate.Certificate = parsedCert[0]
-		certificate.Key = parsedKey.(*rsa.PrivateKey)
+		certificate.Key = parsedKey.(crypto.Signer)
 		tmpCerts[certName] = certificate
 		logrus.Debugf("[certificates] Recovered certificate: %s", certName)
 	}
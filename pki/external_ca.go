@@ -0,0 +1,186 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/rancher/rke/pki/cert"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	externalSignerTimeout = 30 * time.Second
+	// ExternalSignerValidityEnv is the validity of the certificate requested to the signer command
+	ExternalSignerValidityEnv = "RKE_CERT_VALIDITY"
+)
+
+// IsExternalCA returns true if the cluster CA is an intermediate CA of the configuration
+func IsExternalCA(rkeConfig *v3.RancherKubernetesEngineConfig) bool {
+	return rkeConfig.PKI != nil && rkeConfig.PKI.ExternalCA != nil
+}
+
+// IsExternalSigner returns true if the certificates of the cluster CA are signed by the signer command, the cluster CA
+// has no key then
+func IsExternalSigner(rkeConfig *v3.RancherKubernetesEngineConfig) bool {
+	return IsExternalCA(rkeConfig) && rkeConfig.PKI.ExternalCA.SignerCommand != ""
+}
+
+// ValidateExternalCA makes sure the intermediate CA certificate is a CA chained to the certificates following it, and
+// that either its key or a signer command is set
+func ValidateExternalCA(externalCA *v3.ExternalCAConfig) error {
+	_, _, err := parseExternalCA(externalCA)
+	return err
+}
+
+// parseExternalCA returns the intermediate CA certificate followed by its chain, and its key
+func parseExternalCA(externalCA *v3.ExternalCAConfig) ([]*x509.Certificate, crypto.Signer, error) {
+	if externalCA.CACert == "" {
+		return nil, nil, fmt.Errorf("External CA [ca_cert] is not set")
+	}
+	if (externalCA.CAKey == "") == (externalCA.SignerCommand == "") {
+		return nil, nil, fmt.Errorf("External CA requires either [ca_key] or [signer_command]")
+	}
+	if externalCA.SignerCommand != "" {
+		if _, err := splitSignerCommand(externalCA.SignerCommand); err != nil {
+			return nil, nil, err
+		}
+	}
+	caCerts, err := cert.ParseCertsPEM([]byte(externalCA.CACert))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse external CA certificate: %v", err)
+	}
+	caCert := caCerts[0]
+	if !caCert.IsCA {
+		return nil, nil, fmt.Errorf("External CA certificate [%s] is not a CA certificate", caCert.Subject.CommonName)
+	}
+	if err := verifyCAChain(caCert, caCerts[1:]); err != nil {
+		return nil, nil, err
+	}
+	if externalCA.CAKey == "" {
+		return caCerts, nil, nil
+	}
+	parsedKey, err := cert.ParsePrivateKeyPEM([]byte(externalCA.CAKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse external CA key: %v", err)
+	}
+	caKey, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("External CA key type %T is not supported", parsedKey)
+	}
+	if err := validateKeyPair(CACertName, CertificatePKI{Certificate: caCert, Key: caKey}); err != nil {
+		return nil, nil, err
+	}
+	return caCerts, caKey, nil
+}
+
+// verifyCAChain makes sure the CA certificate is chained to the last certificate of the chain, a CA certificate without
+// chain is not verified
+func verifyCAChain(caCert *x509.Certificate, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return nil
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1])
+	intermediates := x509.NewCertPool()
+	for _, chainCert := range chain[:len(chain)-1] {
+		intermediates.AddCert(chainCert)
+	}
+	if _, err := caCert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("CA certificate [%s] is not chained to [%s]: %v", caCert.Subject.CommonName, chain[len(chain)-1].Subject.CommonName, err)
+	}
+	return nil
+}
+
+func loadExternalCA(certs map[string]CertificatePKI, externalCA *v3.ExternalCAConfig) error {
+	caCerts, caKey, err := parseExternalCA(externalCA)
+	if err != nil {
+		return err
+	}
+	caCert := caCerts[0]
+	logrus.Infof("[certificates] Using external CA [%s] issued by [%s]", caCert.Subject.CommonName, caCert.Issuer.CommonName)
+	caCertObj := ToCertObject(CACertName, "", "", caCert, caKey, nil)
+	// the deployed CA certificate is followed by its chain, up to the root CA
+	var chainPEM []byte
+	for _, chainCert := range caCerts {
+		chainPEM = append(chainPEM, cert.EncodeCertPEM(chainCert)...)
+	}
+	caCertObj.CertificatePEM = string(chainPEM)
+	certs[CACertName] = caCertObj
+	return nil
+}
+
+// ExternalCAChanged returns true if the CA of the bundle is not the external CA of the configuration
+func ExternalCAChanged(certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) bool {
+	if !IsExternalCA(&rkeConfig) {
+		return false
+	}
+	caCerts, err := cert.ParseCertsPEM([]byte(rkeConfig.PKI.ExternalCA.CACert))
+	if err != nil || certs[CACertName].Certificate == nil {
+		return true
+	}
+	return !caCerts[0].Equal(certs[CACertName].Certificate)
+}
+
+// signWithExternalSigner runs the signer command with the CSR of the certificate on stdin, the signer prints the
+// certificate signed by the CA, possibly followed by its chain
+func signWithExternalSigner(signerCommand string, cfg cert.Config, key crypto.Signer, caCrt *x509.Certificate, extensions []pkix.Extension, validity time.Duration) (*x509.Certificate, error) {
+	csr, err := newCertSigningRequest(cfg, key, extensions)
+	if err != nil {
+		return nil, err
+	}
+	args, err := splitSignerCommand(signerCommand)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), externalSignerTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: cert.CertificateRequestBlockType, Bytes: csr}))
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", ExternalSignerValidityEnv, validity))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	logrus.Debugf("[certificates] Running signer command [%s] for certificate [%s]", signerCommand, cfg.CommonName)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Signer command [%s] failed: %v: %s", signerCommand, err, strings.TrimSpace(stderr.String()))
+	}
+	signedCerts, err := cert.ParseCertsPEM(output)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse certificate of signer command [%s]: %v", signerCommand, err)
+	}
+	signedCert := signedCerts[0]
+	if err := signedCert.CheckSignatureFrom(caCrt); err != nil {
+		return nil, fmt.Errorf("Certificate of signer command [%s] is not signed by CA [%s]: %v", signerCommand, caCrt.Subject.CommonName, err)
+	}
+	if err := validateKeyPair(cfg.CommonName, CertificatePKI{Certificate: signedCert, Key: key}); err != nil {
+		return nil, err
+	}
+	return signedCert, nil
+}
+
+// splitSignerCommand splits the signer command into its arguments like a shell
+func splitSignerCommand(signerCommand string) ([]string, error) {
+	args, err := shlex.Split(signerCommand)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse signer command [%s]: %v", signerCommand, err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Signer command [%s] is empty", signerCommand)
+	}
+	return args, nil
+}
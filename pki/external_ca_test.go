@@ -0,0 +1,161 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rancher/rke/pki/cert"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSignerCAEnv is the file of the CA certificate and key of the signer command
+const testSignerCAEnv = "RKE_TEST_SIGNER_CA"
+
+func newTestIntermediateCA(t *testing.T, root *x509.Certificate, rootKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := NewPrivateKey(KeyTypeECDSAP256)
+	assert.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "corp-intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(DefaultCAValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, root, key.Public(), rootKey)
+	assert.Nil(t, err)
+	intermediate, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return intermediate, key
+}
+
+func TestExternalCA(t *testing.T) {
+	opts, err := GetCertOptions(nil)
+	assert.Nil(t, err)
+	root, rootKey, err := GenerateCACertAndKey("corp-root", nil, opts)
+	assert.Nil(t, err)
+	intermediate, intermediateKey := newTestIntermediateCA(t, root, rootKey)
+	caCertPEM := string(cert.EncodeCertPEM(intermediate)) + string(cert.EncodeCertPEM(root))
+	intermediateKeyPEM, err := cert.EncodePrivateKeyPEM(intermediateKey)
+	assert.Nil(t, err)
+
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd", "worker"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: FakeClusterCidr},
+			Kubelet: v3.KubeletService{ClusterDomain: FakeClusterDomain},
+		},
+		PKI: &v3.PKIConfig{
+			ExternalCA: &v3.ExternalCAConfig{
+				CACert: caCertPEM,
+				CAKey:  string(intermediateKeyPEM),
+			},
+		},
+	}
+	certs, err := GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	assert.Nil(t, err)
+	assert.True(t, intermediate.Equal(certs[CACertName].Certificate))
+	// the certificates are chained to the root CA
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	_, err = certs[KubeAPICertName].Certificate.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	assert.Nil(t, err)
+	assert.Nil(t, ValidateBundleContent(&rkeConfig, TransformPEMToObject(certs), "", ""))
+	assert.False(t, ExternalCAChanged(certs, rkeConfig))
+	// the deployed CA certificate has the chain of the state file
+	stateCA := TransformPEMToObject(certs)[CACertName]
+	assert.Equal(t, stateCA.EnvName+"="+caCertPEM, stateCA.CertToEnv())
+
+	// the CA key is replaced by the signer command, the test binary runs TestExternalSignerHelper
+	caFile, err := ioutil.TempFile("", "rke-test-ca")
+	assert.Nil(t, err)
+	defer os.Remove(caFile.Name())
+	_, err = caFile.WriteString(rkeConfig.PKI.ExternalCA.CACert + rkeConfig.PKI.ExternalCA.CAKey)
+	assert.Nil(t, err)
+	assert.Nil(t, caFile.Close())
+	os.Setenv(testSignerCAEnv, caFile.Name())
+	defer os.Unsetenv(testSignerCAEnv)
+	rkeConfig.PKI.ExternalCA.CAKey = ""
+	rkeConfig.PKI.ExternalCA.SignerCommand = fmt.Sprintf("%s '-test.run=^TestExternalSignerHelper$'", os.Args[0])
+	assert.True(t, IsExternalSigner(&rkeConfig))
+	certs, err = GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	assert.Nil(t, err)
+	assert.Nil(t, certs[CACertName].Key)
+	assert.Nil(t, certs[KubeNodeCertName].Certificate.CheckSignatureFrom(intermediate))
+	assert.Nil(t, ValidateBundleContent(&rkeConfig, TransformPEMToObject(certs), "", ""))
+	// the admin certificate is only signed again once rotated
+	kubeAdmin := certs[KubeAdminCertName]
+	assert.Nil(t, GenerateKubeAdminCertificate(context.Background(), certs, rkeConfig, "", "", false))
+	assert.True(t, kubeAdmin.Certificate.Equal(certs[KubeAdminCertName].Certificate))
+	assert.Nil(t, GenerateKubeAdminCertificate(context.Background(), certs, rkeConfig, "", "", true))
+	assert.False(t, kubeAdmin.Certificate.Equal(certs[KubeAdminCertName].Certificate))
+
+	// a CA with both its key and a signer command, or not chained to the root
+	assert.NotNil(t, ValidateExternalCA(&v3.ExternalCAConfig{CACert: caCertPEM, CAKey: string(intermediateKeyPEM), SignerCommand: "signer"}))
+	otherRoot, _, err := GenerateCACertAndKey("other-root", nil, opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, ValidateExternalCA(&v3.ExternalCAConfig{CACert: string(cert.EncodeCertPEM(intermediate)) + string(cert.EncodeCertPEM(otherRoot)), SignerCommand: "signer"}))
+	// a signer command with an unterminated quote
+	assert.NotNil(t, ValidateExternalCA(&v3.ExternalCAConfig{CACert: caCertPEM, SignerCommand: "signer '--ca=intermediate"}))
+}
+
+// TestExternalSignerHelper signs the CSR of stdin with the CA of the test, it runs as signer command of TestExternalCA
+func TestExternalSignerHelper(t *testing.T) {
+	caFile := os.Getenv(testSignerCAEnv)
+	if caFile == "" {
+		return
+	}
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		os.Exit(1)
+	}
+	caCerts, _ := cert.ParseCertsPEM(caPEM)
+	caKey, _ := cert.ParsePrivateKeyPEM(caPEM)
+	csrPEM, _ := ioutil.ReadAll(os.Stdin)
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || len(caCerts) == 0 || caKey == nil {
+		os.Exit(1)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		os.Exit(1)
+	}
+	validity, _ := time.ParseDuration(os.Getenv(ExternalSignerValidityEnv))
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, caCerts[0], csr.PublicKey, caKey)
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Stdout.Write(cert.EncodeCertPEM(&x509.Certificate{Raw: der}))
+	os.Exit(0)
+}
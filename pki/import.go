@@ -0,0 +1,262 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/rke/pki/cert"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+)
+
+var extKeyUsageOIDs = map[string]x509.ExtKeyUsage{
+	oidExtKeyUsageServerAuth.String(): x509.ExtKeyUsageServerAuth,
+	oidExtKeyUsageClientAuth.String(): x509.ExtKeyUsageClientAuth,
+}
+
+// signedCertificate is a certificate returned for a CSR, the certificates following it in its file and the file
+type signedCertificate struct {
+	certificate   *x509.Certificate
+	intermediates []*x509.Certificate
+	fileName      string
+}
+
+// ImportSignedCertificates matches the certificates found in the certificate dir to the CSRs of `rke cert generate-csr`
+// by public key, and checks their subject, SANs, extended key usages, issuer and validity against what each component
+// needs. When every certificate passes, they are written as <name>.pem to be used by `rke up --custom-certs`.
+func ImportSignedCertificates(certDir string, rkeConfig *v3.RancherKubernetesEngineConfig, now time.Time) ([]CertificateCheck, error) {
+	csrs, err := ReadCSRsAndKeysFromDir(certDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(csrs) == 0 {
+		return nil, fmt.Errorf("Failed to find CSRs in [%s], run `rke cert generate-csr` first", certDir)
+	}
+	caCert, err := getCertFromFile(certDir, CACertName+".pem")
+	if err != nil {
+		return nil, err
+	}
+	if caCert == nil {
+		return nil, fmt.Errorf("Failed to find CA certificate [%s.pem] in [%s]", CACertName, certDir)
+	}
+	if err := verifyCAChainFromFile(certDir, CACertName+".pem"); err != nil {
+		return nil, err
+	}
+	requestHeaderCACert, err := getCertFromFile(certDir, RequestHeaderCACertName+".pem")
+	if err != nil {
+		return nil, err
+	}
+	if requestHeaderCACert == nil {
+		logrus.Warnf("[certificates] Failed to find RequestHeader CA certificate, using master CA certificate")
+		requestHeaderCACert = caCert
+	}
+	signed, err := matchSignedCertificates(certDir, csrs)
+	if err != nil {
+		return nil, err
+	}
+
+	certNames := make([]string, 0, len(csrs))
+	for certName := range csrs {
+		certNames = append(certNames, certName)
+	}
+	sort.Strings(certNames)
+	var checks []CertificateCheck
+	var imports []string
+	passed := true
+	for _, certName := range certNames {
+		csr := csrs[certName]
+		match, ok := signed[certName]
+		if !ok {
+			check := CertificateCheck{Name: certName, Location: certDir}
+			if csr.Key == nil {
+				check.Problems = append(check.Problems, fmt.Sprintf("key [%s-key.pem] is missing", certName))
+			} else {
+				check.Problems = append(check.Problems, "no signed certificate matches the key of the CSR")
+			}
+			checks = append(checks, check)
+			passed = false
+			continue
+		}
+		expected, err := GetExpectedAltNames(rkeConfig, certName)
+		if err != nil {
+			return nil, err
+		}
+		check := CheckCertificate(certName, match.fileName, match.certificate, expected, now, 0)
+		check.Problems = append(check.Problems, checkSubject(csr.CSR, match.certificate)...)
+		check.Problems = append(check.Problems, checkExtKeyUsages(csr.CSR, match.certificate)...)
+		issuer := caCert
+		if certName == APIProxyClientCertName {
+			issuer = requestHeaderCACert
+		}
+		if err := verifySignedCertificate(match, issuer, now); err != nil {
+			check.Problems = append(check.Problems, fmt.Sprintf("not signed by CA [%s]: %v", issuer.Subject.CommonName, err))
+		}
+		checks = append(checks, check)
+		if len(check.Problems) > 0 {
+			passed = false
+		} else if match.fileName != certName+".pem" {
+			imports = append(imports, certName)
+		}
+	}
+	if !passed {
+		return checks, nil
+	}
+	for _, certName := range imports {
+		match := signed[certName]
+		certPEM := cert.EncodeCertPEM(match.certificate)
+		for _, intermediate := range match.intermediates {
+			certPEM = append(certPEM, cert.EncodeCertPEM(intermediate)...)
+		}
+		certificatePath := filepath.Join(certDir, certName+".pem")
+		if err := ioutil.WriteFile(certificatePath, certPEM, 0640); err != nil {
+			return nil, fmt.Errorf("Failed to write certificate to path %v: %v", certificatePath, err)
+		}
+		logrus.Infof("[certificates] Imported certificate [%s] from [%s]", certName, match.fileName)
+	}
+	return checks, nil
+}
+
+// verifySignedCertificate verifies the chain from the certificate to the CA through the certificates following it in
+// its file. The validity of the certificate itself is reported by CheckCertificate, an expired certificate is verified
+// at the end of its validity
+func verifySignedCertificate(match signedCertificate, issuer *x509.Certificate, now time.Time) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range match.intermediates {
+		intermediates.AddCert(intermediate)
+	}
+	verifyTime := now
+	if verifyTime.After(match.certificate.NotAfter) {
+		verifyTime = match.certificate.NotAfter
+	} else if verifyTime.Before(match.certificate.NotBefore) {
+		verifyTime = match.certificate.NotBefore
+	}
+	_, err := match.certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   verifyTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// matchSignedCertificates reads the certificates of the files of the dir and returns them by the name of the CSR whose
+// key they match, the certificate expiring last wins when several certificates match the same CSR
+func matchSignedCertificates(certDir string, csrs map[string]CertificatePKI) (map[string]signedCertificate, error) {
+	files, err := ioutil.ReadDir(certDir)
+	if err != nil {
+		return nil, err
+	}
+	signed := make(map[string]signedCertificate)
+	for _, file := range files {
+		fileName := file.Name()
+		if file.IsDir() || strings.HasSuffix(fileName, "-key.pem") || strings.HasSuffix(fileName, "-csr.pem") {
+			continue
+		}
+		certPEM, err := ioutil.ReadFile(filepath.Join(certDir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read file [%s]: %v", fileName, err)
+		}
+		certificates, err := cert.ParseCertsPEM(certPEM)
+		if err != nil {
+			logrus.Debugf("[certificates] Skipping file [%s] without certificate: %v", fileName, err)
+			continue
+		}
+		certificate := certificates[0]
+		if certificate.IsCA {
+			continue
+		}
+		certName := matchCSR(certificate, csrs)
+		if certName == "" {
+			logrus.Warnf("[certificates] Certificate [%s] of file [%s] does not match the key of any CSR", certificate.Subject.CommonName, fileName)
+			continue
+		}
+		if current, ok := signed[certName]; ok && !certificate.NotAfter.After(current.certificate.NotAfter) {
+			continue
+		}
+		signed[certName] = signedCertificate{certificate: certificate, intermediates: certificates[1:], fileName: fileName}
+	}
+	return signed, nil
+}
+
+func matchCSR(certificate *x509.Certificate, csrs map[string]CertificatePKI) string {
+	for certName, csr := range csrs {
+		if csr.Key == nil {
+			continue
+		}
+		if validateKeyPair(certName, CertificatePKI{Certificate: certificate, Key: csr.Key}) == nil {
+			return certName
+		}
+	}
+	return ""
+}
+
+// checkSubject reports the common name and the organizations of the CSR the certificate misses, the components are
+// authorized by them
+func checkSubject(csr *x509.CertificateRequest, certificate *x509.Certificate) []string {
+	if csr == nil {
+		return nil
+	}
+	var problems []string
+	if certificate.Subject.CommonName != csr.Subject.CommonName {
+		problems = append(problems, fmt.Sprintf("common name is %s, the CSR requests %s", certificate.Subject.CommonName, csr.Subject.CommonName))
+	}
+	for _, org := range csr.Subject.Organization {
+		found := false
+		for _, certOrg := range certificate.Subject.Organization {
+			if org == certOrg {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("missing organization %s", org))
+		}
+	}
+	return problems
+}
+
+// checkExtKeyUsages reports the extended key usages of the CSR the certificate misses, a certificate without extended
+// key usages is valid for any usage
+func checkExtKeyUsages(csr *x509.CertificateRequest, certificate *x509.Certificate) []string {
+	if csr == nil || len(certificate.ExtKeyUsage) == 0 {
+		return nil
+	}
+	usages := map[x509.ExtKeyUsage]bool{}
+	for _, usage := range certificate.ExtKeyUsage {
+		usages[usage] = true
+	}
+	if usages[x509.ExtKeyUsageAny] {
+		return nil
+	}
+	var problems []string
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidExtensionExtendedKeyUsage) {
+			continue
+		}
+		var oids []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(ext.Value, &oids); err != nil {
+			return []string{fmt.Sprintf("failed to read the extended key usages of the CSR: %v", err)}
+		}
+		for _, oid := range oids {
+			if usage, ok := extKeyUsageOIDs[oid.String()]; ok && !usages[usage] {
+				problems = append(problems, fmt.Sprintf("missing extended key usage %s", extKeyUsageName(usage)))
+			}
+		}
+	}
+	return problems
+}
+
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	if usage == x509.ExtKeyUsageServerAuth {
+		return "server auth"
+	}
+	return "client auth"
+}
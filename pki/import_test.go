@@ -0,0 +1,158 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/rke/pki/cert"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func signTestCSR(t *testing.T, csr *x509.CertificateRequest, caCert *x509.Certificate, caKey crypto.Signer, usages []x509.ExtKeyUsage) []byte {
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, caCert, csr.PublicKey, caKey)
+	assert.Nil(t, err)
+	return cert.EncodeCertPEM(&x509.Certificate{Raw: der})
+}
+
+func signTestIntermediateCA(t *testing.T, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := cert.NewPrivateKey()
+	assert.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "intermediate-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(48 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, caCert, key.Public(), caKey)
+	assert.Nil(t, err)
+	intermediate, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return intermediate, key
+}
+
+func TestImportSignedCertificates(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd", "worker"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: FakeClusterCidr},
+			Kubelet: v3.KubeletService{ClusterDomain: FakeClusterDomain},
+		},
+	}
+	certDir, err := ioutil.TempDir("", "rke-test-import")
+	assert.Nil(t, err)
+	defer os.RemoveAll(certDir)
+	csrs := map[string]CertificatePKI{}
+	assert.Nil(t, GenerateRKEServicesCSRs(context.Background(), csrs, rkeConfig))
+	assert.Nil(t, WriteCertificates(certDir, csrs))
+
+	// nothing is signed yet
+	_, err = ImportSignedCertificates(certDir, &rkeConfig, time.Now())
+	assert.NotNil(t, err)
+	opts, err := GetCertOptions(nil)
+	assert.Nil(t, err)
+	caCert, caKey, err := GenerateCACertAndKey(CACertName, nil, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(certDir, CACertName+".pem"), cert.EncodeCertPEM(caCert), 0640))
+	checks, err := ImportSignedCertificates(certDir, &rkeConfig, time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, checks, len(csrs))
+	for _, check := range checks {
+		assert.Equal(t, []string{"no signed certificate matches the key of the CSR"}, check.Problems)
+	}
+
+	// the certificates are returned with any file name
+	usages := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	i := 0
+	for _, csr := range csrs {
+		i++
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(certDir, fmt.Sprintf("signed-%d.crt", i)), signTestCSR(t, csr.CSR, caCert, caKey, usages), 0640))
+	}
+	checks, err = ImportSignedCertificates(certDir, &rkeConfig, time.Now())
+	assert.Nil(t, err)
+	for _, check := range checks {
+		assert.Empty(t, check.Problems, check.Name)
+	}
+	certBundle, err := ReadCertsAndKeysFromDir(certDir)
+	assert.Nil(t, err)
+	assert.Nil(t, ValidateBundleContent(&rkeConfig, certBundle, "", ""))
+
+	// a kube-apiserver certificate without server auth and a SAN, and a kube-node certificate of another CA
+	kubeAPICSR := *csrs[KubeAPICertName].CSR
+	kubeAPICSR.DNSNames = kubeAPICSR.DNSNames[1:]
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(certDir, "kube-apiserver.crt"), signTestCSR(t, &kubeAPICSR, caCert, caKey, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}), 0640))
+	otherCACert, otherCAKey, err := GenerateCACertAndKey("other-ca", nil, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(certDir, "kube-node.crt"), signTestCSR(t, csrs[KubeNodeCertName].CSR, otherCACert, otherCAKey, usages), 0640))
+	// a kube-proxy certificate signed by an intermediate CA with the chain in its file, and a kube-scheduler certificate
+	// signed by the intermediate CA without it
+	intermediateCert, intermediateKey := signTestIntermediateCA(t, caCert, caKey)
+	kubeProxyPEM := append(signTestCSR(t, csrs[KubeProxyCertName].CSR, intermediateCert, intermediateKey, usages), cert.EncodeCertPEM(intermediateCert)...)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(certDir, "kube-proxy.crt"), kubeProxyPEM, 0640))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(certDir, "kube-scheduler.crt"), signTestCSR(t, csrs[KubeSchedulerCertName].CSR, intermediateCert, intermediateKey, usages), 0640))
+	importedKubeProxyPEM, err := ioutil.ReadFile(filepath.Join(certDir, KubeProxyCertName+".pem"))
+	assert.Nil(t, err)
+	checks, err = ImportSignedCertificates(certDir, &rkeConfig, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	problems := map[string][]string{}
+	for _, check := range checks {
+		if len(check.Problems) > 0 {
+			problems[check.Name] = check.Problems
+		}
+	}
+	assert.Len(t, problems, 3)
+	assert.Equal(t, []string{"missing SAN " + csrs[KubeAPICertName].CSR.DNSNames[0], "missing extended key usage server auth"}, problems[KubeAPICertName])
+	if assert.Len(t, problems[KubeNodeCertName], 1) {
+		assert.Contains(t, problems[KubeNodeCertName][0], "not signed by CA [kube-ca]")
+	}
+	if assert.Len(t, problems[KubeSchedulerCertName], 1) {
+		assert.Contains(t, problems[KubeSchedulerCertName][0], "not signed by CA [kube-ca]")
+	}
+	// nothing is written while a certificate has problems
+	currentKubeProxyPEM, err := ioutil.ReadFile(filepath.Join(certDir, KubeProxyCertName+".pem"))
+	assert.Nil(t, err)
+	assert.Equal(t, importedKubeProxyPEM, currentKubeProxyPEM)
+
+	// the certificate signed by the intermediate CA is written with its chain
+	for _, fileName := range []string{"kube-apiserver.crt", "kube-node.crt", "kube-scheduler.crt"} {
+		assert.Nil(t, os.Remove(filepath.Join(certDir, fileName)))
+	}
+	checks, err = ImportSignedCertificates(certDir, &rkeConfig, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	for _, check := range checks {
+		assert.Empty(t, check.Problems, check.Name)
+	}
+	currentKubeProxyPEM, err = ioutil.ReadFile(filepath.Join(certDir, KubeProxyCertName+".pem"))
+	assert.Nil(t, err)
+	assert.Equal(t, kubeProxyPEM, currentKubeProxyPEM)
+}
@@ -0,0 +1,121 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+)
+
+const (
+	KeyTypeRSA2048   = "rsa-2048"
+	KeyTypeRSA3072   = "rsa-3072"
+	KeyTypeRSA4096   = "rsa-4096"
+	KeyTypeECDSAP256 = "ecdsa-p256"
+	KeyTypeECDSAP384 = "ecdsa-p384"
+
+	DefaultKeyType      = KeyTypeRSA2048
+	DefaultCAValidity   = duration365d * 10
+	DefaultCertValidity = duration365d * 10
+)
+
+// CertOptions are the validity and the key type of the generated certificates
+type CertOptions struct {
+	CAValidity   time.Duration
+	CertValidity time.Duration
+	KeyType      string
+	// SignerCommand signs the certificates of a CA without key
+	SignerCommand string
+}
+
+// GetCertOptions returns the options of the pki configuration, the options not set have their default value
+func GetCertOptions(pkiConfig *v3.PKIConfig) (CertOptions, error) {
+	opts := CertOptions{
+		CAValidity:   DefaultCAValidity,
+		CertValidity: DefaultCertValidity,
+		KeyType:      DefaultKeyType,
+	}
+	if pkiConfig == nil {
+		return opts, nil
+	}
+	var err error
+	if pkiConfig.CAValidity != "" {
+		if opts.CAValidity, err = parseValidity(pkiConfig.CAValidity); err != nil {
+			return opts, fmt.Errorf("Failed to parse pki [ca_validity]: %v", err)
+		}
+	}
+	if pkiConfig.CertValidity != "" {
+		if opts.CertValidity, err = parseValidity(pkiConfig.CertValidity); err != nil {
+			return opts, fmt.Errorf("Failed to parse pki [cert_validity]: %v", err)
+		}
+	}
+	if pkiConfig.ExternalCA != nil {
+		opts.SignerCommand = pkiConfig.ExternalCA.SignerCommand
+	}
+	if pkiConfig.KeyType != "" {
+		if _, ok := keyGenerators[pkiConfig.KeyType]; !ok {
+			return opts, fmt.Errorf("pki [key_type] [%s] is not supported", pkiConfig.KeyType)
+		}
+		opts.KeyType = pkiConfig.KeyType
+	}
+	return opts, nil
+}
+
+func parseValidity(validity string) (time.Duration, error) {
+	duration, err := time.ParseDuration(validity)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("validity [%s] must be positive", validity)
+	}
+	return duration, nil
+}
+
+var keyGenerators = map[string]func() (crypto.Signer, error){
+	KeyTypeRSA2048:   func() (crypto.Signer, error) { return rsa.GenerateKey(cryptorand.Reader, 2048) },
+	KeyTypeRSA3072:   func() (crypto.Signer, error) { return rsa.GenerateKey(cryptorand.Reader, 3072) },
+	KeyTypeRSA4096:   func() (crypto.Signer, error) { return rsa.GenerateKey(cryptorand.Reader, 4096) },
+	KeyTypeECDSAP256: func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader) },
+	KeyTypeECDSAP384: func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader) },
+}
+
+// NewPrivateKey generates a private key of the key type
+func NewPrivateKey(keyType string) (crypto.Signer, error) {
+	generate, ok := keyGenerators[keyType]
+	if !ok {
+		return nil, fmt.Errorf("key type [%s] is not supported", keyType)
+	}
+	return generate()
+}
+
+// GetKeyType returns the key type of the key, empty if rke doesn't generate keys of its type
+func GetKeyType(key crypto.Signer) string {
+	switch privateKey := key.(type) {
+	case *rsa.PrivateKey:
+		return fmt.Sprintf("rsa-%d", privateKey.N.BitLen())
+	case *ecdsa.PrivateKey:
+		return fmt.Sprintf("ecdsa-p%d", privateKey.Curve.Params().BitSize)
+	}
+	return ""
+}
+
+// KeyTypeChanged returns the key type of the kube-apiserver key of the bundle and true if it's not the key type of
+// the configuration
+func KeyTypeChanged(certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) (string, bool) {
+	key := certs[KubeAPICertName].Key
+	if key == nil {
+		return "", false
+	}
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return "", false
+	}
+	keyType := GetKeyType(key)
+	return keyType, keyType != opts.KeyType
+}
@@ -0,0 +1,139 @@
+package pki
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/rancher/rke/pki/cert"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCertOptions(t *testing.T) {
+	opts, err := GetCertOptions(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, CertOptions{CAValidity: DefaultCAValidity, CertValidity: DefaultCertValidity, KeyType: DefaultKeyType}, opts)
+
+	opts, err = GetCertOptions(&v3.PKIConfig{CertValidity: "2160h", KeyType: KeyTypeRSA3072})
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultCAValidity, opts.CAValidity)
+	assert.Equal(t, 2160*time.Hour, opts.CertValidity)
+	assert.Equal(t, KeyTypeRSA3072, opts.KeyType)
+
+	for _, pkiConfig := range []*v3.PKIConfig{
+		{CAValidity: "10y"},
+		{CertValidity: "-24h"},
+		{KeyType: "ed25519"},
+	} {
+		_, err = GetCertOptions(pkiConfig)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestGenerateECDSACerts(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd", "worker"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: FakeClusterCidr},
+			Kubelet: v3.KubeletService{ClusterDomain: FakeClusterDomain},
+		},
+		PKI: &v3.PKIConfig{
+			CAValidity:   "8760h",
+			CertValidity: "2160h",
+			KeyType:      KeyTypeECDSAP384,
+		},
+	}
+	now := time.Now()
+	certs, err := GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	assert.Nil(t, err)
+
+	caKey, ok := certs[CACertName].Key.(*ecdsa.PrivateKey)
+	if assert.True(t, ok) {
+		assert.Equal(t, elliptic.P384(), caKey.Curve)
+	}
+	assert.WithinDuration(t, now.Add(8760*time.Hour), certs[CACertName].Certificate.NotAfter, time.Minute)
+	assert.WithinDuration(t, now.Add(2160*time.Hour), certs[KubeAPICertName].Certificate.NotAfter, time.Minute)
+	_, ok = certs[KubeAPICertName].Key.(*ecdsa.PrivateKey)
+	assert.True(t, ok)
+
+	// the keys survive the state file
+	stateCerts := TransformPEMToObject(certs)
+	assert.Equal(t, certs[KubeAPICertName].KeyPEM, stateCerts[KubeAPICertName].KeyPEM)
+	assert.Nil(t, ValidateBundleContent(&rkeConfig, stateCerts, "", ""))
+
+	// a leaf certificate does not outlive its CA
+	rkeConfig.PKI = &v3.PKIConfig{CAValidity: "720h", KeyType: KeyTypeRSA2048}
+	certs, err = GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, certs[CACertName].Certificate.NotAfter, certs[KubeNodeCertName].Certificate.NotAfter)
+	_, ok = certs[KubeNodeCertName].Key.(*rsa.PrivateKey)
+	assert.True(t, ok)
+
+	// a key of another certificate is rejected
+	kubeAPICert := stateCerts[KubeAPICertName]
+	kubeAPICert.Key = certs[KubeAPICertName].Key
+	stateCerts[KubeAPICertName] = kubeAPICert
+	assert.NotNil(t, ValidateBundleContent(&rkeConfig, stateCerts, "", ""))
+}
+
+func TestKeyTypeChanged(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd", "worker"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: FakeClusterCidr},
+			Kubelet: v3.KubeletService{ClusterDomain: FakeClusterDomain},
+		},
+	}
+	certs, err := GenerateRKECerts(context.Background(), rkeConfig, "", "")
+	assert.Nil(t, err)
+	_, changed := KeyTypeChanged(certs, rkeConfig)
+	assert.False(t, changed)
+
+	rkeConfig.PKI = &v3.PKIConfig{KeyType: KeyTypeECDSAP256}
+	keyType, changed := KeyTypeChanged(certs, rkeConfig)
+	assert.True(t, changed)
+	assert.Equal(t, KeyTypeRSA2048, keyType)
+	// the existing keys are kept
+	assert.Nil(t, GenerateRKEServicesCerts(context.Background(), certs, rkeConfig, "", "", false))
+	assert.Equal(t, KeyTypeRSA2048, GetKeyType(certs[KubeNodeCertName].Key))
+	// and replaced on rotation
+	assert.Nil(t, GenerateRKEServicesCerts(context.Background(), certs, rkeConfig, "", "", true))
+	_, changed = KeyTypeChanged(certs, rkeConfig)
+	assert.False(t, changed)
+	assert.Equal(t, KeyTypeECDSAP256, GetKeyType(certs[KubeNodeCertName].Key))
+}
+
+func TestEncodeUnsupportedPrivateKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(cryptorand.Reader)
+	assert.Nil(t, err)
+	_, err = cert.EncodePrivateKeyPEM(key)
+	assert.NotNil(t, err)
+
+	// the keys rke can't encode back are not parsed
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	_, err = cert.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: cert.PrivateKeyBlockType, Bytes: der}))
+	assert.NotNil(t, err)
+}
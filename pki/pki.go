@@ -2,7 +2,7 @@ package pki
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"fmt"
 	"net"
@@ -19,7 +19,7 @@ import (
 
 type CertificatePKI struct {
 	Certificate    *x509.Certificate        `json:"-"`
-	Key            *rsa.PrivateKey          `json:"-"`
+	Key            crypto.Signer            `json:"-"`
 	CSR            *x509.CertificateRequest `json:"-"`
 	CertificatePEM string                   `json:"certificatePEM"`
 	KeyPEM         string                   `json:"keyPEM"`
@@ -49,7 +49,7 @@ const (
 func GenerateRKECerts(ctx context.Context, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string) (map[string]CertificatePKI, error) {
 	certs := make(map[string]CertificatePKI)
 	// generate RKE CA certificates
-	if err := GenerateRKECACerts(ctx, certs, configPath, configDir); err != nil {
+	if err := GenerateRKECACerts(ctx, certs, rkeConfig, configPath, configDir); err != nil {
 		return certs, err
 	}
 	// Generating certificates for kubernetes components
@@ -83,7 +83,8 @@ func RegenerateEtcdCertificate(
 	etcdHost *hosts.Host,
 	etcdHosts []*hosts.Host,
 	clusterDomain string,
-	KubernetesServiceIP []net.IP) (map[string]CertificatePKI, error) {
+	KubernetesServiceIP []net.IP,
+	opts CertOptions) (map[string]CertificatePKI, error) {
 
 	etcdName := GetCrtNameForHost(etcdHost, EtcdCertName)
 	log.Infof(ctx, "[certificates] Regenerating new %s certificate and key", etcdName)
@@ -91,7 +92,7 @@ func RegenerateEtcdCertificate(
 	caKey := crtMap[CACertName].Key
 	etcdAltNames := GetAltNames(etcdHosts, clusterDomain, KubernetesServiceIP, []string{})
 
-	etcdCrt, etcdKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, EtcdCertName, etcdAltNames, nil, nil)
+	etcdCrt, etcdKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, EtcdCertName, etcdAltNames, nil, nil, opts)
 	if err != nil {
 		return nil, err
 	}
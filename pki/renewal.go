@@ -0,0 +1,79 @@
+package pki
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+)
+
+// renewalGroup returns the certificates generated together with the certificate, they are renewed together. The CA
+// certificates, the service account token key and the external etcd certificates are not renewed
+func renewalGroup(certName string) string {
+	switch certName {
+	case KubeAPICertName, KubeControllerCertName, KubeSchedulerCertName, KubeProxyCertName, KubeNodeCertName, KubeAdminCertName, APIProxyClientCertName:
+		return certName
+	case CACertName, RequestHeaderCACertName, ServiceAccountTokenKeyName, EtcdClientCACertName, EtcdClientCertName:
+		return ""
+	}
+	if strings.HasPrefix(certName, EtcdCertName+"-") {
+		return EtcdCertName
+	}
+	if strings.HasPrefix(certName, KubeletCertName+"-") {
+		return KubeletCertName
+	}
+	return ""
+}
+
+var renewalGenFuncs = map[string]GenFunc{
+	KubeAPICertName:        GenerateKubeAPICertificate,
+	KubeControllerCertName: GenerateKubeControllerCertificate,
+	KubeSchedulerCertName:  GenerateKubeSchedulerCertificate,
+	KubeProxyCertName:      GenerateKubeProxyCertificate,
+	KubeNodeCertName:       GenerateKubeNodeCertificate,
+	KubeAdminCertName:      GenerateKubeAdminCertificate,
+	APIProxyClientCertName: GenerateAPIProxyClientCertificate,
+	EtcdCertName:           GenerateEtcdCertificates,
+	KubeletCertName:        GenerateKubeletCertificate,
+}
+
+// IsRenewableCertificate returns true if the certificate is renewed during rke up when it expires soon
+func IsRenewableCertificate(certName string) bool {
+	return renewalGroup(certName) != ""
+}
+
+// GetExpiringCertificates returns the names of the renewable certificates of the bundle expiring before renewBefore
+func GetExpiringCertificates(certs map[string]CertificatePKI, renewBefore time.Time) []string {
+	var expiring []string
+	for certName, certPKI := range certs {
+		if certPKI.Certificate == nil || !IsRenewableCertificate(certName) {
+			continue
+		}
+		if certPKI.Certificate.NotAfter.Before(renewBefore) {
+			expiring = append(expiring, certName)
+		}
+	}
+	sort.Strings(expiring)
+	return expiring
+}
+
+// RenewCertificates regenerates the certificates with new keys, the etcd and the kubelet certificates of all the nodes
+// are renewed together
+func RenewCertificates(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, certNames []string, configPath, configDir string) error {
+	renewed := map[string]bool{}
+	for _, certName := range certNames {
+		group := renewalGroup(certName)
+		if group == "" || renewed[group] {
+			continue
+		}
+		log.Infof(ctx, "[certificates] Renewing certificate [%s] expiring on %s", certName, certs[certName].Certificate.NotAfter.Format(time.RFC3339))
+		if err := renewalGenFuncs[group](ctx, certs, rkeConfig, configPath, configDir, true); err != nil {
+			return err
+		}
+		renewed[group] = true
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package pki
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenewCertificates(t *testing.T) {
+	rkeConfig := v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{
+				Address:          "1.1.1.1",
+				InternalAddress:  "192.168.1.5",
+				Role:             []string{"controlplane", "etcd", "worker"},
+				HostnameOverride: "server1",
+			},
+		},
+		Services: v3.RKEConfigServices{
+			KubeAPI: v3.KubeAPIService{ServiceClusterIPRange: FakeClusterCidr},
+			Kubelet: v3.KubeletService{ClusterDomain: FakeClusterDomain},
+		},
+	}
+	ctx := context.Background()
+	certs, err := GenerateRKECerts(ctx, rkeConfig, "", "")
+	assert.Nil(t, err)
+
+	assert.Empty(t, GetExpiringCertificates(certs, time.Now()))
+	expiring := GetExpiringCertificates(certs, time.Now().Add(100*365*24*time.Hour))
+	assert.Contains(t, expiring, KubeAPICertName)
+	assert.Contains(t, expiring, "kube-etcd-192-168-1-5")
+	assert.Contains(t, expiring, KubeAdminCertName)
+	for _, certName := range []string{CACertName, RequestHeaderCACertName, ServiceAccountTokenKeyName} {
+		assert.NotContains(t, expiring, certName)
+	}
+
+	oldCerts := make(map[string]CertificatePKI, len(certs))
+	for certName, certPKI := range certs {
+		oldCerts[certName] = certPKI
+	}
+	assert.Nil(t, RenewCertificates(ctx, certs, rkeConfig, []string{KubeAPICertName, "kube-etcd-192-168-1-5"}, "", ""))
+	assert.NotEqual(t, oldCerts[KubeAPICertName].CertificatePEM, certs[KubeAPICertName].CertificatePEM)
+	assert.NotEqual(t, oldCerts[KubeAPICertName].KeyPEM, certs[KubeAPICertName].KeyPEM)
+	assert.NotEqual(t, oldCerts["kube-etcd-192-168-1-5"].CertificatePEM, certs["kube-etcd-192-168-1-5"].CertificatePEM)
+	// the certificates not expiring and the service account token key are kept
+	assert.Equal(t, oldCerts[KubeControllerCertName].CertificatePEM, certs[KubeControllerCertName].CertificatePEM)
+	assert.Equal(t, oldCerts[ServiceAccountTokenKeyName].KeyPEM, certs[ServiceAccountTokenKeyName].KeyPEM)
+}
@@ -2,7 +2,7 @@ package pki
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"fmt"
 	"reflect"
 	"sort"
@@ -17,9 +17,13 @@ import (
 
 func GenerateKubeAPICertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate API certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	kubernetesServiceIP, err := GetKubernetesServiceIP(rkeConfig.Services.KubeAPI.ServiceClusterIPRange)
@@ -36,11 +40,11 @@ func GenerateKubeAPICertificate(ctx context.Context, certs map[string]Certificat
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kubernetes API server certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeAPICertName].Key
 	}
-	kubeAPICrt, kubeAPIKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, KubeAPICertName, kubeAPIAltNames, serviceKey, nil)
+	kubeAPICrt, kubeAPIKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, KubeAPICertName, kubeAPIAltNames, serviceKey, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -56,6 +60,10 @@ func GenerateKubeAPICertificate(ctx context.Context, certs map[string]Certificat
 
 func GenerateKubeAPICSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
 	// generate API csr and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	kubernetesServiceIP, err := GetKubernetesServiceIP(rkeConfig.Services.KubeAPI.ServiceClusterIPRange)
 	if err != nil {
 		return fmt.Errorf("Failed to get Kubernetes Service IP: %v", err)
@@ -71,7 +79,7 @@ func GenerateKubeAPICSR(ctx context.Context, certs map[string]CertificatePKI, rk
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kubernetes API server csr")
-	kubeAPICSR, kubeAPIKey, err := GenerateCertSigningRequestAndKey(true, KubeAPICertName, kubeAPIAltNames, certs[KubeAPICertName].Key, nil)
+	kubeAPICSR, kubeAPIKey, err := GenerateCertSigningRequestAndKey(true, KubeAPICertName, kubeAPIAltNames, certs[KubeAPICertName].Key, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -81,20 +89,24 @@ func GenerateKubeAPICSR(ctx context.Context, certs map[string]CertificatePKI, rk
 
 func GenerateKubeControllerCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate Kube controller-manager certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	if certs[KubeControllerCertName].Certificate != nil && !rotate {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kube Controller certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeControllerCertName].Key
 	}
-	kubeControllerCrt, kubeControllerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeControllerCertName), nil, serviceKey, nil)
+	kubeControllerCrt, kubeControllerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeControllerCertName), nil, serviceKey, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -104,13 +116,17 @@ func GenerateKubeControllerCertificate(ctx context.Context, certs map[string]Cer
 
 func GenerateKubeControllerCSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
 	// generate Kube controller-manager csr and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	kubeControllerCrt := certs[KubeControllerCertName].Certificate
 	kubeControllerCSRPEM := certs[KubeControllerCertName].CSRPEM
 	if kubeControllerCSRPEM != "" {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kube Controller csr")
-	kubeControllerCSR, kubeControllerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeControllerCertName), nil, certs[KubeControllerCertName].Key, nil)
+	kubeControllerCSR, kubeControllerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeControllerCertName), nil, certs[KubeControllerCertName].Key, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -120,20 +136,24 @@ func GenerateKubeControllerCSR(ctx context.Context, certs map[string]Certificate
 
 func GenerateKubeSchedulerCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate Kube scheduler certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	if certs[KubeSchedulerCertName].Certificate != nil && !rotate {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kube Scheduler certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeSchedulerCertName].Key
 	}
-	kubeSchedulerCrt, kubeSchedulerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeSchedulerCertName), nil, serviceKey, nil)
+	kubeSchedulerCrt, kubeSchedulerKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeSchedulerCertName), nil, serviceKey, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -143,13 +163,17 @@ func GenerateKubeSchedulerCertificate(ctx context.Context, certs map[string]Cert
 
 func GenerateKubeSchedulerCSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
 	// generate Kube scheduler csr and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	kubeSchedulerCrt := certs[KubeSchedulerCertName].Certificate
 	kubeSchedulerCSRPEM := certs[KubeSchedulerCertName].CSRPEM
 	if kubeSchedulerCSRPEM != "" {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kube Scheduler csr")
-	kubeSchedulerCSR, kubeSchedulerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeSchedulerCertName), nil, certs[KubeSchedulerCertName].Key, nil)
+	kubeSchedulerCSR, kubeSchedulerKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeSchedulerCertName), nil, certs[KubeSchedulerCertName].Key, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -159,20 +183,24 @@ func GenerateKubeSchedulerCSR(ctx context.Context, certs map[string]CertificateP
 
 func GenerateKubeProxyCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate Kube Proxy certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	if certs[KubeProxyCertName].Certificate != nil && !rotate {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kube Proxy certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeProxyCertName].Key
 	}
-	kubeProxyCrt, kubeProxyKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeProxyCertName), nil, serviceKey, nil)
+	kubeProxyCrt, kubeProxyKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, getDefaultCN(KubeProxyCertName), nil, serviceKey, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -182,13 +210,17 @@ func GenerateKubeProxyCertificate(ctx context.Context, certs map[string]Certific
 
 func GenerateKubeProxyCSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
 	// generate Kube Proxy csr and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	kubeProxyCrt := certs[KubeProxyCertName].Certificate
 	kubeProxyCSRPEM := certs[KubeProxyCertName].CSRPEM
 	if kubeProxyCSRPEM != "" {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kube Proxy csr")
-	kubeProxyCSR, kubeProxyKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeProxyCertName), nil, certs[KubeProxyCertName].Key, nil)
+	kubeProxyCSR, kubeProxyKey, err := GenerateCertSigningRequestAndKey(false, getDefaultCN(KubeProxyCertName), nil, certs[KubeProxyCertName].Key, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -198,20 +230,24 @@ func GenerateKubeProxyCSR(ctx context.Context, certs map[string]CertificatePKI,
 
 func GenerateKubeNodeCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate kubelet certificate
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	if certs[KubeNodeCertName].Certificate != nil && !rotate {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Node certificate")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[KubeProxyCertName].Key
 	}
-	nodeCrt, nodeKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, KubeNodeCommonName, nil, serviceKey, []string{KubeNodeOrganizationName})
+	nodeCrt, nodeKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, KubeNodeCommonName, nil, serviceKey, []string{KubeNodeOrganizationName}, opts)
 	if err != nil {
 		return err
 	}
@@ -221,13 +257,17 @@ func GenerateKubeNodeCertificate(ctx context.Context, certs map[string]Certifica
 
 func GenerateKubeNodeCSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
 	// generate kubelet csr and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	nodeCrt := certs[KubeNodeCertName].Certificate
 	nodeCSRPEM := certs[KubeNodeCertName].CSRPEM
 	if nodeCSRPEM != "" {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Node csr and key")
-	nodeCSR, nodeKey, err := GenerateCertSigningRequestAndKey(false, KubeNodeCommonName, nil, certs[KubeNodeCertName].Key, []string{KubeNodeOrganizationName})
+	nodeCSR, nodeKey, err := GenerateCertSigningRequestAndKey(false, KubeNodeCommonName, nil, certs[KubeNodeCertName].Key, []string{KubeNodeOrganizationName}, opts)
 	if err != nil {
 		return err
 	}
@@ -237,10 +277,14 @@ func GenerateKubeNodeCSR(ctx context.Context, certs map[string]CertificatePKI, r
 
 func GenerateKubeAdminCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate Admin certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	logrus.Info("[certificates] Generating admin certificates and kubeconfig")
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	cpHosts := hosts.NodesToHosts(rkeConfig.Nodes, controlRole)
@@ -248,13 +292,17 @@ func GenerateKubeAdminCertificate(ctx context.Context, certs map[string]Certific
 		configPath = ClusterConfig
 	}
 	localKubeConfigPath := GetLocalKubeConfig(configPath, configDir)
-	var serviceKey *rsa.PrivateKey
-	if !rotate {
-		serviceKey = certs[KubeAdminCertName].Key
-	}
-	kubeAdminCrt, kubeAdminKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, KubeAdminCertName, nil, serviceKey, []string{KubeAdminOrganizationName})
-	if err != nil {
-		return err
+	// the kubeconfig is rebuilt for the current control plane, the certificate is reused until it expires
+	kubeAdminCrt, kubeAdminKey := certs[KubeAdminCertName].Certificate, certs[KubeAdminCertName].Key
+	if rotate || kubeAdminKey == nil || !isValidCertificate(kubeAdminCrt, caCrt) {
+		var serviceKey crypto.Signer
+		if !rotate {
+			serviceKey = kubeAdminKey
+		}
+		kubeAdminCrt, kubeAdminKey, err = GenerateSignedCertAndKey(caCrt, caKey, false, KubeAdminCertName, nil, serviceKey, []string{KubeAdminOrganizationName}, opts)
+		if err != nil {
+			return err
+		}
 	}
 	kubeAdminCertObj := ToCertObject(KubeAdminCertName, KubeAdminCertName, KubeAdminOrganizationName, kubeAdminCrt, kubeAdminKey, nil)
 	if len(cpHosts) > 0 {
@@ -264,7 +312,7 @@ func GenerateKubeAdminCertificate(ctx context.Context, certs map[string]Certific
 			KubeAdminCertName,
 			string(cert.EncodeCertPEM(caCrt)),
 			string(cert.EncodeCertPEM(kubeAdminCrt)),
-			string(cert.EncodePrivateKeyPEM(kubeAdminKey)))
+			kubeAdminCertObj.KeyPEM)
 		kubeAdminCertObj.Config = kubeAdminConfig
 		kubeAdminCertObj.ConfigPath = localKubeConfigPath
 	} else {
@@ -276,12 +324,16 @@ func GenerateKubeAdminCertificate(ctx context.Context, certs map[string]Certific
 
 func GenerateKubeAdminCSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
 	// generate Admin certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	kubeAdminCrt := certs[KubeAdminCertName].Certificate
 	kubeAdminCSRPEM := certs[KubeAdminCertName].CSRPEM
 	if kubeAdminCSRPEM != "" {
 		return nil
 	}
-	kubeAdminCSR, kubeAdminKey, err := GenerateCertSigningRequestAndKey(false, KubeAdminCertName, nil, certs[KubeAdminCertName].Key, []string{KubeAdminOrganizationName})
+	kubeAdminCSR, kubeAdminKey, err := GenerateCertSigningRequestAndKey(false, KubeAdminCertName, nil, certs[KubeAdminCertName].Key, []string{KubeAdminOrganizationName}, opts)
 	if err != nil {
 		return err
 	}
@@ -293,20 +345,24 @@ func GenerateKubeAdminCSR(ctx context.Context, certs map[string]CertificatePKI,
 
 func GenerateAPIProxyClientCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	//generate API server proxy client key and certs
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[RequestHeaderCACertName].Certificate
 	caKey := certs[RequestHeaderCACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("Request Header CA Certificate or Key is empty")
 	}
 	if certs[APIProxyClientCertName].Certificate != nil && !rotate {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kubernetes API server proxy client certificates")
-	var serviceKey *rsa.PrivateKey
+	var serviceKey crypto.Signer
 	if !rotate {
 		serviceKey = certs[APIProxyClientCertName].Key
 	}
-	apiserverProxyClientCrt, apiserverProxyClientKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, APIProxyClientCertName, nil, serviceKey, nil)
+	apiserverProxyClientCrt, apiserverProxyClientKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, APIProxyClientCertName, nil, serviceKey, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -316,13 +372,17 @@ func GenerateAPIProxyClientCertificate(ctx context.Context, certs map[string]Cer
 
 func GenerateAPIProxyClientCSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
 	//generate API server proxy client key and certs
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	apiserverProxyClientCrt := certs[APIProxyClientCertName].Certificate
 	apiserverProxyClientCSRPEM := certs[APIProxyClientCertName].CSRPEM
 	if apiserverProxyClientCSRPEM != "" {
 		return nil
 	}
 	logrus.Info("[certificates] Generating Kubernetes API server proxy client csr")
-	apiserverProxyClientCSR, apiserverProxyClientKey, err := GenerateCertSigningRequestAndKey(true, APIProxyClientCertName, nil, certs[APIProxyClientCertName].Key, nil)
+	apiserverProxyClientCSR, apiserverProxyClientKey, err := GenerateCertSigningRequestAndKey(true, APIProxyClientCertName, nil, certs[APIProxyClientCertName].Key, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -339,7 +399,7 @@ func GenerateExternalEtcdCertificates(ctx context.Context, certs map[string]Cert
 	if err != nil {
 		return err
 	}
-	certs[EtcdClientCertName] = ToCertObject(EtcdClientCertName, "", "", clientCert[0], clientKey.(*rsa.PrivateKey), nil)
+	certs[EtcdClientCertName] = ToCertObject(EtcdClientCertName, "", "", clientCert[0], clientKey.(crypto.Signer), nil)
 
 	caCert, err := cert.ParseCertsPEM([]byte(rkeConfig.Services.Etcd.CACert))
 	if err != nil {
@@ -350,9 +410,13 @@ func GenerateExternalEtcdCertificates(ctx context.Context, certs map[string]Cert
 }
 
 func GenerateEtcdCertificates(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	kubernetesServiceIP, err := GetKubernetesServiceIP(rkeConfig.Services.KubeAPI.ServiceClusterIPRange)
@@ -393,12 +457,12 @@ func GenerateEtcdCertificates(ctx context.Context, certs map[string]CertificateP
 				}
 			}
 		}
-		var serviceKey *rsa.PrivateKey
+		var serviceKey crypto.Signer
 		if !rotate {
 			serviceKey = certs[etcdName].Key
 		}
 		logrus.Infof("[certificates] Generating %s certificate and key", etcdName)
-		etcdCrt, etcdKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, EtcdCertName, etcdAltNames, serviceKey, nil)
+		etcdCrt, etcdKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, EtcdCertName, etcdAltNames, serviceKey, nil, opts)
 		if err != nil {
 			return err
 		}
@@ -409,6 +473,10 @@ func GenerateEtcdCertificates(ctx context.Context, certs map[string]CertificateP
 }
 
 func GenerateEtcdCSRs(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	kubernetesServiceIP, err := GetKubernetesServiceIP(rkeConfig.Services.KubeAPI.ServiceClusterIPRange)
 	if err != nil {
 		return fmt.Errorf("Failed to get Kubernetes Service IP: %v", err)
@@ -427,7 +495,7 @@ func GenerateEtcdCSRs(ctx context.Context, certs map[string]CertificatePKI, rkeC
 			}
 		}
 		logrus.Infof("[certificates] Generating etcd-%s csr and key", host.InternalAddress)
-		etcdCSR, etcdKey, err := GenerateCertSigningRequestAndKey(true, EtcdCertName, etcdAltNames, certs[etcdName].Key, nil)
+		etcdCSR, etcdKey, err := GenerateCertSigningRequestAndKey(true, EtcdCertName, etcdAltNames, certs[etcdName].Key, nil, opts)
 		if err != nil {
 			return err
 		}
@@ -438,10 +506,14 @@ func GenerateEtcdCSRs(ctx context.Context, certs map[string]CertificatePKI, rkeC
 
 func GenerateServiceTokenKey(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate service account token key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	privateAPIKey := certs[ServiceAccountTokenKeyName].Key
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	if certs[ServiceAccountTokenKeyName].Certificate != nil {
@@ -451,7 +523,7 @@ func GenerateServiceTokenKey(ctx context.Context, certs map[string]CertificatePK
 	if certs[ServiceAccountTokenKeyName].Key == nil {
 		privateAPIKey = certs[KubeAPICertName].Key
 	}
-	tokenCrt, tokenKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, ServiceAccountTokenKeyName, nil, privateAPIKey, nil)
+	tokenCrt, tokenKey, err := GenerateSignedCertAndKey(caCrt, caKey, false, ServiceAccountTokenKeyName, nil, privateAPIKey, nil, opts)
 	if err != nil {
 		return fmt.Errorf("Failed to generate private key for service account token: %v", err)
 	}
@@ -459,18 +531,26 @@ func GenerateServiceTokenKey(ctx context.Context, certs map[string]CertificatePK
 	return nil
 }
 
-func GenerateRKECACerts(ctx context.Context, certs map[string]CertificatePKI, configPath, configDir string) error {
-	if err := GenerateRKEMasterCACert(ctx, certs, configPath, configDir); err != nil {
+func GenerateRKECACerts(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string) error {
+	if err := GenerateRKEMasterCACert(ctx, certs, rkeConfig, configPath, configDir); err != nil {
 		return err
 	}
-	return GenerateRKERequestHeaderCACert(ctx, certs, configPath, configDir)
+	return GenerateRKERequestHeaderCACert(ctx, certs, rkeConfig, configPath, configDir)
 }
 
-func GenerateRKEMasterCACert(ctx context.Context, certs map[string]CertificatePKI, configPath, configDir string) error {
+func GenerateRKEMasterCACert(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string) error {
+	// the cluster CA is the intermediate CA of an external root CA
+	if IsExternalCA(&rkeConfig) {
+		return loadExternalCA(certs, rkeConfig.PKI.ExternalCA)
+	}
 	// generate kubernetes CA certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	logrus.Info("[certificates] Generating CA kubernetes certificates")
 
-	caCrt, caKey, err := GenerateCACertAndKey(CACertName, nil)
+	caCrt, caKey, err := GenerateCACertAndKey(CACertName, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -478,10 +558,14 @@ func GenerateRKEMasterCACert(ctx context.Context, certs map[string]CertificatePK
 	return nil
 }
 
-func GenerateRKERequestHeaderCACert(ctx context.Context, certs map[string]CertificatePKI, configPath, configDir string) error {
+func GenerateRKERequestHeaderCACert(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string) error {
 	// generate request header client CA certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	logrus.Info("[certificates] Generating Kubernetes API server aggregation layer requestheader client CA certificates")
-	requestHeaderCACrt, requestHeaderCAKey, err := GenerateCACertAndKey(RequestHeaderCACertName, nil)
+	requestHeaderCACrt, requestHeaderCAKey, err := GenerateCACertAndKey(RequestHeaderCACertName, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -491,9 +575,13 @@ func GenerateRKERequestHeaderCACert(ctx context.Context, certs map[string]Certif
 
 func GenerateKubeletCertificate(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig, configPath, configDir string, rotate bool) error {
 	// generate kubelet certificate and key
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	caCrt := certs[CACertName].Certificate
 	caKey := certs[CACertName].Key
-	if caCrt == nil || caKey == nil {
+	if caCrt == nil || (caKey == nil && opts.SignerCommand == "") {
 		return fmt.Errorf("CA Certificate or Key is empty")
 	}
 	log.Debugf(ctx, "[certificates] Generating Kubernetes Kubelet certificates")
@@ -510,12 +598,12 @@ func GenerateKubeletCertificate(ctx context.Context, certs map[string]Certificat
 			DeepEqualIPsAltNames(kubeletAltNames.IPs, kubeletCert.IPAddresses) && !rotate {
 			continue
 		}
-		var serviceKey *rsa.PrivateKey
+		var serviceKey crypto.Signer
 		if !rotate {
 			serviceKey = certs[kubeletName].Key
 		}
 		log.Debugf(ctx, "[certificates] Generating %s certificate and key", kubeletName)
-		kubeletCrt, kubeletKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, kubeletName, kubeletAltNames, serviceKey, nil)
+		kubeletCrt, kubeletKey, err := GenerateSignedCertAndKey(caCrt, caKey, true, kubeletName, kubeletAltNames, serviceKey, nil, opts)
 		if err != nil {
 			return err
 		}
@@ -526,6 +614,10 @@ func GenerateKubeletCertificate(ctx context.Context, certs map[string]Certificat
 }
 
 func GenerateKubeletCSR(ctx context.Context, certs map[string]CertificatePKI, rkeConfig v3.RancherKubernetesEngineConfig) error {
+	opts, err := GetCertOptions(rkeConfig.PKI)
+	if err != nil {
+		return err
+	}
 	allHosts := hosts.NodesToHosts(rkeConfig.Nodes, "")
 	for _, host := range allHosts {
 		kubeletName := GetCrtNameForHost(host, KubeletCertName)
@@ -538,7 +630,7 @@ func GenerateKubeletCSR(ctx context.Context, certs map[string]CertificatePKI, rk
 			continue
 		}
 		logrus.Infof("[certificates] Generating %s Kubernetes Kubelet csr", kubeletName)
-		kubeletCSR, kubeletKey, err := GenerateCertSigningRequestAndKey(true, kubeletName, kubeletAltNames, certs[kubeletName].Key, nil)
+		kubeletCSR, kubeletKey, err := GenerateCertSigningRequestAndKey(true, kubeletName, kubeletAltNames, certs[kubeletName].Key, nil, opts)
 		if err != nil {
 			return err
 		}
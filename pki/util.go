@@ -1,6 +1,7 @@
 package pki
 
 import (
+	"crypto"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -33,18 +34,19 @@ var (
 
 func GenerateSignedCertAndKey(
 	caCrt *x509.Certificate,
-	caKey *rsa.PrivateKey,
+	caKey crypto.Signer,
 	serverCrt bool,
 	commonName string,
 	altNames *cert.AltNames,
-	reusedKey *rsa.PrivateKey,
-	orgs []string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	reusedKey crypto.Signer,
+	orgs []string,
+	opts CertOptions) (*x509.Certificate, crypto.Signer, error) {
 	// Generate a generic signed certificate
-	var rootKey *rsa.PrivateKey
+	var rootKey crypto.Signer
 	var err error
 	rootKey = reusedKey
 	if reusedKey == nil {
-		rootKey, err = cert.NewPrivateKey()
+		rootKey, err = NewPrivateKey(opts.KeyType)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to generate private key for %s certificate: %v", commonName, err)
 		}
@@ -62,7 +64,15 @@ func GenerateSignedCertAndKey(
 		Usages:       usages,
 		AltNames:     *altNames,
 	}
-	clientCert, err := newSignedCert(caConfig, rootKey, caCrt, caKey)
+	var clientCert *x509.Certificate
+	if caKey == nil && opts.SignerCommand != "" {
+		var extensions []pkix.Extension
+		if extensions, err = newExtKeyUsageExtensions(serverCrt); err == nil {
+			clientCert, err = signWithExternalSigner(opts.SignerCommand, caConfig, rootKey, caCrt, extensions, opts.CertValidity)
+		}
+	} else {
+		clientCert, err = newSignedCert(caConfig, rootKey, caCrt, caKey, opts.CertValidity)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("Failed to generate %s certificate: %v", commonName, err)
 	}
@@ -73,32 +83,23 @@ func GenerateCertSigningRequestAndKey(
 	serverCrt bool,
 	commonName string,
 	altNames *cert.AltNames,
-	reusedKey *rsa.PrivateKey,
-	orgs []string) ([]byte, *rsa.PrivateKey, error) {
+	reusedKey crypto.Signer,
+	orgs []string,
+	opts CertOptions) ([]byte, crypto.Signer, error) {
 	// Generate a generic signed certificate
-	var rootKey *rsa.PrivateKey
+	var rootKey crypto.Signer
 	var err error
 	rootKey = reusedKey
 	if reusedKey == nil {
-		rootKey, err = cert.NewPrivateKey()
+		rootKey, err = NewPrivateKey(opts.KeyType)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to generate private key for %s certificate: %v", commonName, err)
 		}
 	}
-	usages := []asn1.ObjectIdentifier{oidExtKeyUsageClientAuth}
-	if serverCrt {
-		usages = append(usages, oidExtKeyUsageServerAuth)
-	}
-	marshalledUsages, err := asn1.Marshal(usages)
+	extensions, err := newExtKeyUsageExtensions(serverCrt)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error marshalling key usages while generating csr: %v", err)
+		return nil, nil, err
 	}
-
-	extensions := []pkix.Extension{{
-		Id:       oidExtensionExtendedKeyUsage,
-		Critical: false,
-		Value:    marshalledUsages,
-	}}
 	if altNames == nil {
 		altNames = &cert.AltNames{}
 	}
@@ -115,11 +116,27 @@ func GenerateCertSigningRequestAndKey(
 	return clientCSR, rootKey, nil
 }
 
-func GenerateCACertAndKey(commonName string, privateKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey, error) {
+func newExtKeyUsageExtensions(serverCrt bool) ([]pkix.Extension, error) {
+	usages := []asn1.ObjectIdentifier{oidExtKeyUsageClientAuth}
+	if serverCrt {
+		usages = append(usages, oidExtKeyUsageServerAuth)
+	}
+	marshalledUsages, err := asn1.Marshal(usages)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling key usages while generating csr: %v", err)
+	}
+	return []pkix.Extension{{
+		Id:       oidExtensionExtendedKeyUsage,
+		Critical: false,
+		Value:    marshalledUsages,
+	}}, nil
+}
+
+func GenerateCACertAndKey(commonName string, privateKey crypto.Signer, opts CertOptions) (*x509.Certificate, crypto.Signer, error) {
 	var err error
 	rootKey := privateKey
 	if rootKey == nil {
-		rootKey, err = cert.NewPrivateKey()
+		rootKey, err = NewPrivateKey(opts.KeyType)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to generate private key for CA certificate: %v", err)
 		}
@@ -127,7 +144,7 @@ func GenerateCACertAndKey(commonName string, privateKey *rsa.PrivateKey) (*x509.
 	caConfig := cert.Config{
 		CommonName: commonName,
 	}
-	kubeCACert, err := cert.NewSelfSignedCACert(caConfig, rootKey)
+	kubeCACert, err := newSelfSignedCACert(caConfig, rootKey, opts.CAValidity)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Failed to generate CA certificate: %v", err)
 	}
@@ -212,10 +229,14 @@ func GetAltNames(cpHosts []*hosts.Host, clusterDomain string, KubernetesServiceI
 	}
 }
 
-func (c *CertificatePKI) ToEnv() []string {
+func (c *CertificatePKI) ToEnv() ([]string, error) {
 	env := []string{}
 	if c.Key != nil {
-		env = append(env, c.KeyToEnv())
+		keyEnv, err := c.KeyToEnv()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode the key of [%s]: %v", c.Name, err)
+		}
+		env = append(env, keyEnv)
 	}
 	if c.Certificate != nil {
 		env = append(env, c.CertToEnv())
@@ -223,17 +244,24 @@ func (c *CertificatePKI) ToEnv() []string {
 	if c.Config != "" && c.ConfigEnvName != "" {
 		env = append(env, c.ConfigToEnv())
 	}
-	return env
+	return env, nil
 }
 
 func (c *CertificatePKI) CertToEnv() string {
 	encodedCrt := cert.EncodeCertPEM(c.Certificate)
+	// the PEM of an external CA certificate holds its chain
+	if c.Name == CACertName && c.CertificatePEM != "" {
+		encodedCrt = []byte(c.CertificatePEM)
+	}
 	return fmt.Sprintf("%s=%s", c.EnvName, string(encodedCrt))
 }
 
-func (c *CertificatePKI) KeyToEnv() string {
-	encodedKey := cert.EncodePrivateKeyPEM(c.Key)
-	return fmt.Sprintf("%s=%s", c.KeyEnvName, string(encodedKey))
+func (c *CertificatePKI) KeyToEnv() (string, error) {
+	encodedKey, err := cert.EncodePrivateKeyPEM(c.Key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s=%s", c.KeyEnvName, string(encodedKey)), nil
 }
 
 func (c *CertificatePKI) ConfigToEnv() string {
@@ -286,7 +314,7 @@ func GetConfigTempPath(name string) string {
 	return TempCertPath + "kubecfg-" + name + ".yaml"
 }
 
-func ToCertObject(componentName, commonName, ouName string, certificate *x509.Certificate, key *rsa.PrivateKey, csrASN1 []byte) CertificatePKI {
+func ToCertObject(componentName, commonName, ouName string, certificate *x509.Certificate, key crypto.Signer, csrASN1 []byte) CertificatePKI {
 	var config, configPath, configEnvName, certificatePEM, keyPEM string
 	var csr *x509.CertificateRequest
 	var csrPEM []byte
@@ -303,7 +331,9 @@ func ToCertObject(componentName, commonName, ouName string, certificate *x509.Ce
 		certificatePEM = string(cert.EncodeCertPEM(certificate))
 	}
 	if key != nil {
-		keyPEM = string(cert.EncodePrivateKeyPEM(key))
+		// the keys are generated by rke or parsed by cert.ParsePrivateKeyPEM, both only return RSA and ECDSA keys
+		encodedKey, _ := cert.EncodePrivateKeyPEM(key)
+		keyPEM = string(encodedKey)
 	}
 	if csrASN1 != nil {
 		csr, _ = x509.ParseCertificateRequest(csrASN1)
@@ -441,8 +471,32 @@ func populateCertMap(tmpCerts map[string]CertificatePKI, localConfigPath string,
 	return certs
 }
 
-// Overriding k8s.io/client-go/util/cert.NewSignedCert function to extend the expiration date to 10 years instead of 1 year
-func newSignedCert(cfg cert.Config, key *rsa.PrivateKey, caCert *x509.Certificate, caKey *rsa.PrivateKey) (*x509.Certificate, error) {
+// Overriding k8s.io/client-go/util/cert.NewSelfSignedCACert function to set the validity of the CA certificate
+func newSelfSignedCACert(cfg cert.Config, key crypto.Signer, validity time.Duration) (*x509.Certificate, error) {
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber: new(big.Int).SetInt64(0),
+		Subject: pkix.Name{
+			CommonName:   cfg.CommonName,
+			Organization: cfg.Organization,
+		},
+		NotBefore:             now.UTC(),
+		NotAfter:              now.Add(validity).UTC(),
+		KeyUsage:              keyUsage(key) | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDERBytes)
+}
+
+// Overriding k8s.io/client-go/util/cert.NewSignedCert function to set the validity of the certificate instead of 1 year,
+// the certificate does not outlive its CA
+func newSignedCert(cfg cert.Config, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer, validity time.Duration) (*x509.Certificate, error) {
 	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 	if err != nil {
 		return nil, err
@@ -463,10 +517,13 @@ func newSignedCert(cfg cert.Config, key *rsa.PrivateKey, caCert *x509.Certificat
 		IPAddresses:  cfg.AltNames.IPs,
 		SerialNumber: serial,
 		NotBefore:    caCert.NotBefore,
-		NotAfter:     time.Now().Add(duration365d * 10).UTC(),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:     time.Now().Add(validity).UTC(),
+		KeyUsage:     keyUsage(key),
 		ExtKeyUsage:  cfg.Usages,
 	}
+	if certTmpl.NotAfter.After(caCert.NotAfter) {
+		certTmpl.NotAfter = caCert.NotAfter
+	}
 	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &certTmpl, caCert, key.Public(), caKey)
 	if err != nil {
 		return nil, err
@@ -474,7 +531,20 @@ func newSignedCert(cfg cert.Config, key *rsa.PrivateKey, caCert *x509.Certificat
 	return x509.ParseCertificate(certDERBytes)
 }
 
-func newCertSigningRequest(cfg cert.Config, key *rsa.PrivateKey, extensions []pkix.Extension) ([]byte, error) {
+// isValidCertificate returns true if the certificate is signed by the CA and has not expired
+func isValidCertificate(certificate, caCrt *x509.Certificate) bool {
+	return certificate != nil && certificate.CheckSignatureFrom(caCrt) == nil && time.Now().Before(certificate.NotAfter)
+}
+
+// keyUsage returns the key usage of the certificate of the key, only RSA keys are used for key encipherment
+func keyUsage(key crypto.Signer) x509.KeyUsage {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+func newCertSigningRequest(cfg cert.Config, key crypto.Signer, extensions []pkix.Extension) ([]byte, error) {
 	if len(cfg.CommonName) == 0 {
 		return nil, errors.New("must specify a CommonName")
 	}
@@ -525,9 +595,6 @@ func TransformPEMToObject(in map[string]CertificatePKI) map[string]CertificatePK
 		if len(certs) > 0 {
 			certificate = certs[0]
 		}
-		if key != nil {
-			key = key.(*rsa.PrivateKey)
-		}
 		o := CertificatePKI{
 			ConfigEnvName:  v.ConfigEnvName,
 			Name:           v.Name,
@@ -543,8 +610,8 @@ func TransformPEMToObject(in map[string]CertificatePKI) map[string]CertificatePK
 			CertificatePEM: v.CertificatePEM,
 			KeyPEM:         v.KeyPEM,
 		}
-		if key != nil {
-			o.Key = key.(*rsa.PrivateKey)
+		if signer, ok := key.(crypto.Signer); ok {
+			o.Key = signer
 		}
 
 		out[k] = o
@@ -605,6 +672,11 @@ func ReadCertsAndKeysFromDir(certDir string) (map[string]CertificatePKI, error)
 			}
 			// fetching the cert's key
 			certName := strings.TrimSuffix(file.Name(), ".pem")
+			if certName == CACertName {
+				if err := verifyCAChainFromFile(certDir, file.Name()); err != nil {
+					return nil, err
+				}
+			}
 			key, err := getKeyFromFile(certDir, certName+"-key.pem")
 			if err != nil {
 				return nil, err
@@ -650,15 +722,32 @@ func getCertFromFile(certDir string, fileName string) (*x509.Certificate, error)
 	return certificate, nil
 }
 
-func getKeyFromFile(certDir string, fileName string) (*rsa.PrivateKey, error) {
-	var key *rsa.PrivateKey
+// verifyCAChainFromFile verifies the chain following the CA certificate in the file, the CA can be an intermediate CA
+func verifyCAChainFromFile(certDir string, fileName string) error {
+	certPEM, err := ioutil.ReadFile(filepath.Join(certDir, fileName))
+	if err != nil || len(certPEM) == 0 {
+		return nil
+	}
+	certificates, err := cert.ParseCertsPEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate [%s]: %v", fileName, err)
+	}
+	return verifyCAChain(certificates[0], certificates[1:])
+}
+
+func getKeyFromFile(certDir string, fileName string) (crypto.Signer, error) {
+	var key crypto.Signer
 	keyPEM, _ := ioutil.ReadFile(filepath.Join(certDir, fileName))
 	if len(keyPEM) > 0 {
 		keyInterface, err := cert.ParsePrivateKeyPEM(keyPEM)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read key [%s], make sure it is not encrypted: %v", fileName, err)
 		}
-		key = keyInterface.(*rsa.PrivateKey)
+		signer, ok := keyInterface.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("failed to read key [%s]: unsupported key type %T", fileName, keyInterface)
+		}
+		key = signer
 	}
 	return key, nil
 }
@@ -733,6 +822,9 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 		if certBundle[certName].Certificate == nil || certBundle[certName].Key == nil {
 			return fmt.Errorf("Failed to find [%s] Certificate or Key", certName)
 		}
+		if err := validateKeyPair(certName, certBundle[certName]); err != nil {
+			return err
+		}
 	}
 	etcdHosts := hosts.NodesToHosts(rkeConfig.Nodes, etcdRole)
 	for _, host := range etcdHosts {
@@ -740,19 +832,26 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 		if certBundle[etcdName].Certificate == nil || certBundle[etcdName].Key == nil {
 			return fmt.Errorf("Failed to find etcd [%s] Certificate or Key", etcdName)
 		}
+		if err := validateKeyPair(etcdName, certBundle[etcdName]); err != nil {
+			return err
+		}
 	}
 	// Configure kubeconfig
 	cpHosts := hosts.NodesToHosts(rkeConfig.Nodes, controlRole)
 	localKubeConfigPath := GetLocalKubeConfig(configPath, configDir)
 	if len(cpHosts) > 0 {
 		kubeAdminCertObj := certBundle[KubeAdminCertName]
+		kubeAdminKey, err := cert.EncodePrivateKeyPEM(kubeAdminCertObj.Key)
+		if err != nil {
+			return fmt.Errorf("Failed to encode the key of [%s]: %v", KubeAdminCertName, err)
+		}
 		kubeAdminConfig := GetKubeConfigX509WithData(
 			"https://"+cpHosts[0].Address+":6443",
 			rkeConfig.ClusterName,
 			KubeAdminCertName,
 			string(cert.EncodeCertPEM(certBundle[CACertName].Certificate)),
 			string(cert.EncodeCertPEM(certBundle[KubeAdminCertName].Certificate)),
-			string(cert.EncodePrivateKeyPEM(certBundle[KubeAdminCertName].Key)))
+			string(kubeAdminKey))
 		kubeAdminCertObj.Config = kubeAdminConfig
 		kubeAdminCertObj.ConfigPath = localKubeConfigPath
 		certBundle[KubeAdminCertName] = kubeAdminCertObj
@@ -760,6 +859,15 @@ func ValidateBundleContent(rkeConfig *v3.RancherKubernetesEngineConfig, certBund
 	return validateCAIssuer(rkeConfig, certBundle)
 }
 
+// validateKeyPair makes sure the key is the private key of the certificate, the keys can be RSA or ECDSA keys
+func validateKeyPair(certName string, certPKI CertificatePKI) error {
+	publicKey, ok := certPKI.Key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(certPKI.Certificate.PublicKey) {
+		return fmt.Errorf("Key of [%s] Certificate does not match the certificate", certName)
+	}
+	return nil
+}
+
 func validateCAIssuer(rkeConfig *v3.RancherKubernetesEngineConfig, certBundle map[string]CertificatePKI) error {
 	// make sure all certs are signed by CA cert
 	caCert := certBundle[CACertName].Certificate
@@ -777,13 +885,13 @@ func validateCAIssuer(rkeConfig *v3.RancherKubernetesEngineConfig, certBundle ma
 		ComponentsCerts = append(ComponentsCerts, etcdName)
 	}
 	for _, componentCert := range ComponentsCerts {
-		if certBundle[componentCert].Certificate.Issuer.CommonName != caCert.Subject.CommonName {
-			return fmt.Errorf("Component [%s] is not signed by the custom CA certificate", componentCert)
+		if err := certBundle[componentCert].Certificate.CheckSignatureFrom(caCert); err != nil {
+			return fmt.Errorf("Component [%s] is not signed by the custom CA certificate: %v", componentCert, err)
 		}
 	}
 	requestHeaderCACert := certBundle[RequestHeaderCACertName].Certificate
-	if certBundle[APIProxyClientCertName].Certificate.Issuer.CommonName != requestHeaderCACert.Subject.CommonName {
-		return fmt.Errorf("Component [%s] is not signed by the custom Request Header CA certificate", APIProxyClientCertName)
+	if err := certBundle[APIProxyClientCertName].Certificate.CheckSignatureFrom(requestHeaderCACert); err != nil {
+		return fmt.Errorf("Component [%s] is not signed by the custom Request Header CA certificate: %v", APIProxyClientCertName, err)
 	}
 	return nil
 }
@@ -82,8 +82,34 @@ func UpgradeControlPlaneNodes(ctx context.Context, kubeClient *kubernetes.Client
 		}
 		inactiveHostErr = fmt.Errorf("provisioning incomplete, host(s) [%s] skipped because they could not be contacted", strings.Join(inactiveHostNames, ","))
 	}
-	hostsFailedToUpgrade, err := processControlPlaneForUpgrade(ctx, kubeClient, controlHosts, localConnDialerFactory, prsMap, cpNodePlanMap, updateWorkersOnly, alpineImage, certMap,
-		upgradeStrategy, newHosts, inactiveHosts, maxUnavailable, drainHelper)
+	var hostsFailedToUpgrade []string
+	var err error
+	waves := getUpgradeWaves(kubeClient, controlHosts, upgradeStrategy)
+	pauses := &upgradeWavePauses{
+		pause:     upgradeStrategy.Pause,
+		component: ControlRole,
+		isUpgradable: func(host *hosts.Host) (bool, error) {
+			controlPlaneUpgradable, workerPlaneUpgradable, err := checkHostUpgradable(ctx, host, cpNodePlanMap)
+			return controlPlaneUpgradable || workerPlaneUpgradable, err
+		},
+	}
+	for i, wave := range waves {
+		if err := pauses.before(ctx, wave, newHosts); err != nil {
+			return errMsgMaxUnavailableNotFailed, err
+		}
+		logUpgradeWave(ctx, ControlRole, waves, i)
+		waveMaxUnavailable := maxUnavailable
+		if wave.canary {
+			waveMaxUnavailable = 1
+		}
+		var waveHostsFailedToUpgrade []string
+		waveHostsFailedToUpgrade, err = processControlPlaneForUpgrade(ctx, kubeClient, wave.hosts, localConnDialerFactory, prsMap, cpNodePlanMap, updateWorkersOnly, alpineImage, certMap,
+			upgradeStrategy, newHosts, inactiveHosts, waveMaxUnavailable, drainHelper)
+		hostsFailedToUpgrade = append(hostsFailedToUpgrade, waveHostsFailedToUpgrade...)
+		if err != nil {
+			break
+		}
+	}
 	if err != nil || inactiveHostErr != nil {
 		if len(hostsFailedToUpgrade) > 0 {
 			logrus.Errorf("Failed to upgrade hosts: %v with error %v", strings.Join(hostsFailedToUpgrade, ","), err)
@@ -217,6 +243,7 @@ func checkHostUpgradable(ctx context.Context, runHost *hosts.Host, cpNodePlanMap
 func upgradeControlHost(ctx context.Context, kubeClient *kubernetes.Clientset, host *hosts.Host, drain bool, drainHelper drain.Helper,
 	localConnDialerFactory hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, cpNodePlanMap map[string]v3.RKEConfigNodePlan, updateWorkersOnly bool,
 	alpineImage string, certMap map[string]pki.CertificatePKI, controlPlaneUpgradable, workerPlaneUpgradable bool) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: host.Address})
 	if err := cordonAndDrainNode(kubeClient, host, drain, drainHelper, ControlRole); err != nil {
 		return err
 	}
@@ -319,6 +346,7 @@ func RestartControlPlane(ctx context.Context, controlHosts []*hosts.Host) error
 }
 
 func doDeployControlHost(ctx context.Context, host *hosts.Host, localConnDialerFactory hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, processMap map[string]v3.Process, alpineImage string, certMap map[string]pki.CertificatePKI) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: host.Address})
 	if host.IsWorker {
 		if err := removeNginxProxy(ctx, host); err != nil {
 			return err
@@ -5,12 +5,14 @@ import (
 	"encoding/base64"
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	etcdclient "github.com/coreos/etcd/client"
 	"github.com/docker/docker/api/types/container"
 	"github.com/pkg/errors"
+	"github.com/rancher/rke/backup"
 	"github.com/rancher/rke/docker"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
@@ -47,6 +49,7 @@ func RunEtcdPlane(
 			continue
 		}
 
+		ctx := log.WithFields(ctx, log.Fields{Host: host.Address})
 		etcdProcess := etcdNodePlanMap[host.Address].Processes[EtcdContainerName]
 
 		// need to run this first to set proper ownership and permissions on etcd data dir
@@ -71,10 +74,23 @@ func RunEtcdPlane(
 			if err := createLogLink(ctx, host, EtcdSnapshotContainerName, ETCDRole, alpineImage, prsMap); err != nil {
 				return err
 			}
+			if backup.HasSnapshotUploader(es.BackupConfig) {
+				if err := RunEtcdSnapshotUploader(ctx, host, prsMap, rkeToolsImage, es.BackupConfig); err != nil {
+					return err
+				}
+				if err := createLogLink(ctx, host, EtcdSnapshotUploaderContainerName, ETCDRole, alpineImage, prsMap); err != nil {
+					return err
+				}
+			} else if err := docker.DoRemoveContainer(ctx, host.DClient, EtcdSnapshotUploaderContainerName, host.Address); err != nil {
+				return err
+			}
 		} else {
 			if err := docker.DoRemoveContainer(ctx, host.DClient, EtcdSnapshotContainerName, host.Address); err != nil {
 				return err
 			}
+			if err := docker.DoRemoveContainer(ctx, host.DClient, EtcdSnapshotUploaderContainerName, host.Address); err != nil {
+				return err
+			}
 		}
 		if err := createLogLink(ctx, host, EtcdContainerName, ETCDRole, alpineImage, prsMap); err != nil {
 			return err
@@ -82,7 +98,10 @@ func RunEtcdPlane(
 	}
 	log.Infof(ctx, "[%s] Successfully started etcd plane.. Checking etcd cluster health", ETCDRole)
 	clientCert := cert.EncodeCertPEM(certMap[pki.KubeNodeCertName].Certificate)
-	clientKey := cert.EncodePrivateKeyPEM(certMap[pki.KubeNodeCertName].Key)
+	clientKey, err := cert.EncodePrivateKeyPEM(certMap[pki.KubeNodeCertName].Key)
+	if err != nil {
+		return fmt.Errorf("Failed to encode the key of [%s]: %v", pki.KubeNodeCertName, err)
+	}
 	var healthError error
 	var hosts []string
 	for _, host := range etcdHosts {
@@ -141,6 +160,9 @@ func RemoveEtcdPlane(ctx context.Context, etcdHosts []*hosts.Host, force bool) e
 				if err := docker.DoRemoveContainer(ctx, runHost.DClient, EtcdSnapshotContainerName, runHost.Address); err != nil {
 					errList = append(errList, err)
 				}
+				if err := docker.DoRemoveContainer(ctx, runHost.DClient, EtcdSnapshotUploaderContainerName, runHost.Address); err != nil {
+					errList = append(errList, err)
+				}
 				if !runHost.IsWorker || !runHost.IsControl || force {
 					// remove unschedulable kubelet on etcd host
 					if err := removeKubelet(ctx, runHost); err != nil {
@@ -597,7 +619,7 @@ func RunEtcdSnapshotRemove(ctx context.Context, etcdHost *hosts.Host, prsMap map
 	if cleanupRestore {
 		imageCfg.Cmd = append(imageCfg.Cmd, "--cleanup")
 	}
-	if es.BackupConfig != nil && es.BackupConfig.S3BackupConfig != nil {
+	if backup.IsTransferredByRKETools(es.BackupConfig) {
 		s3cmd := []string{
 			"--s3-backup",
 			"--s3-endpoint=" + es.BackupConfig.S3BackupConfig.Endpoint,
@@ -699,7 +721,7 @@ func configS3BackupImgCmd(ctx context.Context, imageCfg *container.Config, bc *v
 		"--retention=" + fmt.Sprintf("%dh", bc.Retention*bc.IntervalHours),
 	}
 
-	if bc.S3BackupConfig != nil {
+	if backup.IsTransferredByRKETools(bc) {
 		cmd = append(cmd, []string{
 			"--s3-backup=true",
 			"--s3-endpoint=" + bc.S3BackupConfig.Endpoint,
@@ -873,3 +895,76 @@ func getSanitizedSnapshotCmd(imageCfg *container.Config, bc *v3.BackupConfig) st
 	}
 	return cmd
 }
+
+// EtcdSnapshotFile is a snapshot file found in the snapshot directory of an etcd host
+type EtcdSnapshotFile struct {
+	Name      string
+	Size      int64
+	Created   time.Time
+	StateFile bool
+}
+
+// ListEtcdSnapshotsOnHost returns the snapshot files of the etcd host, StateFile is set for the compressed snapshots
+// that include the cluster state file
+func ListEtcdSnapshotsOnHost(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, etcdSnapshotImage string) ([]EtcdSnapshotFile, error) {
+	imageCfg := &container.Config{
+		Cmd: []string{
+			"sh", "-c", "cd /backup && for f in *; do [ -f \"$f\" ] || continue; s=0; " +
+				"case \"$f\" in *" + EtcdSnapshotCompressedExtension + ") unzip -l \"$f\" | grep -q '" + pki.ClusterStateExt + "$' && s=1;; esac; " +
+				"printf '%s\\t%s\\t%s\\t%s\\n' \"$f\" \"$(stat -c %s \"$f\")\" \"$(stat -c %Y \"$f\")\" \"$s\"; done",
+		},
+		Image: etcdSnapshotImage,
+	}
+	hostCfg := &container.HostConfig{
+		Binds: []string{
+			fmt.Sprintf("%s:/backup:ro", EtcdSnapshotPath),
+		},
+		RestartPolicy: container.RestartPolicy{Name: "no"},
+	}
+	if hosts.IsDockerSELinuxEnabled(etcdHost) {
+		hostCfg.SecurityOpt = append(hostCfg.SecurityOpt, SELinuxLabel)
+	}
+	if err := docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdSnapshotListContainerName, etcdHost.Address); err != nil {
+		return nil, err
+	}
+	if err := docker.DoRunContainer(ctx, etcdHost.DClient, imageCfg, hostCfg, EtcdSnapshotListContainerName, etcdHost.Address, ETCDRole, prsMap); err != nil {
+		return nil, err
+	}
+	status, err := docker.WaitForContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotListContainerName)
+	if err != nil {
+		return nil, err
+	}
+	stderr, stdout, err := docker.GetContainerLogsStdoutStderr(ctx, etcdHost.DClient, EtcdSnapshotListContainerName, "all", false)
+	if err != nil {
+		return nil, err
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("Failed to list snapshots on host [%s], exit code [%d]: %v", etcdHost.Address, status, stderr)
+	}
+	if err := docker.RemoveContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotListContainerName); err != nil {
+		return nil, err
+	}
+
+	var snapshots []EtcdSnapshotFile
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 4 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		created, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, EtcdSnapshotFile{
+			Name:      fields[0],
+			Size:      size,
+			Created:   time.Unix(created, 0),
+			StateFile: fields[3] == "1",
+		})
+	}
+	return snapshots, nil
+}
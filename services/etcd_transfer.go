@@ -0,0 +1,99 @@
+package services
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+)
+
+const (
+	EtcdSnapshotCompressedExtension = ".zip"
+	etcdSnapshotTransferDir         = "/backup"
+)
+
+// CopyEtcdSnapshotFromHost writes the snapshot file of the etcd host to writer, the compressed snapshot is preferred
+// over the uncompressed one. It returns the name of the file that was copied.
+func CopyEtcdSnapshotFromHost(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, etcdSnapshotImage, name string, writer io.Writer) (string, error) {
+	if err := runEtcdSnapshotTransferContainer(ctx, etcdHost, prsMap, etcdSnapshotImage); err != nil {
+		return "", err
+	}
+	defer docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdSnapshotTransferContainerName, etcdHost.Address)
+
+	for _, fileName := range []string{name + EtcdSnapshotCompressedExtension, name} {
+		filePath := path.Join(etcdSnapshotTransferDir, fileName)
+		if _, err := etcdHost.DClient.ContainerStatPath(ctx, EtcdSnapshotTransferContainerName, filePath); err != nil {
+			continue
+		}
+		log.Infof(ctx, "[etcd] Copying snapshot file [%s] from host [%s]", fileName, etcdHost.Address)
+		return fileName, docker.CopyFileFromContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotTransferContainerName, filePath, writer)
+	}
+	return "", fmt.Errorf("Failed to find snapshot [%s] in [%s] on host [%s]", name, EtcdSnapshotPath, etcdHost.Address)
+}
+
+// DeployEtcdSnapshotOnHost copies the local snapshot file to the snapshot directory of the etcd host as fileName
+func DeployEtcdSnapshotOnHost(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, etcdSnapshotImage, fileName string, snapshotFile *os.File) error {
+	fileInfo, err := snapshotFile.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := snapshotFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := runEtcdSnapshotTransferContainer(ctx, etcdHost, prsMap, etcdSnapshotImage); err != nil {
+		return err
+	}
+	defer docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdSnapshotTransferContainerName, etcdHost.Address)
+
+	log.Infof(ctx, "[etcd] Copying snapshot file [%s] to host [%s]", fileName, etcdHost.Address)
+	reader, writer := io.Pipe()
+	go func() {
+		tarWriter := tar.NewWriter(writer)
+		err := tarWriter.WriteHeader(&tar.Header{
+			Name:    fileName,
+			Mode:    0600,
+			Size:    fileInfo.Size(),
+			ModTime: fileInfo.ModTime(),
+		})
+		if err == nil {
+			_, err = io.Copy(tarWriter, snapshotFile)
+		}
+		if err == nil {
+			err = tarWriter.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	err = docker.DoCopyToContainer(ctx, etcdHost.DClient, ETCDRole, EtcdSnapshotTransferContainerName, etcdHost.Address, etcdSnapshotTransferDir, reader)
+	reader.Close()
+	return err
+}
+
+// runEtcdSnapshotTransferContainer leaves a stopped container with the snapshot directory mounted, files are copied
+// in and out of it through the docker API
+func runEtcdSnapshotTransferContainer(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, etcdSnapshotImage string) error {
+	imageCfg := &container.Config{
+		Cmd:   []string{"true"},
+		Image: etcdSnapshotImage,
+	}
+	hostCfg := &container.HostConfig{
+		Binds: []string{
+			fmt.Sprintf("%s:%s", EtcdSnapshotPath, etcdSnapshotTransferDir),
+		},
+		RestartPolicy: container.RestartPolicy{Name: "no"},
+	}
+	if hosts.IsDockerSELinuxEnabled(etcdHost) {
+		hostCfg.SecurityOpt = append(hostCfg.SecurityOpt, SELinuxLabel)
+	}
+	if err := docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdSnapshotTransferContainerName, etcdHost.Address); err != nil {
+		return err
+	}
+	return docker.DoRunOnetimeContainer(ctx, etcdHost.DClient, imageCfg, hostCfg, EtcdSnapshotTransferContainerName, etcdHost.Address, ETCDRole, prsMap)
+}
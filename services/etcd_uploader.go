@@ -0,0 +1,272 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rancher/rke/backup"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+)
+
+const (
+	etcdSnapshotUploaderDir           = "/opt/rke-snapshot-uploader"
+	etcdSnapshotUploaderBinary        = "rke"
+	etcdSnapshotUploaderConfig        = "backup.json"
+	etcdSnapshotUploaderChecksumLabel = "io.rancher.rke.snapshot-uploader.checksum"
+	etcdSnapshotEncryptedDir          = "/encrypted"
+)
+
+// getEtcdSnapshotUploaderBinaryPath returns the rke binary to run on the etcd host
+var getEtcdSnapshotUploaderBinaryPath = getEtcdSnapshotUploaderBinary
+
+// dockerArchitectures maps the architecture reported by docker to the GOARCH of the rke binary
+var dockerArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+}
+
+// etcdSnapshotUploaderFiles are the rke binary and the backup configuration copied to the uploader containers
+type etcdSnapshotUploaderFiles struct {
+	binaryPath string
+	config     []byte
+	// checksum of the binary and the configuration
+	checksum string
+}
+
+// RunEtcdSnapshotUploader runs the container uploading the recurring snapshots of the etcd host to the backup backend.
+// rke-tools only uploads plain snapshots to S3, the container runs the rke binary itself, copied from the machine
+// running rke, to encrypt the snapshots on the host and upload them to the other backends.
+func RunEtcdSnapshotUploader(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, etcdSnapshotImage string, bc *v3.BackupConfig) error {
+	hostConfig, err := backup.HostConfig(bc)
+	if err != nil {
+		return err
+	}
+	files, err := getEtcdSnapshotUploaderFiles(etcdHost, hostConfig)
+	if err != nil {
+		return err
+	}
+	imageCfg := &container.Config{
+		Cmd:    getEtcdSnapshotUploaderCmd(),
+		Image:  etcdSnapshotImage,
+		Labels: map[string]string{etcdSnapshotUploaderChecksumLabel: files.checksum},
+	}
+	hostCfg := &container.HostConfig{
+		Binds: []string{
+			fmt.Sprintf("%s:%s:ro", EtcdSnapshotPath, etcdSnapshotTransferDir),
+		},
+		NetworkMode:   container.NetworkMode("host"),
+		RestartPolicy: container.RestartPolicy{Name: "always"},
+	}
+	// the binary is only copied again when rke or the backup configuration changed
+	if current, err := docker.InspectContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotUploaderContainerName); err == nil &&
+		current.State != nil && current.State.Running && current.Config != nil &&
+		current.Config.Image == etcdSnapshotImage && current.Config.Labels[etcdSnapshotUploaderChecksumLabel] == files.checksum {
+		log.Infof(ctx, "[etcd] Snapshot uploader container [%s] is already running on host [%s]", EtcdSnapshotUploaderContainerName, etcdHost.Address)
+		return nil
+	}
+	log.Infof(ctx, "[etcd] Running snapshot uploader container [%s] on host [%s]", EtcdSnapshotUploaderContainerName, etcdHost.Address)
+	return startEtcdSnapshotUploaderContainer(ctx, etcdHost, prsMap, EtcdSnapshotUploaderContainerName, imageCfg, hostCfg, files)
+}
+
+// CopyEncryptedEtcdSnapshotFromHost encrypts the snapshot on the etcd host and writes the encrypted file to writer,
+// the plain snapshot doesn't leave the host. It returns the name of the file that was copied.
+func CopyEncryptedEtcdSnapshotFromHost(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, etcdSnapshotImage string, bc *v3.BackupConfig, name string, writer io.Writer) (string, error) {
+	hostConfig, err := backup.HostConfig(bc)
+	if err != nil {
+		return "", err
+	}
+	// the encrypted file is written in the container and copied from there, the host doesn't need to reach the backend
+	hostConfig = &v3.BackupConfig{
+		FilesystemBackupConfig: &v3.FilesystemBackupConfig{Path: etcdSnapshotEncryptedDir},
+		Encryption:             hostConfig.Encryption,
+	}
+	files, err := getEtcdSnapshotUploaderFiles(etcdHost, hostConfig)
+	if err != nil {
+		return "", err
+	}
+	imageCfg := &container.Config{
+		Cmd:   append(getEtcdSnapshotUploaderCmd(), "--name", name),
+		Image: etcdSnapshotImage,
+	}
+	hostCfg := &container.HostConfig{
+		Binds: []string{
+			fmt.Sprintf("%s:%s:ro", EtcdSnapshotPath, etcdSnapshotTransferDir),
+		},
+		RestartPolicy: container.RestartPolicy{Name: "no"},
+	}
+	log.Infof(ctx, "[etcd] Encrypting snapshot [%s] on host [%s]", name, etcdHost.Address)
+	if err := startEtcdSnapshotUploaderContainer(ctx, etcdHost, prsMap, EtcdSnapshotEncryptContainerName, imageCfg, hostCfg, files); err != nil {
+		return "", err
+	}
+	defer docker.DoRemoveContainer(ctx, etcdHost.DClient, EtcdSnapshotEncryptContainerName, etcdHost.Address)
+	status, err := docker.WaitForContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotEncryptContainerName)
+	if err != nil {
+		return "", err
+	}
+	if status != 0 {
+		stderr, _, err := docker.GetContainerLogsStdoutStderr(ctx, etcdHost.DClient, EtcdSnapshotEncryptContainerName, "5", false)
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("Failed to encrypt snapshot [%s] on host [%s], exit code [%d]: %v", name, etcdHost.Address, status, stderr)
+	}
+	for _, fileName := range []string{name + EtcdSnapshotCompressedExtension, name} {
+		filePath := path.Join(etcdSnapshotEncryptedDir, fileName)
+		if _, err := etcdHost.DClient.ContainerStatPath(ctx, EtcdSnapshotEncryptContainerName, filePath); err != nil {
+			continue
+		}
+		log.Infof(ctx, "[etcd] Copying encrypted snapshot file [%s] from host [%s]", fileName, etcdHost.Address)
+		return fileName, docker.CopyFileFromContainer(ctx, etcdHost.DClient, etcdHost.Address, EtcdSnapshotEncryptContainerName, filePath, writer)
+	}
+	return "", fmt.Errorf("Failed to find encrypted snapshot [%s] on host [%s]", name, etcdHost.Address)
+}
+
+func getEtcdSnapshotUploaderCmd() []string {
+	return []string{
+		path.Join(etcdSnapshotUploaderDir, etcdSnapshotUploaderBinary),
+		"etcd", "snapshot-upload",
+		"--config", path.Join(etcdSnapshotUploaderDir, etcdSnapshotUploaderConfig),
+		"--dir", etcdSnapshotTransferDir,
+	}
+}
+
+func getEtcdSnapshotUploaderFiles(etcdHost *hosts.Host, hostConfig *v3.BackupConfig) (*etcdSnapshotUploaderFiles, error) {
+	binaryPath, err := getEtcdSnapshotUploaderBinaryPath(etcdHost)
+	if err != nil {
+		return nil, err
+	}
+	config, err := json.Marshal(hostConfig)
+	if err != nil {
+		return nil, err
+	}
+	binary, err := os.Open(binaryPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open rke binary [%s]: %v", binaryPath, err)
+	}
+	defer binary.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, binary); err != nil {
+		return nil, fmt.Errorf("Failed to read rke binary [%s]: %v", binaryPath, err)
+	}
+	hash.Write(config)
+	return &etcdSnapshotUploaderFiles{
+		binaryPath: binaryPath,
+		config:     config,
+		checksum:   fmt.Sprintf("%x", hash.Sum(nil)),
+	}, nil
+}
+
+// startEtcdSnapshotUploaderContainer replaces the container, copies the rke binary and the configuration in it and
+// starts it
+func startEtcdSnapshotUploaderContainer(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, containerName string, imageCfg *container.Config, hostCfg *container.HostConfig, files *etcdSnapshotUploaderFiles) error {
+	if hosts.IsDockerSELinuxEnabled(etcdHost) {
+		hostCfg.SecurityOpt = append(hostCfg.SecurityOpt, SELinuxLabel)
+	}
+	if err := docker.DoRemoveContainer(ctx, etcdHost.DClient, containerName, etcdHost.Address); err != nil {
+		return err
+	}
+	if err := docker.UseLocalOrPull(ctx, etcdHost.DClient, etcdHost.Address, imageCfg.Image, ETCDRole, prsMap); err != nil {
+		return err
+	}
+	if _, err := docker.CreateContainer(ctx, etcdHost.DClient, etcdHost.Address, containerName, imageCfg, hostCfg); err != nil {
+		return err
+	}
+	binary, err := os.Open(files.binaryPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open rke binary [%s]: %v", files.binaryPath, err)
+	}
+	defer binary.Close()
+	binaryInfo, err := binary.Stat()
+	if err != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeEtcdSnapshotUploaderFiles(writer, binary, binaryInfo.Size(), files.config))
+	}()
+	err = docker.DoCopyToContainer(ctx, etcdHost.DClient, ETCDRole, containerName, etcdHost.Address, "/", reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	return docker.StartContainer(ctx, etcdHost.DClient, etcdHost.Address, containerName)
+}
+
+// writeEtcdSnapshotUploaderFiles writes the tar archive with the rke binary and the backup configuration, the
+// configuration holds the backend credentials and is only readable by root
+func writeEtcdSnapshotUploaderFiles(writer io.Writer, binary io.Reader, binarySize int64, config []byte) error {
+	tarWriter := tar.NewWriter(writer)
+	files := []struct {
+		name   string
+		mode   int64
+		size   int64
+		reader io.Reader
+	}{
+		{etcdSnapshotUploaderBinary, 0755, binarySize, binary},
+		{etcdSnapshotUploaderConfig, 0600, int64(len(config)), bytes.NewReader(config)},
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Name: etcdSnapshotUploaderDir[1:] + "/", Mode: 0700, Typeflag: tar.TypeDir}); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Name: path.Join(etcdSnapshotUploaderDir[1:], file.name),
+			Mode: file.mode,
+			Size: file.size,
+		}); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tarWriter, file.reader); err != nil {
+			return err
+		}
+	}
+	return tarWriter.Close()
+}
+
+// CheckEtcdSnapshotUploader returns an error if the snapshots can't be encrypted or uploaded by the rke binary on the
+// etcd host
+func CheckEtcdSnapshotUploader(etcdHost *hosts.Host) error {
+	_, err := getEtcdSnapshotUploaderBinaryPath(etcdHost)
+	return err
+}
+
+// getEtcdSnapshotUploaderBinary returns the path of the running rke binary if it can run on the etcd host
+func getEtcdSnapshotUploaderBinary(etcdHost *hosts.Host) (string, error) {
+	hostArch, ok := dockerArchitectures[etcdHost.DockerInfo.Architecture]
+	if !ok {
+		hostArch = etcdHost.DockerInfo.Architecture
+	}
+	if runtime.GOOS != "linux" || runtime.GOARCH != hostArch {
+		return "", fmt.Errorf("Failed to run the etcd snapshot uploader on host [%s]: recurring snapshots to this backup backend are uploaded by the rke binary on the etcd hosts, "+
+			"rke has to run on linux/%s instead of %s/%s", etcdHost.Address, hostArch, runtime.GOOS, runtime.GOARCH)
+	}
+	binaryPath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the rke binary: %v", err)
+	}
+	// the binary runs in the rke-tools image, it can't depend on the libraries of the machine running rke
+	binary, err := elf.Open(binaryPath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read rke binary [%s]: %v", binaryPath, err)
+	}
+	defer binary.Close()
+	for _, prog := range binary.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return "", fmt.Errorf("Failed to run the etcd snapshot uploader on host [%s]: rke binary [%s] is dynamically linked, use a release binary or build it with CGO_ENABLED=0", etcdHost.Address, binaryPath)
+		}
+	}
+	return binaryPath, nil
+}
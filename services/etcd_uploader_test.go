@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rke/docker/dockertest"
+	"github.com/rancher/rke/hosts"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunEtcdSnapshotUploader(t *testing.T) {
+	ctx := context.Background()
+	binaryPath := filepath.Join(t.TempDir(), "rke")
+	assert.Nil(t, ioutil.WriteFile(binaryPath, []byte("rke binary"), 0755))
+	keyPath := filepath.Join(t.TempDir(), "id_rsa")
+	assert.Nil(t, ioutil.WriteFile(keyPath, []byte("private key"), 0600))
+	defer func(getBinaryPath func(*hosts.Host) (string, error)) {
+		getEtcdSnapshotUploaderBinaryPath = getBinaryPath
+	}(getEtcdSnapshotUploaderBinaryPath)
+	getEtcdSnapshotUploaderBinaryPath = func(*hosts.Host) (string, error) { return binaryPath, nil }
+
+	eventLog := &dockertest.EventLog{}
+	host := newFakeRuntimeHost("1.1.1.1", eventLog)
+	fake := host.DClient.(*dockertest.Runtime)
+	bc := &v3.BackupConfig{
+		IntervalHours:    12,
+		Retention:        6,
+		SFTPBackupConfig: &v3.SFTPBackupConfig{Address: "backup:22", User: "rke", SSHKeyPath: keyPath, HostKey: "ssh-ed25519 AAAA"},
+	}
+	assert.Nil(t, RunEtcdSnapshotUploader(ctx, host, nil, testAlpineImage, bc))
+	uploader, ok := fake.Container(EtcdSnapshotUploaderContainerName)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.True(t, uploader.Running)
+	assert.Equal(t, []string{"/opt/rke-snapshot-uploader/rke", "etcd", "snapshot-upload", "--config", "/opt/rke-snapshot-uploader/backup.json", "--dir", "/backup"}, []string(uploader.Config.Cmd))
+	assert.Equal(t, []string{EtcdSnapshotPath + ":/backup:ro"}, uploader.HostConfig.Binds)
+	assert.Equal(t, []byte("rke binary"), uploader.Files[path.Join(etcdSnapshotUploaderDir, etcdSnapshotUploaderBinary)])
+	var hostConfig v3.BackupConfig
+	assert.Nil(t, json.Unmarshal(uploader.Files[path.Join(etcdSnapshotUploaderDir, etcdSnapshotUploaderConfig)], &hostConfig))
+	// the ssh key is read on the machine running rke
+	assert.Equal(t, "private key", hostConfig.SFTPBackupConfig.SSHKey)
+	assert.Empty(t, hostConfig.SFTPBackupConfig.SSHKeyPath)
+
+	// the running uploader is kept if nothing changed
+	events := len(eventLog.Events())
+	assert.Nil(t, RunEtcdSnapshotUploader(ctx, host, nil, testAlpineImage, bc))
+	assert.Len(t, eventLog.Events(), events)
+	bc.Retention = 12
+	assert.Nil(t, RunEtcdSnapshotUploader(ctx, host, nil, testAlpineImage, bc))
+	assert.Contains(t, eventLog.Events()[events:], "1.1.1.1 remove "+EtcdSnapshotUploaderContainerName)
+	uploader, _ = fake.Container(EtcdSnapshotUploaderContainerName)
+	assert.Nil(t, json.Unmarshal(uploader.Files[path.Join(etcdSnapshotUploaderDir, etcdSnapshotUploaderConfig)], &hostConfig))
+	assert.Equal(t, 12, hostConfig.Retention)
+}
+
+func TestGetEtcdSnapshotUploaderBinary(t *testing.T) {
+	host := &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: "1.1.1.1"}}
+	host.DockerInfo.Architecture = "s390x"
+	_, err := getEtcdSnapshotUploaderBinary(host)
+	assert.NotNil(t, err)
+}
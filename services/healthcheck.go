@@ -26,6 +26,13 @@ const (
 )
 
 func runHealthcheck(ctx context.Context, host *hosts.Host, serviceName string, localConnDialerFactory hosts.DialerFactory, url string, certMap map[string]pki.CertificatePKI) error {
+	ctx, finish := log.StartEvent(ctx, log.Event{Phase: "healthcheck", Host: host.Address, Service: serviceName})
+	err := doHealthcheck(ctx, host, serviceName, localConnDialerFactory, url, certMap)
+	finish(err)
+	return err
+}
+
+func doHealthcheck(ctx context.Context, host *hosts.Host, serviceName string, localConnDialerFactory hosts.DialerFactory, url string, certMap map[string]pki.CertificatePKI) error {
 	log.Infof(ctx, "[healthcheck] Start Healthcheck on service [%s] on host [%s]", serviceName, host.Address)
 	var x509Pair tls.Certificate
 
@@ -35,7 +42,10 @@ func runHealthcheck(ctx context.Context, host *hosts.Host, serviceName string, l
 	}
 	if serviceName == KubeAPIContainerName {
 		certificate := cert.EncodeCertPEM(certMap[pki.KubeAPICertName].Certificate)
-		key := cert.EncodePrivateKeyPEM(certMap[pki.KubeAPICertName].Key)
+		key, err := cert.EncodePrivateKeyPEM(certMap[pki.KubeAPICertName].Key)
+		if err != nil {
+			return err
+		}
 		x509Pair, err = tls.X509KeyPair(certificate, key)
 		if err != nil {
 			return err
@@ -12,6 +12,7 @@ import (
 	v3 "github.com/rancher/rke/types"
 	"github.com/rancher/rke/util"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
@@ -36,6 +37,10 @@ const (
 	EtcdServeBackupContainerName                = "etcd-Serve-backup"
 	EtcdChecksumContainerName                   = "etcd-checksum-checker"
 	EtcdStateFileContainerName                  = "etcd-extract-statefile"
+	EtcdSnapshotTransferContainerName           = "etcd-snapshot-transfer"
+	EtcdSnapshotUploaderContainerName           = "etcd-snapshot-uploader"
+	EtcdSnapshotEncryptContainerName            = "etcd-snapshot-encrypt"
+	EtcdSnapshotListContainerName               = "etcd-list-snapshots"
 	ControlPlaneConfigMapStateFileContainerName = "extract-statefile-configmap"
 	NginxProxyContainerName                     = "nginx-proxy"
 	SidekickContainerName                       = "service-sidekick"
@@ -53,6 +58,9 @@ const (
 	ContainerNameLabel = "io.rancher.rke.container.name"
 	MCSLabel           = "label=level:s0:c1000,c1001"
 	SELinuxLabel       = "label=type:rke_container_t"
+
+	// minCPUShares is the minimum CPU shares accepted by docker
+	minCPUShares = 2
 )
 
 type RestartFunc func(context.Context, *hosts.Host) error
@@ -125,6 +133,16 @@ func GetProcessConfig(process v3.Process, host *hosts.Host) (*container.Config,
 	if len(process.RestartPolicy) > 0 {
 		hostCfg.RestartPolicy = container.RestartPolicy{Name: process.RestartPolicy}
 	}
+	if process.Resources != nil {
+		// the resources are validated with the cluster
+		resources, err := GetContainerResources(process.Resources)
+		if err != nil {
+			logrus.Warnf("Ignoring the resources of container [%s] on host [%s]: %v", process.Name, host.Address, err)
+		} else {
+			hostCfg.Resources = resources
+			hostCfg.OomScoreAdj = process.Resources.OOMScoreAdj
+		}
+	}
 	// The MCS label only needs to be applied when container is not running privileged, and running privileged negates need for applying the label
 	// If Docker is configured with selinux-enabled:true, we need to specify MCS label to allow files from service-sidekick to be shared between containers
 	if !process.Privileged && hosts.IsDockerSELinuxEnabled(host) {
@@ -151,6 +169,48 @@ func GetProcessConfig(process v3.Process, host *hosts.Host) (*container.Config,
 	return imageCfg, hostCfg, process.HealthCheck.URL
 }
 
+// GetContainerResources converts the resources of a service to the docker container resources, the CPU reservation is
+// applied as CPU shares with 1024 shares per core
+func GetContainerResources(r *v3.ContainerResources) (container.Resources, error) {
+	var resources container.Resources
+	if r.CPULimit != "" {
+		cpu, err := resource.ParseQuantity(r.CPULimit)
+		if err != nil {
+			return resources, fmt.Errorf("invalid cpu limit [%s]: %v", r.CPULimit, err)
+		}
+		resources.NanoCPUs = cpu.MilliValue() * 1e6
+	}
+	if r.CPUReservation != "" {
+		cpu, err := resource.ParseQuantity(r.CPUReservation)
+		if err != nil {
+			return resources, fmt.Errorf("invalid cpu reservation [%s]: %v", r.CPUReservation, err)
+		}
+		resources.CPUShares = cpu.MilliValue() * 1024 / 1000
+		if resources.CPUShares < minCPUShares {
+			resources.CPUShares = minCPUShares
+		}
+	}
+	if r.MemoryLimit != "" {
+		memory, err := resource.ParseQuantity(r.MemoryLimit)
+		if err != nil {
+			return resources, fmt.Errorf("invalid memory limit [%s]: %v", r.MemoryLimit, err)
+		}
+		resources.Memory = memory.Value()
+	}
+	if r.MemoryReservation != "" {
+		memory, err := resource.ParseQuantity(r.MemoryReservation)
+		if err != nil {
+			return resources, fmt.Errorf("invalid memory reservation [%s]: %v", r.MemoryReservation, err)
+		}
+		resources.MemoryReservation = memory.Value()
+	}
+	if r.PidsLimit != 0 {
+		pidsLimit := r.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+	return resources, nil
+}
+
 func GetHealthCheckURL(useTLS bool, port int) string {
 	if useTLS {
 		return fmt.Sprintf("%s%s:%d%s", HTTPSProtoPrefix, HealthzAddress, port, HealthzEndpoint)
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/rke/docker/dockertest"
+	"github.com/rancher/rke/hosts"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAlpineImage = "rancher/rke-tools:v0.1.72"
+
+func newFakeRuntimeHost(address string, eventLog *dockertest.EventLog) *hosts.Host {
+	host := &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: address, HostnameOverride: address}}
+	host.DClient = dockertest.NewRuntime(address, eventLog)
+	return host
+}
+
+func TestWorkerPlaneLifecycle(t *testing.T) {
+	ctx := context.Background()
+	eventLog := &dockertest.EventLog{}
+	workerHosts := []*hosts.Host{newFakeRuntimeHost("1.1.1.1", eventLog), newFakeRuntimeHost("2.2.2.2", eventLog)}
+	proxyProcess := v3.Process{
+		Name:          NginxProxyContainerName,
+		Image:         NginxProxyImage,
+		Env:           []string{NginxProxyEnvName + "=3.3.3.3"},
+		RestartPolicy: "always",
+	}
+	for _, host := range workerHosts {
+		assert.Nil(t, runNginxProxy(ctx, host, nil, proxyProcess, testAlpineImage))
+		fake := host.DClient.(*dockertest.Runtime)
+		// the log link container is removed once the link is created
+		assert.Equal(t, []string{NginxProxyContainerName}, fake.ContainerNames())
+		proxy, _ := fake.Container(NginxProxyContainerName)
+		assert.True(t, proxy.Running)
+	}
+
+	// the proxy is replaced when the control plane hosts change
+	proxyProcess.Env = []string{NginxProxyEnvName + "=3.3.3.3,4.4.4.4"}
+	assert.Nil(t, runNginxProxy(ctx, workerHosts[0], nil, proxyProcess, testAlpineImage))
+	proxy, _ := workerHosts[0].DClient.(*dockertest.Runtime).Container(NginxProxyContainerName)
+	assert.Equal(t, proxyProcess.Env, proxy.Config.Env)
+
+	assert.Nil(t, RemoveWorkerPlane(ctx, workerHosts, true))
+	for _, host := range workerHosts {
+		assert.Empty(t, host.DClient.(*dockertest.Runtime).ContainerNames())
+	}
+	assert.Contains(t, eventLog.Events(), "2.2.2.2 remove "+NginxProxyContainerName)
+}
+
+func TestGetProcessConfigResources(t *testing.T) {
+	host := &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: "1.1.1.1"}}
+	process := v3.Process{
+		Name:  KubeAPIContainerName,
+		Image: "rancher/hyperkube:v1.20.4-rancher1",
+		Resources: &v3.ContainerResources{
+			CPULimit:          "1500m",
+			CPUReservation:    "0.5",
+			MemoryLimit:       "2Gi",
+			MemoryReservation: "512Mi",
+			OOMScoreAdj:       -900,
+			PidsLimit:         4096,
+		},
+	}
+	_, hostCfg, _ := GetProcessConfig(process, host)
+	assert.Equal(t, int64(1500000000), hostCfg.NanoCPUs)
+	assert.Equal(t, int64(512), hostCfg.CPUShares)
+	assert.Equal(t, int64(2<<30), hostCfg.Memory)
+	assert.Equal(t, int64(512<<20), hostCfg.MemoryReservation)
+	assert.Equal(t, -900, hostCfg.OomScoreAdj)
+	if assert.NotNil(t, hostCfg.PidsLimit) {
+		assert.Equal(t, int64(4096), *hostCfg.PidsLimit)
+	}
+
+	_, err := GetContainerResources(&v3.ContainerResources{MemoryLimit: "lots"})
+	assert.NotNil(t, err)
+}
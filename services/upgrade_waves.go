@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/k8s"
+	"github.com/rancher/rke/log"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	canaryWaveName = "canary"
+
+	UpgradePauseRoleEnv     = "RKE_UPGRADE_ROLE"
+	UpgradePauseWaveEnv     = "RKE_UPGRADE_WAVE"
+	UpgradePauseHostsEnv    = "RKE_UPGRADE_HOSTS"
+	UpgradePauseNextWaveEnv = "RKE_UPGRADE_NEXT_WAVE"
+)
+
+// confirmUpgradeWave asks the operator on the terminal whether the upgrade continues, the prompt is written to stderr
+// because stdout carries the json output
+var confirmUpgradeWave = func(prompt string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/n]: ", prompt)
+	input, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	input = strings.ToLower(strings.TrimSpace(input))
+	return input == "y" || input == "yes", nil
+}
+
+// upgradeWave is a group of hosts that is done upgrading before the next group starts
+type upgradeWave struct {
+	name  string
+	hosts []*hosts.Host
+	// canary hosts are upgraded one at a time
+	canary bool
+}
+
+func (w upgradeWave) hostnames() []string {
+	var names []string
+	for _, host := range w.hosts {
+		names = append(names, host.HostnameOverride)
+	}
+	return names
+}
+
+// needsUpgrade returns true if a host of the wave is new or upgradable, hosts that can't be checked are upgradable
+func (w upgradeWave) needsUpgrade(newHosts map[string]bool, isUpgradable func(*hosts.Host) (bool, error)) bool {
+	for _, host := range w.hosts {
+		if newHosts[host.HostnameOverride] {
+			return true
+		}
+		upgradable, err := isUpgradable(host)
+		if err != nil {
+			logrus.Debugf("Failed to check if host [%s] is upgradable: %v", host.HostnameOverride, err)
+			return true
+		}
+		if upgradable {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the hosts of allHosts that are part of the wave
+func (w upgradeWave) filter(allHosts []*hosts.Host) []*hosts.Host {
+	inWave := make(map[string]bool)
+	for _, host := range w.hosts {
+		inWave[host.Address] = true
+	}
+	var filtered []*hosts.Host
+	for _, host := range allHosts {
+		if inWave[host.Address] {
+			filtered = append(filtered, host)
+		}
+	}
+	return filtered
+}
+
+// getUpgradeWaves returns the canary hosts first, then a wave per value of the waves label in the configured order and
+// the remaining hosts last. Without canary nodes and waves all the hosts are a single wave.
+func getUpgradeWaves(kubeClient *kubernetes.Clientset, allHosts []*hosts.Host, upgradeStrategy *v3.NodeUpgradeStrategy) []upgradeWave {
+	var waves []upgradeWave
+	remaining := allHosts
+	if len(upgradeStrategy.CanaryNodes) > 0 {
+		canaryNodes := make(map[string]bool)
+		for _, node := range upgradeStrategy.CanaryNodes {
+			canaryNodes[node] = true
+		}
+		canaryWave := upgradeWave{name: canaryWaveName, canary: true}
+		var others []*hosts.Host
+		for _, host := range remaining {
+			if canaryNodes[host.Address] || canaryNodes[host.HostnameOverride] {
+				canaryWave.hosts = append(canaryWave.hosts, host)
+				continue
+			}
+			others = append(others, host)
+		}
+		if len(canaryWave.hosts) > 0 {
+			waves = append(waves, canaryWave)
+		}
+		remaining = others
+	}
+	if upgradeStrategy.Waves != nil && upgradeStrategy.Waves.Label != "" {
+		label := upgradeStrategy.Waves.Label
+		hostValues := make(map[string]string)
+		byValue := make(map[string][]*hosts.Host)
+		for _, host := range remaining {
+			value := getHostLabel(kubeClient, host, label)
+			hostValues[host.Address] = value
+			byValue[value] = append(byValue[value], host)
+		}
+		var others []*hosts.Host
+		ordered := make(map[string]bool)
+		for _, value := range upgradeStrategy.Waves.Order {
+			ordered[value] = true
+			if len(byValue[value]) > 0 {
+				waves = append(waves, upgradeWave{name: fmt.Sprintf("%s=%s", label, value), hosts: byValue[value]})
+			}
+		}
+		// keep the order of the hosts in the cluster file for the last wave
+		for _, host := range remaining {
+			if !ordered[hostValues[host.Address]] {
+				others = append(others, host)
+			}
+		}
+		remaining = others
+	}
+	if len(remaining) > 0 || len(waves) == 0 {
+		name := "remaining nodes"
+		if len(waves) == 0 {
+			name = "all nodes"
+		}
+		waves = append(waves, upgradeWave{name: name, hosts: remaining})
+	}
+	return waves
+}
+
+// getHostLabel returns the label from the cluster file, or from the kubernetes node for the labels set by the cloud
+// provider or the kubelet
+func getHostLabel(kubeClient *kubernetes.Clientset, host *hosts.Host, label string) string {
+	if value, ok := host.Labels[label]; ok {
+		return value
+	}
+	if kubeClient == nil {
+		return ""
+	}
+	node, err := k8s.GetNode(kubeClient, host.HostnameOverride)
+	if err != nil {
+		logrus.Debugf("Failed to get node [%s] for label [%s]: %v", host.HostnameOverride, label, err)
+		return ""
+	}
+	return node.Labels[label]
+}
+
+func logUpgradeWave(ctx context.Context, component string, waves []upgradeWave, index int) {
+	if len(waves) == 1 {
+		return
+	}
+	log.Infof(ctx, "[%s] Upgrading wave [%s] (%d/%d): %s", component, waves[index].name, index+1, len(waves), strings.Join(waves[index].hostnames(), ","))
+}
+
+// upgradeWavePauses decides when to pause, only waves with something to upgrade are paused before and they're paused
+// after the last wave that upgraded something
+type upgradeWavePauses struct {
+	pause        *v3.NodeUpgradePause
+	component    string
+	isUpgradable func(*hosts.Host) (bool, error)
+	lastUpgraded *upgradeWave
+}
+
+// before pauses before the wave if needed, it has to be called for every wave in order
+func (p *upgradeWavePauses) before(ctx context.Context, wave upgradeWave, newHosts map[string]bool) error {
+	if p.pause == nil {
+		return nil
+	}
+	if !wave.needsUpgrade(newHosts, p.isUpgradable) {
+		logrus.Debugf("[%s] Nothing to upgrade in wave [%s], not pausing", p.component, wave.name)
+		return nil
+	}
+	done := p.lastUpgraded
+	p.lastUpgraded = &wave
+	if done == nil {
+		return nil
+	}
+	return pauseBetweenWaves(ctx, p.pause, p.component, *done, wave)
+}
+
+// pauseBetweenWaves runs the health command and asks for the operator confirmation of the pause configuration, an
+// error stops the upgrade before the next wave
+func pauseBetweenWaves(ctx context.Context, pause *v3.NodeUpgradePause, component string, done, next upgradeWave) error {
+	if pause == nil {
+		return nil
+	}
+	if pause.HealthCommand != "" {
+		log.Infof(ctx, "[%s] Running health command after wave [%s]", component, done.name)
+		if err := runUpgradeHealthCommand(ctx, pause, component, done, next); err != nil {
+			return fmt.Errorf("upgrade stopped after wave [%s], health command failed: %v", done.name, err)
+		}
+	}
+	if pause.Confirm {
+		confirmed, err := confirmUpgradeWave(fmt.Sprintf("[%s] Wave [%s] is upgraded, continue with wave [%s]?", component, done.name, next.name))
+		if err != nil {
+			return fmt.Errorf("upgrade stopped after wave [%s], failed to read the confirmation: %v", done.name, err)
+		}
+		if !confirmed {
+			return fmt.Errorf("upgrade stopped after wave [%s] by the operator", done.name)
+		}
+	}
+	return nil
+}
+
+func runUpgradeHealthCommand(ctx context.Context, pause *v3.NodeUpgradePause, component string, done, next upgradeWave) error {
+	if pause.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(pause.Timeout)*time.Second)
+		defer cancel()
+	}
+	args, err := shlex.Split(pause.HealthCommand)
+	if err != nil || len(args) == 0 {
+		return fmt.Errorf("Failed to parse health command [%s]: %v", pause.HealthCommand, err)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", UpgradePauseRoleEnv, component),
+		fmt.Sprintf("%s=%s", UpgradePauseWaveEnv, done.name),
+		fmt.Sprintf("%s=%s", UpgradePauseHostsEnv, strings.Join(done.hostnames(), ",")),
+		fmt.Sprintf("%s=%s", UpgradePauseNextWaveEnv, next.name),
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// the children of the command can keep the output open after the command is killed, don't wait for them
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cmd.Wait()
+	}()
+	select {
+	case err := <-errCh:
+		if output.Len() > 0 {
+			logrus.Infof("[%s] Health command output: %s", component, strings.TrimSpace(output.String()))
+		}
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %d seconds", pause.Timeout)
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/rke/hosts"
+	v3 "github.com/rancher/rke/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newWaveHost(address, zone string) *hosts.Host {
+	host := &hosts.Host{RKEConfigNode: v3.RKEConfigNode{Address: address, HostnameOverride: "node-" + address}}
+	if zone != "" {
+		host.Labels = map[string]string{"zone": zone}
+	}
+	return host
+}
+
+func TestGetUpgradeWaves(t *testing.T) {
+	allHosts := []*hosts.Host{
+		newWaveHost("1", "b"),
+		newWaveHost("2", "a"),
+		newWaveHost("3", "c"),
+		newWaveHost("4", "b"),
+		newWaveHost("5", ""),
+		newWaveHost("6", "a"),
+	}
+
+	waves := getUpgradeWaves(nil, allHosts, &v3.NodeUpgradeStrategy{})
+	if assert.Len(t, waves, 1) {
+		assert.Len(t, waves[0].hosts, 6)
+		assert.False(t, waves[0].canary)
+	}
+
+	waves = getUpgradeWaves(nil, allHosts, &v3.NodeUpgradeStrategy{
+		CanaryNodes: []string{"node-6", "3"},
+		Waves:       &v3.NodeUpgradeWaves{Label: "zone", Order: []string{"a", "b", "d"}},
+	})
+	if assert.Len(t, waves, 4) {
+		assert.True(t, waves[0].canary)
+		assert.Equal(t, []string{"node-3", "node-6"}, waves[0].hostnames())
+		assert.Equal(t, "zone=a", waves[1].name)
+		assert.Equal(t, []string{"node-2"}, waves[1].hostnames())
+		assert.Equal(t, "zone=b", waves[2].name)
+		assert.Equal(t, []string{"node-1", "node-4"}, waves[2].hostnames())
+		// the nodes without a listed label value come last
+		assert.Equal(t, []string{"node-5"}, waves[3].hostnames())
+		assert.Equal(t, []*hosts.Host{allHosts[3]}, waves[2].filter(allHosts[2:4]))
+	}
+}
+
+func TestPauseBetweenWaves(t *testing.T) {
+	ctx := context.Background()
+	done := upgradeWave{name: canaryWaveName, hosts: []*hosts.Host{newWaveHost("1", "")}}
+	next := upgradeWave{name: "zone=a"}
+
+	assert.Nil(t, pauseBetweenWaves(ctx, nil, WorkerRole, done, next))
+	assert.Nil(t, pauseBetweenWaves(ctx, &v3.NodeUpgradePause{HealthCommand: `sh -c 'test "$RKE_UPGRADE_WAVE" = canary && test "$RKE_UPGRADE_HOSTS" = node-1'`}, WorkerRole, done, next))
+	assert.NotNil(t, pauseBetweenWaves(ctx, &v3.NodeUpgradePause{HealthCommand: "false"}, WorkerRole, done, next))
+	assert.NotNil(t, pauseBetweenWaves(ctx, &v3.NodeUpgradePause{HealthCommand: "sleep 5", Timeout: 1}, WorkerRole, done, next))
+	// the command is not run by a shell
+	assert.NotNil(t, pauseBetweenWaves(ctx, &v3.NodeUpgradePause{HealthCommand: "false || true"}, WorkerRole, done, next))
+	assert.NotNil(t, pauseBetweenWaves(ctx, &v3.NodeUpgradePause{HealthCommand: "test 'unterminated"}, WorkerRole, done, next))
+
+	defer func(confirm func(string) (bool, error)) { confirmUpgradeWave = confirm }(confirmUpgradeWave)
+	confirmed := false
+	confirmUpgradeWave = func(string) (bool, error) { return confirmed, nil }
+	assert.NotNil(t, pauseBetweenWaves(ctx, &v3.NodeUpgradePause{Confirm: true}, WorkerRole, done, next))
+	confirmed = true
+	assert.Nil(t, pauseBetweenWaves(ctx, &v3.NodeUpgradePause{Confirm: true}, WorkerRole, done, next))
+}
+
+func TestUpgradeWavePauses(t *testing.T) {
+	ctx := context.Background()
+	defer func(confirm func(string) (bool, error)) { confirmUpgradeWave = confirm }(confirmUpgradeWave)
+	var prompts []string
+	confirmUpgradeWave = func(prompt string) (bool, error) {
+		prompts = append(prompts, prompt)
+		return true, nil
+	}
+	upgradable := map[string]bool{"node-1": true, "node-4": true}
+	pauses := &upgradeWavePauses{
+		pause:     &v3.NodeUpgradePause{Confirm: true},
+		component: WorkerRole,
+		isUpgradable: func(host *hosts.Host) (bool, error) {
+			return upgradable[host.HostnameOverride], nil
+		},
+	}
+	waves := []upgradeWave{
+		{name: canaryWaveName, hosts: []*hosts.Host{newWaveHost("1", "")}},
+		{name: "zone=a", hosts: []*hosts.Host{newWaveHost("2", "")}},
+		{name: "zone=b", hosts: []*hosts.Host{newWaveHost("3", "")}},
+		{name: "remaining nodes", hosts: []*hosts.Host{newWaveHost("4", "")}},
+	}
+	// the waves with nothing to upgrade are not paused, a new host is always upgraded
+	for _, wave := range waves {
+		assert.Nil(t, pauses.before(ctx, wave, map[string]bool{"node-3": true}))
+	}
+	assert.Equal(t, []string{
+		"[worker] Wave [canary] is upgraded, continue with wave [zone=b]?",
+		"[worker] Wave [zone=b] is upgraded, continue with wave [remaining nodes]?",
+	}, prompts)
+}
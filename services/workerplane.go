@@ -39,7 +39,9 @@ func RunWorkerPlane(ctx context.Context, allHosts []*hosts.Host, localConnDialer
 				err := doDeployWorkerPlaneHost(ctx, runHost, localConnDialerFactory, prsMap, workerNodePlanMap[runHost.Address].Processes, certMap, updateWorkersOnly, alpineImage)
 				if err != nil {
 					errList = append(errList, err)
+					continue
 				}
+				runHost.WorkerDeployed = true
 			}
 			return util.ErrList(errList)
 		})
@@ -56,25 +58,49 @@ func UpgradeWorkerPlaneForWorkerAndEtcdNodes(ctx context.Context, kubeClient *ku
 	log.Infof(ctx, "[%s] Upgrading Worker Plane..", WorkerRole)
 	var errMsgMaxUnavailableNotFailed string
 	updateNewHostsList(kubeClient, append(mixedRolesHosts, workerOnlyHosts...), newHosts)
-	if len(mixedRolesHosts) > 0 {
-		log.Infof(ctx, "First checking and processing worker components for upgrades on nodes with etcd role one at a time")
-	}
-	multipleRolesHostsFailedToUpgrade, err := processWorkerPlaneForUpgrade(ctx, kubeClient, mixedRolesHosts, localConnDialerFactory, prsMap, workerNodePlanMap, certMap, updateWorkersOnly, alpineImage, 1, upgradeStrategy, newHosts, inactiveHosts)
-	if err != nil {
-		logrus.Errorf("Failed to upgrade hosts: %v with error %v", strings.Join(multipleRolesHostsFailedToUpgrade, ","), err)
-		return errMsgMaxUnavailableNotFailed, err
-	}
-
-	if len(workerOnlyHosts) > 0 {
-		log.Infof(ctx, "Now checking and upgrading worker components on nodes with only worker role %v at a time", maxUnavailable)
+	waves := getUpgradeWaves(kubeClient, append(mixedRolesHosts, workerOnlyHosts...), upgradeStrategy)
+	pauses := &upgradeWavePauses{
+		pause:     upgradeStrategy.Pause,
+		component: WorkerRole,
+		isUpgradable: func(host *hosts.Host) (bool, error) {
+			return isWorkerHostUpgradable(ctx, host, workerNodePlanMap[host.Address].Processes)
+		},
 	}
-	workerOnlyHostsFailedToUpgrade, err := processWorkerPlaneForUpgrade(ctx, kubeClient, workerOnlyHosts, localConnDialerFactory, prsMap, workerNodePlanMap, certMap, updateWorkersOnly, alpineImage, maxUnavailable, upgradeStrategy, newHosts, inactiveHosts)
-	if err != nil {
-		logrus.Errorf("Failed to upgrade hosts: %v with error %v", strings.Join(workerOnlyHostsFailedToUpgrade, ","), err)
-		if len(workerOnlyHostsFailedToUpgrade) >= maxUnavailable {
+	for i, wave := range waves {
+		if err := pauses.before(ctx, wave, newHosts); err != nil {
 			return errMsgMaxUnavailableNotFailed, err
 		}
-		errMsgMaxUnavailableNotFailed = fmt.Sprintf("Failed to upgrade hosts: %v with error %v", strings.Join(workerOnlyHostsFailedToUpgrade, ","), err)
+		logUpgradeWave(ctx, WorkerRole, waves, i)
+		waveMixedRolesHosts := wave.filter(mixedRolesHosts)
+		waveWorkerOnlyHosts := wave.filter(workerOnlyHosts)
+		waveMaxUnavailable := maxUnavailable
+		if wave.canary {
+			waveMaxUnavailable = 1
+		}
+		if len(waveMixedRolesHosts) > 0 {
+			log.Infof(ctx, "First checking and processing worker components for upgrades on nodes with etcd role one at a time")
+		}
+		multipleRolesHostsFailedToUpgrade, err := processWorkerPlaneForUpgrade(ctx, kubeClient, waveMixedRolesHosts, localConnDialerFactory, prsMap, workerNodePlanMap, certMap, updateWorkersOnly, alpineImage, 1, upgradeStrategy, newHosts, inactiveHosts)
+		if err != nil {
+			logrus.Errorf("Failed to upgrade hosts: %v with error %v", strings.Join(multipleRolesHostsFailedToUpgrade, ","), err)
+			return errMsgMaxUnavailableNotFailed, err
+		}
+
+		if len(waveWorkerOnlyHosts) > 0 {
+			log.Infof(ctx, "Now checking and upgrading worker components on nodes with only worker role %v at a time", waveMaxUnavailable)
+		}
+		workerOnlyHostsFailedToUpgrade, err := processWorkerPlaneForUpgrade(ctx, kubeClient, waveWorkerOnlyHosts, localConnDialerFactory, prsMap, workerNodePlanMap, certMap, updateWorkersOnly, alpineImage, waveMaxUnavailable, upgradeStrategy, newHosts, inactiveHosts)
+		if err != nil {
+			logrus.Errorf("Failed to upgrade hosts: %v with error %v", strings.Join(workerOnlyHostsFailedToUpgrade, ","), err)
+			if len(workerOnlyHostsFailedToUpgrade) >= maxUnavailable {
+				return errMsgMaxUnavailableNotFailed, err
+			}
+			// a failed wave doesn't move on to the next waves
+			if i < len(waves)-1 {
+				return errMsgMaxUnavailableNotFailed, fmt.Errorf("upgrade stopped after wave [%s]: %v", wave.name, err)
+			}
+			errMsgMaxUnavailableNotFailed = fmt.Sprintf("Failed to upgrade hosts: %v with error %v", strings.Join(workerOnlyHostsFailedToUpgrade, ","), err)
+		}
 	}
 
 	log.Infof(ctx, "[%s] Successfully upgraded Worker Plane..", WorkerRole)
@@ -126,6 +152,7 @@ func processWorkerPlaneForUpgrade(ctx context.Context, kubeClient *kubernetes.Cl
 						hostsFailed.Store(runHost.HostnameOverride, true)
 						break
 					}
+					runHost.WorkerDeployed = true
 					continue
 				}
 				if err := CheckNodeReady(kubeClient, runHost, WorkerRole); err != nil {
@@ -167,6 +194,7 @@ func processWorkerPlaneForUpgrade(ctx context.Context, kubeClient *kubernetes.Cl
 						// This node didn't undergo an upgrade, so RKE will only log any error after uncordoning it and won't count this in maxUnavailable
 						logrus.Errorf("[workerplane] Failed to uncordon node %v, error: %v", runHost.HostnameOverride, err)
 					}
+					runHost.WorkerDeployed = true
 					continue
 				}
 				if err := upgradeWorkerHost(ctx, kubeClient, runHost, upgradeStrategy.Drain != nil && *upgradeStrategy.Drain, drainHelper, localConnDialerFactory, prsMap, workerNodePlanMap, certMap, updateWorkersOnly, alpineImage); err != nil {
@@ -175,6 +203,7 @@ func processWorkerPlaneForUpgrade(ctx context.Context, kubeClient *kubernetes.Cl
 					hostsFailedToUpgrade <- runHost.HostnameOverride
 					break
 				}
+				runHost.WorkerDeployed = true
 			}
 			return util.ErrList(errList)
 		})
@@ -193,6 +222,7 @@ func processWorkerPlaneForUpgrade(ctx context.Context, kubeClient *kubernetes.Cl
 func upgradeWorkerHost(ctx context.Context, kubeClient *kubernetes.Clientset, runHost *hosts.Host, drainFlag bool, drainHelper drain.Helper,
 	localConnDialerFactory hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, workerNodePlanMap map[string]v3.RKEConfigNodePlan, certMap map[string]pki.CertificatePKI, updateWorkersOnly bool,
 	alpineImage string) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: runHost.Address})
 	// cordon and drain
 	if err := cordonAndDrainNode(kubeClient, runHost, drainFlag, drainHelper, WorkerRole); err != nil {
 		return err
@@ -210,6 +240,7 @@ func upgradeWorkerHost(ctx context.Context, kubeClient *kubernetes.Clientset, ru
 }
 
 func doDeployWorkerPlaneHost(ctx context.Context, host *hosts.Host, localConnDialerFactory hosts.DialerFactory, prsMap map[string]v3.PrivateRegistry, processMap map[string]v3.Process, certMap map[string]pki.CertificatePKI, updateWorkersOnly bool, alpineImage string) error {
+	ctx = log.WithFields(ctx, log.Fields{Host: host.Address})
 	if updateWorkersOnly {
 		if !host.UpdateWorker {
 			return nil
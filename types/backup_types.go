@@ -19,6 +19,14 @@ type BackupConfig struct {
 	Retention int `yaml:"retention" json:"retention,omitempty" norman:"default=6"`
 	// s3 target
 	S3BackupConfig *S3BackupConfig `yaml:",omitempty" json:"s3BackupConfig"`
+	// filesystem target, a local or mounted (NFS) path on the machine running rke, only for rke etcd snapshot-save
+	FilesystemBackupConfig *FilesystemBackupConfig `yaml:"filesystem_backup_config,omitempty" json:"filesystemBackupConfig,omitempty"`
+	// sftp target
+	SFTPBackupConfig *SFTPBackupConfig `yaml:"sftp_backup_config,omitempty" json:"sftpBackupConfig,omitempty"`
+	// azure blob storage target
+	AzureBlobBackupConfig *AzureBlobBackupConfig `yaml:"azure_blob_backup_config,omitempty" json:"azureBlobBackupConfig,omitempty"`
+	// client-side encryption of the snapshots stored in the backup target
+	Encryption *BackupEncryptionConfig `yaml:"encryption,omitempty" json:"encryption,omitempty"`
 	// replace special characters in snapshot names
 	SafeTimestamp bool `yaml:"safe_timestamp" json:"safeTimestamp,omitempty"`
 	// Backup execution timeout
@@ -42,6 +50,52 @@ type S3BackupConfig struct {
 	Folder string `yaml:"folder" json:"folder,omitempty"`
 }
 
+type FilesystemBackupConfig struct {
+	// Directory to place the files
+	Path string `yaml:"path" json:"path,omitempty"`
+}
+
+type SFTPBackupConfig struct {
+	// Address of the sftp server, host:port
+	Address string `yaml:"address" json:"address,omitempty"`
+	// SSH user
+	User string `yaml:"user" json:"user,omitempty"`
+	// SSH password
+	Password string `yaml:"password" json:"password,omitempty" norman:"type=password"`
+	// SSH private key
+	SSHKey string `yaml:"ssh_key" json:"sshKey,omitempty" norman:"type=password"`
+	// SSH private key path
+	SSHKeyPath string `yaml:"ssh_key_path" json:"sshKeyPath,omitempty"`
+	// Public key of the sftp server in authorized_keys format, required to verify the server
+	HostKey string `yaml:"host_key" json:"hostKey,omitempty"`
+	// Folder to place the files
+	Folder string `yaml:"folder" json:"folder,omitempty"`
+}
+
+type AzureBlobBackupConfig struct {
+	// Storage account name
+	AccountName string `yaml:"account_name" json:"accountName,omitempty"`
+	// Storage account key
+	AccountKey string `yaml:"account_key" json:"accountKey,omitempty" norman:"type=password"`
+	// Name of the blob container to use for backup
+	ContainerName string `yaml:"container_name" json:"containerName,omitempty"`
+	// Endpoint is used if this is not the public Azure cloud, for example an Azurite emulator
+	Endpoint string `yaml:"endpoint" json:"endpoint,omitempty"`
+	// Folder to place the files
+	Folder string `yaml:"folder" json:"folder,omitempty"`
+}
+
+type BackupEncryptionConfig struct {
+	// Passphrase to encrypt the snapshots with, can't be combined with a key file or recipients
+	Passphrase string `yaml:"passphrase" json:"passphrase,omitempty" norman:"type=password"`
+	// Path of an age identity file or an armored PGP private key, used to encrypt and decrypt the snapshots
+	KeyFile string `yaml:"key_file" json:"keyFile,omitempty"`
+	// age public keys or armored PGP public keys to encrypt the snapshots for, restoring requires the matching key file
+	Recipients []string `yaml:"recipients" json:"recipients,omitempty"`
+	// Allow restoring snapshots that are not encrypted, set by the --allow-unencrypted flag
+	AllowUnencrypted bool `yaml:"-" json:"-"`
+}
+
 type EtcdBackupSpec struct {
 	// cluster ID
 	ClusterID string `json:"clusterId,omitempty" norman:"required,type=reference[cluster],noupdate"`
@@ -23,12 +23,22 @@ type RancherKubernetesEngineConfig struct {
 	AddonsInclude []string `yaml:"addons_include" json:"addonsInclude,omitempty"`
 	// List of images used internally for proxy, cert download and kubedns
 	SystemImages RKESystemImages `yaml:"system_images" json:"systemImages,omitempty"`
+	// Rules rewriting the system images, for example to internal mirrors, the first matching rule is applied
+	ImageRewrites []ImageRewriteRule `yaml:"image_rewrites" json:"imageRewrites,omitempty"`
 	// SSH Private Key Path
 	SSHKeyPath string `yaml:"ssh_key_path" json:"sshKeyPath,omitempty" norman:"nocreate,noupdate"`
 	// SSH Certificate Path
 	SSHCertPath string `yaml:"ssh_cert_path" json:"sshCertPath,omitempty" norman:"nocreate,noupdate"`
 	// SSH Agent Auth enable
 	SSHAgentAuth bool `yaml:"ssh_agent_auth" json:"sshAgentAuth"`
+	// Command printing the SSH private key or the SSH private key and certificate of a node, run per node
+	SSHKeyCommand string `yaml:"ssh_key_command" json:"sshKeyCommand,omitempty" norman:"nocreate,noupdate"`
+	// Command printing the passphrase of the encrypted SSH private keys
+	SSHKeyPassphraseCommand string `yaml:"ssh_key_passphrase_command" json:"sshKeyPassphraseCommand,omitempty" norman:"nocreate,noupdate"`
+	// OpenSSH known_hosts file the SSH host keys of the nodes and the bastion host are verified against
+	SSHKnownHostsPath string `yaml:"ssh_known_hosts_path" json:"sshKnownHostsPath,omitempty" norman:"nocreate,noupdate"`
+	// Location of the state file, s3://<bucket>[/<folder>] or configmap://<namespace>[/<name>], the state file is kept next to the cluster file when it's not set
+	StateBackend string `yaml:"state_backend" json:"stateBackend,omitempty" norman:"nocreate,noupdate"`
 	// Authorization mode configuration used in the cluster
 	Authorization AuthzConfig `yaml:"authorization" json:"authorization,omitempty"`
 	// Enable/disable strict docker version checking
@@ -59,6 +69,10 @@ type RancherKubernetesEngineConfig struct {
 	Restore RestoreConfig `yaml:"restore" json:"restore,omitempty"`
 	// Rotating Certificates Option
 	RotateCertificates *RotateCertificates `yaml:"rotate_certificates,omitempty" json:"rotateCertificates,omitempty"`
+	// Validity and key type of the generated certificates
+	PKI *PKIConfig `yaml:"pki,omitempty" json:"pki,omitempty"`
+	// Renewal of the certificates expiring soon during rke up
+	CertificateRenewal *CertificateRenewal `yaml:"certificate_renewal,omitempty" json:"certificateRenewal,omitempty"`
 	// Rotate Encryption Key Option
 	RotateEncryptionKey bool `yaml:"rotate_encryption_key" json:"rotateEncryptionKey"`
 	// DNS Config
@@ -78,6 +92,28 @@ type NodeUpgradeStrategy struct {
 	MaxUnavailableControlplane string          `yaml:"max_unavailable_controlplane" json:"maxUnavailableControlplane,omitempty" norman:"min=1,default=1"`
 	Drain                      *bool           `yaml:"drain" json:"drain,omitempty"`
 	DrainInput                 *NodeDrainInput `yaml:"node_drain_input" json:"nodeDrainInput,omitempty"`
+	// Nodes upgraded one at a time before the other nodes of their role, by address or hostname_override
+	CanaryNodes []string `yaml:"canary_nodes" json:"canaryNodes,omitempty"`
+	// Upgrade the nodes in waves grouped by a node label
+	Waves *NodeUpgradeWaves `yaml:"waves" json:"waves,omitempty"`
+	// Pause after the canary nodes and between the waves
+	Pause *NodeUpgradePause `yaml:"pause" json:"pause,omitempty"`
+}
+
+type NodeUpgradeWaves struct {
+	// Node label the waves are grouped by (example, topology.kubernetes.io/zone)
+	Label string `yaml:"label" json:"label,omitempty"`
+	// Label values in upgrade order, nodes with other values or without the label are upgraded in a last wave
+	Order []string `yaml:"order" json:"order,omitempty"`
+}
+
+type NodeUpgradePause struct {
+	// Command run by RKE after each wave, the upgrade stops if it fails
+	HealthCommand string `yaml:"health_command" json:"healthCommand,omitempty"`
+	// Timeout of the health command in seconds
+	Timeout int `yaml:"timeout" json:"timeout,omitempty" norman:"default=300"`
+	// Ask for an operator confirmation after each wave, the upgrade stops if it is not confirmed
+	Confirm bool `yaml:"confirm" json:"confirm,omitempty"`
 }
 
 type BastionHost struct {
@@ -97,8 +133,41 @@ type BastionHost struct {
 	SSHCert string `yaml:"ssh_cert" json:"sshCert,omitempty"`
 	// SSH Certificate Path
 	SSHCertPath string `yaml:"ssh_cert_path" json:"sshCertPath,omitempty"`
+	// Command printing the SSH private key or the SSH private key and certificate of the jump host
+	SSHKeyCommand string `yaml:"ssh_key_command" json:"sshKeyCommand,omitempty"`
+	// Command printing the passphrase of the encrypted SSH private key
+	SSHKeyPassphraseCommand string `yaml:"ssh_key_passphrase_command" json:"sshKeyPassphraseCommand,omitempty"`
+	// SSH host key fingerprint (example, SHA256:...)
+	SSHHostKey string `yaml:"ssh_host_key" json:"sshHostKey,omitempty"`
 	// Ignore proxy environment variables
 	IgnoreProxyEnvVars bool `yaml:"ignore_proxy_env_vars" json:"ignoreProxyEnvVars,omitempty"`
+	// Jump hosts reached through the bastion host in order, the last hop connects to the nodes
+	Hops []BastionHop `yaml:"hops" json:"hops,omitempty"`
+}
+
+type BastionHop struct {
+	// Address of the jump host
+	Address string `yaml:"address" json:"address,omitempty"`
+	// SSH Port of the jump host
+	Port string `yaml:"port" json:"port,omitempty"`
+	// ssh User to the jump host
+	User string `yaml:"user" json:"user,omitempty"`
+	// SSH Agent Auth enable
+	SSHAgentAuth bool `yaml:"ssh_agent_auth,omitempty" json:"sshAgentAuth,omitempty"`
+	// SSH Private Key
+	SSHKey string `yaml:"ssh_key" json:"sshKey,omitempty" norman:"type=password"`
+	// SSH Private Key Path
+	SSHKeyPath string `yaml:"ssh_key_path" json:"sshKeyPath,omitempty"`
+	// SSH Certificate
+	SSHCert string `yaml:"ssh_cert" json:"sshCert,omitempty"`
+	// SSH Certificate Path
+	SSHCertPath string `yaml:"ssh_cert_path" json:"sshCertPath,omitempty"`
+	// Command printing the SSH private key or the SSH private key and certificate of the jump host
+	SSHKeyCommand string `yaml:"ssh_key_command" json:"sshKeyCommand,omitempty"`
+	// Command printing the passphrase of the encrypted SSH private key
+	SSHKeyPassphraseCommand string `yaml:"ssh_key_passphrase_command" json:"sshKeyPassphraseCommand,omitempty"`
+	// SSH host key fingerprint (example, SHA256:...)
+	SSHHostKey string `yaml:"ssh_host_key" json:"sshHostKey,omitempty"`
 }
 
 type PrivateRegistry struct {
@@ -114,6 +183,15 @@ type PrivateRegistry struct {
 	CredentialPlugin map[string]string `yaml:"credentialPlugin" json:"credentialPlugin,omitempty"`
 }
 
+type ImageRewriteRule struct {
+	// Prefix of the images to rewrite, replaced by the replacement
+	Prefix string `yaml:"prefix" json:"prefix,omitempty"`
+	// Regular expression matching the images to rewrite, the replacement can reference its groups ($1)
+	Regex string `yaml:"regex" json:"regex,omitempty"`
+	// Replacement of the prefix or of the regular expression match
+	Replacement string `yaml:"replacement" json:"replacement,omitempty"`
+}
+
 type RKESystemImages struct {
 	// etcd image
 	Etcd string `yaml:"etcd" json:"etcd,omitempty"`
@@ -226,12 +304,43 @@ type RKEConfigNode struct {
 	SSHCert string `yaml:"ssh_cert" json:"sshCert,omitempty"`
 	// SSH Certificate Path
 	SSHCertPath string `yaml:"ssh_cert_path" json:"sshCertPath,omitempty"`
+	// SSH host key fingerprint (example, SHA256:...)
+	SSHHostKey string `yaml:"ssh_host_key" json:"sshHostKey,omitempty"`
+	// Optional - Command printing the SSH private key or the SSH private key and certificate of the node
+	SSHKeyCommand string `yaml:"ssh_key_command" json:"sshKeyCommand,omitempty"`
+	// Optional - Command printing the passphrase of the encrypted SSH private key of the node
+	SSHKeyPassphraseCommand string `yaml:"ssh_key_passphrase_command" json:"sshKeyPassphraseCommand,omitempty"`
+	// Optional - Bastion host of the node instead of the bastion host of the cluster, an empty bastion host connects to the node directly
+	BastionHost *BastionHost `yaml:"bastion_host,omitempty" json:"bastionHost,omitempty"`
+	// Optional - Transport to the Docker API of the node, ssh (default) or docker-tls
+	Transport string `yaml:"transport" json:"transport,omitempty" norman:"type=enum,options=ssh|docker-tls"`
+	// Docker API over TLS of the node, used by the docker-tls transport
+	DockerTLS *DockerTLSConfig `yaml:"docker_tls,omitempty" json:"dockerTls,omitempty"`
 	// Node Labels
 	Labels map[string]string `yaml:"labels" json:"labels,omitempty"`
 	// Node Taints
 	Taints []RKETaint `yaml:"taints" json:"taints,omitempty"`
 }
 
+type DockerTLSConfig struct {
+	// Port of the Docker API, default 2376
+	Port string `yaml:"port" json:"port,omitempty"`
+	// Optional - Server name verified in the certificate of the Docker API, default is the node address
+	ServerName string `yaml:"server_name" json:"serverName,omitempty"`
+	// CA certificate of the Docker API
+	CACert string `yaml:"ca_cert" json:"caCert,omitempty"`
+	// CA certificate path of the Docker API
+	CACertPath string `yaml:"ca_cert_path" json:"caCertPath,omitempty"`
+	// Client certificate
+	Cert string `yaml:"cert" json:"cert,omitempty"`
+	// Client certificate path
+	CertPath string `yaml:"cert_path" json:"certPath,omitempty"`
+	// Client key
+	Key string `yaml:"key" json:"key,omitempty" norman:"type=password"`
+	// Client key path
+	KeyPath string `yaml:"key_path" json:"keyPath,omitempty"`
+}
+
 type K8sVersionInfo struct {
 	MinRKEVersion       string `yaml:"min_rke_version" json:"minRKEVersion,omitempty"`
 	MaxRKEVersion       string `yaml:"max_rke_version" json:"maxRKEVersion,omitempty"`
@@ -376,6 +485,24 @@ type BaseService struct {
 	WindowsExtraBinds []string `yaml:"win_extra_binds" json:"winExtraBinds,omitempty"`
 	// this is to provide extra env variable to the docker container running kubernetes service
 	WindowsExtraEnv []string `yaml:"win_extra_env" json:"winExtraEnv,omitempty"`
+
+	// Resource limits and reservations of the docker container running kubernetes service
+	Resources *ContainerResources `yaml:"resources,omitempty" json:"resources,omitempty"`
+}
+
+type ContainerResources struct {
+	// CPU limit in cores, like 500m or 2
+	CPULimit string `yaml:"cpu_limit" json:"cpuLimit,omitempty"`
+	// CPU reservation in cores, applied as the relative CPU shares of the container
+	CPUReservation string `yaml:"cpu_reservation" json:"cpuReservation,omitempty"`
+	// Memory limit, like 512Mi or 2Gi
+	MemoryLimit string `yaml:"memory_limit" json:"memoryLimit,omitempty"`
+	// Memory reservation, the soft limit of the container
+	MemoryReservation string `yaml:"memory_reservation" json:"memoryReservation,omitempty"`
+	// OOM score adjustment of the container, from -1000 to 1000
+	OOMScoreAdj int `yaml:"oom_score_adj" json:"oomScoreAdj,omitempty"`
+	// Maximum number of processes in the container
+	PidsLimit int64 `yaml:"pids_limit" json:"pidsLimit,omitempty"`
 }
 
 type NetworkConfig struct {
@@ -529,6 +656,8 @@ type Process struct {
 	Publish []string `json:"publish,omitempty"`
 	// docker will run the container with this user
 	User string `json:"user,omitempty"`
+	// Process docker container resource limits and reservations
+	Resources *ContainerResources `json:"resources,omitempty"`
 }
 
 type HealthCheck struct {
@@ -934,6 +1063,34 @@ type RotateCertificates struct {
 	Services []string `json:"services,omitempty" norman:"type=enum,options=etcd|kubelet|kube-apiserver|kube-proxy|kube-scheduler|kube-controller-manager"`
 }
 
+type PKIConfig struct {
+	// Validity of the generated CA certificates, 87600h by default
+	CAValidity string `yaml:"ca_validity" json:"caValidity,omitempty"`
+	// Validity of the generated certificates, 87600h by default
+	CertValidity string `yaml:"cert_validity" json:"certValidity,omitempty"`
+	// Type of the generated keys, rsa-2048 by default
+	KeyType string `yaml:"key_type" json:"keyType,omitempty" norman:"type=enum,options=rsa-2048|rsa-3072|rsa-4096|ecdsa-p256|ecdsa-p384"`
+	// Intermediate CA of an external root CA used as cluster CA
+	ExternalCA *ExternalCAConfig `yaml:"external_ca,omitempty" json:"externalCa,omitempty"`
+}
+
+type ExternalCAConfig struct {
+	// PEM encoded intermediate CA certificate, followed by the certificates of its chain up to the root CA
+	CACert string `yaml:"ca_cert" json:"caCert,omitempty"`
+	// PEM encoded key of the intermediate CA
+	CAKey string `yaml:"ca_key" json:"caKey,omitempty" norman:"type=password"`
+	// Command signing the certificates instead of the CA key, it reads a PEM encoded CSR on stdin and prints the PEM
+	// encoded certificate
+	SignerCommand string `yaml:"signer_command" json:"signerCommand,omitempty"`
+}
+
+type CertificateRenewal struct {
+	// Disable the renewal of the certificates during rke up
+	Disabled bool `yaml:"disabled" json:"disabled,omitempty"`
+	// Number of days before their expiry the certificates are renewed
+	RenewBeforeDays int `yaml:"renew_before_days" json:"renewBeforeDays,omitempty" norman:"default=30"`
+}
+
 type DNSConfig struct {
 	// DNS provider
 	Provider string `yaml:"provider" json:"provider,omitempty"`
@@ -191,6 +191,22 @@ func (in *AuthzConfig) DeepCopy() *AuthzConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureBlobBackupConfig) DeepCopyInto(out *AzureBlobBackupConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureBlobBackupConfig.
+func (in *AzureBlobBackupConfig) DeepCopy() *AzureBlobBackupConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureBlobBackupConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AzureCloudProvider) DeepCopyInto(out *AzureCloudProvider) {
 	*out = *in
@@ -225,6 +241,26 @@ func (in *BackupConfig) DeepCopyInto(out *BackupConfig) {
 		*out = new(S3BackupConfig)
 		**out = **in
 	}
+	if in.FilesystemBackupConfig != nil {
+		in, out := &in.FilesystemBackupConfig, &out.FilesystemBackupConfig
+		*out = new(FilesystemBackupConfig)
+		**out = **in
+	}
+	if in.SFTPBackupConfig != nil {
+		in, out := &in.SFTPBackupConfig, &out.SFTPBackupConfig
+		*out = new(SFTPBackupConfig)
+		**out = **in
+	}
+	if in.AzureBlobBackupConfig != nil {
+		in, out := &in.AzureBlobBackupConfig, &out.AzureBlobBackupConfig
+		*out = new(AzureBlobBackupConfig)
+		**out = **in
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(BackupEncryptionConfig)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -238,6 +274,27 @@ func (in *BackupConfig) DeepCopy() *BackupConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupEncryptionConfig) DeepCopyInto(out *BackupEncryptionConfig) {
+	*out = *in
+	if in.Recipients != nil {
+		in, out := &in.Recipients, &out.Recipients
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupEncryptionConfig.
+func (in *BackupEncryptionConfig) DeepCopy() *BackupEncryptionConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupEncryptionConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BaseService) DeepCopyInto(out *BaseService) {
 	*out = *in
@@ -275,6 +332,11 @@ func (in *BaseService) DeepCopyInto(out *BaseService) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(ContainerResources)
+		**out = **in
+	}
 	return
 }
 
@@ -288,9 +350,30 @@ func (in *BaseService) DeepCopy() *BaseService {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BastionHop) DeepCopyInto(out *BastionHop) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BastionHop.
+func (in *BastionHop) DeepCopy() *BastionHop {
+	if in == nil {
+		return nil
+	}
+	out := new(BastionHop)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BastionHost) DeepCopyInto(out *BastionHost) {
 	*out = *in
+	if in.Hops != nil {
+		in, out := &in.Hops, &out.Hops
+		*out = make([]BastionHop, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -353,6 +436,22 @@ func (in *CanalNetworkProvider) DeepCopy() *CanalNetworkProvider {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateRenewal) DeepCopyInto(out *CertificateRenewal) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateRenewal.
+func (in *CertificateRenewal) DeepCopy() *CertificateRenewal {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateRenewal)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CloudProvider) DeepCopyInto(out *CloudProvider) {
 	*out = *in
@@ -419,6 +518,22 @@ func (in *Configuration) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerResources) DeepCopyInto(out *ContainerResources) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerResources.
+func (in *ContainerResources) DeepCopy() *ContainerResources {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerResources)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DNSConfig) DeepCopyInto(out *DNSConfig) {
 	*out = *in
@@ -554,6 +669,22 @@ func (in *DiskVsphereOpts) DeepCopy() *DiskVsphereOpts {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DockerTLSConfig) DeepCopyInto(out *DockerTLSConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DockerTLSConfig.
+func (in *DockerTLSConfig) DeepCopy() *DockerTLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DockerTLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ETCDService) DeepCopyInto(out *ETCDService) {
 	*out = *in
@@ -661,6 +792,22 @@ func (in *EventRateLimit) DeepCopy() *EventRateLimit {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalCAConfig) DeepCopyInto(out *ExternalCAConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalCAConfig.
+func (in *ExternalCAConfig) DeepCopy() *ExternalCAConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalCAConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExtraEnv) DeepCopyInto(out *ExtraEnv) {
 	*out = *in
@@ -728,6 +875,22 @@ func (in *File) DeepCopy() *File {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FilesystemBackupConfig) DeepCopyInto(out *FilesystemBackupConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FilesystemBackupConfig.
+func (in *FilesystemBackupConfig) DeepCopy() *FilesystemBackupConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(FilesystemBackupConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FlannelNetworkProvider) DeepCopyInto(out *FlannelNetworkProvider) {
 	*out = *in
@@ -808,6 +971,22 @@ func (in *HealthCheck) DeepCopy() *HealthCheck {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageRewriteRule) DeepCopyInto(out *ImageRewriteRule) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRewriteRule.
+func (in *ImageRewriteRule) DeepCopy() *ImageRewriteRule {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageRewriteRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IngressConfig) DeepCopyInto(out *IngressConfig) {
 	*out = *in
@@ -1260,6 +1439,22 @@ func (in *NodeDrainInput) DeepCopy() *NodeDrainInput {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeUpgradePause) DeepCopyInto(out *NodeUpgradePause) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeUpgradePause.
+func (in *NodeUpgradePause) DeepCopy() *NodeUpgradePause {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeUpgradePause)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeUpgradeStrategy) DeepCopyInto(out *NodeUpgradeStrategy) {
 	*out = *in
@@ -1273,6 +1468,21 @@ func (in *NodeUpgradeStrategy) DeepCopyInto(out *NodeUpgradeStrategy) {
 		*out = new(NodeDrainInput)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.CanaryNodes != nil {
+		in, out := &in.CanaryNodes, &out.CanaryNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Waves != nil {
+		in, out := &in.Waves, &out.Waves
+		*out = new(NodeUpgradeWaves)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Pause != nil {
+		in, out := &in.Pause, &out.Pause
+		*out = new(NodeUpgradePause)
+		**out = **in
+	}
 	return
 }
 
@@ -1286,6 +1496,27 @@ func (in *NodeUpgradeStrategy) DeepCopy() *NodeUpgradeStrategy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeUpgradeWaves) DeepCopyInto(out *NodeUpgradeWaves) {
+	*out = *in
+	if in.Order != nil {
+		in, out := &in.Order, &out.Order
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeUpgradeWaves.
+func (in *NodeUpgradeWaves) DeepCopy() *NodeUpgradeWaves {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeUpgradeWaves)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Nodelocal) DeepCopyInto(out *Nodelocal) {
 	*out = *in
@@ -1335,6 +1566,27 @@ func (in *OpenstackCloudProvider) DeepCopy() *OpenstackCloudProvider {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PKIConfig) DeepCopyInto(out *PKIConfig) {
+	*out = *in
+	if in.ExternalCA != nil {
+		in, out := &in.ExternalCA, &out.ExternalCA
+		*out = new(ExternalCAConfig)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PKIConfig.
+func (in *PKIConfig) DeepCopy() *PKIConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(PKIConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PortCheck) DeepCopyInto(out *PortCheck) {
 	*out = *in
@@ -1415,6 +1667,11 @@ func (in *Process) DeepCopyInto(out *Process) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(ContainerResources)
+		**out = **in
+	}
 	return
 }
 
@@ -1436,6 +1693,16 @@ func (in *RKEConfigNode) DeepCopyInto(out *RKEConfigNode) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.BastionHost != nil {
+		in, out := &in.BastionHost, &out.BastionHost
+		*out = new(BastionHost)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DockerTLS != nil {
+		in, out := &in.DockerTLS, &out.DockerTLS
+		*out = new(DockerTLSConfig)
+		**out = **in
+	}
 	if in.Labels != nil {
 		in, out := &in.Labels, &out.Labels
 		*out = make(map[string]string, len(*in))
@@ -1617,6 +1884,11 @@ func (in *RancherKubernetesEngineConfig) DeepCopyInto(out *RancherKubernetesEngi
 		copy(*out, *in)
 	}
 	out.SystemImages = in.SystemImages
+	if in.ImageRewrites != nil {
+		in, out := &in.ImageRewrites, &out.ImageRewrites
+		*out = make([]ImageRewriteRule, len(*in))
+		copy(*out, *in)
+	}
 	in.Authorization.DeepCopyInto(&out.Authorization)
 	if in.IgnoreDockerVersion != nil {
 		in, out := &in.IgnoreDockerVersion, &out.IgnoreDockerVersion
@@ -1637,7 +1909,7 @@ func (in *RancherKubernetesEngineConfig) DeepCopyInto(out *RancherKubernetesEngi
 	}
 	in.Ingress.DeepCopyInto(&out.Ingress)
 	in.CloudProvider.DeepCopyInto(&out.CloudProvider)
-	out.BastionHost = in.BastionHost
+	in.BastionHost.DeepCopyInto(&out.BastionHost)
 	in.Monitoring.DeepCopyInto(&out.Monitoring)
 	out.Restore = in.Restore
 	if in.RotateCertificates != nil {
@@ -1645,6 +1917,16 @@ func (in *RancherKubernetesEngineConfig) DeepCopyInto(out *RancherKubernetesEngi
 		*out = new(RotateCertificates)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PKI != nil {
+		in, out := &in.PKI, &out.PKI
+		*out = new(PKIConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CertificateRenewal != nil {
+		in, out := &in.CertificateRenewal, &out.CertificateRenewal
+		*out = new(CertificateRenewal)
+		**out = **in
+	}
 	if in.DNS != nil {
 		in, out := &in.DNS, &out.DNS
 		*out = new(DNSConfig)
@@ -1737,6 +2019,22 @@ func (in *S3BackupConfig) DeepCopy() *S3BackupConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SFTPBackupConfig) DeepCopyInto(out *SFTPBackupConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SFTPBackupConfig.
+func (in *SFTPBackupConfig) DeepCopy() *SFTPBackupConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SFTPBackupConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SchedulerService) DeepCopyInto(out *SchedulerService) {
 	*out = *in
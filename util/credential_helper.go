@@ -0,0 +1,97 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/shlex"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ExecCredentialPluginType = "exec"
+	// ExecCredentialPluginKey is the docker credential helper executable, with its arguments quoted like in a shell
+	ExecCredentialPluginKey = "exec"
+
+	credentialHelperTimeout = 30 * time.Second
+	// credentialHelperCacheTTL shares the credentials between the pulls of a batch, the next batch runs the helper again
+	credentialHelperCacheTTL = time.Minute
+	// credentialHelperTokenUser is returned by the helpers as username of the identity tokens
+	credentialHelperTokenUser = "<token>"
+)
+
+// credentialHelperOutputs caches the credentials by helper and registry
+var credentialHelperOutputs = struct {
+	sync.Mutex
+	byHelper map[string]cachedCredentials
+}{byHelper: map[string]cachedCredentials{}}
+
+type cachedCredentials struct {
+	authConfig types.AuthConfig
+	expires    time.Time
+}
+
+type credentialHelperOutput struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// IsExecCredentialPlugin returns whether the credential plugin runs a docker credential helper, the type is optional
+func IsExecCredentialPlugin(plugin map[string]string) bool {
+	pluginType, ok := plugin["type"]
+	return (!ok || pluginType == ExecCredentialPluginType) && plugin[ExecCredentialPluginKey] != ""
+}
+
+// ExecCredentialPlugin gets the credentials of the registry from a docker credential helper, following the protocol of
+// docker-credential-helpers: `<helper> get` with the registry URL on stdin prints the username and the secret
+func ExecCredentialPlugin(plugin map[string]string, registryURL string) (types.AuthConfig, error) {
+	helper := strings.TrimSpace(plugin[ExecCredentialPluginKey])
+	if helper == "" {
+		return types.AuthConfig{}, fmt.Errorf("credential helper is not set for registry [%s]", registryURL)
+	}
+	cacheKey := helper + "\x00" + registryURL
+	credentialHelperOutputs.Lock()
+	defer credentialHelperOutputs.Unlock()
+	if cached, ok := credentialHelperOutputs.byHelper[cacheKey]; ok && time.Now().Before(cached.expires) {
+		return cached.authConfig, nil
+	}
+
+	args, err := shlex.Split(helper)
+	if err != nil || len(args) == 0 {
+		return types.AuthConfig{}, fmt.Errorf("Failed to parse credential helper [%s] for registry [%s]: %v", helper, registryURL, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], "get")...)
+	cmd.Stdin = strings.NewReader(registryURL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	logrus.Debugf("Running credential helper [%s] for registry [%s]", helper, registryURL)
+	output, err := cmd.Output()
+	if err != nil {
+		// the helpers print the errors, like credentials not found, on stdout
+		message := strings.TrimSpace(string(output) + stderr.String())
+		return types.AuthConfig{}, fmt.Errorf("Credential helper [%s] failed for registry [%s]: %v: %s", helper, registryURL, err, message)
+	}
+	var creds credentialHelperOutput
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return types.AuthConfig{}, fmt.Errorf("Failed to parse the output of credential helper [%s] for registry [%s]: %v", helper, registryURL, err)
+	}
+	authConfig := types.AuthConfig{ServerAddress: registryURL}
+	if creds.Username == credentialHelperTokenUser {
+		authConfig.IdentityToken = creds.Secret
+	} else {
+		authConfig.Username = creds.Username
+		authConfig.Password = creds.Secret
+	}
+	credentialHelperOutputs.byHelper[cacheKey] = cachedCredentials{authConfig: authConfig, expires: time.Now().Add(credentialHelperCacheTTL)}
+	return authConfig, nil
+}
@@ -105,7 +105,11 @@ func GetDefaultRKETools(image string) (string, error) {
 	if err != nil || toReplaceTag == "" {
 		return "", fmt.Errorf("defaultRKETools: no replace tag %s", defaultImage)
 	}
-	image = strings.Replace(image, tag, toReplaceTag, 1)
+	if tag == toReplaceTag {
+		return image, nil
+	}
+	// the digest the image is pinned to is the digest of the replaced tag
+	image = strings.Replace(strings.SplitN(image, "@", 2)[0], tag, toReplaceTag, 1)
 	return image, nil
 }
 
@@ -114,7 +118,11 @@ func GetImageTagFromImage(image string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	imageTag := parsedImage.(ref.Tagged).Tag()
+	tagged, ok := parsedImage.(ref.Tagged)
+	if !ok {
+		return "", fmt.Errorf("image [%s] has no tag", image)
+	}
+	imageTag := tagged.Tag()
 	logrus.Debugf("Extracted version [%s] from image [%s]", imageTag, image)
 	return imageTag, nil
 }